@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+// Writes a snapshot of the database to file. If BakinBacon is running, the
+// snapshot is taken through its API instead.
+func runBackup(file, passphrase string) error {
+
+	tmpFile := file + ".tmp"
+
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create backup file")
+	}
+	defer os.Remove(tmpFile)
+
+	_, err = storage.BackupFile(*dataDir, f, passphrase)
+	if errors.Cause(err) == storage.ErrDatabaseInUse {
+
+		log.Info("Database in use; Backing up through the running BakinBacon")
+
+		err = backupFromApi(f, passphrase)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(os.Rename(tmpFile, file), "Unable to save backup file")
+}
+
+func backupFromApi(w io.Writer, passphrase string) error {
+
+	body, _ := json.Marshal(map[string]string{"passphrase": passphrase})
+
+	apiUrl := fmt.Sprintf("http://%s:%d/api/settings/backup", *webUiAddr, *webUiPort)
+
+	resp, err := http.Post(apiUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Unable to reach BakinBacon API")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Backup through API failed: %s %s", resp.Status, msg)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+
+	return errors.Wrap(err, "Unable to download backup")
+}
+
+// Replaces the database with a backup; BakinBacon must be stopped
+func runRestore(file, passphrase string, force bool) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open backup file")
+	}
+	defer f.Close()
+
+	return storage.Restore(*dataDir, network, f, passphrase, force)
+}
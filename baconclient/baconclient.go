@@ -1,6 +1,7 @@
 package baconclient
 
 import (
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,20 +25,49 @@ const (
 type BaconSlice struct {
 	*rpc.Client
 	clientId int
-	isActive bool
 	shutdown chan interface{}
+
+	// With the connection options of the endpoint
+	http *http.Client
+
+	// What the endpoint is used for; see storage.ROLE_*
+	role string
+
+	// New heads seen by this endpoint
+	heads headStats
+
+	// Requests, injections and circuit breaker
+	health endpointHealth
 }
 
 type BaconClient struct {
 	NewBlockNotifier chan *rpc.Block
 
-	Current    *BaconSlice
+	// Endpoint in use, and all running endpoints; access through Current, AddRpc,
+	// EditRpc and ShutdownRpc
+	current    *BaconSlice
 	rpcClients []*BaconSlice
 
+	// Recent heads, by hash, to de-duplicate heads reported by each endpoint
+	seenHeads map[string]seenHead
+
+	// When the current head first arrived
+	headArrived time.Time
+
 	Status *BaconStatus
 	Signer *baconsigner.BaconSigner
 
+	// Last cycle a pending consensus key notification was sent
+	consensusNotifiedCycle int
+
+	// Last signer error sent as notification; only changes are sent
+	signerError string
+
 	lock sync.Mutex
+
+	// Keeps blocks sent to NewBlockNotifier in order, without holding lock
+	notifyLock    sync.Mutex
+	notifiedLevel int
 }
 
 // Set from main at startup
@@ -47,9 +77,12 @@ var (
 	waitGroup         *sync.WaitGroup
 )
 
-func New(tbb int, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient, error) {
+// Endpoints must be on chainID, and bake one of protocols; either may be empty to accept any
+func New(db storage.Store, tbb int, chainID string, protocols []string, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient, error) {
 
 	timeBetweenBlocks = tbb
+	networkChainID = chainID
+	knownProtocols = protocols
 	globalShutdown = shutdown
 	waitGroup = wg
 
@@ -57,21 +90,22 @@ func New(tbb int, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient,
 	newBaconClient := &BaconClient{
 		NewBlockNotifier: make(chan *rpc.Block, 1),
 		rpcClients:       make([]*BaconSlice, 0),
+		seenHeads:        make(map[string]seenHead),
 		Status:           &BaconStatus{},
 	}
 
 	// Init bacon signer
-	signer, err := baconsigner.New()
+	signer, err := baconsigner.New(db)
 	if err != nil {
 		return nil, errors.Wrap(err, "Cannot init bacon signer")
 	}
 	newBaconClient.Signer = signer
 
 	// Pull endpoints from storage
-	endpoints, err := storage.DB.GetRPCEndpoints()
+	endpoints, err := db.GetRPCEndpointConfigs()
 	if err != nil {
 		log.WithError(err).Error("Unable to get endpoints")
-		return nil, errors.Wrap(err, "Failed DB.GetRPCEndpoints")
+		return nil, errors.Wrap(err, "Failed GetRPCEndpointConfigs")
 	}
 
 	if len(endpoints) < 1 {
@@ -81,7 +115,16 @@ func New(tbb int, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient,
 
 	// For each RPC client, thread off a polling monitor.
 	for id, e := range endpoints {
-		newBaconClient.AddRpc(id, e)
+
+		if e.Disabled {
+			log.WithField("Endpoint", storage.RedactURL(e.Url)).Info("Endpoint disabled; Not connecting")
+			continue
+		}
+
+		if err := newBaconClient.AddRpc(id, e); err != nil {
+			log.WithField("Endpoint", storage.RedactURL(e.Url)).WithError(err).Error("Unable to add endpoint")
+			continue
+		}
 
 		// Small throttle to offset each poller
 		<-time.After(2 * time.Second)
@@ -90,145 +133,103 @@ func New(tbb int, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient,
 	return newBaconClient, nil
 }
 
-func (b *BaconClient) AddRpc(rpcId int, rpcEndpointUrl string) {
-
-	active := true
-
-	gtRpc, err := rpc.New(rpcEndpointUrl)
-	if err != nil {
-		log.WithField("Endpoint", rpcEndpointUrl).WithError(err).Error("Error from RPC")
-		active = false
-	} else {
-		log.WithField("Endpoint", rpcEndpointUrl).Info("Connected to RPC")
-	}
-
-	newBaconSlice := &BaconSlice{
-		gtRpc,
-		rpcId,
-		active,
-		make(chan interface{}, 1), // For shutting down individual BaconSlices
-	}
-
-	// Add client to list
-	b.rpcClients = append(b.rpcClients, newBaconSlice)
-
-	// Launch client
-	waitGroup.Add(1)
-	go b.blockWatch(newBaconSlice)
-}
-
 func (b *BaconClient) Shutdown() {
 	b.Signer.Close()
 }
 
-func (b *BaconClient) ShutdownRpc(rpcId int) error {
-
-	newClients := make([]*BaconSlice, 0)
-
-	// Iterate through list of rpc clients (BaconSlices) and find matching id
-	for _, bslice := range b.rpcClients {
-		if bslice.clientId == rpcId {
-			close(bslice.shutdown)
-		} else {
-			newClients = append(newClients, bslice) // save those that did not match
-		}
-	}
-
-	// new list with match removed
-	// TODO: Is this a memory leak?
-	b.rpcClients = newClients
-
-	return nil
-}
-
+// Follows new heads of one endpoint. Heads are streamed from /monitor/heads as they
+// arrive; while the stream is down, /head is polled every half block instead.
 func (b *BaconClient) blockWatch(client *BaconSlice) {
 
 	defer waitGroup.Done()
 
 	lostTicks := 0
 
+	heads := make(chan streamHead, 1)
+
+	waitGroup.Add(1)
+	go b.streamHeads(client, heads)
+
 	// Get network constant time_between_blocks and set sleep-ticker to 50%
 	sleepTime := time.Duration(timeBetweenBlocks / 2)
 	ticker := time.NewTicker(sleepTime * time.Second)
+	defer ticker.Stop()
 
-	log.WithField("Endpoint", client.Host).Info("Blockwatch running...")
-
-	for {
-
-		var block *rpc.Block
-		var err error
+	log.WithField("Endpoint", storage.RedactURL(client.Host)).Info("Blockwatch running...")
 
-		if lostTicks > 4 {
-			log.WithField("Endpoint", client.Host).Warn("Lost Sync, Marking inactive")
-			client.isActive = false
-		}
+	// Check network, protocol and sync state on connect, then every ENDPOINT_CHECK_INTERVAL
+	b.checkEndpoint(client)
+	lastCheck := time.Now()
 
-		// watch for new head block
-		_, block, err = client.Block(&rpc.BlockIDHead{})
-		if err != nil {
+	// Poll once on startup; the stream only sends the next head
+	poll := true
 
-			log.
-				WithField("Endpoint", client.Host).
-				WithError(err).
-				Error("Unable to get /head; Will try again")
+	for {
 
-		} else {
+		if poll && !client.heads.isStreaming() && !client.health.circuitOpen() {
 
-			// If just fetched block is current with others, then this client
-			// is in sync with other clients.
-			if b.Status.Level == block.Metadata.Level.Level {
+			// watch for new head block
+			_, block, err := client.Block(&rpc.BlockIDHead{})
+			if err != nil {
 
-				lostTicks = 0
+				log.
+					WithField("Endpoint", storage.RedactURL(client.Host)).
+					WithError(err).
+					Error("Unable to get /head; Will try again")
 
-			} else if block.Metadata.Level.Level > b.Status.Level &&
-				block.Hash != b.Status.Hash {
+			} else {
 
-				lostTicks = 0
-				client.isActive = true
+				b.newHead(client, streamHead{
+					Hash:      block.Hash,
+					Level:     block.Metadata.Level.Level,
+					Timestamp: block.Header.Timestamp,
+				}, block)
+			}
+		}
 
-				if client.isActive {
+		// If this client's head is current with others, then this client
+		// is in sync with other clients.
+		if poll {
 
-					// notify new block
-					b.NewBlockNotifier <- block
+			_, statusLevel, _ := b.Status.Head()
 
-					b.lock.Lock()
-					b.Status.Hash = block.Hash
-					b.Status.Level = block.Metadata.Level.Level
-					b.Status.Cycle = block.Metadata.Level.Cycle
-					b.Status.CyclePosition = block.Metadata.Level.CyclePosition
+			if level := client.heads.lastLevel(); level >= statusLevel {
 
-					if b.Current != client {
-						log.WithField("Endpoint", client.Host).Warn("Switched active RPC")
-						b.Current = client
-					}
+				lostTicks = 0
+				client.heads.setInSync(true)
 
-					b.lock.Unlock()
+			} else {
 
-					log.WithFields(log.Fields{
-						"Cycle":   block.Metadata.Level.Cycle,
-						"Level":   block.Metadata.Level.Level,
-						"Hash":    block.Hash,
-						"ChainID": block.ChainID,
-					}).Info("New Block")
-				}
+				lostTicks += 1
 
-			} else {
 				log.WithFields(log.Fields{
-					"Endpoint": client.Host, "Fetched": block.Metadata.Level.Level, "Current": b.Status.Level, "Lost": lostTicks,
+					"Endpoint": storage.RedactURL(client.Host), "Fetched": level, "Current": statusLevel, "Lost": lostTicks,
 				}).Trace("Endpoint Out of Sync")
-				lostTicks += 1
+			}
+
+			if lostTicks > 4 && client.heads.setInSync(false) {
+				log.WithField("Endpoint", storage.RedactURL(client.Host)).Warn("Lost Sync, Marking inactive")
 			}
 		}
 
-		// wait here for timer, or shutdown
+		// wait here for timer, streamed head, or shutdown
 		select {
 		case <-ticker.C:
 			log.WithField("Id", client.clientId).Debug("tick...")
+			poll = true
+
+			if time.Since(lastCheck) >= ENDPOINT_CHECK_INTERVAL {
+				b.checkEndpoint(client)
+				lastCheck = time.Now()
+			}
+		case head := <-heads:
+			b.newHead(client, head, nil)
+			poll = false
 		case <-client.shutdown:
-			log.WithField("Endpoint", client.Host).Info("Shutting down RPC client")
+			log.WithField("Endpoint", storage.RedactURL(client.Host)).Info("Shutting down RPC client")
 			return
 		case <-globalShutdown:
-			log.WithField("Endpoint", client.Host).Info("(Global) Shutting down RPC client")
+			log.WithField("Endpoint", storage.RedactURL(client.Host)).Info("(Global) Shutting down RPC client")
 			return
 		}
 	}
@@ -238,16 +239,26 @@ func (b *BaconClient) CanBake(silentChecks bool) bool {
 
 	// Always check status of signer, especially important for Ledger
 	if err := b.Signer.SignerStatus(silentChecks); err != nil {
-		b.Status.SetState(NO_SIGNER)
+		if errors.Cause(err) == baconsigner.WALLET_LOCKED {
+			b.Status.SetState(WALLET_LOCKED)
+		} else {
+			b.Status.SetState(NO_SIGNER)
+		}
 		b.Status.SetError(err)
-		notifications.N.Send(err.Error(), notifications.SIGNER)
+		if b.setSignerError(err.Error()) {
+			notifications.N.Send(err.Error(), notifications.SIGNER)
+		}
 		log.WithError(err).Error("Checking signer status")
 		return false
 	}
 
+	if b.setSignerError("") {
+		notifications.N.Send("Signer is available again", notifications.SIGNER)
+	}
+
 	// The remaining checks of being registered with Tezos network, and having
 	// an appropriate balance happen on startup and can be cached
-	if b.Status.State == CAN_BAKE {
+	if b.Status.CurrentState() == CAN_BAKE {
 		return true
 	}
 
@@ -276,6 +287,17 @@ func (b *BaconClient) CanBake(silentChecks bool) bool {
 	return true
 }
 
+// Returns true if the signer error changed
+func (b *BaconClient) setSignerError(e string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	changed := e != b.signerError
+	b.signerError = e
+
+	return changed
+}
+
 // Check if the baker is registered as a baker
 func (b *BaconClient) CheckBakerRegistered() error {
 
@@ -286,7 +308,12 @@ func (b *BaconClient) CheckBakerRegistered() error {
 		ContractID: pkh,
 	}
 
-	resp, contract, err := b.Current.Contract(cdi)
+	client, err := b.currentClient()
+	if err != nil {
+		return err
+	}
+
+	resp, contract, err := client.Contract(cdi)
 
 	log.WithFields(log.Fields{
 		"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -316,7 +343,12 @@ func (b *BaconClient) CheckDelegateBalance() error {
 		Delegate: b.Signer.BakerPkh,
 	}
 
-	resp, delegateBalance, err := b.Current.DelegateBalance(dbi)
+	client, err := b.currentClient()
+	if err != nil {
+		return err
+	}
+
+	resp, delegateBalance, err := client.DelegateBalance(dbi)
 
 	log.WithFields(log.Fields{
 		"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -347,7 +379,12 @@ func (b *BaconClient) GetSpendableBalance() (int, error) {
 		Delegate: b.Signer.BakerPkh,
 	}
 
-	resp, delegateInfo, err := b.Current.Delegate(di)
+	client, err := b.currentClient()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, delegateInfo, err := client.Delegate(di)
 
 	log.WithFields(log.Fields{
 		"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -379,7 +416,12 @@ func (b *BaconClient) IsRevealed() (bool, error) {
 		ContractID: b.Signer.BakerPkh,
 	}
 
-	resp, manager, err := b.Current.ContractManagerKey(cmki)
+	client, err := b.currentClient()
+	if err != nil {
+		return false, err
+	}
+
+	resp, manager, err := client.ContractManagerKey(cmki)
 
 	log.WithFields(log.Fields{
 		"Request": resp.Request.URL, "Response": string(resp.Body()), "Manager": manager,
@@ -395,7 +437,7 @@ func (b *BaconClient) IsRevealed() (bool, error) {
 	}
 
 	// Sanity check
-	if strings.HasPrefix(manager, "edpk") {
+	if strings.HasPrefix(manager, "edpk") || strings.HasPrefix(manager, "sppk") || strings.HasPrefix(manager, "p2pk") {
 		log.WithField("PK", manager).Info("Found public key for baker")
 		return true, nil
 	}
@@ -410,8 +452,13 @@ func (b *BaconClient) RegisterBaker() (string, error) {
 
 	pkh := b.Signer.BakerPkh
 
+	client, err := b.currentClient()
+	if err != nil {
+		return "", err
+	}
+
 	// Need counter
-	resp, counter, err := b.Current.ContractCounter(rpc.ContractCounterInput{
+	resp, counter, err := client.ContractCounter(rpc.ContractCounterInput{
 		BlockID:    &rpc.BlockIDHead{},
 		ContractID: pkh,
 	})
@@ -482,7 +529,9 @@ func (b *BaconClient) RegisterBaker() (string, error) {
 	//
 	// Forge the operation(s)
 	//
-	encodedOperation, err := forge.Encode(b.Status.Hash, registrationContents...) // Returns string hex-encoded operation
+	headHash, _, _ := b.Status.Head()
+
+	encodedOperation, err := forge.Encode(headHash, registrationContents...) // Returns string hex-encoded operation
 	if err != nil {
 		return "", errors.Wrap(err, "Unable to register baker")
 	}
@@ -496,10 +545,19 @@ func (b *BaconClient) RegisterBaker() (string, error) {
 
 	//
 	// Inject operation
-	_, ophash, err := b.Current.InjectionOperation(rpc.InjectionOperationInput{
+	injector, err := b.injectorClient()
+	if err != nil {
+		return "", err
+	}
+
+	resp, ophash, err := injector.InjectionOperation(rpc.InjectionOperationInput{
 		Operation: signerResult.SignedOperation,
 	})
 	if err != nil {
+		err = DecodeRPCError(resp, err)
+		if IsCounterInThePast(err) {
+			return "", errors.Wrap(err, "Operation counter already used; A previous registration may have been included, check baker status before retrying")
+		}
 		return "", errors.Wrap(err, "Failed to inject registration")
 	}
 
@@ -520,7 +578,9 @@ func (b *BaconClient) UpvoteProposal(proposal string, period int) (string, error
 
 	log.WithField("RPC", proposalVote).Debug("RPC-PROPOSAL")
 
-	encodedOperation, err := forge.Encode(b.Status.Hash, proposalVote)
+	headHash, _, _ := b.Status.Head()
+
+	encodedOperation, err := forge.Encode(headHash, proposalVote)
 	if err != nil {
 		return "", err
 	}
@@ -533,11 +593,16 @@ func (b *BaconClient) UpvoteProposal(proposal string, period int) (string, error
 
 	//
 	// Inject operation
-	_, ophash, err := b.Current.InjectionOperation(rpc.InjectionOperationInput{
+	injector, err := b.injectorClient()
+	if err != nil {
+		return "", err
+	}
+
+	resp, ophash, err := injector.InjectionOperation(rpc.InjectionOperationInput{
 		Operation: signerResult.SignedOperation,
 	})
 	if err != nil {
-		return "", errors.Wrap(err, "Failed to inject proposal upvote")
+		return "", errors.Wrap(DecodeRPCError(resp, err), "Failed to inject proposal upvote")
 	}
 
 	// Success
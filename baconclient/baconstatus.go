@@ -1,5 +1,9 @@
 package baconclient
 
+import (
+	"sync"
+)
+
 const (
 
 	// Various states for the UI to take action
@@ -7,9 +11,11 @@ const (
 	LOW_BALANCE    = "lowbal"
 	NOT_REGISTERED = "noreg"
 	NO_SIGNER      = "nosign"
+	WALLET_LOCKED  = "locked"
 )
 
-type BaconStatus struct {
+// Fields of BaconStatus; a copy is returned by Snapshot
+type StatusSnapshot struct {
 	Network       string `json:"net"`
 	Hash          string `json:"hash"`
 	Level         int    `json:"level"`
@@ -35,37 +41,99 @@ type BaconStatus struct {
 	ErrorMsg string `json:"error"`
 }
 
+// Status of the baker and the chain, for the UI. Updated by the block watchers,
+// bakes and endorsements, and read by the API; access through the methods.
+type BaconStatus struct {
+	StatusSnapshot
+
+	lock sync.RWMutex
+}
+
+// Snapshot returns a copy of the status, for the API
+func (b *BaconStatus) Snapshot() StatusSnapshot {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.StatusSnapshot
+}
+
+// Head returns the hash, level and cycle of the current head
+func (b *BaconStatus) Head() (string, int, int) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.Hash, b.Level, b.Cycle
+}
+
+func (b *BaconStatus) CurrentState() string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.State
+}
+
+func (b *BaconStatus) setHead(hash string, level, cycle, cyclePosition int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.Hash = hash
+	b.Level = level
+	b.Cycle = cycle
+	b.CyclePosition = cyclePosition
+}
+
 func (b *BaconStatus) SetNextEndorsement(level, cycle int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.NextEndorsementLevel = level
 	b.NextEndorsementCycle = cycle
 }
 
 func (b *BaconStatus) SetNextBake(level, cycle, priority int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.NextBakingLevel = level
 	b.NextBakingCycle = cycle
 	b.NextBakingPriority = priority
 }
 
 func (b *BaconStatus) SetRecentEndorsement(level, cycle int, hash string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.PreviousEndorsementLevel = level
 	b.PreviousEndorsementCycle = cycle
 	b.PreviousEndorsementHash = hash
 }
 
 func (b *BaconStatus) SetRecentBake(level, cycle int, hash string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.PreviousBakeLevel = level
 	b.PreviousBakeCycle = cycle
 	b.PreviousBakeHash = hash
 }
 
 func (b *BaconStatus) SetError(e error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.ErrorMsg = e.Error()
 }
 
 func (b *BaconStatus) ClearError() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.ErrorMsg = ""
 }
 
 func (b *BaconStatus) SetState(s string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.State = s
 }
@@ -0,0 +1,169 @@
+package baconclient
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/notifications"
+)
+
+const (
+	CONSENSUS_KEY_FEE       = 400
+	CONSENSUS_KEY_GAS_LIMIT = 1100
+)
+
+// Checks if the protocol supports consensus keys (Lima and later) by querying the baker's
+// consensus key. Older protocols do not have this RPC and return 404.
+func (b *BaconClient) ConsensusKeySupported() (bool, error) {
+
+	current, err := b.currentClient()
+	if err != nil {
+		return false, err
+	}
+
+	url := fmt.Sprintf("%s/chains/main/blocks/head/context/delegates/%s/consensus_key", current.Host, b.Signer.BakerPkh)
+
+	client := *current.httpClient()
+	client.Timeout = 10 * time.Second
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to query consensus key")
+	}
+	defer resp.Body.Close()
+
+	log.WithFields(log.Fields{
+		"Request": url, "Status": resp.StatusCode,
+	}).Debug("Checking consensus key support")
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, errors.Errorf("Unexpected status %d querying consensus key", resp.StatusCode)
+}
+
+// Registers the staged consensus key on chain. The key is saved as pending, and
+// BaconSigner switches to it at the activation cycle. Returns the operation hash
+// and the activation cycle.
+func (b *BaconClient) RegisterConsensusKey(passphrase string) (string, int, error) {
+
+	supported, err := b.ConsensusKeySupported()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if !supported {
+		return "", 0, errors.New("Current protocol does not support consensus keys")
+	}
+
+	// Encrypt first; no point injecting if we cannot save the key afterwards
+	esk, err := b.Signer.EncryptStagedConsensusKey(passphrase)
+	if err != nil {
+		return "", 0, err
+	}
+
+	client, err := b.currentClient()
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, counter, err := client.ContractCounter(rpc.ContractCounterInput{
+		BlockID:    &rpc.BlockIDHead{},
+		ContractID: b.Signer.BakerPkh,
+	})
+
+	log.WithFields(log.Fields{
+		"Request": resp.Request.URL, "Response": string(resp.Body()),
+	}).Debug("Fetching contract metadata")
+
+	if err != nil {
+		return "", 0, errors.Wrap(err, "Unable to fetch contract metadata")
+	}
+
+	// Constants are not loaded if the endpoint was down when added
+	_, constants, err := client.Constants(rpc.ConstantsInput{BlockID: &rpc.BlockIDHead{}})
+	if err != nil {
+		return "", 0, errors.Wrap(err, "Unable to fetch network constants")
+	}
+
+	// Key becomes active after the same delay as baking rights
+	headHash, _, cycle := b.Status.Head()
+	activationCycle := cycle + constants.PreservedCycles + 1
+
+	encodedOperation, err := b.Signer.ForgeUpdateConsensusKey(headHash, CONSENSUS_KEY_FEE, counter+1, CONSENSUS_KEY_GAS_LIMIT, 0)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "Unable to forge consensus key update")
+	}
+
+	signerResult, err := b.Signer.SignUpdateConsensusKey(encodedOperation)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "Unable to sign consensus key update")
+	}
+
+	injector, err := b.injectorClient()
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, ophash, err := injector.InjectionOperation(rpc.InjectionOperationInput{
+		Operation: signerResult.SignedOperation,
+	})
+	if err != nil {
+		err = DecodeRPCError(resp, err)
+		if IsCounterInThePast(err) {
+			return "", 0, errors.Wrap(err, "Operation counter already used; A previous update may have been included, check consensus key status before retrying")
+		}
+		return "", 0, errors.Wrap(err, "Failed to inject consensus key update")
+	}
+
+	if err := b.Signer.SavePendingConsensusKey(esk, activationCycle); err != nil {
+		return ophash, activationCycle, err
+	}
+
+	return ophash, activationCycle, nil
+}
+
+// Called on each new block. Notifies the cycle before a pending consensus key activates,
+// and switches BaconSigner to the new key at the activation cycle.
+func (b *BaconClient) CheckConsensusKey(cycle int) {
+
+	status := b.Signer.ConsensusKeyStatus()
+	if status.PendingPkh == "" {
+		return
+	}
+
+	if cycle == status.ActivationCycle-1 && b.consensusNotifiedCycle != cycle {
+
+		b.consensusNotifiedCycle = cycle
+
+		msg := fmt.Sprintf("Consensus key %s activates next cycle, %d", status.PendingPkh, status.ActivationCycle)
+		if status.Locked {
+			msg += "; Unlock the wallet before then"
+		}
+
+		log.WithField("Cycle", cycle).Warn(msg)
+		notifications.N.Send(msg, notifications.CONSENSUS_KEY)
+	}
+
+	pkh, err := b.Signer.ActivateConsensusKey(cycle)
+	if err != nil {
+		log.WithError(err).Error("Unable to switch consensus key")
+		notifications.N.Send(err.Error(), notifications.CONSENSUS_KEY)
+		return
+	}
+
+	if pkh != "" {
+		notifications.N.Send("Switched to consensus key "+pkh+" in cycle "+strconv.Itoa(cycle), notifications.CONSENSUS_KEY)
+	}
+}
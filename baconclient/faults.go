@@ -0,0 +1,309 @@
+package baconclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// One fault of a scenario. A request matches if its method and path match, and it
+// goes to one of endpoints; the first Skip matches pass through, then the fault is
+// injected Count times (0 is forever), each time with Probability (0 is always).
+//
+// Faults, applied in this order: LatencyMs delays the request; Status answers with
+// that HTTP status without reaching the node; Stale answers with the first response
+// the node gave to the same request, ie: a head which does not move; Truncate cuts
+// the body of the node's response in half; DropAfterMs breaks the response body,
+// ie: the /monitor/heads stream, after that long.
+type Fault struct {
+	Name        string  `json:"name"`
+	Method      string  `json:"method"`
+	Path        string  `json:"path"` // path.Match pattern, ie: /chains/main/blocks/*/helpers/baking_rights
+	Endpoints   []int   `json:"endpoints"`
+	Skip        int     `json:"skip"`
+	Count       int     `json:"count"`
+	Probability float64 `json:"probability"`
+
+	LatencyMs   int  `json:"latency_ms"`
+	Status      int  `json:"status"`
+	Stale       bool `json:"stale"`
+	Truncate    bool `json:"truncate"`
+	DropAfterMs int  `json:"drop_after_ms"`
+
+	matched int
+}
+
+// FaultScenario is a list of faults injected between BaconClient and its endpoints,
+// for testing how BakinBacon copes with misbehaving nodes. Seed makes Probability
+// repeatable.
+type FaultScenario struct {
+	Seed   int64    `json:"seed"`
+	Faults []*Fault `json:"faults"`
+
+	random *rand.Rand
+	stale  map[string]staleResponse
+	lock   sync.Mutex
+}
+
+// First response of the node to a request matching a Stale fault
+type staleResponse struct {
+	header http.Header
+	body   []byte
+}
+
+// Set from main at startup; if not nil, requests of each endpoint go through the scenario
+var faultScenario *FaultScenario
+
+func SetFaultScenario(s *FaultScenario) {
+	faultScenario = s
+}
+
+// LoadFaultScenario reads a JSON scenario file
+func LoadFaultScenario(file string) (*FaultScenario, error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read fault scenario")
+	}
+
+	s := &FaultScenario{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse fault scenario")
+	}
+
+	for i, f := range s.Faults {
+
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("fault-%d", i)
+		}
+
+		if _, err := path.Match(f.Path, "/"); err != nil {
+			return nil, errors.Wrapf(err, "Invalid path of fault %s", f.Name)
+		}
+
+		if f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+			return nil, errors.Errorf("Invalid status %d of fault %s", f.Status, f.Name)
+		}
+	}
+
+	return s, nil
+}
+
+// The fault to inject into req to endpoint rpcId, if any
+func (s *FaultScenario) match(req *http.Request, rpcId int) *Fault {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.random == nil {
+		s.random = rand.New(rand.NewSource(s.Seed))
+	}
+
+	for _, f := range s.Faults {
+
+		if f.Method != "" && f.Method != req.Method {
+			continue
+		}
+
+		if ok, _ := path.Match(f.Path, req.URL.Path); f.Path != "" && !ok {
+			continue
+		}
+
+		if len(f.Endpoints) > 0 && !containsId(f.Endpoints, rpcId) {
+			continue
+		}
+
+		f.matched++
+
+		if f.matched <= f.Skip || (f.Count > 0 && f.matched > f.Skip+f.Count) {
+			continue
+		}
+
+		if f.Probability > 0 && s.random.Float64() >= f.Probability {
+			continue
+		}
+
+		return f
+	}
+
+	return nil
+}
+
+func containsId(ids []int, id int) bool {
+
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Injects the faults of a scenario into the requests of one endpoint
+type faultTransport struct {
+	next     http.RoundTripper
+	scenario *FaultScenario
+	rpcId    int
+}
+
+func newFaultTransport(next http.RoundTripper, scenario *FaultScenario, rpcId int) *faultTransport {
+	return &faultTransport{
+		next:     next,
+		scenario: scenario,
+		rpcId:    rpcId,
+	}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	f := t.scenario.match(req, t.rpcId)
+	if f == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	log.WithFields(log.Fields{
+		"Fault": f.Name, "Endpoint": t.rpcId, "Request": req.URL.Path,
+	}).Debug("Injecting fault")
+
+	if f.LatencyMs > 0 {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(time.Duration(f.LatencyMs) * time.Millisecond):
+		}
+	}
+
+	if f.Status != 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return faultResponse(req, f.Status, http.Header{"Content-Type": {"text/plain"}}, []byte(fmt.Sprintf("Fault injected: %s\n", f.Name))), nil
+	}
+
+	key := fmt.Sprintf("%d %s %s", t.rpcId, req.Method, req.URL.RequestURI())
+
+	if f.Stale {
+		t.scenario.lock.Lock()
+		stale, found := t.scenario.stale[key]
+		t.scenario.lock.Unlock()
+
+		if found {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return faultResponse(req, http.StatusOK, stale.header.Clone(), stale.body), nil
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if f.Stale || f.Truncate {
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Stale && resp.StatusCode == http.StatusOK {
+			t.scenario.lock.Lock()
+			if t.scenario.stale == nil {
+				t.scenario.stale = make(map[string]staleResponse)
+			}
+			if _, found := t.scenario.stale[key]; !found {
+				t.scenario.stale[key] = staleResponse{resp.Header.Clone(), body}
+			}
+			t.scenario.lock.Unlock()
+		}
+
+		if f.Truncate {
+			body = body[:len(body)/2]
+			resp.Header.Del("Content-Length")
+		}
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+	}
+
+	if f.DropAfterMs > 0 {
+		resp.Body = newDroppingBody(resp.Body, time.Duration(f.DropAfterMs)*time.Millisecond)
+	}
+
+	return resp, nil
+}
+
+func faultResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Response body which fails, as if the connection was lost, after a delay
+type droppingBody struct {
+	body    io.ReadCloser
+	timer   *time.Timer
+	dropped chan struct{}
+}
+
+func newDroppingBody(body io.ReadCloser, after time.Duration) *droppingBody {
+
+	d := &droppingBody{
+		body:    body,
+		dropped: make(chan struct{}),
+	}
+
+	// Closing the body unblocks a pending Read, ie: of a stream waiting for the next head
+	d.timer = time.AfterFunc(after, func() {
+		close(d.dropped)
+		d.body.Close()
+	})
+
+	return d
+}
+
+func (d *droppingBody) Read(p []byte) (int, error) {
+
+	select {
+	case <-d.dropped:
+		return 0, io.ErrUnexpectedEOF
+	default:
+	}
+
+	n, err := d.body.Read(p)
+
+	select {
+	case <-d.dropped:
+		return n, io.ErrUnexpectedEOF
+	default:
+	}
+
+	return n, err
+}
+
+func (d *droppingBody) Close() error {
+	d.timer.Stop()
+	return d.body.Close()
+}
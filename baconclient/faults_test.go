@@ -0,0 +1,189 @@
+package baconclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+func loadScenario(t *testing.T, scenario string) *FaultScenario {
+
+	file := filepath.Join(t.TempDir(), "scenario.json")
+	if err := ioutil.WriteFile(file, []byte(scenario), 0600); err != nil {
+		t.Fatalf("Unable to write scenario: %s", err)
+	}
+
+	s, err := LoadFaultScenario(file)
+	if err != nil {
+		t.Fatalf("Unable to load scenario: %s", err)
+	}
+
+	return s
+}
+
+func TestFaultTransport(t *testing.T) {
+
+	var level int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/monitor/heads/main":
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/chains/main/blocks/head/header":
+			fmt.Fprintf(w, `{"level":%d}`, atomic.AddInt32(&level, 1))
+		default:
+			w.Write([]byte(`"NetXz969SFaFn8k"`))
+		}
+	}))
+	defer srv.Close()
+
+	s := loadScenario(t, `{"seed": 1, "faults": [
+		{"name": "slow", "path": "/chains/main/blocks/head/hash", "latency_ms": 100},
+		{"name": "unavailable", "method": "GET", "path": "/chains/main/chain_id", "skip": 1, "count": 2, "status": 503},
+		{"name": "stuck", "path": "/chains/main/blocks/head/header", "stale": true},
+		{"name": "cut", "path": "/chains/*/checkpoint", "truncate": true},
+		{"name": "dropped", "path": "/monitor/heads/main", "drop_after_ms": 100},
+		{"name": "other", "path": "/chains/main/blocks/head/protocols", "endpoints": [1], "status": 500}
+	]}`)
+
+	client := &http.Client{Transport: newFaultTransport(http.DefaultTransport, s, 0)}
+
+	get := func(path string) (int, string) {
+
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %s", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(body)
+	}
+
+	start := time.Now()
+	if get("/chains/main/blocks/head/hash"); time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Latency not injected")
+	}
+
+	var statuses []int
+	for i := 0; i < 4; i++ {
+		status, _ := get("/chains/main/chain_id")
+		statuses = append(statuses, status)
+	}
+
+	if fmt.Sprint(statuses) != "[200 503 503 200]" {
+		t.Errorf("Unexpected statuses %v; expected 200 503 503 200", statuses)
+	}
+
+	_, first := get("/chains/main/blocks/head/header")
+	_, second := get("/chains/main/blocks/head/header")
+	if first != `{"level":1}` || second != first || atomic.LoadInt32(&level) != 1 {
+		t.Errorf("Stale header not repeated: %s, %s", first, second)
+	}
+
+	if _, body := get("/chains/main/checkpoint"); body != `"NetXz96` {
+		t.Errorf("Unexpected truncated body %q", body)
+	}
+
+	// Other endpoint only
+	if status, _ := get("/chains/main/blocks/head/protocols"); status != http.StatusOK {
+		t.Errorf("Fault of endpoint 1 injected into endpoint 0")
+	}
+
+	resp, err := client.Get(srv.URL + "/monitor/heads/main")
+	if err != nil {
+		t.Fatalf("Unable to follow heads: %s", err)
+	}
+	defer resp.Body.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("Unexpected error of dropped stream: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Stream not dropped")
+	}
+}
+
+func TestLoadFaultScenario(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "scenario.json")
+
+	for _, scenario := range []string{
+		`{"faults": [{"path": "/chains/[main"}]}`,
+		`{"faults": [{"path": "/chains/main/chain_id", "status": 42}]}`,
+		`{"faults": [`,
+	} {
+		ioutil.WriteFile(file, []byte(scenario), 0600)
+
+		if _, err := LoadFaultScenario(file); err == nil {
+			t.Errorf("Invalid scenario loaded: %s", scenario)
+		}
+	}
+}
+
+// The node fails to answer, then answers badly, then recovers; so must the state
+func TestFaultsRecoverCanBake(t *testing.T) {
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	db.SetDelegate("edsk3yXukqCQXjCnS4KRKEiotS7wRZPoKuimSJmWnfH2m3a2krJVdf", "tz1MTZEJE7YH3wzo8YYiAGd8sgiCTxNRHczR")
+	db.SetSignerType(baconsigner.SIGNER_WALLET)
+
+	if err := notifications.New(db); err != nil {
+		t.Fatalf("Unable to init notifications: %s", err)
+	}
+
+	signer, err := baconsigner.New(db)
+	if err != nil {
+		t.Fatalf("Unable to init signer: %s", err)
+	}
+	signer.LoadDelegate(true)
+
+	replay, err := LoadFixtures("testdata/canbake-granadanet.jsonl")
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	s := loadScenario(t, `{"faults": [
+		{"path": "/chains/main/blocks/*/context/contracts/*", "count": 2, "status": 503},
+		{"path": "/chains/main/blocks/*/context/delegates/*/balance", "count": 1, "truncate": true}
+	]}`)
+
+	bc := NewReplay(newFaultTransport(replay, s, 0), signer)
+
+	var states []string
+	for i := 0; i < 5; i++ {
+		bc.CanBake(true)
+		states = append(states, bc.Status.CurrentState())
+	}
+
+	expected := []string{NOT_REGISTERED, NOT_REGISTERED, LOW_BALANCE, CAN_BAKE, CAN_BAKE}
+	if strings.Join(states, " ") != strings.Join(expected, " ") {
+		t.Errorf("Unexpected states %v; expected %v", states, expected)
+	}
+
+	if misses := replay.Misses(); len(misses) > 0 {
+		t.Errorf("Requests without fixture: %v", misses)
+	}
+}
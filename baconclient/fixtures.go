@@ -0,0 +1,311 @@
+package baconclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconsigner"
+)
+
+// One request to an endpoint and its response, as stored in fixture files. Bodies
+// are kept as JSON, compacted, when they are JSON; Text is set for other bodies, ie: plain
+// text errors, which are then stored as a JSON string. Headers are not recorded,
+// so credentials of the endpoint are not written to fixtures.
+type Fixture struct {
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+	Text     bool            `json:"text,omitempty"`
+}
+
+// Set from main at startup; if not empty, go-tezos requests of each endpoint are
+// appended to <dir>/endpoint-<id>.jsonl
+var fixtureDir string
+
+func SetFixtureRecorder(dir string) {
+	fixtureDir = dir
+}
+
+// Path and query of a request; fixtures are matched on this and the method
+func fixturePath(req *http.Request) string {
+
+	if req.URL.RawQuery == "" {
+		return req.URL.Path
+	}
+
+	return req.URL.Path + "?" + req.URL.RawQuery
+}
+
+func encodeFixtureBody(b []byte) (json.RawMessage, bool) {
+
+	if len(b) == 0 {
+		return nil, false
+	}
+
+	if json.Valid(b) {
+		return json.RawMessage(bytes.TrimSpace(b)), false
+	}
+
+	s, _ := json.Marshal(string(b))
+
+	return s, true
+}
+
+// Writes each request, and its response, to a fixture file
+type recordingTransport struct {
+	next http.RoundTripper
+	file string
+	lock sync.Mutex
+}
+
+func newRecordingTransport(next http.RoundTripper, dir string, rpcId int) (*recordingTransport, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "Unable to create fixture directory")
+	}
+
+	return &recordingTransport{
+		next: next,
+		file: filepath.Join(dir, fmt.Sprintf("endpoint-%d.jsonl", rpcId)),
+	}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	f := Fixture{
+		Method: req.Method,
+		Path:   fixturePath(req),
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ := ioutil.ReadAll(body)
+			body.Close()
+			f.Request, _ = encodeFixtureBody(reqBody)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	f.Status = resp.StatusCode
+	f.Response, f.Text = encodeFixtureBody(respBody)
+
+	if err := t.write(f); err != nil {
+		log.WithError(err).WithField("File", t.file).Error("Unable to record fixture")
+	}
+
+	return resp, nil
+}
+
+func (t *recordingTransport) write(f Fixture) error {
+
+	line, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	file, err := os.OpenFile(t.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ReplayTransport serves recorded fixtures without a network. Requests are matched
+// on method, path and query; a request recorded more than once is served in the
+// order recorded, and the last response repeats. Requests without a fixture get a
+// 404 and are kept in Misses.
+type ReplayTransport struct {
+	fixtures map[string][]Fixture
+	served   map[string]int
+	misses   []string
+	lock     sync.Mutex
+}
+
+// LoadFixtures reads fixture files, one JSON fixture per line, for replay
+func LoadFixtures(files ...string) (*ReplayTransport, error) {
+
+	t := &ReplayTransport{
+		fixtures: make(map[string][]Fixture),
+		served:   make(map[string]int),
+	}
+
+	for _, name := range files {
+
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to open fixtures")
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+		for line := 1; scanner.Scan(); line++ {
+
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var f Fixture
+			if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+				file.Close()
+				return nil, errors.Wrapf(err, "Invalid fixture at %s:%d", name, line)
+			}
+
+			key := f.Method + " " + f.Path
+			t.fixtures[key] = append(t.fixtures[key], f)
+		}
+
+		err = scanner.Err()
+		file.Close()
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read fixtures %s", name)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	key := req.Method + " " + fixturePath(req)
+
+	t.lock.Lock()
+
+	var f Fixture
+
+	fixtures, found := t.fixtures[key]
+	if found {
+		n := t.served[key]
+		if n >= len(fixtures) {
+			n = len(fixtures) - 1
+		}
+		f = fixtures[n]
+		t.served[key]++
+	} else {
+		t.misses = append(t.misses, key)
+	}
+
+	t.lock.Unlock()
+
+	resp := &http.Response{
+		StatusCode: f.Status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Request:    req,
+	}
+
+	body := []byte(f.Response)
+
+	switch {
+	case !found:
+		resp.StatusCode = http.StatusNotFound
+		resp.Header.Set("Content-Type", "text/plain")
+		body = []byte("No fixture for " + key)
+	case f.Text:
+		var s string
+		_ = json.Unmarshal(f.Response, &s)
+		resp.Header.Set("Content-Type", "text/plain")
+		body = []byte(s)
+	}
+
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// Served returns how many times requests matching method and path, with query, were served
+func (t *ReplayTransport) Served(method, path string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.served[method+" "+path]
+}
+
+// Misses returns the requests, as "METHOD /path?query", for which there was no fixture
+func (t *ReplayTransport) Misses() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return append([]string(nil), t.misses...)
+}
+
+// NewReplay returns a client with a single endpoint, used for reads and injection,
+// whose requests go through transport; ie: a ReplayTransport. Heads are not
+// followed and network constants are not loaded. For tests which call Current or
+// Injector, ie: bakes and registration, against recorded payloads. As for other
+// endpoints, requests go through the fault scenario, if set.
+func NewReplay(transport http.RoundTripper, signer *baconsigner.BaconSigner) *BaconClient {
+
+	if faultScenario != nil {
+		transport = newFaultTransport(transport, faultScenario, 0)
+	}
+
+	gtRpc := &rpc.Client{Host: "http://replay.invalid"}
+	gtRpc.SetChain("main")
+
+	gtRpc.OverrideClient(resty.New().
+		SetHeaders(map[string]string{
+			"User-Agent": "BakinBacon/1.0.1",
+		}).
+		SetTimeout(30 * time.Second).
+		SetTransport(transport))
+
+	client := &BaconSlice{
+		Client:   gtRpc,
+		shutdown: make(chan interface{}),
+		http:     &http.Client{Transport: transport},
+	}
+	client.heads.setInSync(true)
+
+	return &BaconClient{
+		NewBlockNotifier: make(chan *rpc.Block, 1),
+		current:          client,
+		rpcClients:       []*BaconSlice{client},
+		seenHeads:        make(map[string]seenHead),
+		Status:           &BaconStatus{},
+		Signer:           signer,
+	}
+}
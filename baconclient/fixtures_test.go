@@ -0,0 +1,134 @@
+package baconclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+func TestRecordReplay(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chains/main/chain_id":
+			w.Write([]byte(`"NetXz969SFaFn8k"`))
+		case "/injection/operation":
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write([]byte(`"` + string(body[1:9]) + `"`))
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	recorder, err := newRecordingTransport(http.DefaultTransport, dir, 3)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %s", err)
+	}
+
+	// Status and body of each request, through client
+	exchange := func(client *http.Client) []string {
+
+		var results []string
+
+		for _, req := range []struct{ method, path, body string }{
+			{"GET", "/chains/main/chain_id", ""},
+			{"POST", "/injection/operation?chain_id=NetXz969SFaFn8k", `"a1b2c3d4e5"`},
+			{"GET", "/chains/main/blocks/head?metadata=never", ""},
+		} {
+			r, _ := http.NewRequest(req.method, srv.URL+req.path, strings.NewReader(req.body))
+
+			resp, err := client.Do(r)
+			if err != nil {
+				t.Fatalf("Request failed: %s", err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			results = append(results, resp.Status+" "+string(body))
+		}
+
+		return results
+	}
+
+	recorded := exchange(&http.Client{Transport: recorder})
+
+	replay, err := LoadFixtures(dir + "/endpoint-3.jsonl")
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	replayed := exchange(&http.Client{Transport: replay})
+
+	for i := range recorded {
+		if recorded[i] != replayed[i] {
+			t.Errorf("Replayed %q; recorded %q", replayed[i], recorded[i])
+		}
+	}
+
+	if misses := replay.Misses(); len(misses) > 0 {
+		t.Errorf("Requests without fixture: %v", misses)
+	}
+
+	// Not recorded
+	resp, _ := (&http.Client{Transport: replay}).Get(srv.URL + "/chains/main/blocks/head/hash")
+	if resp.StatusCode != http.StatusNotFound || len(replay.Misses()) != 1 {
+		t.Errorf("Unexpected response %s to a request without fixture", resp.Status)
+	}
+}
+
+func TestReplayRegisterBaker(t *testing.T) {
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	db.SetDelegate("edsk3yXukqCQXjCnS4KRKEiotS7wRZPoKuimSJmWnfH2m3a2krJVdf", "tz1MTZEJE7YH3wzo8YYiAGd8sgiCTxNRHczR")
+	db.SetSignerType(baconsigner.SIGNER_WALLET)
+
+	if err := notifications.New(db); err != nil {
+		t.Fatalf("Unable to init notifications: %s", err)
+	}
+
+	// Registration is a manager operation; no confirmation through the API here
+	policy := baconsigner.DefaultPolicy()
+	policy.ConfirmManagerOps = false
+	baconsigner.SetPolicy(policy)
+	t.Cleanup(func() { baconsigner.SetPolicy(baconsigner.DefaultPolicy()) })
+
+	signer, err := baconsigner.New(db)
+	if err != nil {
+		t.Fatalf("Unable to init signer: %s", err)
+	}
+	signer.LoadDelegate(true)
+
+	replay, err := LoadFixtures("testdata/register-granadanet.jsonl")
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	bc := NewReplay(replay, signer)
+	bc.Status.setHead("BLaFKaVAfXczucuUGTfh7VxrqW41uSmKBbJbv4PUgnETjH9iQmY", 331390, 81, 3709)
+
+	// Not revealed; reveal and delegation are injected together
+	opHash, err := bc.RegisterBaker()
+	if err != nil || opHash != "ooYuqTYBd6mNC5KWLEDSKcAWKiw7qWuBrqFDyhsEvBz5j8LLXds" {
+		t.Fatalf("Unexpected registration %q: %v", opHash, err)
+	}
+
+	// Injected again; the node reports the counter was used
+	if _, err := bc.RegisterBaker(); !IsCounterInThePast(err) {
+		t.Errorf("Counter in the past not reported: %v", err)
+	}
+
+	if misses := replay.Misses(); len(misses) > 0 {
+		t.Errorf("Requests without fixture: %v", misses)
+	}
+}
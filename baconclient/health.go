@@ -0,0 +1,444 @@
+package baconclient
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+const (
+	// Requests and injections kept for error rate and latency
+	HEALTH_SAMPLES = 50
+
+	// A new endpoint must score this much higher than Current to replace it
+	SCORE_HYSTERESIS = 15
+
+	// Consecutive failed requests which open the circuit; the endpoint is not
+	// used until the circuit closes again, doubling each time it re-opens
+	CIRCUIT_FAILURES = 5
+	CIRCUIT_MIN_OPEN = 30 * time.Second
+	CIRCUIT_MAX_OPEN = 5 * time.Minute
+
+	// Request latency scoring full marks, and scoring none
+	GOOD_LATENCY = 250 * time.Millisecond
+	BAD_LATENCY  = 3 * time.Second
+)
+
+// Health of an endpoint, for the UI. Score is 0-100; the other factors are 0-1.
+type EndpointHealth struct {
+	Score     int       `json:"score"`
+	Role      string    `json:"role"`
+	Current   bool      `json:"current"`
+	Injector  bool      `json:"injector"`
+	Active    bool      `json:"active"`
+	Circuit   string    `json:"circuit"`
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+
+	// Set when the endpoint is quarantined, ie: wrong network or unknown protocol
+	Quarantined string `json:"quarantined,omitempty"`
+
+	Freshness float64 `json:"freshness"`
+	Latency   float64 `json:"latency"`
+	Errors    float64 `json:"errors"`
+	Injection float64 `json:"injection"`
+
+	Requests        int   `json:"requests"`
+	FailedRequests  int   `json:"failedRequests"`
+	Injections      int   `json:"injections"`
+	FailedInjection int   `json:"failedInjections"`
+	AvgLatency      int64 `json:"avgLatency"`
+
+	Heads HeadStats `json:"heads"`
+}
+
+type requestSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+type endpointHealth struct {
+	lock sync.Mutex
+
+	requests     []requestSample
+	nextRequest  int
+	injections   []bool
+	nextInjected int
+
+	totalRequests   int
+	failedRequests  int
+	totalInjections int
+	failedInjection int
+
+	// Circuit breaker
+	consecutive int
+	openUntil   time.Time
+	openFor     time.Duration
+
+	// Why the endpoint is quarantined; nil if it checks out
+	quarantined error
+}
+
+// Records a request; returns true if it opened the circuit
+func (h *endpointHealth) recordRequest(latency time.Duration, failed bool) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.totalRequests++
+
+	sample := requestSample{latency, failed}
+	if len(h.requests) < HEALTH_SAMPLES {
+		h.requests = append(h.requests, sample)
+	} else {
+		h.requests[h.nextRequest] = sample
+	}
+	h.nextRequest = (h.nextRequest + 1) % HEALTH_SAMPLES
+
+	if !failed {
+		h.consecutive = 0
+		h.openUntil = time.Time{}
+		h.openFor = 0
+		return false
+	}
+
+	h.failedRequests++
+	h.consecutive++
+
+	// Closed, or half-open after the cool down; the probe failed
+	if h.consecutive >= CIRCUIT_FAILURES && !time.Now().Before(h.openUntil) {
+
+		if h.openFor *= 2; h.openFor < CIRCUIT_MIN_OPEN {
+			h.openFor = CIRCUIT_MIN_OPEN
+		} else if h.openFor > CIRCUIT_MAX_OPEN {
+			h.openFor = CIRCUIT_MAX_OPEN
+		}
+
+		h.openUntil = time.Now().Add(h.openFor)
+
+		return true
+	}
+
+	return false
+}
+
+func (h *endpointHealth) recordInjection(ok bool) {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.totalInjections++
+	if !ok {
+		h.failedInjection++
+	}
+
+	if len(h.injections) < HEALTH_SAMPLES {
+		h.injections = append(h.injections, ok)
+	} else {
+		h.injections[h.nextInjected] = ok
+	}
+	h.nextInjected = (h.nextInjected + 1) % HEALTH_SAMPLES
+}
+
+// Returns true if the quarantine changed
+func (h *endpointHealth) setQuarantine(err error) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	changed := (h.quarantined == nil) != (err == nil) ||
+		(err != nil && h.quarantined.Error() != err.Error())
+
+	h.quarantined = err
+
+	return changed
+}
+
+func (h *endpointHealth) isQuarantined() bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.quarantined != nil
+}
+
+// Circuit-broken, or quarantined
+func (h *endpointHealth) unusable() bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.quarantined != nil || time.Now().Before(h.openUntil)
+}
+
+func (h *endpointHealth) circuitOpen() bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return time.Now().Before(h.openUntil)
+}
+
+// Fills the request factors of s
+func (h *endpointHealth) snapshot(s *EndpointHealth) {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.quarantined != nil {
+		s.Quarantined = h.quarantined.Error()
+	}
+
+	s.Circuit = "closed"
+	switch {
+	case time.Now().Before(h.openUntil):
+		s.Circuit = "open"
+		s.OpenUntil = h.openUntil
+	case h.consecutive >= CIRCUIT_FAILURES:
+		s.Circuit = "half-open"
+	}
+
+	s.Requests = h.totalRequests
+	s.FailedRequests = h.failedRequests
+	s.Injections = h.totalInjections
+	s.FailedInjection = h.failedInjection
+
+	s.Latency, s.Errors, s.Injection = 1, 1, 1
+
+	if n := len(h.requests); n > 0 {
+
+		var latency time.Duration
+		failed := 0
+
+		for _, r := range h.requests {
+			latency += r.latency
+			if r.failed {
+				failed++
+			}
+		}
+
+		avg := latency / time.Duration(n)
+		s.AvgLatency = avg.Milliseconds()
+		s.Latency = 1 - clamp(float64(avg-GOOD_LATENCY)/float64(BAD_LATENCY-GOOD_LATENCY))
+		s.Errors = 1 - float64(failed)/float64(n)
+	}
+
+	if n := len(h.injections); n > 0 {
+
+		failed := 0
+		for _, ok := range h.injections {
+			if !ok {
+				failed++
+			}
+		}
+
+		s.Injection = 1 - float64(failed)/float64(n)
+	}
+}
+
+func clamp(f float64) float64 {
+	return math.Max(0, math.Min(1, f))
+}
+
+// Records the latency and result of every RPC request made through go-tezos.
+// Node errors are 5xx; 4xx are answers, ie: a contract not found.
+type healthTransport struct {
+	next   http.RoundTripper
+	health *endpointHealth
+
+	// Called when the circuit opens
+	onOpen func()
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	start := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+
+	failed := err != nil || resp.StatusCode >= 500
+
+	if strings.Contains(req.URL.Path, "/injection/") {
+		t.health.recordInjection(err == nil && resp.StatusCode == http.StatusOK)
+	}
+
+	if t.health.recordRequest(time.Since(start), failed) && t.onOpen != nil {
+		t.onOpen()
+	}
+
+	return resp, err
+}
+
+// Scores an endpoint on head freshness (40%), request errors (25%), request
+// latency (20%) and injection success (15%). Caller must hold the lock.
+func (b *BaconClient) endpointHealth(client *BaconSlice) EndpointHealth {
+
+	s := EndpointHealth{
+		Role:    client.role,
+		Current: client == b.current,
+		Active:  client.heads.inSync(),
+		Heads:   client.heads.snapshot(),
+	}
+
+	client.health.snapshot(&s)
+
+	// Endpoints one block behind are not penalized until half a block after
+	// the head arrived elsewhere; they are usually just slower to report it
+	_, level, _ := b.Status.Head()
+
+	switch lag := level - s.Heads.Level; {
+	case lag <= 0:
+		s.Freshness = 1
+	case lag == 1 && time.Since(b.headArrived) < time.Duration(timeBetweenBlocks)*time.Second/2:
+		s.Freshness = 1
+	case lag == 1:
+		s.Freshness = 0.5
+	}
+
+	// And for how long, on average, it trails the first endpoint to report each head
+	if timeBetweenBlocks > 0 {
+		s.Freshness *= 1 - 0.5*clamp(float64(s.Heads.AvgBehind)/float64(timeBetweenBlocks*1000/2))
+	}
+
+	if !s.Active {
+		s.Freshness = 0
+	}
+
+	if s.Circuit != "open" && s.Quarantined == "" {
+		s.Score = int(math.Round(100 * (0.4*s.Freshness + 0.25*s.Errors + 0.2*s.Latency + 0.15*s.Injection)))
+	}
+
+	return s
+}
+
+// Endpoints used for reads; without a usable one, reads fall back to inject endpoints
+func (c *BaconSlice) canRead() bool {
+	return c.role == "" || c.role == storage.ROLE_BOTH || c.role == storage.ROLE_READ
+}
+
+// Endpoints used for preapply and injection; without a usable one, these fall back
+// to read endpoints
+func (c *BaconSlice) canInject() bool {
+	return c.role == "" || c.role == storage.ROLE_BOTH || c.role == storage.ROLE_INJECT
+}
+
+// Best scoring endpoint of the role, skipping circuit-broken and quarantined ones.
+// Returns the score of keep, or -1 if it is not a usable endpoint of the role.
+// Caller must hold the lock.
+func (b *BaconClient) bestEndpoint(role func(*BaconSlice) bool, keep *BaconSlice) (*BaconSlice, int, int) {
+
+	var (
+		best      *BaconSlice
+		bestScore = -1
+		keepScore = -1
+	)
+
+	for _, c := range b.rpcClients {
+
+		if !role(c) || c.health.unusable() {
+			continue
+		}
+
+		score := b.endpointHealth(c).Score
+		if c == keep {
+			keepScore = score
+		}
+
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+
+	return best, bestScore, keepScore
+}
+
+// Switches Current to the best scoring read endpoint. Current is kept unless it is
+// circuit-broken or quarantined, or another endpoint scores SCORE_HYSTERESIS more, so that
+// endpoints of similar health do not take turns. Caller must hold the lock.
+func (b *BaconClient) selectCurrent() {
+
+	best, bestScore, current := b.bestEndpoint((*BaconSlice).canRead, b.current)
+
+	// All read endpoints down; read from an inject endpoint
+	if best == nil {
+		best, bestScore, current = b.bestEndpoint((*BaconSlice).canInject, b.current)
+	}
+
+	// All endpoints circuit-broken or quarantined; keep what we have
+	if best == nil || best == b.current {
+		return
+	}
+
+	if b.current != nil && current >= 0 && bestScore < current+SCORE_HYSTERESIS {
+		return
+	}
+
+	fields := log.Fields{"Endpoint": storage.RedactURL(best.Host), "Score": bestScore}
+	if b.current != nil {
+		fields["Previous"] = storage.RedactURL(b.current.Host)
+		fields["PreviousScore"] = current
+	}
+
+	log.WithFields(fields).Warn("Switched active RPC")
+
+	b.current = best
+}
+
+// Best inject endpoint; Current is preferred if it can inject, with the same
+// hysteresis. Falls back to read endpoints, then to Current; fallback is true
+// if so. Caller must hold the lock.
+func (b *BaconClient) selectInjector() (injector *BaconSlice, fallback bool) {
+
+	best, bestScore, current := b.bestEndpoint((*BaconSlice).canInject, b.current)
+
+	if best == nil {
+
+		if best, _, _ = b.bestEndpoint((*BaconSlice).canRead, b.current); best == nil {
+			return b.current, true
+		}
+
+		return best, true
+	}
+
+	if current >= 0 && bestScore < current+SCORE_HYSTERESIS {
+		return b.current, false
+	}
+
+	return best, false
+}
+
+// Circuit of client opened; move off it if it was Current
+func (b *BaconClient) circuitOpened(client *BaconSlice) {
+
+	log.WithField("Endpoint", storage.RedactURL(client.Host)).Warn("Too many failed requests; Endpoint circuit-broken")
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.current == client {
+		b.selectCurrent()
+	}
+}
+
+// EndpointHealth returns the health and score of each endpoint, by endpoint id
+func (b *BaconClient) EndpointHealth() map[int]EndpointHealth {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	injector, _ := b.selectInjector()
+
+	health := make(map[int]EndpointHealth, len(b.rpcClients))
+	for _, c := range b.rpcClients {
+		h := b.endpointHealth(c)
+		h.Injector = c == injector
+		health[c.clientId] = h
+	}
+
+	return health
+}
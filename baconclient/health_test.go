@@ -0,0 +1,218 @@
+package baconclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/storage"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	failing := true
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "node is down", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		health endpointHealth
+		opened int
+	)
+
+	client := &http.Client{Transport: &healthTransport{
+		next:   http.DefaultTransport,
+		health: &health,
+		onOpen: func() { opened++ },
+	}}
+
+	get := func(path string) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	for i := 0; i < CIRCUIT_FAILURES-1; i++ {
+		get("/chains/main/blocks/head")
+	}
+
+	if health.circuitOpen() {
+		t.Fatalf("Circuit opened after %d failures", CIRCUIT_FAILURES-1)
+	}
+
+	get("/injection/operation")
+
+	if !health.circuitOpen() || opened != 1 {
+		t.Fatalf("Circuit not opened after %d failures", CIRCUIT_FAILURES)
+	}
+
+	var s EndpointHealth
+	health.snapshot(&s)
+
+	if s.Circuit != "open" || s.FailedRequests != CIRCUIT_FAILURES || s.Errors != 0 || s.Injection != 0 || s.Injections != 1 {
+		t.Errorf("Unexpected health %+v", s)
+	}
+
+	// Cool down passes; failed probe re-opens for longer
+	health.openUntil = time.Now()
+	get("/chains/main/blocks/head")
+
+	if !health.circuitOpen() || opened != 2 || health.openFor != 2*CIRCUIT_MIN_OPEN {
+		t.Errorf("Circuit not re-opened after failed probe; open for %s", health.openFor)
+	}
+
+	// Successful probe closes it
+	failing = false
+	health.openUntil = time.Now()
+	get("/chains/main/blocks/head")
+
+	health.snapshot(&s)
+	if health.circuitOpen() || s.Circuit != "closed" {
+		t.Errorf("Circuit %s after successful probe", s.Circuit)
+	}
+}
+
+func TestSelectCurrent(t *testing.T) {
+
+	timeBetweenBlocks = 30
+
+	newSlice := func(id int) *BaconSlice {
+		return &BaconSlice{Client: &rpc.Client{Host: "http://rpc"}, clientId: id}
+	}
+
+	head := func(c *BaconSlice, level int) {
+		now := time.Now()
+		c.heads.record(streamHead{Hash: fmt.Sprintf("BLock%d", level), Level: level, Timestamp: now}, now, now)
+	}
+
+	a, b := newSlice(1), newSlice(2)
+
+	bc := &BaconClient{
+		Status:      &BaconStatus{StatusSnapshot: StatusSnapshot{Level: 10}},
+		rpcClients:  []*BaconSlice{a, b},
+		headArrived: time.Now(),
+	}
+
+	head(a, 10)
+	head(b, 10)
+
+	bc.selectCurrent()
+	if bc.current == nil {
+		t.Fatalf("No endpoint selected")
+	}
+
+	current, other := bc.current, b
+	if current == b {
+		other = a
+	}
+
+	// Slightly slower requests on Current; within hysteresis
+	current.health.recordRequest(time.Second, false)
+
+	bc.selectCurrent()
+	if bc.current != current {
+		t.Errorf("Switched endpoint within hysteresis")
+	}
+
+	// Current falls two blocks behind
+	bc.Status.Level = 12
+	head(other, 12)
+
+	bc.selectCurrent()
+	if bc.current != other {
+		t.Errorf("Did not switch from stale endpoint")
+	}
+
+	health := bc.EndpointHealth()
+	if !health[other.clientId].Current || health[other.clientId].Score != 100 || health[current.clientId].Freshness != 0 {
+		t.Errorf("Unexpected health %+v", health)
+	}
+
+	// Circuit-broken Current is replaced even by a lower score
+	head(current, 12)
+	for i := 0; i < CIRCUIT_FAILURES; i++ {
+		other.health.recordRequest(time.Millisecond, true)
+	}
+
+	bc.selectCurrent()
+	if bc.current != current {
+		t.Errorf("Did not switch from circuit-broken endpoint")
+	}
+
+	if s := bc.EndpointHealth()[other.clientId]; s.Score != 0 || s.Circuit != "open" {
+		t.Errorf("Circuit-broken endpoint scored %d, circuit %s", s.Score, s.Circuit)
+	}
+}
+
+func TestEndpointRoles(t *testing.T) {
+
+	timeBetweenBlocks = 30
+
+	newSlice := func(id int, role string) *BaconSlice {
+		c := &BaconSlice{Client: &rpc.Client{Host: fmt.Sprintf("http://rpc%d", id)}, clientId: id, role: role}
+		now := time.Now()
+		c.heads.record(streamHead{Hash: "BLock10", Level: 10, Timestamp: now}, now, now)
+		return c
+	}
+
+	read := newSlice(1, storage.ROLE_READ)
+	inject := newSlice(2, storage.ROLE_INJECT)
+	monitor := newSlice(3, storage.ROLE_MONITOR)
+
+	// Monitor endpoint is the fastest, but never used for requests
+	read.health.recordRequest(time.Second, false)
+
+	bc := &BaconClient{
+		Status:      &BaconStatus{StatusSnapshot: StatusSnapshot{Level: 10}},
+		rpcClients:  []*BaconSlice{monitor, read, inject},
+		headArrived: time.Now(),
+	}
+
+	bc.selectCurrent()
+	if bc.current != read || bc.Injector() != inject {
+		t.Fatalf("Unexpected routing; current %v, injector %v", bc.current.Host, bc.Injector().Host)
+	}
+
+	health := bc.EndpointHealth()
+	if !health[1].Current || !health[2].Injector || health[3].Current || health[3].Injector || health[2].Role != storage.ROLE_INJECT {
+		t.Errorf("Unexpected health %+v", health)
+	}
+
+	// Inject endpoint down; inject through the read endpoint
+	for i := 0; i < CIRCUIT_FAILURES; i++ {
+		inject.health.recordRequest(time.Millisecond, true)
+	}
+
+	if bc.Injector() != read {
+		t.Errorf("Did not fall back to read endpoint for injection")
+	}
+
+	// Read endpoint down too; nothing else to read from, keep it
+	read.health.setQuarantine(errors.New("wrong chain"))
+
+	bc.selectCurrent()
+	if bc.current != read {
+		t.Errorf("Switched to %s with no usable endpoint", bc.current.Host)
+	}
+
+	// Inject endpoint back; reads fall back to it
+	inject.health.openUntil = time.Now()
+	inject.health.recordRequest(time.Millisecond, false)
+
+	bc.selectCurrent()
+	if bc.current != inject || bc.Injector() != inject {
+		t.Errorf("Did not fall back to inject endpoint for reads")
+	}
+}
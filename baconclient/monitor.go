@@ -0,0 +1,368 @@
+package baconclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+const (
+	// Wait before reopening a dropped /monitor/heads stream; /head is polled meanwhile
+	HEAD_STREAM_RETRY = 30 * time.Second
+
+	// Levels of heads kept to de-duplicate between endpoints
+	SEEN_HEADS = 10
+
+	// Head arrivals kept for latency stats
+	HEAD_SAMPLES = 50
+)
+
+// Shell header of a head, as sent by /monitor/heads
+type streamHead struct {
+	Hash      string    `json:"hash"`
+	Level     int       `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// First arrival of a head, from any endpoint
+type seenHead struct {
+	level   int
+	arrived time.Time
+}
+
+// When each endpoint reports new heads, in milliseconds. Latency is from the block
+// timestamp; Behind is after the first endpoint which reported the same head.
+type HeadStats struct {
+	Streaming   bool      `json:"streaming"`
+	Level       int       `json:"level"`
+	Hash        string    `json:"hash"`
+	LastArrival time.Time `json:"lastArrival"`
+	Heads       int       `json:"heads"`
+	First       int       `json:"first"`
+	LastLatency int64     `json:"lastLatency"`
+	AvgLatency  int64     `json:"avgLatency"`
+	AvgBehind   int64     `json:"avgBehind"`
+	StreamDrops int       `json:"streamDrops"`
+}
+
+type headStats struct {
+	lock sync.Mutex
+
+	streaming   bool
+	level       int
+	hash        string
+	lastArrival time.Time
+	heads       int
+	first       int
+	streamDrops int
+
+	// Fell behind the other endpoints; set by blockWatch
+	lostSync bool
+
+	latencies []time.Duration
+	behind    []time.Duration
+	next      int
+}
+
+func (h *headStats) setStreaming(streaming bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.streaming && !streaming {
+		h.streamDrops++
+	}
+	h.streaming = streaming
+}
+
+func (h *headStats) isStreaming() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.streaming
+}
+
+func (h *headStats) lastLevel() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.level
+}
+
+// Returns true if the sync state changed
+func (h *headStats) setInSync(inSync bool) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	changed := h.lostSync == inSync
+	h.lostSync = !inSync
+
+	return changed
+}
+
+func (h *headStats) inSync() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return !h.lostSync
+}
+
+// Records a head reported by this endpoint; repeats of the same head are ignored
+func (h *headStats) record(head streamHead, arrived, firstArrival time.Time) {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if head.Hash == h.hash || head.Level < h.level {
+		return
+	}
+
+	h.level = head.Level
+	h.hash = head.Hash
+	h.lastArrival = arrived
+	h.heads++
+
+	behind := arrived.Sub(firstArrival)
+	if behind == 0 {
+		h.first++
+	}
+
+	latency := arrived.Sub(head.Timestamp)
+
+	if len(h.latencies) < HEAD_SAMPLES {
+		h.latencies = append(h.latencies, latency)
+		h.behind = append(h.behind, behind)
+	} else {
+		h.latencies[h.next] = latency
+		h.behind[h.next] = behind
+	}
+	h.next = (h.next + 1) % HEAD_SAMPLES
+}
+
+func (h *headStats) snapshot() HeadStats {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s := HeadStats{
+		Streaming:   h.streaming,
+		Level:       h.level,
+		Hash:        h.hash,
+		LastArrival: h.lastArrival,
+		Heads:       h.heads,
+		First:       h.first,
+		StreamDrops: h.streamDrops,
+	}
+
+	if n := len(h.latencies); n > 0 {
+
+		var latency, behind time.Duration
+		for i := range h.latencies {
+			latency += h.latencies[i]
+			behind += h.behind[i]
+		}
+
+		s.LastLatency = h.latencies[(h.next+HEAD_SAMPLES-1)%HEAD_SAMPLES].Milliseconds()
+		s.AvgLatency = (latency / time.Duration(n)).Milliseconds()
+		s.AvgBehind = (behind / time.Duration(n)).Milliseconds()
+	}
+
+	return s
+}
+
+// HeadStats returns how quickly this endpoint reports new heads
+func (s *BaconSlice) HeadStats() HeadStats {
+	return s.heads.snapshot()
+}
+
+// Follows /monitor/heads/main, sending each head as it arrives, until shutdown. When
+// the stream drops, blockWatch falls back to polling /head until it is reopened.
+func (b *BaconClient) streamHeads(client *BaconSlice, heads chan<- streamHead) {
+
+	defer waitGroup.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-client.shutdown:
+		case <-globalShutdown:
+		}
+		cancel()
+	}()
+
+	for {
+
+		err := b.followHeads(ctx, client, heads)
+
+		client.heads.setStreaming(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithField("Endpoint", storage.RedactURL(client.Host)).WithError(err).Warn("Head stream closed; Polling /head")
+
+		select {
+		case <-time.After(HEAD_STREAM_RETRY):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reads one /monitor/heads stream until it fails. A stream which sends nothing for
+// a few blocks is considered stalled and closed.
+func (b *BaconClient) followHeads(ctx context.Context, client *BaconSlice, heads chan<- streamHead) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.Host+"/monitor/heads/main", nil)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create request")
+	}
+	req.Header.Set("User-Agent", "BakinBacon/1.0.1")
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open head stream")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Unexpected status %d from head stream", resp.StatusCode)
+	}
+
+	stallTimeout := time.Duration(timeBetweenBlocks*3) * time.Second
+	stalled := time.AfterFunc(stallTimeout, cancel)
+	defer stalled.Stop()
+
+	client.heads.setStreaming(true)
+
+	log.WithField("Endpoint", storage.RedactURL(client.Host)).Info("Streaming heads")
+
+	// Each chunk is a JSON object; the decoder reads them as they arrive
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+
+		var head streamHead
+		if err := decoder.Decode(&head); err != nil {
+			if ctx.Err() != nil && !stalled.Stop() {
+				return errors.New("Head stream stalled")
+			}
+			return errors.Wrap(err, "Unable to read head stream")
+		}
+
+		stalled.Reset(stallTimeout)
+
+		select {
+		case heads <- head:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Handles a head reported by an endpoint, either streamed or polled. The block is
+// sent to NewBlockNotifier once, and Current is re-evaluated; reports of the same head by other endpoints are only
+// recorded for their latency.
+func (b *BaconClient) newHead(client *BaconSlice, head streamHead, block *rpc.Block) {
+
+	arrived := time.Now()
+
+	b.lock.Lock()
+
+	first, seen := b.seenHeads[head.Hash]
+
+	firstArrival := arrived
+	if seen {
+		firstArrival = first.arrived
+	}
+
+	_, level, _ := b.Status.Head()
+
+	isNew := !seen && head.Level > level
+	b.lock.Unlock()
+
+	client.heads.record(head, arrived, firstArrival)
+
+	// Heads of a quarantined endpoint are not used until it checks out again
+	if !isNew || client.health.isQuarantined() {
+		return
+	}
+
+	// Streamed heads only carry the header; fetch the full block from the same endpoint
+	if block == nil {
+
+		blockID := rpc.BlockIDHash(head.Hash)
+
+		_, fetched, err := client.Block(&blockID)
+		if err != nil {
+			log.WithField("Endpoint", storage.RedactURL(client.Host)).WithError(err).Error("Unable to fetch streamed head")
+			return
+		}
+
+		block = fetched
+	}
+
+	// Wrong network or unknown protocol; quarantines the endpoint
+	if err := b.validateBlock(client, block); err != nil {
+		log.WithField("Endpoint", storage.RedactURL(client.Host)).WithError(err).Warn("Ignoring head")
+		return
+	}
+
+	b.lock.Lock()
+
+	// Another endpoint may have reported it while fetching
+	_, level, _ = b.Status.Head()
+
+	if _, seen := b.seenHeads[head.Hash]; seen || head.Level <= level {
+		b.lock.Unlock()
+		return
+	}
+
+	b.seenHeads[head.Hash] = seenHead{head.Level, arrived}
+	for hash, h := range b.seenHeads {
+		if h.level <= head.Level-SEEN_HEADS {
+			delete(b.seenHeads, hash)
+		}
+	}
+
+	b.Status.setHead(block.Hash, block.Metadata.Level.Level, block.Metadata.Level.Cycle, block.Metadata.Level.CyclePosition)
+
+	// Pick Current by score; the endpoint which reported first scores best on freshness
+	b.headArrived = arrived
+	b.selectCurrent()
+
+	log.WithFields(log.Fields{
+		"Cycle":    block.Metadata.Level.Cycle,
+		"Level":    block.Metadata.Level.Level,
+		"Hash":     block.Hash,
+		"ChainID":  block.ChainID,
+		"Endpoint": storage.RedactURL(client.Host),
+		"Latency":  arrived.Sub(head.Timestamp).Milliseconds(),
+	}).Info("New Block")
+
+	b.lock.Unlock()
+
+	// notify new block; not under lock, as the receiver uses Current. A newer
+	// head may be sent first meanwhile; this one is then stale, and dropped.
+	b.notifyLock.Lock()
+	defer b.notifyLock.Unlock()
+
+	if block.Metadata.Level.Level > b.notifiedLevel {
+		b.notifiedLevel = block.Metadata.Level.Level
+		b.NewBlockNotifier <- block
+	}
+}
@@ -0,0 +1,85 @@
+package baconclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+)
+
+func TestFollowHeads(t *testing.T) {
+
+	timeBetweenBlocks = 30
+
+	// Node sends two heads as separate chunks, then closes the stream
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/monitor/heads/main" {
+			http.NotFound(w, r)
+			return
+		}
+
+		for level := 100; level < 102; level++ {
+			fmt.Fprintf(w, `{"hash":"BLock%d","level":%d,"proto":1,"timestamp":"2021-08-01T00:00:00Z"}`+"\n", level, level)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	client := &BaconSlice{Client: &rpc.Client{Host: srv.URL}}
+	heads := make(chan streamHead, 2)
+
+	err := (&BaconClient{}).followHeads(context.Background(), client, heads)
+	if err == nil {
+		t.Errorf("Expected error on closed stream")
+	}
+
+	for level := 100; level < 102; level++ {
+		head := <-heads
+		if head.Level != level || head.Hash != fmt.Sprintf("BLock%d", level) || head.Timestamp.IsZero() {
+			t.Errorf("Unexpected head %+v", head)
+		}
+	}
+
+	stats := client.HeadStats()
+	if !stats.Streaming {
+		t.Errorf("Stream not marked as open")
+	}
+
+	client.heads.setStreaming(false)
+	if stats = client.HeadStats(); stats.Streaming || stats.StreamDrops != 1 {
+		t.Errorf("Unexpected stats after drop %+v", stats)
+	}
+}
+
+func TestHeadStats(t *testing.T) {
+
+	var h headStats
+
+	now := time.Now()
+	head := streamHead{Hash: "BLock1", Level: 1, Timestamp: now.Add(-2 * time.Second)}
+
+	// First to report, then the same head again from a poll
+	h.record(head, now, now)
+	h.record(head, now.Add(time.Second), now)
+
+	// Behind another endpoint by 500ms
+	head = streamHead{Hash: "BLock2", Level: 2, Timestamp: now}
+	h.record(head, now.Add(time.Second), now.Add(500*time.Millisecond))
+
+	// Old head is ignored
+	h.record(streamHead{Hash: "BLock0", Level: 0}, now, now)
+
+	s := h.snapshot()
+	if s.Heads != 2 || s.First != 1 || s.Level != 2 || s.Hash != "BLock2" {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+
+	if s.LastLatency != 1000 || s.AvgLatency != 1500 || s.AvgBehind != 250 {
+		t.Errorf("Unexpected latency %+v", s)
+	}
+}
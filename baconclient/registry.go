@@ -0,0 +1,189 @@
+package baconclient
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+var NO_ENDPOINT = errors.New("No usable RPC endpoint")
+
+// Current returns the endpoint used to read chain state: rights, mempool, balances.
+// Work which makes several requests, ie: a bake, should call it once and use that
+// client throughout; Current may change meanwhile. Nil if every endpoint was removed,
+// or only monitor endpoints remain.
+func (b *BaconClient) Current() *BaconSlice {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.current
+}
+
+// Injector returns the endpoint used to preapply and inject operations and blocks.
+// Like Current, call it once per bake, endorsement or reveal; also nil like Current.
+func (b *BaconClient) Injector() *BaconSlice {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	injector, fallback := b.selectInjector()
+	if fallback && injector != nil {
+		log.WithField("Endpoint", storage.RedactURL(injector.Host)).Warn("No usable inject endpoint; Injecting through read endpoint")
+	}
+
+	return injector
+}
+
+// Current, or NO_ENDPOINT
+func (b *BaconClient) currentClient() (*BaconSlice, error) {
+	if client := b.Current(); client != nil {
+		return client, nil
+	}
+	return nil, NO_ENDPOINT
+}
+
+// Injector, or NO_ENDPOINT
+func (b *BaconClient) injectorClient() (*BaconSlice, error) {
+	if client := b.Injector(); client != nil {
+		return client, nil
+	}
+	return nil, NO_ENDPOINT
+}
+
+// AddRpc connects to an endpoint, with its connection options, and starts following its heads
+func (b *BaconClient) AddRpc(rpcId int, endpoint storage.RPCEndpoint) error {
+
+	transport, err := newTransport(endpoint)
+	if err != nil {
+		return errors.Wrap(err, "Unable to configure endpoint")
+	}
+
+	// Misbehave like a bad node, for testing; both go-tezos requests and the head stream
+	if faultScenario != nil {
+		transport = newFaultTransport(transport, faultScenario, rpcId)
+	}
+
+	// Not rpc.New, which loads constants without our transport; they are loaded below
+	gtRpc := &rpc.Client{Host: endpointHost(endpoint.Url)}
+	gtRpc.SetChain("main")
+
+	newBaconSlice := &BaconSlice{
+		Client:   gtRpc,
+		clientId: rpcId,
+		shutdown: make(chan interface{}), // For shutting down individual BaconSlices
+		http:     &http.Client{Transport: transport},
+		role:     endpoint.Role,
+	}
+
+	// Write requests to fixtures, for replay in tests; not the head stream, which does not end
+	if fixtureDir != "" {
+		recorder, recErr := newRecordingTransport(transport, fixtureDir, rpcId)
+		if recErr != nil {
+			return recErr
+		}
+		transport = recorder
+	}
+
+	// Record latency and errors of each request for the endpoint score
+	gtRpc.OverrideClient(resty.New().
+		SetHeaders(map[string]string{
+			"User-Agent": "BakinBacon/1.0.1",
+		}).
+		SetTimeout(30 * time.Second).
+		SetTransport(&healthTransport{
+			next:   transport,
+			health: &newBaconSlice.health,
+			onOpen: func() { b.circuitOpened(newBaconSlice) },
+		}))
+
+	// Every request, the first included, has the headers, credentials and certificates of the endpoint
+	_, constants, err := gtRpc.Constants(rpc.ConstantsInput{BlockID: &rpc.BlockIDHead{}})
+	if err == nil {
+		gtRpc.SetConstants(constants)
+	}
+
+	if err != nil {
+		log.WithField("Endpoint", storage.RedactURL(endpoint.Url)).WithError(err).Error("Error from RPC")
+	} else {
+		log.WithField("Endpoint", storage.RedactURL(endpoint.Url)).Info("Connected to RPC")
+	}
+
+	// Inactive until it catches up with the other endpoints
+	newBaconSlice.heads.setInSync(err == nil)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, c := range b.rpcClients {
+		if c.clientId == rpcId {
+			log.WithField("Endpoint", storage.RedactURL(endpoint.Url)).Warn("Endpoint already running; Not adding")
+			return nil
+		}
+	}
+
+	b.rpcClients = append(b.rpcClients, newBaconSlice)
+
+	// Launch client
+	waitGroup.Add(1)
+	go b.blockWatch(newBaconSlice)
+
+	return nil
+}
+
+// ShutdownRpc stops following the endpoint rpcId. Work in progress which already
+// holds its client, from Current, finishes with it.
+func (b *BaconClient) ShutdownRpc(rpcId int) error {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, c := range b.rpcClients {
+
+		if c.clientId != rpcId {
+			continue
+		}
+
+		close(c.shutdown)
+
+		// Copy, rather than re-slice, so the removed client is not kept by the backing array
+		b.rpcClients = append(append(make([]*BaconSlice, 0, len(b.rpcClients)-1), b.rpcClients[:i]...), b.rpcClients[i+1:]...)
+
+		// Move to the best remaining endpoint; none, if only monitor endpoints remain
+		if c == b.current {
+			b.current = nil
+			b.selectCurrent()
+
+			// Any endpoint but monitor-only ones
+			for _, c := range b.rpcClients {
+				if b.current == nil && (c.canRead() || c.canInject()) {
+					b.current = c
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return errors.Errorf("No running endpoint with id %d", rpcId)
+}
+
+// EditRpc reconnects the endpoint rpcId with a new URL or connection options; its health starts over
+func (b *BaconClient) EditRpc(rpcId int, endpoint storage.RPCEndpoint) error {
+
+	// Refuse bad options before stopping the running endpoint
+	if _, err := newTransport(endpoint); err != nil {
+		return errors.Wrap(err, "Unable to configure endpoint")
+	}
+
+	if err := b.ShutdownRpc(rpcId); err != nil {
+		return err
+	}
+
+	return b.AddRpc(rpcId, endpoint)
+}
@@ -0,0 +1,162 @@
+package baconclient
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/storage"
+)
+
+// Run with -race; endpoints are added, edited and removed while heads arrive
+func TestRegistryConcurrency(t *testing.T) {
+
+	node := &fakeNode{"NetXz969SFaFn8k", "PtGRANADsDU8R9daYKAgWnQYAJ64omN1o3KMGVCykShA97vQbvV", true, "synced"}
+
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	timeBetweenBlocks = 2
+	globalShutdown = make(chan interface{})
+	waitGroup = &sync.WaitGroup{}
+
+	bc := &BaconClient{
+		NewBlockNotifier: make(chan *rpc.Block, 1),
+		Status:           &BaconStatus{},
+		seenHeads:        make(map[string]seenHead),
+	}
+
+	// Main loop; uses Current for each block, as bakes do
+	received := make(chan int)
+	go func() {
+		last := 0
+		for block := range bc.NewBlockNotifier {
+			if c := bc.Current(); c != nil {
+				_ = c.Host
+			}
+			if block.Metadata.Level.Level <= last {
+				t.Errorf("Block %d sent after %d", block.Metadata.Level.Level, last)
+			}
+			last = block.Metadata.Level.Level
+		}
+		received <- last
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 1; i <= 4; i++ {
+		bc.AddRpc(i, storage.RPCEndpoint{Url: srv.URL})
+	}
+
+	// Heads from each endpoint
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			reporter := &BaconSlice{Client: &rpc.Client{Host: srv.URL}, clientId: 100 + i}
+
+			for level := 1; level <= 50; level++ {
+				block := &rpc.Block{Hash: fmt.Sprintf("BLock%d", level)}
+				block.Metadata.Level.Level = level
+				bc.newHead(reporter, streamHead{Hash: block.Hash, Level: level}, block)
+			}
+		}(i)
+	}
+
+	// Endpoints edited, removed and re-added
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for n := 0; n < 20; n++ {
+			id := n%4 + 1
+
+			if err := bc.EditRpc(id, storage.RPCEndpoint{Url: srv.URL + "/"}); err != nil {
+				t.Errorf("Unable to edit endpoint %d: %s", id, err)
+			}
+
+			if err := bc.ShutdownRpc(id); err != nil {
+				t.Errorf("Unable to remove endpoint %d: %s", id, err)
+			}
+
+			if err := bc.ShutdownRpc(id); err == nil {
+				t.Errorf("Removed endpoint %d twice", id)
+			}
+
+			bc.AddRpc(id, storage.RPCEndpoint{Url: srv.URL})
+		}
+	}()
+
+	// UI
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for n := 0; n < 50; n++ {
+			_ = bc.EndpointHealth()
+			_ = bc.Status.Snapshot()
+		}
+	}()
+
+	wg.Wait()
+
+	close(globalShutdown)
+	waitGroup.Wait()
+
+	close(bc.NewBlockNotifier)
+	if last := <-received; last != 50 {
+		t.Errorf("Last block received %d, expected 50", last)
+	}
+
+	if len(bc.EndpointHealth()) != 4 {
+		t.Errorf("Expected 4 endpoints, got %+v", bc.EndpointHealth())
+	}
+}
+
+// Removing Current leaves no Current if only monitor endpoints remain, or none at all
+func TestShutdownCurrent(t *testing.T) {
+
+	newSlice := func(id int, role string) *BaconSlice {
+		return &BaconSlice{Client: &rpc.Client{Host: fmt.Sprintf("http://rpc%d", id)}, clientId: id, role: role, shutdown: make(chan interface{})}
+	}
+
+	read := newSlice(1, storage.ROLE_BOTH)
+	monitor := newSlice(2, storage.ROLE_MONITOR)
+
+	bc := &BaconClient{
+		rpcClients: []*BaconSlice{read, monitor},
+		current:    read,
+	}
+
+	if err := bc.ShutdownRpc(read.clientId); err != nil {
+		t.Fatalf("Unable to remove endpoint: %s", err)
+	}
+
+	if bc.Current() != nil || bc.Injector() != nil {
+		t.Errorf("Monitor endpoint used for requests; current %v, injector %v", bc.Current(), bc.Injector())
+	}
+
+	if _, err := bc.currentClient(); err != NO_ENDPOINT {
+		t.Errorf("Expected no endpoint, got %v", err)
+	}
+
+	// Last endpoint
+	bc = &BaconClient{
+		rpcClients: []*BaconSlice{read},
+		current:    read,
+	}
+
+	read.shutdown = make(chan interface{})
+
+	if err := bc.ShutdownRpc(read.clientId); err != nil {
+		t.Fatalf("Unable to remove endpoint: %s", err)
+	}
+
+	if bc.Current() != nil {
+		t.Errorf("Current still set to removed endpoint")
+	}
+}
@@ -0,0 +1,207 @@
+package baconclient
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// Kinds of node errors
+const (
+	// The operation or block can never be included
+	ERROR_PERMANENT = "permanent"
+
+	// It may be included later, ie: once the node has caught up
+	ERROR_TEMPORARY = "temporary"
+
+	// It cannot be included on this branch, but may be on another
+	ERROR_BRANCH = "branch"
+)
+
+// Node's wrapping of operation errors at injection; the hash is in msg
+var applyingOperation = regexp.MustCompile(`while applying operation (o[a-zA-Z0-9]{50})`)
+
+// One error of the JSON array returned by the node when an RPC fails. ID is the
+// protocol error ID, ie: proto.010-PtGRANAD.contract.counter_in_the_past; Fields are the
+// other members of the error, as decoded JSON values.
+type RPCError struct {
+	Kind   string                 `json:"kind"`
+	ID     string                 `json:"id"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+func (e *RPCError) UnmarshalJSON(b []byte) error {
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	e.Kind, _ = fields["kind"].(string)
+	e.ID, _ = fields["id"].(string)
+
+	delete(fields, "kind")
+	delete(fields, "id")
+
+	e.Fields = nil
+	if len(fields) > 0 {
+		e.Fields = fields
+	}
+
+	return nil
+}
+
+// HasID returns true if the error ID is name, without the protocol prefix
+func (e RPCError) HasID(name string) bool {
+	return e.ID == name || strings.HasSuffix(e.ID, "."+name)
+}
+
+// Field returns a string field of the error
+func (e RPCError) Field(name string) string {
+	s, _ := e.Fields[name].(string)
+	return s
+}
+
+func (e RPCError) Error() string {
+
+	if msg := e.Field("msg"); msg != "" {
+		return e.Kind + " " + e.ID + ": " + msg
+	}
+
+	return e.Kind + " " + e.ID
+}
+
+// RPCErrors are the errors returned by the node for one RPC, in the order given
+type RPCErrors []RPCError
+
+func (e RPCErrors) Error() string {
+
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+
+	return "rpc error: " + strings.Join(msgs, "; ")
+}
+
+// Find returns the first error with ID name, without the protocol prefix
+func (e RPCErrors) Find(name string) (RPCError, bool) {
+
+	for _, r := range e {
+		if r.HasID(name) {
+			return r, true
+		}
+	}
+
+	return RPCError{}, false
+}
+
+// Permanent returns true if any of the errors is permanent
+func (e RPCErrors) Permanent() bool {
+
+	for _, r := range e {
+		if r.Kind == ERROR_PERMANENT {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OperationHash returns the hash of the operation the node failed to apply at
+// injection, if reported
+func (e RPCErrors) OperationHash() string {
+
+	for _, r := range e {
+		if parts := applyingOperation.FindStringSubmatch(r.Field("msg")); len(parts) > 1 {
+			return parts[1]
+		}
+	}
+
+	return ""
+}
+
+// DecodeRPCError returns the errors of the node's response as RPCErrors, with the
+// context of err, ie: failed to inject operation. Returns err as is if the response
+// is not an error array.
+func DecodeRPCError(resp *resty.Response, err error) error {
+
+	if err == nil || resp == nil {
+		return err
+	}
+
+	var rpcErrors RPCErrors
+	if json.Unmarshal(resp.Body(), &rpcErrors) != nil || len(rpcErrors) == 0 || rpcErrors[0].ID == "" {
+		return err
+	}
+
+	return errors.WithMessage(rpcErrors, strings.SplitN(err.Error(), ":", 2)[0])
+}
+
+// RPCErrorsOf returns the RPCErrors decoded by DecodeRPCError
+func RPCErrorsOf(err error) (RPCErrors, bool) {
+	rpcErrors, ok := errors.Cause(err).(RPCErrors)
+	return rpcErrors, ok
+}
+
+func hasRPCError(err error, name string) bool {
+
+	rpcErrors, ok := RPCErrorsOf(err)
+	if !ok {
+		return false
+	}
+
+	_, found := rpcErrors.Find(name)
+
+	return found
+}
+
+// IsCounterInThePast returns true if an operation of the same counter was already included
+func IsCounterInThePast(err error) bool {
+	return hasRPCError(err, "contract.counter_in_the_past")
+}
+
+// IsTimestampTooEarly returns true if a block was preapplied or injected before its
+// minimal timestamp; the minimum is returned when the node reports it
+func IsTimestampTooEarly(err error) (time.Time, bool) {
+
+	rpcErrors, ok := RPCErrorsOf(err)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	e, found := rpcErrors.Find("baking.timestamp_too_early")
+	if !found {
+		return time.Time{}, false
+	}
+
+	minimum, _ := time.Parse(time.RFC3339, e.Field("minimum"))
+
+	return minimum, true
+}
+
+// IsNoncePreviouslyRevealed returns true if the seed nonce was already revealed. At
+// injection, the node reports it in the message of a generic failure.
+func IsNoncePreviouslyRevealed(err error) bool {
+
+	rpcErrors, ok := RPCErrorsOf(err)
+	if !ok {
+		return false
+	}
+
+	if _, found := rpcErrors.Find("nonce.previously_revealed"); found {
+		return true
+	}
+
+	for _, r := range rpcErrors {
+		if strings.Contains(strings.ToLower(r.Field("msg")), "previously revealed") {
+			return true
+		}
+	}
+
+	return false
+}
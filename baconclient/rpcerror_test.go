@@ -0,0 +1,65 @@
+package baconclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+func TestDecodeRPCError(t *testing.T) {
+
+	var body string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, body, http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	decode := func(b string) error {
+		body = b
+		resp, err := resty.New().R().Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		return errors.Wrap(DecodeRPCError(resp, errors.New("failed to preapply new block: rpc error")), "Unable to bake")
+	}
+
+	err := decode(`[{"kind":"permanent","id":"proto.010-PtGRANAD.baking.timestamp_too_early","minimum":"2021-08-01T00:00:30Z","provided":"2021-08-01T00:00:00Z"}]`)
+
+	minimum, tooEarly := IsTimestampTooEarly(err)
+	if !tooEarly || !minimum.Equal(time.Date(2021, 8, 1, 0, 0, 30, 0, time.UTC)) {
+		t.Errorf("Timestamp too early not decoded: %s", err)
+	}
+
+	rpcErrors, ok := RPCErrorsOf(err)
+	if !ok || !rpcErrors.Permanent() || rpcErrors[0].Field("provided") != "2021-08-01T00:00:00Z" || IsCounterInThePast(err) {
+		t.Errorf("Unexpected errors %+v", rpcErrors)
+	}
+
+	if err.Error() != "Unable to bake: failed to preapply new block: rpc error: permanent proto.010-PtGRANAD.baking.timestamp_too_early" {
+		t.Errorf("Unexpected message %q", err)
+	}
+
+	err = decode(`[{"kind":"temporary","id":"proto.010-PtGRANAD.contract.counter_in_the_past","contract":"tz1Qny7jVMGiwRrP9FikRK95jTNbJcffTpx1","expected":"12","found":"11"}]`)
+	if !IsCounterInThePast(err) {
+		t.Errorf("Counter in the past not decoded: %s", err)
+	}
+
+	// Nonce revealed before; hash of the reveal is in the message
+	err = decode(`[{"kind":"temporary","id":"failure","msg":"Error while applying operation ooYuqTYBd6mNC5KWLEDSKcAWKiw7qWuBrqFDyhsEvBz5j8LLXds:\nbranch refused (Error:\n  Previously revealed nonce\n)"}]`)
+
+	rpcErrors, _ = RPCErrorsOf(err)
+	if !IsNoncePreviouslyRevealed(err) || rpcErrors.OperationHash() != "ooYuqTYBd6mNC5KWLEDSKcAWKiw7qWuBrqFDyhsEvBz5j8LLXds" {
+		t.Errorf("Previously revealed nonce not decoded: %s", err)
+	}
+
+	// Not an error array
+	err = decode("Internal server error")
+	if _, ok := RPCErrorsOf(err); ok || err.Error() != "Unable to bake: failed to preapply new block: rpc error" {
+		t.Errorf("Unexpected error %q", err)
+	}
+}
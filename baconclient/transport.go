@@ -0,0 +1,110 @@
+package baconclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"bakinbacon/storage"
+)
+
+// Adds the headers and credentials of an endpoint to each request
+type authTransport struct {
+	next        http.RoundTripper
+	headers     map[string]string
+	username    string
+	password    string
+	bearerToken string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	// RoundTrippers must not modify the request
+	req = req.Clone(req.Context())
+
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	if t.username != "" || t.password != "" {
+		req.SetBasicAuth(t.username, t.password)
+	}
+
+	if t.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.bearerToken)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// Builds the transport of an endpoint from its connection options: client
+// certificate, CA bundle, proxy, headers and credentials
+func newTransport(e storage.RPCEndpoint) (http.RoundTripper, error) {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if e.ProxyURL != "" {
+
+		proxy, err := url.Parse(e.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid proxy URL")
+		}
+
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.Errorf("Unsupported proxy scheme %q; use http, https or socks5", proxy.Scheme)
+		}
+
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if e.CACert != "" || e.ClientCert != "" || e.ClientKey != "" {
+
+		tlsConfig := &tls.Config{}
+
+		if e.CACert != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(e.CACert)) {
+				return nil, errors.New("No certificate found in CA bundle")
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if e.ClientCert != "" || e.ClientKey != "" {
+			cert, err := tls.X509KeyPair([]byte(e.ClientCert), []byte(e.ClientKey))
+			if err != nil {
+				return nil, errors.Wrap(err, "Invalid client certificate or key")
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if len(e.Headers) == 0 && e.Username == "" && e.Password == "" && e.BearerToken == "" {
+		return transport, nil
+	}
+
+	return &authTransport{
+		next:        transport,
+		headers:     e.Headers,
+		username:    e.Username,
+		password:    e.Password,
+		bearerToken: e.BearerToken,
+	}, nil
+}
+
+// Plain HTTP client for the endpoint, for requests go-tezos does not cover, ie: the
+// head stream. Slices built without AddRpc use the default client.
+func (s *BaconSlice) httpClient() *http.Client {
+
+	if s.http == nil {
+		return http.DefaultClient
+	}
+
+	return s.http
+}
@@ -0,0 +1,183 @@
+package baconclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/storage"
+)
+
+func transportGet(t *testing.T, e storage.RPCEndpoint, url string) (*http.Response, error) {
+
+	transport, err := newTransport(e)
+	if err != nil {
+		t.Fatalf("Unable to build transport: %s", err)
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	return resp, err
+}
+
+func TestTransportAuth(t *testing.T) {
+
+	var req *http.Request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+	}))
+	defer srv.Close()
+
+	if _, err := transportGet(t, storage.RPCEndpoint{
+		Headers:  map[string]string{"X-Api-Key": "key"},
+		Username: "baker",
+		Password: "secret",
+	}, srv.URL); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+
+	if user, pass, ok := req.BasicAuth(); !ok || user != "baker" || pass != "secret" || req.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("Credentials not sent: %v", req.Header)
+	}
+
+	if _, err := transportGet(t, storage.RPCEndpoint{BearerToken: "token"}, srv.URL); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+
+	if req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("Token not sent: %v", req.Header)
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	// Client certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bakinbacon"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create client certificate: %s", err)
+	}
+
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	certKey := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+
+	if _, err := transportGet(t, storage.RPCEndpoint{CACert: ca}, srv.URL); err == nil {
+		t.Errorf("Connected without client certificate")
+	}
+
+	if _, err := transportGet(t, storage.RPCEndpoint{ClientCert: cert, ClientKey: certKey}, srv.URL); err == nil {
+		t.Errorf("Connected without CA bundle")
+	}
+
+	if _, err := transportGet(t, storage.RPCEndpoint{CACert: ca, ClientCert: cert, ClientKey: certKey}, srv.URL); err != nil {
+		t.Errorf("Unable to connect with CA bundle and client certificate: %s", err)
+	}
+
+	// Bad options are refused
+	for _, e := range []storage.RPCEndpoint{
+		{CACert: "not a certificate"},
+		{ClientCert: cert},
+		{ProxyURL: "ftp://proxy"},
+	} {
+		if _, err := newTransport(e); err == nil {
+			t.Errorf("Options accepted: %+v", e)
+		}
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+
+	var proxied string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	if _, err := transportGet(t, storage.RPCEndpoint{ProxyURL: proxy.URL}, "http://node.invalid:8732/chains/main/chain_id"); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+
+	if proxied != "http://node.invalid:8732/chains/main/chain_id" {
+		t.Errorf("Request not proxied: %q", proxied)
+	}
+}
+
+// The constants fetched on add, and every request after, go through the endpoint transport
+func TestAddRpcHeaders(t *testing.T) {
+
+	var (
+		lock     sync.Mutex
+		requests []*http.Request
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r)
+		lock.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	timeBetweenBlocks = 2
+	globalShutdown = make(chan interface{})
+	waitGroup = &sync.WaitGroup{}
+
+	bc := &BaconClient{
+		NewBlockNotifier: make(chan *rpc.Block, 1),
+		Status:           &BaconStatus{},
+		seenHeads:        make(map[string]seenHead),
+	}
+
+	if err := bc.AddRpc(1, storage.RPCEndpoint{Url: srv.URL, Headers: map[string]string{"X-Api-Key": "key"}}); err != nil {
+		t.Fatalf("Unable to add endpoint: %s", err)
+	}
+
+	close(globalShutdown)
+	waitGroup.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(requests) == 0 {
+		t.Fatalf("No requests to endpoint")
+	}
+
+	for _, r := range requests {
+		if r.Header.Get("X-Api-Key") != "key" {
+			t.Errorf("Request to %s without header: %v", r.URL.Path, r.Header)
+		}
+	}
+}
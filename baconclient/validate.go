@@ -0,0 +1,211 @@
+package baconclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+const (
+	// How often each endpoint is checked for network, protocol and sync state
+	ENDPOINT_CHECK_INTERVAL = 1 * time.Minute
+)
+
+// Set from main at startup
+var (
+	networkChainID string
+	knownProtocols []string
+)
+
+// What an endpoint reports about its chain, checked on connect and on each tick
+type EndpointInfo struct {
+	ChainID      string `json:"chainId"`
+	Protocol     string `json:"protocol"`
+	NextProtocol string `json:"nextProtocol"`
+	Bootstrapped bool   `json:"bootstrapped"`
+	SyncState    string `json:"syncState"`
+}
+
+// Fetches chain id, head protocols and bootstrap state of the node at host
+func fetchEndpointInfo(httpClient *http.Client, host string) (*EndpointInfo, error) {
+
+	client := *httpClient
+	client.Timeout = 10 * time.Second
+
+	get := func(path string, v interface{}) error {
+
+		resp, err := client.Get(host + path)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to query %s", path)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("Unexpected status %d querying %s", resp.StatusCode, path)
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return errors.Wrapf(err, "Unable to decode %s", path)
+		}
+
+		return nil
+	}
+
+	info := &EndpointInfo{}
+
+	if err := get("/chains/main/chain_id", &info.ChainID); err != nil {
+		return nil, err
+	}
+
+	var protocols struct {
+		Protocol     string `json:"protocol"`
+		NextProtocol string `json:"next_protocol"`
+	}
+
+	if err := get("/chains/main/blocks/head/protocols", &protocols); err != nil {
+		return nil, err
+	}
+
+	info.Protocol = protocols.Protocol
+	info.NextProtocol = protocols.NextProtocol
+
+	var bootstrapped struct {
+		Bootstrapped bool   `json:"bootstrapped"`
+		SyncState    string `json:"sync_state"`
+	}
+
+	if err := get("/chains/main/is_bootstrapped", &bootstrapped); err != nil {
+		return nil, err
+	}
+
+	info.Bootstrapped = bootstrapped.Bootstrapped
+	info.SyncState = bootstrapped.SyncState
+
+	return info, nil
+}
+
+// Checks an endpoint is on the configured network, running a protocol BakinBacon
+// can bake, and synced. Blocks are baked in next_protocol, which differs from
+// protocol on the last block before an upgrade.
+func (i *EndpointInfo) validate() error {
+
+	if err := checkChain(i.ChainID, i.NextProtocol); err != nil {
+		return err
+	}
+
+	if !i.Bootstrapped {
+		return errors.New("Endpoint is not bootstrapped; wait for the node to catch up")
+	}
+
+	// Older nodes do not report a sync state
+	if i.SyncState != "" && i.SyncState != "synced" {
+		return errors.Errorf("Endpoint is %s; node is not synced with the network", i.SyncState)
+	}
+
+	return nil
+}
+
+func checkChain(chainID, protocol string) error {
+
+	if networkChainID != "" && chainID != networkChainID {
+		return errors.Errorf("Endpoint is on chain %s, not %s; check that it is a node of the right network", chainID, networkChainID)
+	}
+
+	if len(knownProtocols) == 0 {
+		return nil
+	}
+
+	for _, p := range knownProtocols {
+		if p == protocol {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Endpoint is running unknown protocol %s; upgrade BakinBacon, or use a node of the supported protocol", protocol)
+}
+
+// ValidateEndpoint checks an endpoint, with its connection options, before it is added
+func ValidateEndpoint(endpoint storage.RPCEndpoint) error {
+
+	transport, err := newTransport(endpoint)
+	if err != nil {
+		return err
+	}
+
+	info, err := fetchEndpointInfo(&http.Client{Transport: transport}, endpointHost(endpoint.Url))
+	if err != nil {
+		return err
+	}
+
+	return info.validate()
+}
+
+// Host of an endpoint url; same defaults as go-tezos
+func endpointHost(url string) string {
+
+	host := strings.TrimSuffix(url, "/")
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+
+	return host
+}
+
+// Validates a block reported by client, before it is used
+func (b *BaconClient) validateBlock(client *BaconSlice, block *rpc.Block) error {
+
+	if err := checkChain(block.ChainID, block.Metadata.NextProtocol); err != nil {
+		b.quarantine(client, err)
+		return err
+	}
+
+	return nil
+}
+
+// Re-checks client; lifts the quarantine of an endpoint which checks out again
+func (b *BaconClient) checkEndpoint(client *BaconSlice) {
+
+	info, err := fetchEndpointInfo(client.httpClient(), client.Host)
+	if err != nil {
+		// Not reachable is a health problem, not a mismatch; scoring takes care of it
+		log.WithField("Endpoint", storage.RedactURL(client.Host)).WithError(err).Debug("Unable to check endpoint")
+		return
+	}
+
+	if err := info.validate(); err != nil {
+		b.quarantine(client, err)
+		return
+	}
+
+	if client.health.setQuarantine(nil) {
+		log.WithField("Endpoint", storage.RedactURL(client.Host)).Info("Endpoint checks out; Quarantine lifted")
+	}
+}
+
+// Stops using client until it checks out again; its heads are ignored
+func (b *BaconClient) quarantine(client *BaconSlice, err error) {
+
+	if !client.health.setQuarantine(err) {
+		return
+	}
+
+	log.WithField("Endpoint", storage.RedactURL(client.Host)).WithError(err).Error("Endpoint quarantined")
+	notifications.N.Send(fmt.Sprintf("RPC endpoint %s quarantined: %s", client.Host, err), notifications.RPC)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.current == client {
+		b.selectCurrent()
+	}
+}
@@ -0,0 +1,141 @@
+package baconclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+// Node answering the RPCs checked by ValidateEndpoint
+type fakeNode struct {
+	chainID      string
+	protocol     string
+	bootstrapped bool
+	syncState    string
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var v interface{}
+
+	switch r.URL.Path {
+	case "/chains/main/chain_id":
+		v = n.chainID
+	case "/chains/main/blocks/head/protocols":
+		v = map[string]string{"protocol": n.protocol, "next_protocol": n.protocol}
+	case "/chains/main/is_bootstrapped":
+		v = map[string]interface{}{"bootstrapped": n.bootstrapped, "sync_state": n.syncState}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestValidateEndpoint(t *testing.T) {
+
+	networkChainID = "NetXz969SFaFn8k"
+	knownProtocols = []string{"PtGRANADsDU8R9daYKAgWnQYAJ64omN1o3KMGVCykShA97vQbvV"}
+	t.Cleanup(func() { networkChainID, knownProtocols = "", nil })
+
+	node := &fakeNode{networkChainID, knownProtocols[0], true, "synced"}
+
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	if err := ValidateEndpoint(storage.RPCEndpoint{Url: srv.URL + "/"}); err != nil {
+		t.Errorf("Valid endpoint refused: %s", err)
+	}
+
+	cases := []struct {
+		change func()
+		msg    string
+	}{
+		{func() { node.chainID = "NetXdQprcVkpaWU" }, "is on chain NetXdQprcVkpaWU"},
+		{func() { node.protocol = "PsFLorenaUUuikDWvMDr6fGBRG8kt3e3D3fHoXK1j1BFRxeSH4i" }, "unknown protocol"},
+		{func() { node.bootstrapped = false }, "not bootstrapped"},
+		{func() { node.syncState = "stuck" }, "is stuck"},
+	}
+
+	for _, c := range cases {
+
+		*node = fakeNode{networkChainID, knownProtocols[0], true, "synced"}
+		c.change()
+
+		if err := ValidateEndpoint(storage.RPCEndpoint{Url: srv.URL}); err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("Expected error %q, got %v", c.msg, err)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	if err := notifications.New(db); err != nil {
+		t.Fatalf("Unable to init notifications: %s", err)
+	}
+
+	networkChainID = "NetXz969SFaFn8k"
+	knownProtocols = []string{"PtGRANADsDU8R9daYKAgWnQYAJ64omN1o3KMGVCykShA97vQbvV"}
+	t.Cleanup(func() { networkChainID, knownProtocols = "", nil })
+
+	node := &fakeNode{"NetXdQprcVkpaWU", knownProtocols[0], true, "synced"}
+
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	mainnet := &BaconSlice{Client: &rpc.Client{Host: srv.URL}, clientId: 1}
+	other := &BaconSlice{Client: &rpc.Client{Host: "http://other"}, clientId: 2}
+
+	bc := &BaconClient{
+		Status:     &BaconStatus{},
+		current:    mainnet,
+		rpcClients: []*BaconSlice{mainnet, other},
+		seenHeads:  make(map[string]seenHead),
+	}
+
+	bc.checkEndpoint(mainnet)
+
+	health := bc.EndpointHealth()[1]
+	if !strings.Contains(health.Quarantined, "is on chain NetXdQprcVkpaWU") || health.Score != 0 {
+		t.Errorf("Endpoint not quarantined: %+v", health)
+	}
+
+	if bc.current != other {
+		t.Errorf("Current not moved off quarantined endpoint")
+	}
+
+	// Heads from the quarantined endpoint are ignored
+	block := &rpc.Block{Hash: "BLock1", ChainID: "NetXdQprcVkpaWU"}
+	block.Metadata.Level.Level = 1
+
+	bc.newHead(mainnet, streamHead{Hash: block.Hash, Level: 1}, block)
+
+	if bc.Status.Level != 0 || len(bc.seenHeads) != 0 {
+		t.Errorf("Head of quarantined endpoint was used")
+	}
+
+	// So are blocks of another chain, from an endpoint which checked out before
+	bc.newHead(other, streamHead{Hash: block.Hash, Level: 1}, block)
+
+	if bc.Status.Level != 0 || !other.health.isQuarantined() {
+		t.Errorf("Block of another chain was used")
+	}
+
+	// Node is fixed
+	node.chainID = networkChainID
+	bc.checkEndpoint(mainnet)
+
+	if mainnet.health.isQuarantined() {
+		t.Errorf("Quarantine not lifted")
+	}
+}
@@ -0,0 +1,53 @@
+package baconsigner
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+const (
+	AUDIT_SIGNED  = "signed"
+	AUDIT_DENIED  = "denied"
+	AUDIT_PENDING = "pending"
+	AUDIT_FAILED  = "failed"
+)
+
+// Records a signing request in the audit log. A signature is only returned
+// to the caller if it was successfully recorded.
+func (s *BaconSigner) recordAudit(opBytes []byte, contents []OperationContent, result string, signErr error) error {
+
+	bytesHash, err := util.CryptoGenericHash(opBytes, []byte{})
+	if err != nil {
+		return errors.Wrap(err, "Unable to hash audit bytes")
+	}
+
+	entry := storage.AuditEntry{
+		Timestamp:  time.Now().UTC(),
+		BytesHash:  hex.EncodeToString(bytesHash),
+		Result:     result,
+		Operations: make([]string, 0, len(contents)),
+	}
+
+	if len(opBytes) > 0 {
+		entry.Watermark = int(opBytes[0])
+	}
+
+	for _, c := range contents {
+		entry.Operations = append(entry.Operations, c.String())
+	}
+
+	if signErr != nil {
+		entry.Error = signErr.Error()
+	}
+
+	if err := s.db.AddAuditEntry(entry); err != nil {
+		return errors.Wrap(err, "Unable to record audit entry")
+	}
+
+	return nil
+}
@@ -3,8 +3,9 @@ package baconsigner
 import (
 	"encoding/hex"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/Messer4/base58check"
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
@@ -15,6 +16,7 @@ import (
 const (
 	SIGNER_WALLET = 1
 	SIGNER_LEDGER = 2
+	SIGNER_PKCS11 = 3
 )
 
 var (
@@ -24,6 +26,14 @@ var (
 type BaconSigner struct {
 	BakerPkh      string
 	SignerType    int
+
+	db storage.Store
+
+	consensus     consensusKeys
+	consensusLock sync.RWMutex
+
+	// Availability and latency, updated by the supervisor and each signature
+	health signerHealth
 }
 
 // SignOperationOutput contains an operation with the signature appended, and the signature
@@ -34,30 +44,50 @@ type SignOperationOutput struct {
 }
 
 // New
-func New() (*BaconSigner, error) {
+func New(db storage.Store) (*BaconSigner, error) {
 
-	bs := &BaconSigner{}
+	bs := &BaconSigner{db: db}
 
 	// Get which signing method (wallet or ledger), so we can perform sanity checks
-	signerType, err := storage.DB.GetSignerType()
+	signerType, err := db.GetSignerType()
 	if err != nil {
 		return bs, errors.Wrap(err, "Unable to get signer type from DB")
 	}
 	bs.SignerType = signerType
 
+	// HSM on the command line takes over as signer
+	if Pkcs11Configured() {
+		bs.SignerType = SIGNER_PKCS11
+	}
+
 	switch bs.SignerType {
 	case SIGNER_WALLET:
-		if err := InitWalletSigner(); err != nil {
+		if err := InitWalletSigner(db); err != nil {
 			return bs, errors.Wrap(err, "Cannot init wallet signer")
 		}
 	case SIGNER_LEDGER:
-		if err := InitLedgerSigner(); err != nil {
-			return bs, errors.Wrap(err, "Cannot init ledger signer")
+		// Device may be unplugged; the supervisor keeps trying to reconnect
+		if err := InitLedgerSigner(db); err != nil {
+			log.WithError(err).Error("Cannot init ledger signer")
+			bs.health.setUnavailable(errors.Wrap(err, "Ledger unavailable"))
+		}
+	case SIGNER_PKCS11:
+		if err := InitPkcs11Signer(db); err != nil {
+			return bs, errors.Wrap(err, "Cannot init PKCS#11 signer")
+		}
+		// Key checked against the delegate in DB; saved as such, if new or forced
+		if err := P.SaveSigner(); err != nil {
+			return bs, err
 		}
 	default:
 		log.WithField("Type", signerType).Error("No signer type defined. New setup?")
 	}
 
+	// Rotated consensus key, if any, signs blocks and endorsements once active
+	if err := bs.loadConsensusKeys(); err != nil {
+		return bs, errors.Wrap(err, "Cannot load consensus keys")
+	}
+
 	return bs, nil
 }
 
@@ -70,6 +100,21 @@ func (s *BaconSigner) SignerStatus(silent bool) error {
 		return errors.Wrap(err, "Loading Delegate")
 	}
 
+	// Encrypted wallet waiting for passphrase
+	if w := getWallet(); s.SignerType == SIGNER_WALLET && (w == nil || w.IsLocked()) {
+		return WALLET_LOCKED
+	}
+
+	// Ledger unplugged, or baking app closed
+	if err := s.health.status(); err != nil {
+		return err
+	}
+
+	// Active consensus key waiting for passphrase
+	if _, err := s.consensusSigningKey(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -77,7 +122,7 @@ func (s *BaconSigner) LoadDelegate(silent bool) error {
 
 	var err error
 
-	_, s.BakerPkh, err = storage.DB.GetDelegate()
+	_, s.BakerPkh, err = s.db.GetDelegate()
 	if err != nil {
 		log.WithError(err).Error("Unable to load delegate from DB")
 	}
@@ -110,16 +155,24 @@ func (s *BaconSigner) ConfirmBakingPkh(pkh, bip string) error {
 func (s *BaconSigner) GetPublicKey() (string, string, error) {
 	switch s.SignerType {
 	case SIGNER_WALLET:
-		return W.GetPublicKey()
+		return getWallet().GetPublicKey()
 	case SIGNER_LEDGER:
 		return L.GetPublicKey()
+	case SIGNER_PKCS11:
+		return P.GetPublicKey()
 	}
 	return "", "", NO_SIGNER_TYPE
 }
 
-// Generates new key; Not applicable to Ledger
-func (s *BaconSigner) GenerateNewKey() (string, string, error) {
-	sk, pkh, err := GenerateNewKey()
+// Generates new key of the given curve; Not applicable to Ledger
+func (s *BaconSigner) GenerateNewKey(curve string) (string, string, error) {
+
+	kind, err := ParseCurve(curve)
+	if err != nil {
+		return "", "", err
+	}
+
+	sk, pkh, err := GenerateNewKey(s.db, kind)
 
 	// Need to set if all is good
 	if err == nil {
@@ -130,8 +183,8 @@ func (s *BaconSigner) GenerateNewKey() (string, string, error) {
 }
 
 // Imports a secret key; Not applicable to ledger
-func (s *BaconSigner) ImportSecretKey(k string) (string, string, error) {
-	sk, pkh, err := ImportSecretKey(k)
+func (s *BaconSigner) ImportSecretKey(k, passphrase string) (string, string, error) {
+	sk, pkh, err := ImportSecretKey(s.db, k, passphrase)
 
 	// Need to set if all is good
 	if err == nil {
@@ -141,27 +194,101 @@ func (s *BaconSigner) ImportSecretKey(k string) (string, string, error) {
 	return sk, pkh, err
 }
 
-// Not applicable to wallet
-func (s *BaconSigner) TestLedger() (*LedgerInfo, error) {
-	return TestLedger()
+// Derives a key from a BIP39 mnemonic; Not applicable to ledger
+func (s *BaconSigner) ImportMnemonic(mnemonic, passphrase, path, curve string) (string, string, error) {
+
+	kind, err := ParseCurve(curve)
+	if err != nil {
+		return "", "", err
+	}
+
+	sk, pkh, err := ImportMnemonic(s.db, mnemonic, passphrase, path, kind)
+
+	// Need to set if all is good
+	if err == nil {
+		s.SignerType = SIGNER_WALLET
+	}
+
+	return sk, pkh, err
+}
+
+// Derives the key of a fundraiser account; Not applicable to ledger
+func (s *BaconSigner) ImportFundraiser(mnemonic, email, password string) (string, string, error) {
+	sk, pkh, err := ImportFundraiser(s.db, mnemonic, email, password)
+
+	// Need to set if all is good
+	if err == nil {
+		s.SignerType = SIGNER_WALLET
+	}
+
+	return sk, pkh, err
+}
+
+// High watermarks of the ledger device; not applicable to wallet or HSM
+func (s *BaconSigner) LedgerWatermarks() (*LedgerWatermarks, error) {
+
+	if s.SignerType != SIGNER_LEDGER || L == nil {
+		return nil, errors.New("Signer is not a ledger")
+	}
+
+	return L.GetHighWatermarks()
+}
+
+// Sets the ledger device high watermark; user must confirm on device
+func (s *BaconSigner) SetLedgerWatermark(level int) error {
+
+	if s.SignerType != SIGNER_LEDGER || L == nil {
+		return errors.New("Signer is not a ledger")
+	}
+
+	return L.SetHighWatermark(level)
+}
+
+// Not applicable to wallet; curve is used when the device has no authorized baking key
+func (s *BaconSigner) TestLedger(curve string) (*LedgerInfo, error) {
+
+	kind, err := ParseCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return TestLedger(s.db, kind)
+}
+
+// Unlocks an encrypted wallet, and any consensus keys; Ledger signers only have consensus keys to unlock
+func (s *BaconSigner) UnlockWallet(passphrase string) error {
+
+	if w := getWallet(); s.SignerType == SIGNER_WALLET && w != nil {
+		if err := w.Unlock(passphrase); err != nil {
+			return err
+		}
+	} else if !s.ConsensusKeysLocked() {
+		return errors.New("Signer is not a software wallet")
+	}
+
+	return s.unlockConsensusKeys(passphrase)
 }
 
-// Save signer config to DB
-func (s *BaconSigner) SaveSigner() error {
+// Save signer config to DB; passphrase is used to encrypt wallet secret keys
+func (s *BaconSigner) SaveSigner(passphrase string) error {
 	switch s.SignerType {
 	case SIGNER_WALLET:
-		return W.SaveSigner()
+		return getWallet().SaveSigner(passphrase)
 	case SIGNER_LEDGER:
 		return L.SaveSigner()
+	case SIGNER_PKCS11:
+		return P.SaveSigner()
 	}
 	return NO_SIGNER_TYPE
 }
 
-// Close ledger or wallet
+// Close ledger, HSM or wallet
 func (s *BaconSigner) Close() {
 	switch s.SignerType {
 	case SIGNER_LEDGER:
 		L.Close()
+	case SIGNER_PKCS11:
+		P.Close()
 	}
 }
 
@@ -193,6 +320,10 @@ func (s *BaconSigner) SignSetDelegate(delegateBytes string) (SignOperationOutput
 	return s.signGeneric(genericopprefix, delegateBytes, "")
 }
 
+func (s *BaconSigner) SignUpdateConsensusKey(updateBytes string) (SignOperationOutput, error) {
+	return s.signGeneric(genericopprefix, updateBytes, "")
+}
+
 func (s *BaconSigner) SignProposalVote(proposalBytes string) (SignOperationOutput, error) {
 	return s.signGeneric(genericopprefix, proposalBytes, "")
 }
@@ -230,21 +361,12 @@ func (s *BaconSigner) signGeneric(opPrefix prefix, incOpHex, chainID string) (Si
 	//fmt.Println("ToSignBytes: ", opBytes)
 	//fmt.Println("ToSignByHex: ", finalOpHex)
 
-	edSig, err := func(b []byte) (string, error) {
-		switch s.SignerType {
-		case SIGNER_WALLET:
-			return W.SignBytes(b)
-		case SIGNER_LEDGER:
-			return L.SignBytes(b)
-		}
-		return "", NO_SIGNER_TYPE
-	}(opBytes)
-
+	edSig, err := s.SignRawBytes(opBytes)
 	if err != nil {
-		return SignOperationOutput{}, errors.Wrap(err, "Failed sign bytes")
+		return SignOperationOutput{}, err
 	}
 
-	// Decode out the signature from the operation
+	// Decode out the signature from the operation; edsig, spsig1 or p2sig
 	decodedSig, err := decodeSignature(edSig)
 	if err != nil {
 		return SignOperationOutput{}, errors.Wrap(err, "Failed to decode signed block")
@@ -258,22 +380,97 @@ func (s *BaconSigner) signGeneric(opPrefix prefix, incOpHex, chainID string) (Si
 	}, nil
 }
 
-// Helper function to return the decoded signature
-func decodeSignature(signature string) (string, error) {
+// Signs bytes which already include the watermark prefix (and chain id, if needed).
+// Returns the b58 encoded signature. Used by the remote signer, which receives
+// the fully constructed bytes from the requesting baker, and by signGeneric.
+// The bytes are checked against the signing policy, and the request is recorded
+// in the audit log, whoever asks.
+func (s *BaconSigner) SignRawBytes(opBytes []byte) (string, error) {
 
-	decBytes, err := base58check.Decode(signature)
+	// Check the signing policy before anything is signed
+	contents, err := checkPolicy(opBytes)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to decode signature")
+
+		result := AUDIT_DENIED
+		if _, ok := err.(*ConfirmationRequiredError); ok {
+			result = AUDIT_PENDING
+		}
+
+		if auditErr := s.recordAudit(opBytes, contents, result, err); auditErr != nil {
+			log.WithError(auditErr).Error("Unable to record denied signing request")
+		}
+
+		return "", &PolicyError{Err: err}
 	}
 
-	decodedSigHex := hex.EncodeToString(decBytes)
+	edSig, err := s.signTimed(opBytes)
+	if err != nil {
 
-	// sanity
-	if len(decodedSigHex) > 10 {
-		decodedSigHex = decodedSigHex[10:]
-	} else {
-		return "", errors.Wrap(err, "decoded signature is invalid length")
+		if auditErr := s.recordAudit(opBytes, contents, AUDIT_FAILED, err); auditErr != nil {
+			log.WithError(auditErr).Error("Unable to record failed signing request")
+		}
+
+		return "", errors.Wrap(err, "Failed sign bytes")
+	}
+
+	// Signature is not released unless it is in the audit log
+	if err := s.recordAudit(opBytes, contents, AUDIT_SIGNED, nil); err != nil {
+		return "", err
+	}
+
+	return edSig, nil
+}
+
+// Blocks and endorsements are signed by the active consensus key, if one has been rotated in.
+func (s *BaconSigner) signTimed(opBytes []byte) (string, error) {
+
+	start := time.Now()
+
+	sig, err := s.signRawBytes(opBytes)
+
+	s.health.recordSignature(time.Since(start), err)
+
+	// Check the device now, rather than at the next interval
+	if err != nil && s.SignerType == SIGNER_LEDGER {
+		s.health.wake()
+	}
+
+	return sig, err
+}
+
+func (s *BaconSigner) signRawBytes(opBytes []byte) (string, error) {
+
+	if len(opBytes) > 0 && isConsensusWatermark(opBytes[0]) {
+
+		key, err := s.consensusSigningKey()
+		if err != nil {
+			return "", err
+		}
+
+		if key != nil {
+			return key.Sign(opBytes)
+		}
+	}
+
+	switch s.SignerType {
+	case SIGNER_WALLET:
+		return getWallet().SignBytes(opBytes)
+	case SIGNER_LEDGER:
+		s.checkDriftBeforeSigning(L, opBytes)
+		return L.SignBytes(opBytes)
+	case SIGNER_PKCS11:
+		return P.SignBytes(opBytes)
+	}
+	return "", NO_SIGNER_TYPE
+}
+
+// Helper function to return the hex of the raw signature, without the b58 prefix
+func decodeSignature(signature string) (string, error) {
+
+	decBytes, err := decodeSignatureBytes(signature)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode signature")
 	}
 
-	return decodedSigHex, nil
+	return hex.EncodeToString(decBytes), nil
 }
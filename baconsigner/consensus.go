@@ -0,0 +1,374 @@
+package baconsigner
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+const (
+	// update_consensus_key; not supported by go-tezos forge
+	UPDATE_CONSENSUS_KEY_TAG = 114
+)
+
+// Consensus keys are always software keys. The manager key, wallet or ledger,
+// continues to sign manager operations and votes after the switch.
+type consensusKeys struct {
+	active  storage.ConsensusKey
+	pending storage.ConsensusKey
+
+	// Decrypted keys; nil while locked
+	activeKey  *walletKey
+	pendingKey *walletKey
+
+	// Generated, or imported, through the wizard but not yet registered on chain
+	staged *walletKey
+}
+
+// Returned from the API; never includes secret keys
+type ConsensusKeyStatus struct {
+	ActivePkh       string `json:"activePkh"`
+	PendingPkh      string `json:"pendingPkh"`
+	ActivationCycle int    `json:"activationCycle"`
+	StagedPkh       string `json:"stagedPkh"`
+	Locked          bool   `json:"locked"`
+}
+
+// Loads consensus keys from DB; keys stay locked if no passphrase is available
+func (s *BaconSigner) loadConsensusKeys() error {
+
+	active, pending, err := s.db.GetConsensusKeys()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get consensus keys from DB")
+	}
+
+	s.consensusLock.Lock()
+	s.consensus.active = active
+	s.consensus.pending = pending
+	s.consensusLock.Unlock()
+
+	if !active.IsSet() && !pending.IsSet() {
+		return nil
+	}
+
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get wallet passphrase")
+	}
+
+	if passphrase == "" {
+		log.WithFields(log.Fields{
+			"Active": active.Pkh, "Pending": pending.Pkh,
+		}).Warn("Consensus key is encrypted; Waiting for unlock")
+
+		return nil
+	}
+
+	return s.unlockConsensusKeys(passphrase)
+}
+
+// Decrypts any locked consensus keys
+func (s *BaconSigner) unlockConsensusKeys(passphrase string) error {
+
+	s.consensusLock.Lock()
+	defer s.consensusLock.Unlock()
+
+	c := &s.consensus
+
+	if !c.active.IsSet() && !c.pending.IsSet() {
+		return nil
+	}
+
+	if c.active.IsSet() && c.activeKey == nil {
+		key, err := decryptSecretKey(c.active.Sk, passphrase)
+		if err != nil {
+			return errors.Wrap(err, "Unable to unlock consensus key")
+		}
+		c.activeKey = key
+	}
+
+	if c.pending.IsSet() && c.pendingKey == nil {
+		key, err := decryptSecretKey(c.pending.Sk, passphrase)
+		if err != nil {
+			return errors.Wrap(err, "Unable to unlock pending consensus key")
+		}
+		c.pendingKey = key
+	}
+
+	log.WithFields(log.Fields{
+		"Active": c.active.Pkh, "Pending": c.pending.Pkh, "ActivationCycle": c.pending.ActivationCycle,
+	}).Info("Loaded consensus keys")
+
+	return nil
+}
+
+// Any registered consensus key, active or pending, still encrypted
+func (s *BaconSigner) ConsensusKeysLocked() bool {
+
+	s.consensusLock.RLock()
+	defer s.consensusLock.RUnlock()
+
+	return s.consensus.locked()
+}
+
+func (c *consensusKeys) locked() bool {
+	return (c.active.IsSet() && c.activeKey == nil) || (c.pending.IsSet() && c.pendingKey == nil)
+}
+
+func (s *BaconSigner) ConsensusKeyStatus() ConsensusKeyStatus {
+
+	s.consensusLock.RLock()
+	defer s.consensusLock.RUnlock()
+
+	c := s.consensus
+
+	status := ConsensusKeyStatus{
+		ActivePkh:       c.active.Pkh,
+		PendingPkh:      c.pending.Pkh,
+		ActivationCycle: c.pending.ActivationCycle,
+		Locked:          c.locked(),
+	}
+
+	if c.staged != nil {
+		status.StagedPkh = c.staged.Address()
+	}
+
+	return status
+}
+
+// Generates a new consensus key of the given curve; it is not saved until registered
+func (s *BaconSigner) GenerateConsensusKey(curve string) (string, string, error) {
+
+	kind, err := ParseCurve(curve)
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := generateWalletKey(kind)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Failed to generate consensus key")
+	}
+
+	s.stageConsensusKey(key)
+
+	return key.SecretKey(), key.Address(), nil
+}
+
+// Imports a secret key to use as the consensus key; it is not saved until registered
+func (s *BaconSigner) ImportConsensusKey(sk, passphrase string) (string, error) {
+
+	var (
+		key *walletKey
+		err error
+	)
+
+	if isEncryptedKey(sk) {
+		key, err = decryptSecretKey(sk, passphrase)
+	} else {
+		key, err = parseSecretKey(sk)
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to import consensus key")
+	}
+
+	s.stageConsensusKey(key)
+
+	return key.Address(), nil
+}
+
+func (s *BaconSigner) stageConsensusKey(key *walletKey) {
+
+	s.consensusLock.Lock()
+	s.consensus.staged = key
+	s.consensusLock.Unlock()
+
+	log.WithField("PKH", key.Address()).Info("Staged new consensus key")
+}
+
+// Encrypts the staged consensus key, so registration fails before injection if there is no passphrase
+func (s *BaconSigner) EncryptStagedConsensusKey(passphrase string) (string, error) {
+
+	s.consensusLock.RLock()
+	staged := s.consensus.staged
+	s.consensusLock.RUnlock()
+
+	if staged == nil {
+		return "", errors.New("No consensus key; Generate or import one first")
+	}
+
+	if staged.Address() == s.BakerPkh {
+		return "", errors.New("Consensus key cannot be the baker's own key")
+	}
+
+	if passphrase == "" {
+
+		var err error
+
+		passphrase, err = getPassphrase()
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to get wallet passphrase")
+		}
+
+		if passphrase == "" {
+			return "", errors.New("A passphrase is required to encrypt the consensus key")
+		}
+	}
+
+	return encryptSecretKey(staged, passphrase)
+}
+
+// Saves the staged consensus key, after registration, as pending until the activation cycle.
+// Blocks and endorsements continue to be signed by the current key until then.
+func (s *BaconSigner) SavePendingConsensusKey(esk string, activationCycle int) error {
+
+	s.consensusLock.Lock()
+	defer s.consensusLock.Unlock()
+
+	c := &s.consensus
+
+	if c.staged == nil {
+		return errors.New("No consensus key staged")
+	}
+
+	pending := storage.ConsensusKey{
+		Sk:              esk,
+		Pkh:             c.staged.Address(),
+		ActivationCycle: activationCycle,
+	}
+
+	if err := s.db.SetPendingConsensusKey(pending); err != nil {
+		return errors.Wrap(err, "Unable to save consensus key")
+	}
+
+	c.pending = pending
+	c.pendingKey = c.staged
+	c.staged = nil
+
+	log.WithFields(log.Fields{
+		"PKH": pending.Pkh, "ActivationCycle": activationCycle,
+	}).Info("Saved pending consensus key")
+
+	return nil
+}
+
+// Switches to the pending consensus key once its activation cycle is reached. Returns
+// the newly active key's pkh, or an empty string if nothing changed.
+func (s *BaconSigner) ActivateConsensusKey(cycle int) (string, error) {
+
+	s.consensusLock.Lock()
+	defer s.consensusLock.Unlock()
+
+	c := &s.consensus
+
+	if !c.pending.IsSet() || cycle < c.pending.ActivationCycle {
+		return "", nil
+	}
+
+	if err := s.db.ActivatePendingConsensusKey(); err != nil {
+		return "", errors.Wrap(err, "Unable to activate consensus key")
+	}
+
+	c.active, c.activeKey = c.pending, c.pendingKey
+	c.pending, c.pendingKey = storage.ConsensusKey{}, nil
+
+	log.WithFields(log.Fields{
+		"PKH": c.active.Pkh, "Cycle": cycle,
+	}).Info("Switched to new consensus key")
+
+	return c.active.Pkh, nil
+}
+
+// Blocks, preendorsements and endorsements are signed by the consensus key; consensus keys
+// only exist on Tenderbake protocols, which use 0x11, 0x12 and 0x13
+func isConsensusWatermark(m byte) bool {
+	switch m {
+	case blockprefix[0], endorsementprefix[0], tbblockprefix[0], preendorsementprefix[0], tbendorsementprefix[0]:
+		return true
+	}
+	return false
+}
+
+// Returns the key which signs blocks and endorsements; nil means the baker's own key
+func (s *BaconSigner) consensusSigningKey() (*walletKey, error) {
+
+	s.consensusLock.RLock()
+	defer s.consensusLock.RUnlock()
+
+	if !s.consensus.active.IsSet() {
+		return nil, nil
+	}
+
+	if s.consensus.activeKey == nil {
+		return nil, WALLET_LOCKED
+	}
+
+	return s.consensus.activeKey, nil
+}
+
+// Forges update_consensus_key for the staged key, using the baker as source.
+// Returns the hex-encoded operation, without watermark, ready for SignUpdateConsensusKey.
+func (s *BaconSigner) ForgeUpdateConsensusKey(branch string, fee, counter, gasLimit, storageLimit int) (string, error) {
+
+	s.consensusLock.RLock()
+	staged := s.consensus.staged
+	s.consensusLock.RUnlock()
+
+	if staged == nil {
+		return "", errors.New("No consensus key staged")
+	}
+
+	branchBytes, err := b58cdecodeChecked(branch, branchprefix, 32)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid branch")
+	}
+
+	source, err := PkhBytes(s.BakerPkh)
+	if err != nil {
+		return "", err
+	}
+
+	op := append([]byte{}, branchBytes...)
+	op = append(op, UPDATE_CONSENSUS_KEY_TAG)
+	op = append(op, source...)
+
+	for _, n := range []int{fee, counter, gasLimit, storageLimit} {
+		op = append(op, encodeNat(n)...)
+	}
+
+	op = append(op, encodePublicKey(staged)...)
+
+	return hex.EncodeToString(op), nil
+}
+
+// tag(1) + public key
+func encodePublicKey(k *walletKey) []byte {
+
+	tag := byte(0)
+	switch k.kind {
+	case gtks.Secp256k1:
+		tag = 1
+	case gtks.NistP256:
+		tag = 2
+	}
+
+	return append([]byte{tag}, k.publicKeyBytes()...)
+}
+
+// Zarith encoded natural number
+func encodeNat(n int) []byte {
+
+	var b []byte
+
+	for n >= 0x80 {
+		b = append(b, byte(n&0x7f)|0x80)
+		n >>= 7
+	}
+
+	return append(b, byte(n))
+}
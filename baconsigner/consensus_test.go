@@ -0,0 +1,160 @@
+package baconsigner
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/forge"
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/storage"
+)
+
+func TestForgeUpdateConsensusKey(t *testing.T) {
+
+	branch := B58cencode(make([]byte, 32), branchprefix)
+
+	for _, v := range keyVectors[:3] {
+
+		s := &BaconSigner{BakerPkh: testBaker}
+		s.stageConsensusKey(loadVector(t, v.sk, v.passphrase))
+
+		opHex, err := s.ForgeUpdateConsensusKey(branch, 400, 11, 1100, 0)
+		if err != nil {
+			t.Fatalf("Unable to forge: %s", err)
+		}
+
+		// Same encoding as a reveal of the same key, other than the tag
+		revealHex, err := forge.Encode(branch, rpc.Content{
+			Kind: rpc.REVEAL, Source: testBaker, Fee: "400", Counter: "11", GasLimit: "1100", StorageLimit: "0",
+			PublicKey: v.pk,
+		})
+		if err != nil {
+			t.Fatalf("Unable to forge reveal: %s", err)
+		}
+
+		if opHex[:64] != revealHex[:64] || opHex[66:] != revealHex[66:] || opHex[64:66] != "72" {
+			t.Errorf("%s: forged %s, reveal %s", v.pk, opHex, revealHex)
+		}
+
+		opBytes, _ := hex.DecodeString(opHex)
+
+		contents, err := decodeOperation(append([]byte{genericopprefix[0]}, opBytes...))
+		if err != nil {
+			t.Fatalf("Unable to decode: %s", err)
+		}
+
+		if len(contents) != 1 || contents[0].Kind != OP_UPDATE_CONSENSUS_KEY ||
+			contents[0].Source != testBaker || contents[0].PublicKey != v.pk || !contents[0].IsManager() {
+			t.Errorf("Unexpected contents %+v", contents)
+		}
+	}
+}
+
+func TestStageOwnKey(t *testing.T) {
+
+	s := &BaconSigner{BakerPkh: keyVectors[0].pkh}
+	s.stageConsensusKey(loadVector(t, keyVectors[0].sk, ""))
+
+	if _, err := s.EncryptStagedConsensusKey("foo"); err == nil {
+		t.Errorf("Baker's own key accepted as consensus key")
+	}
+}
+
+// Tenderbake block header; magic, chain id, level
+func tenderbakeBlockBytes(level uint32) []byte {
+	b := blockBytes(level)
+	b[0] = tbblockprefix[0]
+	return b
+}
+
+// Tenderbake (pre)endorsement; magic, chain id, branch, tag, slot, level, round, block payload hash
+func tenderbakeBytes(magic prefix, level uint32) []byte {
+
+	tag := byte(21)
+	if magic[0] == preendorsementprefix[0] {
+		tag = 20
+	}
+
+	b := append([]byte{magic[0], 0x7a, 0x06, 0xa7, 0x70}, make([]byte, 32)...)
+	b = append(b, tag, 0, 0)
+	b = append(b, make([]byte, 4+4+32)...)
+	binary.BigEndian.PutUint32(b[40:44], level)
+
+	return b
+}
+
+// Blocks and endorsements switch to the consensus key at the activation cycle; other operations stay with the baker
+func TestActivateConsensusKey(t *testing.T) {
+
+	defer func(w *WalletSigner) { W = w }(W)
+
+	baker, consensus := keyVectors[0], keyVectors[2]
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	W = &WalletSigner{wallet: loadVector(t, baker.sk, ""), Pkh: baker.pkh, db: db}
+
+	s := &BaconSigner{BakerPkh: baker.pkh, SignerType: SIGNER_WALLET, db: db}
+	s.stageConsensusKey(loadVector(t, consensus.sk, consensus.passphrase))
+
+	esk, err := s.EncryptStagedConsensusKey("foo")
+	if err != nil {
+		t.Fatalf("Unable to encrypt consensus key: %s", err)
+	}
+
+	if err := s.SavePendingConsensusKey(esk, 10); err != nil {
+		t.Fatalf("Unable to save consensus key: %s", err)
+	}
+
+	// Signed by pk, or not
+	signedBy := func(opBytes []byte, pk string) bool {
+		sig, err := s.signRawBytes(opBytes)
+		if err != nil {
+			t.Fatalf("Unable to sign: %s", err)
+		}
+		return VerifySignature(pk, sig, opBytes) == nil
+	}
+
+	if pkh, err := s.ActivateConsensusKey(9); pkh != "" || err != nil {
+		t.Fatalf("Consensus key activated before its cycle: %s %v", pkh, err)
+	}
+
+	if !signedBy(blockBytes(100), baker.pk) || !signedBy(endorsementBytes(100), baker.pk) {
+		t.Errorf("Not signed by baker before activation")
+	}
+
+	if pkh, err := s.ActivateConsensusKey(10); pkh != consensus.pkh || err != nil {
+		t.Fatalf("Consensus key not activated: %s %v", pkh, err)
+	}
+
+	if !signedBy(blockBytes(101), consensus.pk) || !signedBy(endorsementBytes(101), consensus.pk) {
+		t.Errorf("Not signed by consensus key after activation")
+	}
+
+	// Consensus keys only exist on Tenderbake protocols
+	for _, opBytes := range [][]byte{tenderbakeBlockBytes(102), tenderbakeBytes(preendorsementprefix, 102), tenderbakeBytes(tbendorsementprefix, 102)} {
+
+		if _, err := decodeOperation(opBytes); err != nil {
+			t.Errorf("Unable to decode 0x%02x: %s", opBytes[0], err)
+		}
+
+		if !signedBy(opBytes, consensus.pk) {
+			t.Errorf("0x%02x not signed by consensus key after activation", opBytes[0])
+		}
+	}
+
+	if !signedBy(forgeGeneric(t, registration("10")...), baker.pk) {
+		t.Errorf("Manager operation not signed by baker after activation")
+	}
+
+	if active, pending, _ := db.GetConsensusKeys(); active.Pkh != consensus.pkh || pending.IsSet() {
+		t.Errorf("Unexpected consensus keys in DB; active %+v, pending %+v", active, pending)
+	}
+
+	// Once active, nothing more to switch
+	if pkh, err := s.ActivateConsensusKey(11); pkh != "" || err != nil {
+		t.Errorf("Consensus key activated twice: %s %v", pkh, err)
+	}
+}
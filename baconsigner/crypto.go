@@ -17,16 +17,33 @@ type prefix []byte
 var (
 	// For (de)constructing addresses
 	tz1prefix         prefix = []byte{6, 161, 159}
+	tz2prefix         prefix = []byte{6, 161, 161}
+	tz3prefix         prefix = []byte{6, 161, 164}
 	ktprefix          prefix = []byte{2, 90, 121}
 	edskprefix        prefix = []byte{43, 246, 78, 7}
 	edskprefix2       prefix = []byte{13, 15, 58, 7}
 	edpkprefix        prefix = []byte{13, 15, 37, 217}
 	edeskprefix       prefix = []byte{7, 90, 60, 179, 41}
+	spskprefix        prefix = []byte{17, 162, 224, 201}
+	speskprefix       prefix = []byte{9, 237, 241, 174, 150}
+	p2skprefix        prefix = []byte{16, 81, 238, 189}
+	p2eskprefix       prefix = []byte{9, 48, 57, 115, 171}
+	sppkprefix        prefix = []byte{3, 254, 226, 86}
+	p2pkprefix        prefix = []byte{3, 178, 139, 127}
+	edsigprefix       prefix = []byte{9, 245, 205, 134, 18}
+	spsigprefix       prefix = []byte{13, 115, 101, 19, 63}
+	p2sigprefix       prefix = []byte{54, 240, 44, 52}
+	sigprefix         prefix = []byte{4, 130, 43}
 	branchprefix      prefix = []byte{1, 52}
 	chainidprefix     prefix = []byte{57, 52, 00}
 	blockprefix       prefix = []byte{1}
 	endorsementprefix prefix = []byte{2}
 	genericopprefix   prefix = []byte{3}
+
+	// Tenderbake (Ithaca and later) block, preendorsement and endorsement watermarks
+	tbblockprefix        prefix = []byte{17}
+	preendorsementprefix prefix = []byte{18}
+	tbendorsementprefix  prefix = []byte{19}
 	networkprefix     prefix = []byte{87, 82, 0}
 )
 
@@ -48,6 +65,21 @@ func b58cdecode(payload string, prefix []byte) []byte {
 	return b58c[len(prefix):]
 }
 
+// b58cdecodeChecked is like b58cdecode, but validates the checksum, length and prefix
+func b58cdecodeChecked(payload string, p prefix, payloadLen int) ([]byte, error) {
+
+	b58c, err := decode(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b58c) != len(p)+payloadLen || !bytes.HasPrefix(b58c, p) {
+		return nil, errors.Errorf("Invalid length or prefix for %s", payload)
+	}
+
+	return b58c[len(p):], nil
+}
+
 const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
 
 func encode(dataBytes []byte) string {
@@ -0,0 +1,265 @@
+package baconsigner
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+
+	"bakinbacon/util"
+)
+
+// Software wallet keys are handled here rather than by go-tezos/keys, which
+// only loads ed25519 secret keys correctly; its secp256k1 and P-256 public
+// keys are wrong for some keys, and its ECDSA signatures are not padded.
+type walletKey struct {
+	kind gtks.ECKind
+
+	// ed25519 seed, or ECDSA private scalar; always 32 bytes
+	secret []byte
+}
+
+// ParseCurve converts a curve name, as used by the UI and octez, to a key kind.
+// An empty string is treated as ed25519.
+func ParseCurve(curve string) (gtks.ECKind, error) {
+	switch strings.ToLower(curve) {
+	case "", "ed25519", "tz1":
+		return gtks.Ed25519, nil
+	case "secp256k1", "tz2":
+		return gtks.Secp256k1, nil
+	case "p256", "p-256", "secp256r1", "tz3":
+		return gtks.NistP256, nil
+	}
+	return "", errors.Errorf("Unknown curve %s", curve)
+}
+
+// Returns the curve name, as accepted by ParseCurve
+func curveName(kind gtks.ECKind) string {
+	switch kind {
+	case gtks.Secp256k1:
+		return "secp256k1"
+	case gtks.NistP256:
+		return "p256"
+	}
+	return "ed25519"
+}
+
+// Returns the key kind of a public key hash (tz1, tz2, tz3)
+func curveFromPkh(pkh string) (gtks.ECKind, error) {
+	switch {
+	case strings.HasPrefix(pkh, "tz1"):
+		return gtks.Ed25519, nil
+	case strings.HasPrefix(pkh, "tz2"):
+		return gtks.Secp256k1, nil
+	case strings.HasPrefix(pkh, "tz3"):
+		return gtks.NistP256, nil
+	}
+	return "", errors.Errorf("Unknown public key hash type: %s", pkh)
+}
+
+// Generates a new random key of the given kind
+func generateWalletKey(kind gtks.ECKind) (*walletKey, error) {
+
+	for {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "Unable to read random bytes")
+		}
+
+		key, err := newWalletKey(kind, secret)
+		if err == nil {
+			return key, nil
+		}
+
+		// Scalar out of range for the curve; Astronomically rare, try again
+		if kind == gtks.Ed25519 {
+			return nil, err
+		}
+	}
+}
+
+// Decodes an unencrypted b58 secret key (edsk, spsk, p2sk)
+func parseSecretKey(sk string) (*walletKey, error) {
+
+	switch {
+	case strings.HasPrefix(sk, "edsk") && len(sk) == 98:
+
+		// 64-byte form is seed || public key
+		b, err := b58cdecodeChecked(sk, edskprefix, ed25519.PrivateKeySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode secret key")
+		}
+
+		key, err := newWalletKey(gtks.Ed25519, b[:ed25519.SeedSize])
+		if err != nil {
+			return nil, err
+		}
+
+		// Sanity check the embedded public key
+		if string(ed25519.NewKeyFromSeed(key.secret)[ed25519.SeedSize:]) != string(b[ed25519.SeedSize:]) {
+			return nil, errors.New("Secret key does not match embedded public key")
+		}
+
+		return key, nil
+
+	case strings.HasPrefix(sk, "edsk"):
+
+		b, err := b58cdecodeChecked(sk, edskprefix2, ed25519.SeedSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode secret key")
+		}
+
+		return newWalletKey(gtks.Ed25519, b)
+
+	case strings.HasPrefix(sk, "spsk"):
+
+		b, err := b58cdecodeChecked(sk, spskprefix, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode secret key")
+		}
+
+		return newWalletKey(gtks.Secp256k1, b)
+
+	case strings.HasPrefix(sk, "p2sk"):
+
+		b, err := b58cdecodeChecked(sk, p2skprefix, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode secret key")
+		}
+
+		return newWalletKey(gtks.NistP256, b)
+	}
+
+	return nil, errors.New("Unknown secret key type; Must be edsk, spsk or p2sk")
+}
+
+// Creates a key from the raw 32 secret bytes, checking ECDSA scalars are within the curve order
+func newWalletKey(kind gtks.ECKind, secret []byte) (*walletKey, error) {
+
+	if len(secret) != 32 {
+		return nil, errors.Errorf("Invalid secret key length %d", len(secret))
+	}
+
+	if kind != gtks.Ed25519 {
+
+		d := new(big.Int).SetBytes(secret)
+		if d.Sign() == 0 || d.Cmp(ecCurve(kind).Params().N) >= 0 {
+			return nil, errors.New("Secret key is out of range for curve")
+		}
+	}
+
+	s := make([]byte, 32)
+	copy(s, secret)
+
+	return &walletKey{
+		kind:   kind,
+		secret: s,
+	}, nil
+}
+
+func ecCurve(kind gtks.ECKind) elliptic.Curve {
+	if kind == gtks.Secp256k1 {
+		return btcec.S256()
+	}
+	return elliptic.P256()
+}
+
+// Returns the b58 encoded secret key; edsk keys use the 98-character form, as octez does
+func (k *walletKey) SecretKey() string {
+	switch k.kind {
+	case gtks.Secp256k1:
+		return B58cencode(k.secret, spskprefix)
+	case gtks.NistP256:
+		return B58cencode(k.secret, p2skprefix)
+	}
+	return B58cencode(ed25519.NewKeyFromSeed(k.secret), edskprefix)
+}
+
+// Returns the raw public key; 32 bytes for ed25519, 33 byte compressed point for ECDSA
+func (k *walletKey) publicKeyBytes() []byte {
+
+	if k.kind == gtks.Ed25519 {
+		return ed25519.NewKeyFromSeed(k.secret)[ed25519.SeedSize:]
+	}
+
+	curve := ecCurve(k.kind)
+	x, y := curve.ScalarBaseMult(k.secret)
+
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+// Returns the b58 encoded public key (edpk, sppk, p2pk)
+func (k *walletKey) PublicKey() string {
+	switch k.kind {
+	case gtks.Secp256k1:
+		return B58cencode(k.publicKeyBytes(), sppkprefix)
+	case gtks.NistP256:
+		return B58cencode(k.publicKeyBytes(), p2pkprefix)
+	}
+	return B58cencode(k.publicKeyBytes(), edpkprefix)
+}
+
+// Returns the public key hash (tz1, tz2, tz3)
+func (k *walletKey) Address() string {
+	// Cannot fail on a public key we encoded ourselves
+	pkh, _ := PublicKeyHash(k.PublicKey())
+	return pkh
+}
+
+// Hashes msg with blake2b and signs; returns the b58 encoded signature (edsig, spsig1, p2sig)
+func (k *walletKey) Sign(msg []byte) (string, error) {
+
+	msgHash, err := util.CryptoGenericHash(msg, []byte{})
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to hash message")
+	}
+
+	switch k.kind {
+	case gtks.Ed25519:
+
+		return B58cencode(ed25519.Sign(ed25519.NewKeyFromSeed(k.secret), msgHash), edsigprefix), nil
+
+	case gtks.Secp256k1:
+
+		// Deterministic (RFC6979) and normalized to low-S, as required by octez
+		privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), k.secret)
+
+		sig, err := privKey.Sign(msgHash)
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to sign with secp256k1 key")
+		}
+
+		return B58cencode(ecdsaSignatureBytes(sig.R, sig.S), spsigprefix), nil
+
+	case gtks.NistP256:
+
+		curve := elliptic.P256()
+		privKey := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.secret)}
+		privKey.Curve = curve
+		privKey.X, privKey.Y = curve.ScalarBaseMult(k.secret)
+
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, msgHash)
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to sign with P-256 key")
+		}
+
+		return B58cencode(ecdsaSignatureBytes(r, s), p2sigprefix), nil
+	}
+
+	return "", errors.Errorf("Unknown key kind %s", k.kind)
+}
+
+// Tezos ECDSA signatures are r || s, each left-padded to 32 bytes
+func ecdsaSignatureBytes(r, s *big.Int) []byte {
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig
+}
@@ -0,0 +1,227 @@
+package baconsigner
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+)
+
+// Known keys from octez
+var keyVectors = []struct {
+	sk         string
+	pk         string
+	pkh        string
+	passphrase string
+	kind       gtks.ECKind
+}{
+	{
+		sk:   "edsk4FTF78Qf1m2rykGpHqostAiq5gYW4YZEoGUSWBTJr2njsDHSnd",
+		pk:   "edpkv45regue1bWtuHnCgLU8xWKLwa9qRqv4gimgJKro4LSc3C5VjV",
+		pkh:  "tz1LggX2HUdvJ1tF4Fvv8fjsrzLeW4Jr9t2Q",
+		kind: gtks.Ed25519,
+	},
+	{
+		sk:   "spsk2oTAhiaSywh9ctt8yZLRxL3bo8Mayd3hKFi5iBaoqj2R8bx7ow",
+		pk:   "sppk7auhfZa5wAcR8hk3WCw47kHgG3Pp8zaP3ctdAqdDd2dBAeZBof1",
+		pkh:  "tz2VN9n2C56xGLykHCjhNvZQqUeTVisrHjxA",
+		kind: gtks.Secp256k1,
+	},
+	{
+		sk:   "p2sk35q9MJHLN1SBHNhKq7oho1vnZL28bYfsSKDUrDn2e4XVcp6ohZ",
+		pk:   "p2pk64zMPtYav6yiaHV2DhSQ65gbKMr3gkLQtK7TTQCpJEVUhxxEnxo",
+		pkh:  "tz3VCJEo1rRyyVejmpaRjbgGT9uE66sZmUtQ",
+		kind: gtks.NistP256,
+	},
+	{
+		sk:         "edesk1uiM6BaysskGto8pRtzKQqFqsy1sea1QRjTzaQYuBxYNhuN6eqEU78TGRXZocsVRJYcN7AaU9JDykwUd8KW",
+		pk:         "edpkttVn1coEZNjcjjAF36jDXDB377imNiKCHqjdXSt85eVN779jfX",
+		pkh:        "tz1MKPxkZLfdw31LL7zi55aZEoyH9DPL7eh7",
+		passphrase: "foo",
+		kind:       gtks.Ed25519,
+	},
+	{
+		sk:         "spesk246GnDVaqGoYZvKbjrWM1g6xUXnyETXtwZgEYFnP8BQXcaS4rfQQco7C94D1yBmcL1v46Sqy8fXrhBSM7TW",
+		pk:         "sppk7aSJpAzeXNTaobig65si221WTqgPh8mJsCJSAiZU7asJkWBVGyx",
+		pkh:        "tz29QkiEM1xf3chaZj6DjL5udNLbUZ8d6QJ4",
+		passphrase: "foo",
+		kind:       gtks.Secp256k1,
+	},
+	{
+		sk:         "p2esk27ocLPLp1JkTWfxByXysGyB7MBDURYJAzAGJLR3XSEV9Nq8wFFdDVXVTwvCwR7Ne2dcUveamjXbvZf3on6T",
+		pk:         "p2pk66vAYU7rN1ckJMp38Z9pXCrkiZCVyi6KyeMwhY69h5WDPHdMecH",
+		pkh:        "tz3Qa3kjWa6B3XgvZcVe24gTfjkc5WZRz59Q",
+		passphrase: "foo",
+		kind:       gtks.NistP256,
+	},
+}
+
+func loadVector(t *testing.T, sk, passphrase string) *walletKey {
+
+	var (
+		key *walletKey
+		err error
+	)
+
+	if isEncryptedKey(sk) {
+		key, err = decryptSecretKey(sk, passphrase)
+	} else {
+		key, err = parseSecretKey(sk)
+	}
+
+	if err != nil {
+		t.Fatalf("Unable to load %s: %s", sk, err)
+	}
+
+	return key
+}
+
+func TestKeyVectors(t *testing.T) {
+
+	for _, v := range keyVectors {
+
+		key := loadVector(t, v.sk, v.passphrase)
+
+		if key.kind != v.kind {
+			t.Errorf("%s: kind %s, expected %s", v.sk, key.kind, v.kind)
+		}
+
+		if pk := key.PublicKey(); pk != v.pk {
+			t.Errorf("%s: public key %s, expected %s", v.sk, pk, v.pk)
+		}
+
+		if pkh := key.Address(); pkh != v.pkh {
+			t.Errorf("%s: address %s, expected %s", v.sk, pkh, v.pkh)
+		}
+
+		// Unencrypted secret key round trip
+		if v.passphrase == "" && v.kind != gtks.Ed25519 && key.SecretKey() != v.sk {
+			t.Errorf("%s: secret key encoded as %s", v.sk, key.SecretKey())
+		}
+
+		reloaded, err := parseSecretKey(key.SecretKey())
+		if err != nil || reloaded.PublicKey() != v.pk {
+			t.Errorf("%s: unable to reload secret key %s: %v", v.sk, key.SecretKey(), err)
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	if _, err := decryptSecretKey(keyVectors[3].sk, "bar"); err == nil {
+		t.Errorf("Decrypted with wrong passphrase")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+
+	msg := []byte{0x02, 0x7a, 0x06, 0xa7, 0x70, 0xde, 0xad, 0xbe, 0xef}
+
+	for _, v := range keyVectors {
+
+		key := loadVector(t, v.sk, v.passphrase)
+
+		// Sign multiple times to catch unpadded r, s
+		for i := 0; i < 32; i++ {
+
+			msg[len(msg)-1] = byte(i)
+
+			sig, err := key.Sign(msg)
+			if err != nil {
+				t.Fatalf("%s: unable to sign: %s", v.sk, err)
+			}
+
+			if err := VerifySignature(v.pk, sig, msg); err != nil {
+				t.Errorf("%s: signature %s did not verify: %s", v.sk, sig, err)
+			}
+
+			decoded, err := decodeSignature(sig)
+			if err != nil {
+				t.Errorf("%s: unable to decode signature %s: %s", v.sk, sig, err)
+			}
+
+			if len(decoded) != 128 {
+				t.Errorf("%s: decoded signature %s is not 64 bytes", v.sk, decoded)
+			}
+		}
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+
+	for _, kind := range []gtks.ECKind{gtks.Ed25519, gtks.Secp256k1, gtks.NistP256} {
+
+		key, err := generateWalletKey(kind)
+		if err != nil {
+			t.Fatalf("Unable to generate %s key: %s", kind, err)
+		}
+
+		esk, err := encryptSecretKey(key, "correct horse")
+		if err != nil {
+			t.Fatalf("Unable to encrypt %s key: %s", kind, err)
+		}
+
+		if !isEncryptedKey(esk) || len(esk) != 88 {
+			t.Errorf("Unexpected encrypted key %s", esk)
+		}
+
+		decrypted, err := decryptSecretKey(esk, "correct horse")
+		if err != nil {
+			t.Fatalf("Unable to decrypt %s: %s", esk, err)
+		}
+
+		if decrypted.Address() != key.Address() {
+			t.Errorf("Decrypted address %s, expected %s", decrypted.Address(), key.Address())
+		}
+	}
+}
+
+func TestLedgerEncodings(t *testing.T) {
+
+	msg := []byte{0x01, 0x02, 0x03}
+
+	for _, v := range keyVectors[1:3] {
+
+		key := loadVector(t, v.sk, v.passphrase)
+
+		// Device returns uncompressed points
+		curve := ecCurve(key.kind)
+		x, y := curve.ScalarBaseMult(key.secret)
+
+		pk, pkh, err := ledgerPublicKey(key.kind, elliptic.Marshal(curve, x, y))
+		if err != nil || pk != v.pk || pkh != v.pkh {
+			t.Errorf("%s: ledger public key %s %s, %v", v.sk, pk, pkh, err)
+		}
+
+		// Device returns DER signatures, with parity in the first byte
+		sig, _ := key.Sign(msg)
+		sigBytes, _ := decodeSignatureBytes(sig)
+
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sigBytes[:32]),
+			new(big.Int).SetBytes(sigBytes[32:]),
+		})
+		if err != nil {
+			t.Fatalf("Unable to DER encode: %s", err)
+		}
+		der[0] |= 0x01
+
+		ledgerSig, err := ledgerSignature(key.kind, der)
+		if err != nil {
+			t.Fatalf("%s: unable to decode ledger signature: %s", v.sk, err)
+		}
+
+		if err := VerifySignature(v.pk, ledgerSig, msg); err != nil {
+			t.Errorf("%s: ledger signature did not verify: %s", v.sk, err)
+		}
+	}
+}
+
+func TestCurveFromPkh(t *testing.T) {
+	for _, v := range keyVectors {
+		if kind, err := curveFromPkh(v.pkh); err != nil || kind != v.kind {
+			t.Errorf("%s: curve %s, %v", v.pkh, kind, err)
+		}
+	}
+}
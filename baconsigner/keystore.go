@@ -0,0 +1,146 @@
+package baconsigner
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/pbkdf2"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// Secret keys are encrypted using the same scheme as octez (tezos-client), so an
+// 'edesk' from BakinBacon's DB can be imported there, and vice versa.
+const (
+	PBKDF2_ITERATIONS = 32768
+	SALT_LENGTH       = 8
+)
+
+var (
+	// Where to look for the wallet passphrase on startup; set from main
+	passphraseEnv  string
+	passphraseFile string
+)
+
+// Sets the environment variable name, and key file, to check for the wallet passphrase
+func SetPassphraseSources(env, file string) {
+	passphraseEnv = env
+	passphraseFile = file
+}
+
+// Returns the passphrase from the environment, or the key file, in that order.
+// An empty string means no passphrase source is available and the wallet must be
+// unlocked through the API.
+func getPassphrase() (string, error) {
+
+	if passphraseEnv != "" {
+		if p := os.Getenv(passphraseEnv); p != "" {
+			log.WithField("Env", passphraseEnv).Debug("Using wallet passphrase from environment")
+			return p, nil
+		}
+	}
+
+	if passphraseFile != "" {
+		p, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to read passphrase file")
+		}
+
+		log.WithField("File", passphraseFile).Debug("Using wallet passphrase from file")
+
+		return strings.TrimRight(string(p), "\r\n"), nil
+	}
+
+	return "", nil
+}
+
+// Checks if the secret key is encrypted by prefix (edesk, spesk, p2esk)
+func isEncryptedKey(sk string) bool {
+	return strings.HasPrefix(sk, "edesk") || strings.HasPrefix(sk, "spesk") || strings.HasPrefix(sk, "p2esk")
+}
+
+// Encrypts the secret key using pbkdf2/secretbox and returns the b58 encoded 'edesk', 'spesk' or 'p2esk'
+func encryptSecretKey(key *walletKey, passphrase string) (string, error) {
+
+	if passphrase == "" {
+		return "", errors.New("Passphrase cannot be empty")
+	}
+
+	salt := make([]byte, SALT_LENGTH)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "Unable to generate salt")
+	}
+
+	var nonce [24]byte // octez uses an all-zero nonce; the salt makes each key unique
+
+	secretKey := passphraseKey(passphrase, salt)
+
+	// Only the 32-byte seed, or scalar, is encrypted
+	sealed := secretbox.Seal(nil, key.secret, &nonce, &secretKey)
+
+	eskPrefix := edeskprefix
+	switch key.kind {
+	case gtks.Secp256k1:
+		eskPrefix = speskprefix
+	case gtks.NistP256:
+		eskPrefix = p2eskprefix
+	}
+
+	return B58cencode(append(salt, sealed...), eskPrefix), nil
+}
+
+// Decrypts an 'edesk', 'spesk' or 'p2esk' into a usable key
+func decryptSecretKey(esk, passphrase string) (*walletKey, error) {
+
+	if passphrase == "" {
+		return nil, errors.New("Passphrase required for encrypted secret key")
+	}
+
+	var (
+		kind      gtks.ECKind
+		eskPrefix prefix
+	)
+
+	switch {
+	case strings.HasPrefix(esk, "edesk"):
+		kind, eskPrefix = gtks.Ed25519, edeskprefix
+	case strings.HasPrefix(esk, "spesk"):
+		kind, eskPrefix = gtks.Secp256k1, speskprefix
+	case strings.HasPrefix(esk, "p2esk"):
+		kind, eskPrefix = gtks.NistP256, p2eskprefix
+	default:
+		return nil, errors.New("Unknown encrypted secret key type")
+	}
+
+	// salt + 32-byte secret + secretbox overhead
+	b, err := b58cdecodeChecked(esk, eskPrefix, SALT_LENGTH+32+secretbox.Overhead)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode encrypted secret key")
+	}
+
+	var nonce [24]byte
+
+	secretKey := passphraseKey(passphrase, b[:SALT_LENGTH])
+
+	secret, ok := secretbox.Open(nil, b[SALT_LENGTH:], &nonce, &secretKey)
+	if !ok {
+		return nil, errors.New("Unable to decrypt secret key; Wrong passphrase?")
+	}
+
+	return newWalletKey(kind, secret)
+}
+
+// Derives the secretbox key from the passphrase
+func passphraseKey(passphrase string, salt []byte) [32]byte {
+
+	var secretKey [32]byte
+	copy(secretKey[:], pbkdf2.Key([]byte(passphrase), salt, PBKDF2_ITERATIONS, 32, sha512.New))
+
+	return secretKey
+}
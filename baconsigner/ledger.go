@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
 	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
 	log "github.com/sirupsen/logrus"
 
@@ -22,33 +23,58 @@ type LedgerInfo struct {
 	PrevAuth bool   `json:"prevAuth"`
 	Pkh      string `json:"pkh"`
 	BipPath  string `json:"bipPath"`
+	Curve    string `json:"curve"`
 }
 
 type LedgerSigner struct {
 	Info   *LedgerInfo
 
-	// Actual object of the ledger
-	ledger *ledger.TezosLedger
-	lock sync.Mutex
+	// Device, or emulator, and the encoded BIP path of the key in use
+	transport LedgerTransport
+	bipPath   []byte
+	lock      sync.Mutex
+
+	// Curve of the baking key
+	kind gtks.ECKind
+
+	db storage.Store
 }
 
 var L *LedgerSigner
 
-func InitLedgerSigner() error {
+func InitLedgerSigner(db storage.Store) error {
 
-	L = &LedgerSigner{}
+	L = &LedgerSigner{db: db}
 	L.Info = &LedgerInfo{}
 
+	return L.open()
+}
+
+// Opens the device and checks it is set up to bake with the key in DB config
+func (s *LedgerSigner) open() error {
+
 	// Get device
-	dev, err := ledger.Get()
+	transport, err := openLedgerTransport()
 	if err != nil {
 		return errors.Wrap(err, "Cannot get ledger device")
 	}
 
-	L.ledger = dev
+	s.lock.Lock()
+	s.transport = transport
+	s.lock.Unlock()
+
+	if err := s.verify(); err != nil {
+		s.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *LedgerSigner) verify() error {
 
 	// Get bipPath and PKH from DB
-	pkh, dbBipPath, err := storage.DB.GetLedgerConfig()
+	pkh, dbBipPath, err := s.db.GetLedgerConfig()
 	if err != nil {
 		return errors.Wrap(err, "Cannot load ledger config from DB")
 	}
@@ -58,13 +84,19 @@ func InitLedgerSigner() error {
 		return errors.New("No BIP path found in DB. Cannot configure ledger.")
 	}
 
+	// Curve is determined by the baker's address (tz1, tz2, tz3)
+	s.kind, err = curveFromPkh(pkh)
+	if err != nil {
+		return errors.Wrap(err, "Cannot determine ledger key curve")
+	}
+
 	// Sanity check if wallet app is open instead of baking app
-	if _, err := L.IsBakingApp(); err != nil {
+	if _, err := s.IsBakingApp(); err != nil {
 		return err
 	}
 
 	// Get the bipPath that is authorized to bake
-	authBipPath, err := L.GetAuthorizedKeyPath()
+	authBipPath, authKind, err := s.GetAuthorizedKey()
 	if err != nil {
 		return errors.Wrap(err, "Cannot get auth BIP path from ledger")
 	}
@@ -74,13 +106,17 @@ func InitLedgerSigner() error {
 		return errors.New(fmt.Sprintf("Authorized BipPath, %s, does not match DB Config, %s", authBipPath, dbBipPath))
 	}
 
+	if authKind != s.kind {
+		return errors.New(fmt.Sprintf("Authorized curve, %s, does not match DB Config, %s", curveName(authKind), curveName(s.kind)))
+	}
+
 	// Set dbBipPath from DB config
-	if err := L.SetBipPath(dbBipPath); err != nil {
+	if err := s.SetBipPath(dbBipPath); err != nil {
 		return errors.Wrap(err, "Cannot set BIP path on ledger device")
 	}
 
 	// Get the pkh from dbBipPath from DB config
-	_, compPkh, err := L.GetPublicKey()
+	_, compPkh, err := s.GetPublicKey()
 	if err != nil {
 		return errors.Wrap(err, "Cannot fetch pkh from ledger")
 	}
@@ -89,10 +125,61 @@ func InitLedgerSigner() error {
 		return errors.New(fmt.Sprintf("Authorized PKH, %s, does not match DB Config, %s", compPkh, pkh))
 	}
 
-	L.Info.Pkh = pkh
-	L.Info.BipPath = authBipPath
+	s.Info.Pkh = pkh
+	s.Info.BipPath = authBipPath
+	s.Info.Curve = curveName(s.kind)
 
-	log.WithFields(log.Fields{"KeyPath": authBipPath, "PKH": pkh}).Debug("Ledger Baking Config")
+	log.WithFields(log.Fields{"KeyPath": authBipPath, "PKH": pkh, "Curve": s.Info.Curve}).Debug("Ledger Baking Config")
+
+	return nil
+}
+
+// Reconnect closes, and reopens, the device; used after the device was unplugged,
+// or the baking app was closed. The device must still be authorized for the same key.
+func (s *LedgerSigner) Reconnect() error {
+
+	s.Close()
+
+	// Checks run against a new signer, so signing is not blocked by the device
+	c := &LedgerSigner{Info: &LedgerInfo{}, db: s.db}
+	if err := c.open(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.transport, s.bipPath, s.kind = c.transport, c.bipPath, c.kind
+	*s.Info = *c.Info
+
+	return nil
+}
+
+// Ping checks the device is connected, in the baking app, and still authorized
+// for the configured key
+func (s *LedgerSigner) Ping() error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	version, err := s.getVersion()
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(version, "Wallet") {
+		return errors.New("The Tezos Wallet app is currently open. Please close it and open the Tezos Baking app.")
+	}
+
+	authBipPath, authKind, err := s.getAuthorizedKey()
+	if err != nil {
+		return err
+	}
+
+	if authBipPath != s.Info.BipPath || authKind != s.kind {
+		return errors.New(fmt.Sprintf("Authorized BipPath, %s (%s), does not match DB Config, %s (%s)",
+			authBipPath, curveName(authKind), s.Info.BipPath, curveName(s.kind)))
+	}
 
 	return nil
 }
@@ -102,7 +189,10 @@ func (s *LedgerSigner) Close() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.ledger.Close()
+	if s.transport != nil {
+		s.transport.Close()
+		s.transport = nil
+	}
 }
 
 // Gets the public key from ledger device
@@ -111,10 +201,7 @@ func (s *LedgerSigner) GetPublicKey() (string, string, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	// ledger.GetPublicKey returns (pk, pkh, error)
-	pk, pkh, err := s.ledger.GetPublicKey()
-
-	return pk, pkh, err
+	return s.getKey(ledger.GetPubKey)
 }
 
 func (s *LedgerSigner) SignBytes(opBytes []byte) (string, error) {
@@ -122,7 +209,7 @@ func (s *LedgerSigner) SignBytes(opBytes []byte) (string, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	return s.ledger.SignBytes(opBytes) // Returns b58 encoded signature
+	return s.signBytes(opBytes) // Returns b58 encoded signature
 }
 
 func (s *LedgerSigner) IsBakingApp() (string, error) {
@@ -130,7 +217,7 @@ func (s *LedgerSigner) IsBakingApp() (string, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	version, err := s.ledger.GetVersion()
+	version, err := s.getVersion()
 	if err != nil {
 		log.WithError(err).Error("Unable to GetVersion")
 		return "", errors.Wrap(err, "Unable to get app version")
@@ -144,12 +231,13 @@ func (s *LedgerSigner) IsBakingApp() (string, error) {
 	return version, nil
 }
 
-func (s *LedgerSigner) GetAuthorizedKeyPath() (string, error) {
+// Returns the BIP path, and curve, of the key authorized for baking
+func (s *LedgerSigner) GetAuthorizedKey() (string, gtks.ECKind, error) {
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	return s.ledger.GetAuthorizedKeyPath()
+	return s.getAuthorizedKey()
 }
 
 func (s *LedgerSigner) SetBipPath(p string) error {
@@ -157,24 +245,33 @@ func (s *LedgerSigner) SetBipPath(p string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	return s.ledger.SetBipPath(p)
+	bipPath, err := encodeBipPath(p)
+	if err != nil {
+		return err
+	}
+
+	s.bipPath = bipPath
+
+	return nil
 }
 
 //
 // This function is only called from web UI during initial setup.
 // It will open the ledger, get the version string of the running app, and
 // fetch either the currently auth'd baking key, or fetch the default BIP path key
-func TestLedger() (*LedgerInfo, error) {
+// using the given curve
+func TestLedger(db storage.Store, kind gtks.ECKind) (*LedgerInfo, error) {
 
-	L = &LedgerSigner{}
+	L = &LedgerSigner{db: db}
 	L.Info = &LedgerInfo{}
+	L.kind = kind
 
 	// Get device
-	dev, err := ledger.Get()
+	transport, err := openLedgerTransport()
 	if err != nil {
 		return L.Info, errors.Wrap(err, "Cannot get ledger device")
 	}
-	L.ledger = dev
+	L.transport = transport
 
 	version, err := L.IsBakingApp()
 	if err != nil {
@@ -187,20 +284,25 @@ func TestLedger() (*LedgerInfo, error) {
 	// Check if ledger is already configured for baking
 	L.Info.BipPath = DEFAULT_BIP_PATH
 
-	bipPath, err := L.GetAuthorizedKeyPath()
+	bipPath, authKind, err := L.GetAuthorizedKey()
 	if err != nil {
-		log.WithError(err).Error("Unable to GetAuthorizedKeyPath")
+		log.WithError(err).Error("Unable to GetAuthorizedKey")
 		return L.Info, errors.Wrap(err, "Unable to query auth path")
 	}
 
 	// Check returned path from device
 	if bipPath != "" {
 		// Ledger is already setup for baking
-		log.WithField("Path", bipPath).Info("Ledger previously configured for baking")
+		log.WithFields(log.Fields{
+			"Path": bipPath, "Curve": curveName(authKind),
+		}).Info("Ledger previously configured for baking")
 		L.Info.PrevAuth = true
 		L.Info.BipPath = bipPath
+		L.kind = authKind
 	}
 
+	L.Info.Curve = curveName(L.kind)
+
 	// Get the key from the path
 	if err := L.SetBipPath(L.Info.BipPath); err != nil {
 		log.WithError(err).Error("Unable to SetBipPath")
@@ -226,6 +328,16 @@ func (s *LedgerSigner) ConfirmBakingPkh(pkh, bipPath string) error {
 		"PKH": pkh, "Path": bipPath,
 	}).Debug("Confirming Baking PKH")
 
+	// Curve is determined by the address being confirmed (tz1, tz2, tz3)
+	kind, err := curveFromPkh(pkh)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.kind = kind
+	s.lock.Unlock()
+
 	// Get the key from the path
 	if err := s.SetBipPath(bipPath); err != nil {
 		log.WithError(err).Error("Unable to SetBipPath")
@@ -233,7 +345,7 @@ func (s *LedgerSigner) ConfirmBakingPkh(pkh, bipPath string) error {
 	}
 
 	// Ask user to confirm PKH and authorize for baking
-	_, authPkh, err := s.ledger.AuthorizeBaking()
+	_, authPkh, err := s.authorizeBaking()
 	if err != nil {
 		log.WithError(err).Error("Unable to AuthorizeBaking")
 		return errors.Wrap(err, "Unable to authorize baking on device")
@@ -248,13 +360,14 @@ func (s *LedgerSigner) ConfirmBakingPkh(pkh, bipPath string) error {
 	}
 
 	// Save config to DB
-	if err := storage.DB.SaveLedgerToDB(authPkh, bipPath, SIGNER_LEDGER); err != nil {
+	if err := s.db.SaveLedgerToDB(authPkh, bipPath, SIGNER_LEDGER); err != nil {
 		log.WithError(err).Error("Cannot save key/wallet to db")
 		return err
 	}
 
 	s.Info.Pkh = authPkh
 	s.Info.BipPath = bipPath
+	s.Info.Curve = curveName(kind)
 
 	log.WithFields(log.Fields{
 		"BakerPKH": authPkh, "BipPath": bipPath,
@@ -264,10 +377,19 @@ func (s *LedgerSigner) ConfirmBakingPkh(pkh, bipPath string) error {
 	return nil
 }
 
+// Prompts user on device to authorize the current BIP path for baking
+func (s *LedgerSigner) authorizeBaking() (string, string, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.getKey(ledger.AuthBaking)
+}
+
 // Saves Sk/Pkh to DB
 func (s *LedgerSigner) SaveSigner() error {
 
-	if err := storage.DB.SaveLedgerToDB(s.Info.Pkh, s.Info.BipPath, SIGNER_LEDGER); err != nil {
+	if err := s.db.SaveLedgerToDB(s.Info.Pkh, s.Info.BipPath, SIGNER_LEDGER); err != nil {
 		log.WithError(err).Error("Cannot save key/wallet to db")
 		return err
 	}
@@ -0,0 +1,285 @@
+package baconsigner
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	goledger "github.com/bakingbacon/goledger"
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+)
+
+// APDUs of the Tezos Baking app, built here rather than by goledger's Tezos app,
+// which only speaks ed25519 (P2 is always 0) and only talks to a USB device.
+// Every command goes through the LedgerTransport. Callers must hold the LedgerSigner lock.
+// https://github.com/LedgerHQ/app-tezos/blob/master/APDUs.md
+
+const HARDENED = 0x80000000
+
+var bipPathSection = regexp.MustCompile(`^/(\d+)([hH']?)`)
+
+// Derivation type sent in P2 of each APDU
+func derivationType(kind gtks.ECKind) uint8 {
+	switch kind {
+	case gtks.Secp256k1:
+		return 0x01
+	case gtks.NistP256:
+		return 0x02
+	}
+	return 0x00
+}
+
+func kindFromDerivationType(d uint8) (gtks.ECKind, error) {
+	switch d {
+	case 0x00, 0x03:
+		return gtks.Ed25519, nil
+	case 0x01:
+		return gtks.Secp256k1, nil
+	case 0x02:
+		return gtks.NistP256, nil
+	}
+	return "", errors.Errorf("Unknown ledger derivation type %d", d)
+}
+
+// Encodes a BIP32 path, ie: /44'/1729'/0'/1', as the number of components
+// followed by each component as a big endian uint32
+func encodeBipPath(path string) ([]byte, error) {
+
+	encoded := []byte{0}
+
+	for rest := path; rest != ""; {
+
+		section := bipPathSection.FindStringSubmatch(rest)
+		if section == nil {
+			return nil, errors.Errorf("Invalid BIP path %s", path)
+		}
+		rest = rest[len(section[0]):]
+
+		val, err := strconv.ParseUint(section[1], 10, 32)
+		if err != nil || val >= HARDENED {
+			return nil, errors.Errorf("Invalid child index in BIP path %s", path)
+		}
+
+		if section[2] != "" {
+			val += HARDENED
+		}
+
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(val))
+
+		encoded = append(encoded, b[:]...)
+		encoded[0]++
+	}
+
+	if encoded[0] == 0 {
+		return nil, errors.New("BIP path is empty")
+	}
+
+	return encoded, nil
+}
+
+// Writes a single APDU and returns the response
+func (s *LedgerSigner) exchange(ins, p1 uint8, cdata []byte) ([]byte, error) {
+
+	if s.transport == nil {
+		return nil, errors.New("Ledger is not open")
+	}
+
+	if len(cdata) > 255 {
+		return nil, errors.Errorf("APDU data too long, %d bytes", len(cdata))
+	}
+
+	apdu := append([]byte{ledger.CLA, ins, p1, derivationType(s.kind), byte(len(cdata))}, cdata...)
+
+	return s.transport.Exchange(apdu)
+}
+
+// Returns the version string of the open app, ie: Baking 2.2.11
+func (s *LedgerSigner) getVersion() (string, error) {
+
+	resp, err := s.exchange(ledger.Version, 0x00, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to get version")
+	}
+
+	if len(resp) < 4 {
+		return "", ledger.ErrLengthMismatch
+	}
+
+	// https://github.com/LedgerHQ/app-tezos/blob/master/src/version.h
+	class := "Wallet"
+	if resp[0] == 1 {
+		class = "Baking"
+	}
+
+	return fmt.Sprintf("%s %d.%d.%d", class, resp[1], resp[2], resp[3]), nil
+}
+
+// Gets, or authorizes, the key at the current BIP path. Returns pk and pkh.
+func (s *LedgerSigner) getKey(ins uint8) (string, string, error) {
+
+	if len(s.bipPath) == 0 {
+		return "", "", errors.New("No BIP Path is set; Use SetBipPath()")
+	}
+
+	resp, err := s.exchange(ins, 0x00, s.bipPath)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Unable to read key request")
+	}
+
+	// First byte is length of the key
+	if len(resp) < 2 || int(resp[0]) != len(resp[1:]) {
+		return "", "", ledger.ErrLengthMismatch
+	}
+
+	return ledgerPublicKey(s.kind, resp[1:])
+}
+
+// Converts the public key returned by the device into b58 pk and pkh.
+// ed25519 keys are 0x02 || key; ECDSA keys are uncompressed points.
+func ledgerPublicKey(kind gtks.ECKind, raw []byte) (string, string, error) {
+
+	var pk string
+
+	switch kind {
+	case gtks.Ed25519:
+
+		if len(raw) != 33 {
+			return "", "", ledger.ErrLengthMismatch
+		}
+
+		pk = B58cencode(raw[1:], edpkprefix)
+
+	case gtks.Secp256k1:
+
+		pubKey, err := btcec.ParsePubKey(raw, btcec.S256())
+		if err != nil {
+			return "", "", errors.Wrap(err, "Unable to parse secp256k1 public key")
+		}
+
+		pk = B58cencode(pubKey.SerializeCompressed(), sppkprefix)
+
+	case gtks.NistP256:
+
+		x, y := elliptic.Unmarshal(elliptic.P256(), raw)
+		if x == nil {
+			return "", "", errors.New("Unable to parse P-256 public key")
+		}
+
+		pk = B58cencode(elliptic.MarshalCompressed(elliptic.P256(), x, y), p2pkprefix)
+	}
+
+	pkh, err := PublicKeyHash(pk)
+	if err != nil {
+		return pk, "", err
+	}
+
+	return pk, pkh, nil
+}
+
+// Signing takes two APDUs; the first with the BIP path to use, then the bytes
+// to sign. Returns the b58 encoded signature.
+func (s *LedgerSigner) signBytes(bytesToSign []byte) (string, error) {
+
+	if len(s.bipPath) == 0 {
+		return "", errors.New("No BIP Path is set; Use SetBipPath()")
+	}
+
+	if _, err := s.exchange(ledger.SignBytes, 0x00, s.bipPath); err != nil {
+		return "", errors.Wrap(err, "Unable to sign bytes (1)")
+	}
+
+	resp, err := s.exchange(ledger.SignBytes, 0x81, bytesToSign)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to read bytes signature")
+	}
+
+	return ledgerSignature(s.kind, resp)
+}
+
+// Converts the signature returned by the device into a b58 signature. ECDSA
+// signatures are DER encoded, with the parity of R in the low bit of the first byte.
+func ledgerSignature(kind gtks.ECKind, resp []byte) (string, error) {
+
+	if kind == gtks.Ed25519 {
+		if len(resp) != 64 {
+			return "", ledger.ErrLengthMismatch
+		}
+		return B58cencode(resp, edsigprefix), nil
+	}
+
+	if len(resp) == 0 {
+		return "", ledger.ErrLengthZero
+	}
+
+	der := make([]byte, len(resp))
+	copy(der, resp)
+	der[0] &= 0xfe
+
+	var sig struct {
+		R, S *big.Int
+	}
+
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return "", errors.Wrap(err, "Unable to decode ledger signature")
+	}
+
+	if kind == gtks.Secp256k1 {
+
+		// octez only accepts low-S secp256k1 signatures
+		n := btcec.S256().N
+		if sig.S.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+			sig.S.Sub(n, sig.S)
+		}
+
+		return B58cencode(ecdsaSignatureBytes(sig.R, sig.S), spsigprefix), nil
+	}
+
+	return B58cencode(ecdsaSignatureBytes(sig.R, sig.S), p2sigprefix), nil
+}
+
+// Returns the BIP path and curve of the authorized baking key. Older versions
+// of the baking app do not support the query with curve; assume ed25519.
+func (s *LedgerSigner) getAuthorizedKey() (string, gtks.ECKind, error) {
+
+	resp, err := s.exchange(ledger.QueryBakingKey, 0x00, nil)
+	if err != nil || len(resp) < 2 {
+
+		resp, err := s.exchange(ledger.GetAuthKey, 0x00, nil)
+		if err != nil {
+			return "", "", errors.Wrap(err, "Unable to read auth request")
+		}
+
+		if len(resp) == 0 {
+			return "", gtks.Ed25519, nil
+		}
+
+		path, err := goledger.DecodeBipPath(resp)
+		return path, gtks.Ed25519, err
+	}
+
+	kind, err := kindFromDerivationType(resp[0])
+	if err != nil {
+		return "", "", err
+	}
+
+	// No authorized key
+	if resp[1] == 0 {
+		return "", kind, nil
+	}
+
+	path, err := goledger.DecodeBipPath(resp[1:])
+	if err != nil {
+		return "", "", err
+	}
+
+	return path, kind, nil
+}
@@ -0,0 +1,465 @@
+package baconsigner
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/util"
+)
+
+// Status words of the Tezos Baking app
+type ledgerStatus uint16
+
+const (
+	swDenied        ledgerStatus = 0x6985
+	swSecurity      ledgerStatus = 0x6982
+	swWatermark     ledgerStatus = 0x6a80
+	swWrongParam    ledgerStatus = 0x6b00
+	swWrongLength   ledgerStatus = 0x6c00
+	swUnknownIns    ledgerStatus = 0x6d00
+	swClassNotFound ledgerStatus = 0x6e00
+	swParseError    ledgerStatus = 0x9405
+)
+
+// Same messages as goledger returns for a physical device
+func (s ledgerStatus) Error() string {
+	switch s {
+	case swDenied:
+		return "Operation denied by the user"
+	case swSecurity:
+		return "Key is not authorized for baking"
+	case swWatermark:
+		return "Level is below safety watermark"
+	case swWrongParam:
+		return "Incorrect parameters received P1/P2"
+	case swWrongLength:
+		return "Wrong length"
+	case swUnknownIns:
+		return "Unsupported Instruction"
+	case swClassNotFound:
+		return "Unexpected state of device: verify that the right application is opened?"
+	case swParseError:
+		return "Parse error"
+	}
+	return fmt.Sprintf("Unknown status 0x%02x", uint16(s))
+}
+
+// Highest level signed on a chain, and if an endorsement was signed at that level
+type emulatorHWM struct {
+	level    uint32
+	endorsed bool
+}
+
+// LedgerEmulator is a software model of the Tezos Baking app (2.2), for tests and demos
+// without a device. It implements LedgerTransport, speaks the same APDUs, and enforces
+// the same high watermark rules. Keys are derived from the seed with blake2b, not
+// BIP32; a real device with the same seed has different keys.
+type LedgerEmulator struct {
+	// Called for actions which need a button press on the device; return false
+	// to reject. If nil, everything is confirmed.
+	Confirm func(prompt string) bool
+
+	// Answer as the Tezos Wallet app, to test detection of the wrong app
+	WalletApp bool
+
+	seed []byte
+	lock sync.Mutex
+
+	// Authorized baking key; authPath is nil when not authorized
+	authPath []byte
+	authKind gtks.ECKind
+
+	// Main chain id is zero until set up; any chain is then treated as main
+	chainID   []byte
+	main      emulatorHWM
+	test      emulatorHWM
+	signPath  []byte
+	signKind  gtks.ECKind
+	signReady bool
+}
+
+// NewLedgerEmulator creates an emulated device, in the Baking app, with no key authorized
+func NewLedgerEmulator(seed []byte) *LedgerEmulator {
+
+	s := make([]byte, len(seed))
+	copy(s, seed)
+
+	return &LedgerEmulator{
+		seed:    s,
+		chainID: make([]byte, 4),
+	}
+}
+
+// LoadLedgerEmulator creates an emulated device from the seed in file; a random
+// seed is saved to the file if it does not exist, so keys survive restarts.
+func LoadLedgerEmulator(file string) (*LedgerEmulator, error) {
+
+	seed, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+
+		seed = make([]byte, 32)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, errors.Wrap(err, "Unable to generate emulator seed")
+		}
+
+		if err := ioutil.WriteFile(file, seed, 0600); err != nil {
+			return nil, errors.Wrap(err, "Unable to save emulator seed")
+		}
+
+		log.WithField("File", file).Info("Created new ledger emulator seed")
+
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Unable to read emulator seed")
+	}
+
+	return NewLedgerEmulator(seed), nil
+}
+
+// HighWatermark returns the main chain high watermark, as GetMainHWM would
+func (e *LedgerEmulator) HighWatermark() int {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return int(e.main.level)
+}
+
+// Exchange handles one command APDU; LedgerTransport
+func (e *LedgerEmulator) Exchange(apdu []byte) ([]byte, error) {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(apdu) < 5 || int(apdu[4]) != len(apdu[5:]) {
+		return nil, swWrongLength
+	}
+
+	if apdu[0] != ledger.CLA {
+		return nil, swClassNotFound
+	}
+
+	ins, p1, p2, cdata := apdu[1], apdu[2], apdu[3], apdu[5:]
+
+	kind, err := kindFromDerivationType(p2)
+	if err != nil {
+		return nil, swWrongParam
+	}
+
+	// Wallet app only answers the version; none of the baking commands exist
+	if e.WalletApp && ins != ledger.Version {
+		return nil, swUnknownIns
+	}
+
+	switch ins {
+	case ledger.Version:
+
+		if e.WalletApp {
+			return []byte{0, 2, 2, 11}, nil
+		}
+
+		return []byte{1, 2, 2, 11}, nil
+
+	case ledger.GetPubKey, ledger.PromptPubKey:
+
+		key, err := e.key(cdata, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if ins == ledger.PromptPubKey && !e.confirm(fmt.Sprintf("Provide public key %s", key.Address())) {
+			return nil, swDenied
+		}
+
+		return emulatorPublicKey(key), nil
+
+	case ledger.AuthBaking:
+
+		key, err := e.key(cdata, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.confirm(fmt.Sprintf("Authorize baking with public key %s", key.Address())) {
+			return nil, swDenied
+		}
+
+		e.authPath, e.authKind = append([]byte{}, cdata...), kind
+
+		return emulatorPublicKey(key), nil
+
+	case ledger.BakingSetup:
+
+		// chain id, main hwm, test hwm, BIP path
+		if len(cdata) < 12 {
+			return nil, swWrongLength
+		}
+
+		key, err := e.key(cdata[12:], kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.confirm(fmt.Sprintf("Setup baking with public key %s", key.Address())) {
+			return nil, swDenied
+		}
+
+		e.authPath, e.authKind = append([]byte{}, cdata[12:]...), kind
+		e.chainID = append([]byte{}, cdata[:4]...)
+		e.main = emulatorHWM{level: binary.BigEndian.Uint32(cdata[4:8])}
+		e.test = emulatorHWM{level: binary.BigEndian.Uint32(cdata[8:12])}
+
+		return emulatorPublicKey(key), nil
+
+	case ledger.DeauthBaking:
+
+		e.authPath = nil
+
+		return nil, nil
+
+	case ledger.GetAuthKey:
+
+		if e.authPath == nil {
+			return []byte{0}, nil
+		}
+
+		return e.authPath, nil
+
+	case ledger.QueryBakingKey:
+
+		if e.authPath == nil {
+			return []byte{derivationType(e.authKind), 0}, nil
+		}
+
+		return append([]byte{derivationType(e.authKind)}, e.authPath...), nil
+
+	case ledger.ResetHLW:
+
+		if len(cdata) != 4 {
+			return nil, swWrongLength
+		}
+
+		level := binary.BigEndian.Uint32(cdata)
+
+		if !e.confirm(fmt.Sprintf("Reset HWM to %d", level)) {
+			return nil, swDenied
+		}
+
+		e.main = emulatorHWM{level: level}
+		e.test = emulatorHWM{level: level}
+
+		return nil, nil
+
+	case ledger.GetMainHWM:
+
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, e.main.level)
+
+		return b, nil
+
+	case ledger.GetBakingHLW:
+
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b[:4], e.main.level)
+		binary.BigEndian.PutUint32(b[4:], e.test.level)
+
+		return append(b, e.chainID...), nil
+
+	case ledger.SignBytes:
+
+		// First part is the BIP path; last part (0x80 set) is the data
+		if p1&0x80 == 0 {
+
+			if _, err := e.key(cdata, kind); err != nil {
+				return nil, err
+			}
+
+			e.signPath, e.signKind, e.signReady = append([]byte{}, cdata...), kind, true
+
+			return nil, nil
+		}
+
+		if !e.signReady {
+			return nil, swWrongParam
+		}
+		e.signReady = false
+
+		return e.sign(cdata)
+	}
+
+	return nil, swUnknownIns
+}
+
+// Close does nothing; state is kept so the emulator can be opened again
+func (e *LedgerEmulator) Close() {
+}
+
+// Signs blocks and endorsements with the authorized key, above the high watermark.
+// Other operations need confirmation, and the baking app only allows reveals and delegations.
+func (e *LedgerEmulator) sign(data []byte) ([]byte, error) {
+
+	if len(data) == 0 {
+		return nil, swParseError
+	}
+
+	switch data[0] {
+	case blockprefix[0], endorsementprefix[0]:
+
+		if e.authPath == nil || !bytes.Equal(e.signPath, e.authPath) || e.signKind != e.authKind {
+			return nil, swSecurity
+		}
+
+		// magic(1) + chain_id(4) + level(4) for blocks; endorsements have the branch(32)
+		// and a tag before the level
+		var level uint32
+		isEndorsement := data[0] == endorsementprefix[0]
+
+		if isEndorsement {
+			if len(data) < 42 || data[37] != 0 {
+				return nil, swParseError
+			}
+			level = binary.BigEndian.Uint32(data[38:42])
+		} else {
+			if len(data) < 9 {
+				return nil, swParseError
+			}
+			level = binary.BigEndian.Uint32(data[5:9])
+		}
+
+		hwm := &e.main
+		if !bytes.Equal(e.chainID, make([]byte, 4)) && !bytes.Equal(e.chainID, data[1:5]) {
+			hwm = &e.test
+		}
+
+		// Blocks must be above the watermark; one endorsement is allowed at the watermark level
+		if level < hwm.level || (level == hwm.level && (!isEndorsement || hwm.endorsed)) {
+			return nil, swWatermark
+		}
+
+		if level > hwm.level {
+			*hwm = emulatorHWM{level: level}
+		}
+		hwm.endorsed = hwm.endorsed || isEndorsement
+
+	case genericopprefix[0]:
+
+		contents, err := decodeOperation(data)
+		if err != nil {
+			return nil, swParseError
+		}
+
+		for _, c := range contents {
+			if c.Kind != OP_REVEAL && c.Kind != OP_DELEGATION {
+				return nil, swParseError
+			}
+
+			if !e.confirm(fmt.Sprintf("Confirm %s", c)) {
+				return nil, swDenied
+			}
+		}
+
+	default:
+		return nil, swParseError
+	}
+
+	key, err := e.deriveKey(e.signPath, e.signKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return emulatorSignature(key, data)
+}
+
+// Derives the key at an encoded BIP path
+func (e *LedgerEmulator) key(bipPath []byte, kind gtks.ECKind) (*walletKey, error) {
+
+	if len(bipPath) < 1 || len(bipPath) != 1+4*int(bipPath[0]) {
+		return nil, swWrongLength
+	}
+
+	return e.deriveKey(bipPath, kind)
+}
+
+// Secret is blake2b(seed || derivation type || path || counter), the counter
+// bumped until the secret is a valid ECDSA scalar
+func (e *LedgerEmulator) deriveKey(bipPath []byte, kind gtks.ECKind) (*walletKey, error) {
+
+	msg := append(append(append([]byte{}, e.seed...), derivationType(kind)), bipPath...)
+
+	for i := byte(0); i < 255; i++ {
+
+		secret, err := util.CryptoGenericHash(append(msg, i), []byte{})
+		if err != nil {
+			return nil, err
+		}
+
+		if key, err := newWalletKey(kind, secret); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("Unable to derive emulator key")
+}
+
+// Length prefixed public key; ed25519 as 0x02 || key, ECDSA as an uncompressed point
+func emulatorPublicKey(key *walletKey) []byte {
+
+	var raw []byte
+
+	if key.kind == gtks.Ed25519 {
+		raw = append([]byte{0x02}, ed25519.NewKeyFromSeed(key.secret)[ed25519.SeedSize:]...)
+	} else {
+		curve := ecCurve(key.kind)
+		x, y := curve.ScalarBaseMult(key.secret)
+		raw = elliptic.Marshal(curve, x, y)
+	}
+
+	return append([]byte{byte(len(raw))}, raw...)
+}
+
+// Raw ed25519 signature, or DER encoded ECDSA signature, as the device returns
+func emulatorSignature(key *walletKey, data []byte) ([]byte, error) {
+
+	sig, err := key.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sigBytes, err := decodeSignatureBytes(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.kind == gtks.Ed25519 {
+		return sigBytes, nil
+	}
+
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(sigBytes[:32]),
+		new(big.Int).SetBytes(sigBytes[32:]),
+	})
+}
+
+func (e *LedgerEmulator) confirm(prompt string) bool {
+
+	if e.Confirm == nil {
+		log.WithField("Prompt", prompt).Warn("Emulated ledger confirmed")
+		return true
+	}
+
+	return e.Confirm(prompt)
+}
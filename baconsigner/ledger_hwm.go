@@ -0,0 +1,171 @@
+package baconsigner
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+	log "github.com/sirupsen/logrus"
+)
+
+// High watermarks kept by the baking app. The device refuses to sign blocks, or
+// endorsements, at or below these levels. Blocks and endorsements from the main
+// chain are checked against Main, any other chain against Test.
+type LedgerWatermarks struct {
+	ChainID string `json:"chainId"` // Empty if the device was not set up for a chain; any chain is main
+	Main    int    `json:"main"`
+	Test    int    `json:"test"`
+}
+
+// GetHighWatermarks reads the high watermarks of each chain from the device
+func (s *LedgerSigner) GetHighWatermarks() (*LedgerWatermarks, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.getHighWatermarks()
+}
+
+// SetHighWatermark sets the watermark of both chains to level, like
+// 'tezos-client set ledger high watermark'. User must confirm on the device.
+func (s *LedgerSigner) SetHighWatermark(level int) error {
+
+	if level < 0 || level > 0xffffffff {
+		return errors.Errorf("Invalid level %d", level)
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(level))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.exchange(ledger.ResetHLW, 0x00, b); err != nil {
+		return errors.Wrap(err, "Unable to reset ledger high watermark")
+	}
+
+	log.WithField("Level", level).Info("Ledger high watermark reset")
+
+	return nil
+}
+
+// Older versions of the baking app can only report the main chain watermark
+func (s *LedgerSigner) getHighWatermarks() (*LedgerWatermarks, error) {
+
+	resp, err := s.exchange(ledger.GetBakingHLW, 0x00, nil)
+	if err == nil && len(resp) >= 12 {
+
+		hwm := &LedgerWatermarks{
+			Main: int(binary.BigEndian.Uint32(resp[0:4])),
+			Test: int(binary.BigEndian.Uint32(resp[4:8])),
+		}
+
+		if !bytes.Equal(resp[8:12], make([]byte, 4)) {
+			hwm.ChainID = B58cencode(resp[8:12], networkprefix)
+		}
+
+		return hwm, nil
+	}
+
+	resp, err = s.exchange(ledger.GetMainHWM, 0x00, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read ledger high watermark")
+	}
+
+	if len(resp) < 4 {
+		return nil, ledger.ErrLengthMismatch
+	}
+
+	return &LedgerWatermarks{
+		Main: int(binary.BigEndian.Uint32(resp[0:4])),
+	}, nil
+}
+
+// The device watermark of the chain being signed, and the highest level BakinBacon has recorded
+type WatermarkDrift struct {
+	Device  int `json:"device"`
+	Storage int `json:"storage"`
+}
+
+// Compares the device watermark of chainID with the highest level BakinBacon has recorded.
+// They differ if the device signed for another baker, or was reset, or the database was
+// replaced. An empty chainID, nothing signed yet, is checked against the main chain.
+// Returns nil if they match.
+func (s *LedgerSigner) checkWatermarkDrift(chainID string) (*WatermarkDrift, error) {
+
+	s.lock.Lock()
+	hwm, err := s.getHighWatermarks()
+	s.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	bakingWatermark, err := s.db.GetBakingWatermark()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get baking watermark")
+	}
+
+	endorsingWatermark, err := s.db.GetEndorsingWatermark()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get endorsing watermark")
+	}
+
+	stored := bakingWatermark
+	if endorsingWatermark > stored {
+		stored = endorsingWatermark
+	}
+
+	// As the device does when signing
+	device := hwm.Main
+	if hwm.ChainID != "" && chainID != "" && chainID != hwm.ChainID {
+		device = hwm.Test
+	}
+
+	if device == stored {
+		return nil, nil
+	}
+
+	return &WatermarkDrift{Device: device, Storage: stored}, nil
+}
+
+// Chain id and level of a block, preendorsement or endorsement; false for anything else
+func consensusLevel(opBytes []byte) (string, int, bool) {
+
+	if len(opBytes) < 5 {
+		return "", 0, false
+	}
+
+	var level []byte
+
+	switch opBytes[0] {
+	case blockprefix[0], tbblockprefix[0]:
+
+		// magic(1) + chain_id(4) + level(4)
+		if len(opBytes) >= 9 {
+			level = opBytes[5:9]
+		}
+
+	case endorsementprefix[0]:
+
+		// magic(1) + chain_id(4) + branch(32) + tag(1) + level(4)
+		if len(opBytes) >= 42 && opBytes[37] == 0 {
+			level = opBytes[38:42]
+		}
+
+	case preendorsementprefix[0], tbendorsementprefix[0]:
+
+		// magic(1) + chain_id(4) + branch(32) + tag(1) + slot(2) + level(4)
+		if len(opBytes) >= 44 {
+			level = opBytes[40:44]
+		}
+	}
+
+	if level == nil {
+		return "", 0, false
+	}
+
+	return B58cencode(opBytes[1:5], networkprefix), int(binary.BigEndian.Uint32(level)), true
+}
@@ -0,0 +1,315 @@
+package baconsigner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	goledger "github.com/bakingbacon/goledger"
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+
+	"bakinbacon/storage"
+)
+
+// Ledger signer runs against the emulator, with an in-memory database
+func openEmulator(t *testing.T) (*LedgerEmulator, storage.Store) {
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	emulator := NewLedgerEmulator([]byte("bakinbacon ledger emulator"))
+
+	SetLedgerTransport(func() (LedgerTransport, error) {
+		return emulator, nil
+	})
+	t.Cleanup(func() { SetLedgerTransport(nil) })
+
+	return emulator, db
+}
+
+// Wizard flow: test the ledger, confirm the key, then load it as on startup
+func setupLedger(t *testing.T, db storage.Store, kind gtks.ECKind) *LedgerInfo {
+
+	info, err := TestLedger(db, kind)
+	if err != nil {
+		t.Fatalf("Unable to test ledger: %s", err)
+	}
+
+	if err := L.ConfirmBakingPkh(info.Pkh, info.BipPath); err != nil {
+		t.Fatalf("Unable to confirm baking pkh: %s", err)
+	}
+
+	if err := InitLedgerSigner(db); err != nil {
+		t.Fatalf("Unable to init ledger signer: %s", err)
+	}
+
+	return info
+}
+
+func blockBytes(level uint32) []byte {
+	b := []byte{blockprefix[0], 0x7a, 0x06, 0xa7, 0x70, 0, 0, 0, 0, 0x01, 0x02}
+	binary.BigEndian.PutUint32(b[5:9], level)
+	return b
+}
+
+func endorsementBytes(level uint32) []byte {
+	b := append([]byte{endorsementprefix[0], 0x7a, 0x06, 0xa7, 0x70}, make([]byte, 32)...)
+	b = append(b, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[38:42], level)
+	return b
+}
+
+func TestEncodeBipPath(t *testing.T) {
+
+	encoded, err := encodeBipPath(DEFAULT_BIP_PATH)
+	if err != nil {
+		t.Fatalf("Unable to encode: %s", err)
+	}
+
+	expected := []byte{4, 128, 0, 0, 44, 128, 0, 6, 193, 128, 0, 0, 0, 128, 0, 0, 1}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encoded %v, expected %v", encoded, expected)
+	}
+
+	if decoded, err := goledger.DecodeBipPath(encoded); err != nil || decoded != DEFAULT_BIP_PATH {
+		t.Errorf("Decoded %s, %v", decoded, err)
+	}
+
+	for _, p := range []string{"", "44'/1729'", "/44'/x", "/4294967296'", "/44'/1729'q"} {
+		if _, err := encodeBipPath(p); err == nil {
+			t.Errorf("Invalid path %q encoded", p)
+		}
+	}
+}
+
+func TestLedgerWizard(t *testing.T) {
+
+	for _, kind := range []gtks.ECKind{gtks.Ed25519, gtks.Secp256k1, gtks.NistP256} {
+		t.Run(curveName(kind), func(t *testing.T) {
+
+			_, db := openEmulator(t)
+			info := setupLedger(t, db, kind)
+
+			if info.PrevAuth || info.BipPath != DEFAULT_BIP_PATH || info.Version != "Baking 2.2.11" {
+				t.Errorf("Unexpected ledger info %+v", info)
+			}
+
+			if c, _ := curveFromPkh(info.Pkh); c != kind {
+				t.Errorf("Address %s is not of the curve", info.Pkh)
+			}
+
+			if L.Info.Pkh != info.Pkh {
+				t.Errorf("Loaded %s, confirmed %s", L.Info.Pkh, info.Pkh)
+			}
+
+			pk, pkh, err := L.GetPublicKey()
+			if err != nil || pkh != info.Pkh {
+				t.Fatalf("Public key %s, %v", pkh, err)
+			}
+
+			// Wizard now finds the authorized key, whichever curve is asked for
+			again, err := TestLedger(db, gtks.Ed25519)
+			if err != nil || !again.PrevAuth || again.Pkh != info.Pkh || again.Curve != curveName(kind) {
+				t.Errorf("Unexpected ledger info after auth %+v, %v", again, err)
+			}
+
+			msg := blockBytes(1)
+
+			sig, err := L.SignBytes(msg)
+			if err != nil {
+				t.Fatalf("Unable to sign: %s", err)
+			}
+
+			if err := VerifySignature(pk, sig, msg); err != nil {
+				t.Errorf("Signature did not verify: %s", err)
+			}
+		})
+	}
+}
+
+func TestLedgerHighWatermark(t *testing.T) {
+
+	emulator, db := openEmulator(t)
+	setupLedger(t, db, gtks.Ed25519)
+
+	steps := []struct {
+		msg []byte
+		ok  bool
+	}{
+		{blockBytes(10), true},
+		{blockBytes(10), false},
+		{endorsementBytes(10), true},
+		{endorsementBytes(10), false},
+		{endorsementBytes(9), false},
+		{blockBytes(11), true},
+		{endorsementBytes(11), true},
+		{blockBytes(11), false},
+	}
+
+	for i, s := range steps {
+
+		_, err := L.SignBytes(s.msg)
+		if s.ok && err != nil {
+			t.Errorf("Step %d: unable to sign: %s", i, err)
+		}
+
+		if !s.ok && errors.Cause(err) != swWatermark {
+			t.Errorf("Step %d: expected watermark error, got %v", i, err)
+		}
+	}
+
+	if hwm := emulator.HighWatermark(); hwm != 11 {
+		t.Errorf("HWM %d, expected 11", hwm)
+	}
+
+	reset := func(level uint32) error {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, level)
+
+		L.lock.Lock()
+		defer L.lock.Unlock()
+
+		_, err := L.exchange(ledger.ResetHLW, 0x00, b)
+		return err
+	}
+
+	// User rejects the reset on the device
+	emulator.Confirm = func(string) bool { return false }
+
+	if err := reset(5); errors.Cause(err) != swDenied {
+		t.Errorf("Expected denied reset, got %v", err)
+	}
+
+	emulator.Confirm = nil
+
+	if err := reset(5); err != nil {
+		t.Fatalf("Unable to reset HWM: %s", err)
+	}
+
+	if _, err := L.SignBytes(blockBytes(6)); err != nil {
+		t.Errorf("Unable to sign after reset: %s", err)
+	}
+}
+
+func TestLedgerWalletApp(t *testing.T) {
+
+	emulator, db := openEmulator(t)
+	emulator.WalletApp = true
+
+	if _, err := TestLedger(db, gtks.Ed25519); err == nil || !strings.Contains(err.Error(), "Wallet app") {
+		t.Errorf("Expected wallet app error, got %v", err)
+	}
+}
+
+func TestLedgerNotAuthorized(t *testing.T) {
+
+	emulator, db := openEmulator(t)
+
+	info, err := TestLedger(db, gtks.Ed25519)
+	if err != nil {
+		t.Fatalf("Unable to test ledger: %s", err)
+	}
+
+	// User rejects authorizing the key; nothing is saved
+	emulator.Confirm = func(string) bool { return false }
+
+	if err := L.ConfirmBakingPkh(info.Pkh, info.BipPath); errors.Cause(err) != swDenied {
+		t.Errorf("Expected denied authorization, got %v", err)
+	}
+
+	if signerType, _ := db.GetSignerType(); signerType == SIGNER_LEDGER {
+		t.Errorf("Ledger saved as signer without authorization")
+	}
+
+	if err := L.SetBipPath(info.BipPath); err != nil {
+		t.Fatalf("Unable to set BIP path: %s", err)
+	}
+
+	if _, err := L.SignBytes(blockBytes(10)); errors.Cause(err) != swSecurity {
+		t.Errorf("Expected unauthorized key error, got %v", err)
+	}
+}
+
+func TestLedgerWatermarks(t *testing.T) {
+
+	emulator, db := openEmulator(t)
+	setupLedger(t, db, gtks.Ed25519)
+
+	emulator.Confirm = func(string) bool { return false }
+
+	if err := L.SetHighWatermark(100); errors.Cause(err) != swDenied {
+		t.Errorf("Expected denied reset, got %v", err)
+	}
+
+	emulator.Confirm = nil
+
+	if err := L.SetHighWatermark(100); err != nil {
+		t.Fatalf("Unable to set HWM: %s", err)
+	}
+
+	hwm, err := L.GetHighWatermarks()
+	if err != nil || hwm.Main != 100 || hwm.Test != 100 || hwm.ChainID != "" {
+		t.Errorf("Unexpected watermarks %+v, %v", hwm, err)
+	}
+
+	// Device is at 100, nothing recorded in storage
+	s := &BaconSigner{SignerType: SIGNER_LEDGER, db: db}
+	backoff := RECONNECT_MIN_BACKOFF
+
+	s.checkSigner(&backoff)
+
+	if d := s.Health().WatermarkDrift; d == nil || *d != (WatermarkDrift{100, 0}) {
+		t.Errorf("Drift %v not detected", d)
+	}
+
+	if _, err := L.SignBytes(blockBytes(101)); err != nil {
+		t.Fatalf("Unable to sign: %s", err)
+	}
+
+	if err := db.RecordBakedBlock(101, "BLockHash"); err != nil {
+		t.Fatalf("Unable to record block: %s", err)
+	}
+
+	s.checkSigner(&backoff)
+
+	if d := s.Health().WatermarkDrift; d != nil {
+		t.Errorf("Drift %v after watermarks match", d)
+	}
+
+	// Device set up for another chain; granadanet is checked against its test watermark,
+	// before signing
+	emulator.chainID = []byte{1, 2, 3, 4}
+	emulator.test = emulatorHWM{level: 90}
+
+	if _, err := s.SignRawBytes(blockBytes(102)); err != nil {
+		t.Fatalf("Unable to sign: %s", err)
+	}
+
+	if d := s.Health().WatermarkDrift; d == nil || *d != (WatermarkDrift{90, 101}) {
+		t.Errorf("Test chain drift %v not detected", d)
+	}
+
+	if err := db.RecordBakedBlock(102, "BLockHash"); err != nil {
+		t.Fatalf("Unable to record block: %s", err)
+	}
+
+	// Checked once per level; the device is now at 102, like storage, but is not asked again
+	if _, err := s.SignRawBytes(endorsementBytes(102)); err != nil {
+		t.Fatalf("Unable to sign endorsement: %s", err)
+	}
+
+	if d := s.Health().WatermarkDrift; d == nil || *d != (WatermarkDrift{90, 101}) {
+		t.Errorf("Drift checked twice at the same level: %v", d)
+	}
+
+	if _, err := s.SignRawBytes(blockBytes(103)); err != nil {
+		t.Fatalf("Unable to sign: %s", err)
+	}
+
+	if d := s.Health().WatermarkDrift; d != nil {
+		t.Errorf("Drift %v at the next level", d)
+	}
+}
@@ -0,0 +1,86 @@
+package baconsigner
+
+import (
+	"github.com/pkg/errors"
+
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+)
+
+// LedgerTransport carries APDUs to the Tezos Baking app. Exchange sends a complete
+// command APDU (CLA, INS, P1, P2, LC, CDATA) and returns the response data without
+// the status word; any status other than 0x9000 is returned as an error.
+type LedgerTransport interface {
+	Exchange(apdu []byte) ([]byte, error)
+	Close()
+}
+
+// Opens the transport for each new LedgerSigner; USB HID unless replaced
+var openLedgerTransport = openHidTransport
+
+// SetLedgerTransport replaces the USB device with another transport, such as
+// the emulator. Passing nil restores USB HID.
+func SetLedgerTransport(open func() (LedgerTransport, error)) {
+
+	if open == nil {
+		open = openHidTransport
+	}
+
+	openLedgerTransport = open
+}
+
+// Physical device over USB HID, via goledger
+type hidTransport struct {
+	dev *ledger.TezosLedger
+}
+
+// Already marshaled APDU; implements goledger's Apdu interface
+type rawApdu []byte
+
+func (a rawApdu) MarshalBinary() ([]byte, error) {
+	return a, nil
+}
+
+func openHidTransport() (LedgerTransport, error) {
+
+	dev, err := ledger.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	return &hidTransport{dev: dev}, nil
+}
+
+func (t *hidTransport) Exchange(apdu []byte) ([]byte, error) {
+
+	if len(apdu) < 5 {
+		return nil, errors.New("APDU too short")
+	}
+
+	// The last part of a signing request waits for the user, or the device, so
+	// block on the read instead of timing out
+	blocking := apdu[1] == ledger.SignBytes && apdu[2]&0x80 != 0
+
+	if blocking {
+		if r, err := t.dev.Dev.SetNonBlocking(false); r == -1 {
+			return nil, errors.Wrap(err, "Could not set non-blocking")
+		}
+	}
+
+	if _, err := t.dev.Write(rawApdu(apdu), ledger.TEZOS_CHANNEL); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.dev.Read(ledger.TEZOS_CHANNEL)
+
+	if blocking {
+		if r, err := t.dev.Dev.SetNonBlocking(true); r == -1 {
+			return nil, errors.Wrap(err, "Could not set non-blocking")
+		}
+	}
+
+	return resp, err
+}
+
+func (t *hidTransport) Close() {
+	t.dev.Close()
+}
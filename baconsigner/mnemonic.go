@@ -0,0 +1,187 @@
+package baconsigner
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip39"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+// Derivation path used by Temple, Kukai and octez for the first account of a mnemonic
+const DEFAULT_MNEMONIC_PATH = "/44'/1729'/0'/0'"
+
+// Keys derived from a BIP39 mnemonic follow SLIP-10, which extends BIP32 to
+// ed25519 and P-256. https://github.com/satoshilabs/slips/blob/master/slip-0010.md
+func slip10Curve(kind gtks.ECKind) string {
+	switch kind {
+	case gtks.Secp256k1:
+		return "Bitcoin seed"
+	case gtks.NistP256:
+		return "Nist256p1 seed"
+	}
+	return "ed25519 seed"
+}
+
+// Splits a BIP32 path, ie: m/44'/1729'/0'/0', into child indexes
+func parseBipPath(path string) ([]uint32, error) {
+
+	encoded, err := encodeBipPath(strings.TrimPrefix(strings.TrimSpace(path), "m"))
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]uint32, encoded[0])
+	for i := range indexes {
+		indexes[i] = binary.BigEndian.Uint32(encoded[1+i*4:])
+	}
+
+	return indexes, nil
+}
+
+// Derives the 32 byte secret of the key at path from a BIP39 seed
+func deriveSlip10(kind gtks.ECKind, seed []byte, path []uint32) ([]byte, error) {
+
+	hmacSha512 := func(key, data []byte) []byte {
+		h := hmac.New(sha512.New, key)
+		h.Write(data)
+		return h.Sum(nil)
+	}
+
+	var n *big.Int
+	if kind != gtks.Ed25519 {
+		n = ecCurve(kind).Params().N
+	}
+
+	// Master key; ECDSA curves retry on a scalar out of range
+	curveKey := []byte(slip10Curve(kind))
+
+	I := hmacSha512(curveKey, seed)
+	for n != nil {
+		d := new(big.Int).SetBytes(I[:32])
+		if d.Sign() != 0 && d.Cmp(n) < 0 {
+			break
+		}
+		I = hmacSha512(curveKey, I)
+	}
+
+	key, chainCode := I[:32], I[32:]
+
+	for _, index := range path {
+
+		if kind == gtks.Ed25519 && index < HARDENED {
+			return nil, errors.New("ed25519 keys only support hardened derivation; use ' after each index")
+		}
+
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], index)
+
+		var data []byte
+		if index >= HARDENED {
+			data = append([]byte{0x00}, key...)
+		} else {
+			parent, _ := newWalletKey(kind, key)
+			data = parent.publicKeyBytes()
+		}
+
+		I = hmacSha512(chainCode, append(data, idx[:]...))
+
+		if kind == gtks.Ed25519 {
+			key, chainCode = I[:32], I[32:]
+			continue
+		}
+
+		// Child is parse256(IL) + parent mod n; retry if IL is out of range or the child is zero
+		for {
+			il := new(big.Int).SetBytes(I[:32])
+			child := new(big.Int).Add(il, new(big.Int).SetBytes(key))
+			child.Mod(child, n)
+
+			if il.Cmp(n) < 0 && child.Sign() != 0 {
+				key = child.FillBytes(make([]byte, 32))
+				chainCode = I[32:]
+				break
+			}
+
+			I = hmacSha512(chainCode, append(append([]byte{0x01}, I[32:]...), idx[:]...))
+		}
+	}
+
+	return key, nil
+}
+
+// Normalizes whitespace and case, then checks the words and checksum
+func mnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid mnemonic")
+	}
+
+	return seed, nil
+}
+
+// Derives a key of the given kind from a BIP39 mnemonic, optional passphrase and
+// derivation path (DEFAULT_MNEMONIC_PATH if empty). Like ImportSecretKey, the key
+// is only saved to DB by SaveSigner, once the user has confirmed the address.
+func ImportMnemonic(db storage.Store, mnemonic, passphrase, path string, kind gtks.ECKind) (string, string, error) {
+
+	if path == "" {
+		path = DEFAULT_MNEMONIC_PATH
+	}
+
+	indexes, err := parseBipPath(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	seed, err := mnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := deriveSlip10(kind, seed, indexes)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Unable to derive key")
+	}
+
+	importKey, err := newWalletKey(kind, secret)
+	if err != nil {
+		log.WithError(err).Error("Failed to import key")
+		return "", "", err
+	}
+
+	sk, pkh := setNewWallet(db, importKey, importKey.SecretKey())
+
+	return sk, pkh, nil
+}
+
+// Derives the key of a 2017 fundraiser account. These are not hierarchical; the
+// BIP39 seed, salted with email and password, is the ed25519 seed.
+func ImportFundraiser(db storage.Store, mnemonic, email, password string) (string, string, error) {
+
+	seed, err := mnemonicSeed(mnemonic, email+password)
+	if err != nil {
+		return "", "", err
+	}
+
+	importKey, err := newWalletKey(gtks.Ed25519, seed[:32])
+	if err != nil {
+		log.WithError(err).Error("Failed to import key")
+		return "", "", err
+	}
+
+	sk, pkh := setNewWallet(db, importKey, importKey.SecretKey())
+
+	return sk, pkh, nil
+}
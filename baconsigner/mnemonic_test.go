@@ -0,0 +1,117 @@
+package baconsigner
+
+import (
+	"encoding/hex"
+	"testing"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+
+	"bakinbacon/storage"
+)
+
+// Test vector 1 from SLIP-10
+func TestDeriveSlip10(t *testing.T) {
+
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	vectors := []struct {
+		kind   gtks.ECKind
+		path   string
+		secret string
+	}{
+		{gtks.Ed25519, "m", "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7"},
+		{gtks.Ed25519, "m/0'", "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3"},
+		{gtks.Ed25519, "m/0'/1'", "b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2"},
+		{gtks.Secp256k1, "m", "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{gtks.Secp256k1, "m/0'", "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{gtks.Secp256k1, "m/0'/1", "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{gtks.NistP256, "m", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{gtks.NistP256, "m/0'", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{gtks.NistP256, "m/0'/1", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+	}
+
+	for _, v := range vectors {
+
+		var path []uint32
+		if v.path != "m" {
+			var err error
+			if path, err = parseBipPath(v.path); err != nil {
+				t.Fatalf("Unable to parse %s: %s", v.path, err)
+			}
+		}
+
+		secret, err := deriveSlip10(v.kind, seed, path)
+		if err != nil {
+			t.Errorf("%s %s: %s", curveName(v.kind), v.path, err)
+			continue
+		}
+
+		if hex.EncodeToString(secret) != v.secret {
+			t.Errorf("%s %s: derived %x, expected %s", curveName(v.kind), v.path, secret, v.secret)
+		}
+	}
+
+	if _, err := deriveSlip10(gtks.Ed25519, seed, []uint32{1}); err == nil {
+		t.Errorf("Derived non-hardened ed25519 key")
+	}
+}
+
+func TestImportMnemonic(t *testing.T) {
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	mnemonic := "normal dash crumble neutral reflect parrot know stairs culture fault check whale flock dog scout"
+
+	// Fundraiser vector from go-tezos
+	sk, pkh, err := ImportFundraiser(db, mnemonic, "vksbjweo.qsrgfvbw@tezos.example.org", "PYh8nXDQLB")
+	if err != nil {
+		t.Fatalf("Unable to import fundraiser: %s", err)
+	}
+
+	if pkh != "tz1Qny7jVMGiwRrP9FikRK95jTNbJcffTpx1" || W.wallet.PublicKey() != "edpkvEoAbkdaGALxi2FfeefB8hUkMZ4J1UVwkzyumx2GvbVpkYUHnm" {
+		t.Errorf("Unexpected fundraiser key %s %s", pkh, W.wallet.PublicKey())
+	}
+
+	if sk != "edskRxB2DmoyZSyvhsqaJmw5CK6zYT7dbkUfEVSiQeWU1gw3ZMnC99QMMXru3imsbUrLhvuHktrymvNqhMxkhz7Y4LJAtevW5V" {
+		t.Errorf("Unexpected fundraiser secret key %s", sk)
+	}
+
+	// Default path, and the same path given explicitly, with sloppy spacing
+	_, pkh, err = ImportMnemonic(db, mnemonic, "", "", gtks.Ed25519)
+	if err != nil {
+		t.Fatalf("Unable to import mnemonic: %s", err)
+	}
+
+	_, again, err := ImportMnemonic(db, "  Normal dash crumble neutral reflect parrot know stairs culture fault check whale flock dog  scout ", "", "m/44'/1729'/0'/0'", gtks.Ed25519)
+	if err != nil || again != pkh {
+		t.Errorf("Imported %s, expected %s: %v", again, pkh, err)
+	}
+
+	// Passphrase and path change the key
+	_, other, _ := ImportMnemonic(db, mnemonic, "secret", "", gtks.Ed25519)
+	_, account, _ := ImportMnemonic(db, mnemonic, "", "m/44'/1729'/1'/0'", gtks.Ed25519)
+	if other == pkh || account == pkh || other == account {
+		t.Errorf("Passphrase or path ignored: %s %s %s", pkh, other, account)
+	}
+
+	for _, kind := range []gtks.ECKind{gtks.Secp256k1, gtks.NistP256} {
+		if _, pkh, err := ImportMnemonic(db, mnemonic, "", "", kind); err != nil {
+			t.Errorf("Unable to import %s mnemonic: %s", curveName(kind), err)
+		} else if c, _ := curveFromPkh(pkh); c != kind {
+			t.Errorf("Address %s is not %s", pkh, curveName(kind))
+		}
+	}
+
+	// Bad checksum, unknown word, bad path
+	if _, _, err := ImportMnemonic(db, "normal dash crumble neutral reflect parrot know stairs culture fault check whale flock dog dog", "", "", gtks.Ed25519); err == nil {
+		t.Errorf("Imported mnemonic with bad checksum")
+	}
+
+	if _, _, err := ImportMnemonic(db, "bacon dash crumble neutral reflect parrot know stairs culture fault check whale flock dog scout", "", "", gtks.Ed25519); err == nil {
+		t.Errorf("Imported mnemonic with unknown word")
+	}
+
+	if _, _, err := ImportMnemonic(db, mnemonic, "", "m/44'/1729'/0'/0", gtks.Ed25519); err == nil {
+		t.Errorf("Imported ed25519 key on non-hardened path")
+	}
+}
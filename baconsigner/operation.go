@@ -0,0 +1,424 @@
+package baconsigner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Operation kinds, as named by the Tezos RPC. Blocks are not operations, but
+// are given a kind so policies can treat all signing requests the same way.
+const (
+	OP_BLOCK                 = "block"
+	OP_ENDORSEMENT           = "endorsement"
+	OP_ENDORSEMENT_WITH_SLOT = "endorsement_with_slot"
+	OP_PREENDORSEMENT        = "preendorsement"
+	OP_SEED_NONCE_REVELATION = "seed_nonce_revelation"
+	OP_PROPOSALS             = "proposals"
+	OP_BALLOT                = "ballot"
+	OP_REVEAL                = "reveal"
+	OP_TRANSACTION           = "transaction"
+	OP_ORIGINATION           = "origination"
+	OP_DELEGATION            = "delegation"
+	OP_UPDATE_CONSENSUS_KEY  = "update_consensus_key"
+)
+
+var operationTags = map[byte]string{
+	0:   OP_ENDORSEMENT,
+	1:   OP_SEED_NONCE_REVELATION,
+	5:   OP_PROPOSALS,
+	6:   OP_BALLOT,
+	10:  OP_ENDORSEMENT_WITH_SLOT,
+	20:  OP_PREENDORSEMENT,
+	21:  OP_ENDORSEMENT, // Tenderbake
+	107: OP_REVEAL,
+	108: OP_TRANSACTION,
+	109: OP_ORIGINATION,
+	110: OP_DELEGATION,
+	114: OP_UPDATE_CONSENSUS_KEY,
+}
+
+// A single decoded operation content; only the fields used by the signing policy
+type OperationContent struct {
+	Kind        string
+	Source      string
+	Destination string
+	Delegate    string
+	PublicKey   string
+	Amount      *big.Int
+
+	// Manager operations only
+	Fee          *big.Int
+	GasLimit     *big.Int
+	StorageLimit *big.Int
+}
+
+// Manager operations move funds or change account state, and may need confirmation
+func (c OperationContent) IsManager() bool {
+	switch c.Kind {
+	case OP_REVEAL, OP_TRANSACTION, OP_ORIGINATION, OP_DELEGATION, OP_UPDATE_CONSENSUS_KEY:
+		return true
+	}
+	return false
+}
+
+// Human readable description, used for confirmations and the audit log.
+// Excludes the counter so a re-forged operation has the same description, but
+// includes the fee and limits so a confirmation cannot be reused with a higher fee.
+func (c OperationContent) String() string {
+	if c.IsManager() {
+		return fmt.Sprintf("%s, fee %s mutez, gas limit %s, storage limit %s", c.description(), c.Fee, c.GasLimit, c.StorageLimit)
+	}
+	return c.description()
+}
+
+func (c OperationContent) description() string {
+	switch c.Kind {
+	case OP_TRANSACTION:
+		return fmt.Sprintf("transaction of %s mutez from %s to %s", c.Amount, c.Source, c.Destination)
+	case OP_ORIGINATION:
+		return fmt.Sprintf("origination from %s with balance %s mutez", c.Source, c.Amount)
+	case OP_DELEGATION:
+		if c.Delegate == "" {
+			return fmt.Sprintf("delegation withdrawal of %s", c.Source)
+		}
+		return fmt.Sprintf("delegation of %s to %s", c.Source, c.Delegate)
+	case OP_UPDATE_CONSENSUS_KEY:
+		return fmt.Sprintf("consensus key of %s to %s", c.Source, c.PublicKey)
+	case OP_REVEAL, OP_PROPOSALS, OP_BALLOT:
+		return fmt.Sprintf("%s by %s", c.Kind, c.Source)
+	}
+	return c.Kind
+}
+
+// Decodes the contents of the bytes to be signed; opBytes includes the watermark
+// (magic byte), and the chain id for blocks and endorsements.
+func decodeOperation(opBytes []byte) ([]OperationContent, error) {
+
+	if len(opBytes) == 0 {
+		return nil, errors.New("Nothing to sign")
+	}
+
+	var d *opDecoder
+
+	switch opBytes[0] {
+	case blockprefix[0], tbblockprefix[0]:
+		return []OperationContent{{Kind: OP_BLOCK}}, nil
+	case endorsementprefix[0], preendorsementprefix[0], tbendorsementprefix[0]:
+		// magic(1) + chain_id(4) + branch(32)
+		d = &opDecoder{b: opBytes, pos: 37}
+	case genericopprefix[0]:
+		// magic(1) + branch(32)
+		d = &opDecoder{b: opBytes, pos: 33}
+	default:
+		return nil, errors.Errorf("Unknown watermark 0x%02x", opBytes[0])
+	}
+
+	if d.pos > len(opBytes) {
+		return nil, errors.New("Operation too short")
+	}
+
+	var contents []OperationContent
+
+	for d.pos < len(d.b) {
+
+		c, err := d.content()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to decode operation content %d", len(contents))
+		}
+
+		contents = append(contents, c)
+	}
+
+	if len(contents) == 0 {
+		return nil, errors.New("Operation has no contents")
+	}
+
+	return contents, nil
+}
+
+type opDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *opDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.b) {
+		return nil, errors.New("Unexpected end of operation")
+	}
+	r := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return r, nil
+}
+
+func (d *opDecoder) byte() (byte, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// Skips a 4-byte length prefixed field
+func (d *opDecoder) skipArray() error {
+	l, err := d.take(4)
+	if err != nil {
+		return err
+	}
+	_, err = d.take(int(binary.BigEndian.Uint32(l)))
+	return err
+}
+
+// Zarith encoded natural number
+func (d *opDecoder) nat() (*big.Int, error) {
+
+	n := new(big.Int)
+
+	for shift := uint(0); ; shift += 7 {
+
+		b, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		n.Or(n, new(big.Int).Lsh(big.NewInt(int64(b&0x7f)), shift))
+
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+}
+
+// Implicit account; tag(1) + hash(20)
+func (d *opDecoder) pkh() (string, error) {
+
+	b, err := d.take(21)
+	if err != nil {
+		return "", err
+	}
+
+	switch b[0] {
+	case 0:
+		return B58cencode(b[1:], tz1prefix), nil
+	case 1:
+		return B58cencode(b[1:], tz2prefix), nil
+	case 2:
+		return B58cencode(b[1:], tz3prefix), nil
+	}
+
+	return "", errors.Errorf("Unknown public key hash tag %d", b[0])
+}
+
+// Implicit or originated account; 22 bytes
+func (d *opDecoder) contract() (string, error) {
+
+	tag, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+
+	if tag == 0 {
+		return d.pkh()
+	}
+
+	b, err := d.take(21)
+	if err != nil {
+		return "", err
+	}
+
+	return B58cencode(b[:20], ktprefix), nil
+}
+
+// Optional implicit account, prefixed by a boolean
+func (d *opDecoder) optionalPkh() (string, error) {
+
+	present, err := d.byte()
+	if err != nil || present == 0 {
+		return "", err
+	}
+
+	return d.pkh()
+}
+
+// Public key; tag(1) + 32 bytes for ed25519, 33 bytes (compressed) for secp256k1 and P-256
+func (d *opDecoder) publicKey() (string, error) {
+
+	tag, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		l int
+		p prefix
+	)
+
+	switch tag {
+	case 0:
+		l, p = 32, edpkprefix
+	case 1:
+		l, p = 33, sppkprefix
+	case 2:
+		l, p = 33, p2pkprefix
+	default:
+		return "", errors.Errorf("Unknown public key tag %d", tag)
+	}
+
+	b, err := d.take(l)
+	if err != nil {
+		return "", err
+	}
+
+	return B58cencode(b, p), nil
+}
+
+// source, fee, counter, gas_limit, storage_limit
+func (d *opDecoder) managerHeader(c *OperationContent) (err error) {
+
+	if c.Source, err = d.pkh(); err != nil {
+		return err
+	}
+
+	if c.Fee, err = d.nat(); err != nil {
+		return err
+	}
+
+	if _, err = d.nat(); err != nil {
+		return err
+	}
+
+	if c.GasLimit, err = d.nat(); err != nil {
+		return err
+	}
+
+	c.StorageLimit, err = d.nat()
+
+	return err
+}
+
+func (d *opDecoder) content() (OperationContent, error) {
+
+	tag, err := d.byte()
+	if err != nil {
+		return OperationContent{}, err
+	}
+
+	kind, ok := operationTags[tag]
+	if !ok {
+		return OperationContent{}, errors.Errorf("Unknown operation tag %d", tag)
+	}
+
+	c := OperationContent{Kind: kind}
+
+	switch kind {
+	case OP_ENDORSEMENT, OP_PREENDORSEMENT:
+
+		if tag == 0 {
+			_, err = d.take(4) // level
+		} else {
+			_, err = d.take(2 + 4 + 4 + 32) // slot, level, round, block payload hash
+		}
+
+	case OP_ENDORSEMENT_WITH_SLOT:
+
+		// Inlined, signed endorsement, then slot
+		if err = d.skipArray(); err == nil {
+			_, err = d.take(2)
+		}
+
+	case OP_SEED_NONCE_REVELATION:
+
+		_, err = d.take(4 + 32) // level, nonce
+
+	case OP_PROPOSALS:
+
+		if c.Source, err = d.pkh(); err == nil {
+			if _, err = d.take(4); err == nil { // period
+				err = d.skipArray()
+			}
+		}
+
+	case OP_BALLOT:
+
+		if c.Source, err = d.pkh(); err == nil {
+			_, err = d.take(4 + 32 + 1) // period, proposal, ballot
+		}
+
+	case OP_REVEAL:
+
+		if err = d.managerHeader(&c); err == nil {
+			c.PublicKey, err = d.publicKey()
+		}
+
+	case OP_TRANSACTION:
+
+		if err = d.managerHeader(&c); err != nil {
+			break
+		}
+
+		if c.Amount, err = d.nat(); err != nil {
+			break
+		}
+
+		if c.Destination, err = d.contract(); err != nil {
+			break
+		}
+
+		// Optional parameters; entrypoint and micheline value
+		var hasParams, entrypoint byte
+		if hasParams, err = d.byte(); err != nil || hasParams == 0 {
+			break
+		}
+
+		if entrypoint, err = d.byte(); err != nil {
+			break
+		}
+
+		if entrypoint == 255 {
+			var l byte
+			if l, err = d.byte(); err != nil {
+				break
+			}
+			if _, err = d.take(int(l)); err != nil {
+				break
+			}
+		}
+
+		err = d.skipArray()
+
+	case OP_ORIGINATION:
+
+		if err = d.managerHeader(&c); err != nil {
+			break
+		}
+
+		if c.Amount, err = d.nat(); err != nil {
+			break
+		}
+
+		if c.Delegate, err = d.optionalPkh(); err != nil {
+			break
+		}
+
+		// Script; code and storage
+		if err = d.skipArray(); err == nil {
+			err = d.skipArray()
+		}
+
+	case OP_DELEGATION:
+
+		if err = d.managerHeader(&c); err == nil {
+			c.Delegate, err = d.optionalPkh()
+		}
+
+	case OP_UPDATE_CONSENSUS_KEY:
+
+		if err = d.managerHeader(&c); err == nil {
+			c.PublicKey, err = d.publicKey()
+		}
+	}
+
+	return c, err
+}
@@ -0,0 +1,408 @@
+package baconsigner
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	"github.com/btcsuite/btcd/btcec"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+// Not defined by miekg/pkcs11 v1.1.1; from PKCS#11 v3.0
+const (
+	CKK_EC_EDWARDS = 0x00000040
+	CKM_EDDSA      = 0x00001057
+)
+
+var (
+	oidP256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	oidEd25519   = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// Set from main using the -pkcs11-* flags
+type Pkcs11Config struct {
+	ModulePath string
+	Slot       uint
+	PinEnv     string
+	PinFile    string
+	KeyLabel   string
+
+	// Bake with the key even if the DB has another delegate, replacing it
+	Force bool
+}
+
+type Pkcs11Signer struct {
+	Pkh string
+
+	pk   string
+	kind gtks.ECKind
+
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	privKey pkcs11.ObjectHandle
+
+	// Sessions are not safe for concurrent use
+	lock sync.Mutex
+
+	db storage.Store
+}
+
+var (
+	P *Pkcs11Signer
+
+	pkcs11Config Pkcs11Config
+)
+
+func SetPkcs11Config(c Pkcs11Config) {
+	pkcs11Config = c
+}
+
+// An HSM module on the command line makes PKCS#11 the signer
+func Pkcs11Configured() bool {
+	return pkcs11Config.ModulePath != ""
+}
+
+// Loads the PKCS#11 module, logs in to the slot and finds the key pair by label
+func InitPkcs11Signer(db storage.Store) error {
+
+	if !Pkcs11Configured() {
+		return errors.New("PKCS#11 signer configured in DB, but no module; Use -pkcs11-module")
+	}
+
+	if pkcs11Config.KeyLabel == "" {
+		return errors.New("No PKCS#11 key label; Use -pkcs11-key-label")
+	}
+
+	pin, err := getPkcs11Pin()
+	if err != nil {
+		return err
+	}
+
+	ctx := pkcs11.New(pkcs11Config.ModulePath)
+	if ctx == nil {
+		return errors.Errorf("Unable to load PKCS#11 module %s", pkcs11Config.ModulePath)
+	}
+
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return errors.Wrap(err, "Unable to initialize PKCS#11 module")
+	}
+
+	s := &Pkcs11Signer{ctx: ctx, db: db}
+
+	if err := s.open(pin); err != nil {
+		s.Close()
+		return err
+	}
+
+	if err := checkPkcs11Delegate(db, s.Pkh); err != nil {
+		s.Close()
+		return err
+	}
+
+	P = s
+
+	log.WithFields(log.Fields{
+		"Module": pkcs11Config.ModulePath, "Slot": pkcs11Config.Slot, "Label": pkcs11Config.KeyLabel,
+		"Baker": s.Pkh, "PublicKey": s.pk,
+	}).Info("Loaded PKCS#11 signer")
+
+	return nil
+}
+
+// Key must match the delegate in DB, unless this is a new setup or forced
+func checkPkcs11Delegate(db storage.Store, pkh string) error {
+
+	_, dbPkh, err := db.GetDelegate()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get delegate from DB")
+	}
+
+	if dbPkh == "" || dbPkh == pkh {
+		return nil
+	}
+
+	if !pkcs11Config.Force {
+		return errors.Errorf("PKCS#11 key %s does not match delegate %s in DB; Use -pkcs11-force to bake with it instead", pkh, dbPkh)
+	}
+
+	log.WithFields(log.Fields{
+		"Previous": dbPkh, "New": pkh,
+	}).Warn("PKCS#11 key replaces previous delegate")
+
+	return nil
+}
+
+func (s *Pkcs11Signer) open(pin string) error {
+
+	session, err := s.ctx.OpenSession(pkcs11Config.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to open session on slot %d", pkcs11Config.Slot)
+	}
+	s.session = session
+
+	if err := s.ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return errors.Wrap(err, "Unable to log in to PKCS#11 slot")
+	}
+
+	s.privKey, err = s.findKey(pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := s.findKey(pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := s.ctx.GetAttributeValue(session, pubKey, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to read PKCS#11 public key")
+	}
+
+	s.kind, err = pkcs11KeyKind(attrs[0].Value, attrs[1].Value)
+	if err != nil {
+		return err
+	}
+
+	s.pk, err = pkcs11PublicKey(s.kind, attrs[2].Value)
+	if err != nil {
+		return err
+	}
+
+	s.Pkh, err = PublicKeyHash(s.pk)
+
+	return err
+}
+
+// Finds exactly one object of the class with the configured label
+func (s *Pkcs11Signer) findKey(class uint) (pkcs11.ObjectHandle, error) {
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, pkcs11Config.KeyLabel),
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, errors.Wrap(err, "Unable to search PKCS#11 objects")
+	}
+
+	objs, _, err := s.ctx.FindObjects(s.session, 2)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to search PKCS#11 objects")
+	}
+
+	switch len(objs) {
+	case 0:
+		return 0, errors.Errorf("No key with label %s", pkcs11Config.KeyLabel)
+	case 1:
+		return objs[0], nil
+	}
+
+	return 0, errors.Errorf("Multiple keys with label %s", pkcs11Config.KeyLabel)
+}
+
+func (s *Pkcs11Signer) Close() {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ctx == nil {
+		return
+	}
+
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+
+	_ = s.ctx.Finalize()
+	s.ctx.Destroy()
+	s.ctx = nil
+}
+
+func (s *Pkcs11Signer) GetPublicKey() (string, string, error) {
+	return s.pk, s.Pkh, nil
+}
+
+// Signs the blake2b digest of opBytes inside the HSM
+func (s *Pkcs11Signer) SignBytes(opBytes []byte) (string, error) {
+
+	digest, err := util.CryptoGenericHash(opBytes, []byte{})
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to hash message")
+	}
+
+	mechanism := pkcs11.CKM_ECDSA
+	if s.kind == gtks.Ed25519 {
+		mechanism = CKM_EDDSA
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ctx == nil {
+		return "", errors.New("PKCS#11 signer is closed")
+	}
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(uint(mechanism), nil)}, s.privKey); err != nil {
+		return "", errors.Wrap(err, "Unable to init PKCS#11 signing")
+	}
+
+	sig, err := s.ctx.Sign(s.session, digest)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed PKCS#11 signer")
+	}
+
+	return pkcs11Signature(s.kind, sig)
+}
+
+// Saves the HSM key as the delegate; the secret key never leaves the HSM
+func (s *Pkcs11Signer) SaveSigner() error {
+
+	if err := s.db.SetDelegatePkh(s.Pkh); err != nil {
+		return errors.Wrap(err, "Unable to save PKCS#11 signer")
+	}
+
+	if err := s.db.SetSignerType(SIGNER_PKCS11); err != nil {
+		return errors.Wrap(err, "Unable to save PKCS#11 signer")
+	}
+
+	return nil
+}
+
+// PIN from the environment, or file, in that order
+func getPkcs11Pin() (string, error) {
+
+	if pkcs11Config.PinEnv != "" {
+		if p := os.Getenv(pkcs11Config.PinEnv); p != "" {
+			return p, nil
+		}
+	}
+
+	if pkcs11Config.PinFile != "" {
+		p, err := ioutil.ReadFile(pkcs11Config.PinFile)
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to read PKCS#11 PIN file")
+		}
+		return strings.TrimRight(string(p), "\r\n"), nil
+	}
+
+	return "", errors.New("No PKCS#11 PIN; Set the PIN environment variable or use -pkcs11-pin-file")
+}
+
+// Curve from CKA_KEY_TYPE and CKA_EC_PARAMS; EC_PARAMS is an OID, or for
+// Edwards keys in some modules, the printable curve name
+func pkcs11KeyKind(keyType, ecParams []byte) (gtks.ECKind, error) {
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(ecParams, &oid); err == nil {
+		switch {
+		case oid.Equal(oidP256):
+			return gtks.NistP256, nil
+		case oid.Equal(oidSecp256k1):
+			return gtks.Secp256k1, nil
+		case oid.Equal(oidEd25519):
+			return gtks.Ed25519, nil
+		}
+		return "", errors.Errorf("Unsupported PKCS#11 curve %s", oid)
+	}
+
+	var name string
+	if _, err := asn1.UnmarshalWithParams(ecParams, &name, "printable"); err == nil && name == "edwards25519" {
+		return gtks.Ed25519, nil
+	}
+
+	if len(keyType) > 0 && keyType[0] == CKK_EC_EDWARDS {
+		return gtks.Ed25519, nil
+	}
+
+	return "", errors.New("Unsupported PKCS#11 key type")
+}
+
+// CKA_EC_POINT is usually a DER OCTET STRING; some modules return the raw point
+func pkcs11PublicKey(kind gtks.ECKind, ecPoint []byte) (string, error) {
+
+	var point []byte
+	if rest, err := asn1.Unmarshal(ecPoint, &point); err != nil || len(rest) > 0 {
+		point = ecPoint
+	}
+
+	var pk string
+
+	switch kind {
+	case gtks.Ed25519:
+
+		if len(point) != 32 {
+			return "", errors.Errorf("Invalid ed25519 public key length %d", len(point))
+		}
+
+		pk = B58cencode(point, edpkprefix)
+
+	case gtks.Secp256k1:
+
+		pubKey, err := btcec.ParsePubKey(point, btcec.S256())
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to parse secp256k1 public key")
+		}
+
+		pk = B58cencode(pubKey.SerializeCompressed(), sppkprefix)
+
+	case gtks.NistP256:
+
+		x, y := elliptic.Unmarshal(elliptic.P256(), point)
+		if x == nil {
+			return "", errors.New("Unable to parse P-256 public key")
+		}
+
+		pk = B58cencode(elliptic.MarshalCompressed(elliptic.P256(), x, y), p2pkprefix)
+	}
+
+	return pk, nil
+}
+
+// CKM_ECDSA and CKM_EDDSA both return r || s; secp256k1 is normalized to low-S for octez
+func pkcs11Signature(kind gtks.ECKind, sig []byte) (string, error) {
+
+	if len(sig) != 64 {
+		return "", errors.Errorf("Invalid PKCS#11 signature length %d", len(sig))
+	}
+
+	switch kind {
+	case gtks.Ed25519:
+		return B58cencode(sig, edsigprefix), nil
+	case gtks.NistP256:
+		return B58cencode(sig, p2sigprefix), nil
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+
+	n := btcec.S256().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	return B58cencode(ecdsaSignatureBytes(r, s), spsigprefix), nil
+}
@@ -0,0 +1,174 @@
+package baconsigner
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	"github.com/btcsuite/btcd/btcec"
+
+	"bakinbacon/storage"
+)
+
+func TestPkcs11Encodings(t *testing.T) {
+
+	msg := []byte{0x01, 0x02, 0x03}
+
+	for _, v := range keyVectors[:3] {
+
+		key := loadVector(t, v.sk, v.passphrase)
+
+		var (
+			oid   asn1.ObjectIdentifier
+			point []byte
+		)
+
+		switch key.kind {
+		case gtks.Ed25519:
+			oid, point = oidEd25519, key.publicKeyBytes()
+		case gtks.Secp256k1:
+			oid = oidSecp256k1
+			x, y := btcec.S256().ScalarBaseMult(key.secret)
+			point = elliptic.Marshal(btcec.S256(), x, y)
+		case gtks.NistP256:
+			oid = oidP256
+			x, y := elliptic.P256().ScalarBaseMult(key.secret)
+			point = elliptic.Marshal(elliptic.P256(), x, y)
+		}
+
+		ecParams, _ := asn1.Marshal(oid)
+		derPoint, _ := asn1.Marshal(point)
+
+		kind, err := pkcs11KeyKind(nil, ecParams)
+		if err != nil || kind != v.kind {
+			t.Errorf("%s: kind %s, %v", v.pk, kind, err)
+		}
+
+		// Modules return DER, or raw, points
+		for _, p := range [][]byte{derPoint, point} {
+			if pk, err := pkcs11PublicKey(kind, p); err != nil || pk != v.pk {
+				t.Errorf("%s: public key %s, %v", v.pk, pk, err)
+			}
+		}
+
+		// HSM signatures are r || s, and not normalized
+		sig, _ := key.Sign(msg)
+		sigBytes, _ := decodeSignatureBytes(sig)
+
+		if key.kind == gtks.Secp256k1 {
+			s := new(big.Int).SetBytes(sigBytes[32:])
+			new(big.Int).Sub(btcec.S256().N, s).FillBytes(sigBytes[32:])
+		}
+
+		hsmSig, err := pkcs11Signature(kind, sigBytes)
+		if err != nil {
+			t.Fatalf("%s: unable to encode signature: %s", v.pk, err)
+		}
+
+		if err := VerifySignature(v.pk, hsmSig, msg); err != nil {
+			t.Errorf("%s: signature did not verify: %s", v.pk, err)
+		}
+	}
+}
+
+// An HSM key other than the delegate in DB is refused, unless forced
+func TestPkcs11Delegate(t *testing.T) {
+
+	defer SetPkcs11Config(Pkcs11Config{})
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	// New setup
+	if err := checkPkcs11Delegate(db, testBaker); err != nil {
+		t.Errorf("Key refused without delegate: %s", err)
+	}
+
+	if err := db.SetDelegatePkh(testBaker); err != nil {
+		t.Fatalf("Unable to set delegate: %s", err)
+	}
+
+	if err := checkPkcs11Delegate(db, testBaker); err != nil {
+		t.Errorf("Delegate's own key refused: %s", err)
+	}
+
+	if err := checkPkcs11Delegate(db, testDest); err == nil {
+		t.Errorf("Key of another baker accepted")
+	}
+
+	SetPkcs11Config(Pkcs11Config{Force: true})
+
+	if err := checkPkcs11Delegate(db, testDest); err != nil {
+		t.Errorf("Forced key refused: %s", err)
+	}
+}
+
+// Runs against a real module, such as SoftHSM, when configured:
+//
+//	softhsm2-util --init-token --free --label bakinbacon --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --login --pin 1234 \
+//	  --keypairgen --key-type EC:prime256v1 --label baker
+//	PKCS11_TEST_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TEST_SLOT=<slot> \
+//	  PKCS11_TEST_PIN=1234 PKCS11_TEST_LABEL=baker go test ./baconsigner -run Pkcs11Module
+func TestPkcs11Module(t *testing.T) {
+
+	module := os.Getenv("PKCS11_TEST_MODULE")
+	if module == "" {
+		t.Skip("PKCS11_TEST_MODULE not set")
+	}
+
+	slot, _ := strconv.Atoi(os.Getenv("PKCS11_TEST_SLOT"))
+
+	SetPkcs11Config(Pkcs11Config{
+		ModulePath: module,
+		Slot:       uint(slot),
+		PinEnv:     "PKCS11_TEST_PIN",
+		KeyLabel:   os.Getenv("PKCS11_TEST_LABEL"),
+	})
+	defer SetPkcs11Config(Pkcs11Config{})
+
+	pin, err := getPkcs11Pin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("Unable to load %s", module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("Unable to initialize: %s", err)
+	}
+
+	s := &Pkcs11Signer{ctx: ctx}
+	defer s.Close()
+
+	if err := s.open(pin); err != nil {
+		t.Fatalf("Unable to open: %s", err)
+	}
+
+	pk, pkh, _ := s.GetPublicKey()
+	t.Logf("Key %s, %s", pkh, pk)
+
+	msg := []byte{0x02, 0x7a, 0x06, 0xa7, 0x70, 0x00}
+
+	for i := 0; i < 8; i++ {
+
+		msg[len(msg)-1] = byte(i)
+
+		sig, err := s.SignBytes(msg)
+		if err != nil {
+			t.Fatalf("Unable to sign: %s", err)
+		}
+
+		if err := VerifySignature(pk, sig, msg); err != nil {
+			t.Errorf("Signature %s did not verify: %s", sig, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package baconsigner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// How long an API confirmation of a manager operation remains valid
+	CONFIRMATION_TTL = 5 * time.Minute
+)
+
+// SigningPolicy is checked by SignRawBytes before any bytes are signed.
+// Loaded from a JSON file using -signing-policy, otherwise DefaultPolicy is used.
+type SigningPolicy struct {
+	// Watermarks (magic bytes) which may be signed; 1 = block, 2 = endorsement, 3 = generic,
+	// 17 = Tenderbake block, 18 = preendorsement, 19 = Tenderbake endorsement
+	AllowedWatermarks []int `json:"allowed_watermarks"`
+
+	// Operation kinds which may be signed; see OP_* for names
+	AllowedKinds []string `json:"allowed_kinds"`
+
+	// Maximum amount, in mutez, of each transaction; 0 is no limit
+	MaxTransferMutez int64 `json:"max_transfer_mutez"`
+
+	// Maximum total fee, in mutez, of the manager operations in one request; 0 is no limit
+	MaxFeeMutez int64 `json:"max_fee_mutez"`
+
+	// If not empty, transactions may only be sent to these addresses
+	AllowedDestinations []string `json:"allowed_destinations"`
+
+	// Manager operations (reveal, transaction, origination, delegation, update_consensus_key) must be confirmed through the API
+	ConfirmManagerOps bool `json:"confirm_manager_ops"`
+}
+
+// Everything BakinBacon needs for baking, registration, consensus key rotation and voting. Transactions
+// and originations are not signed unless enabled by a policy file.
+func DefaultPolicy() SigningPolicy {
+	return SigningPolicy{
+		AllowedWatermarks: []int{1, 2, 3, 17, 18, 19},
+		AllowedKinds: []string{
+			OP_BLOCK, OP_ENDORSEMENT, OP_ENDORSEMENT_WITH_SLOT, OP_PREENDORSEMENT, OP_SEED_NONCE_REVELATION,
+			OP_PROPOSALS, OP_BALLOT, OP_REVEAL, OP_DELEGATION, OP_UPDATE_CONSENSUS_KEY,
+		},
+		MaxFeeMutez:       1000000,
+		ConfirmManagerOps: true,
+	}
+}
+
+// Loads a policy from JSON file; fields not present in the file keep their default value
+func LoadPolicy(path string) (SigningPolicy, error) {
+
+	p := DefaultPolicy()
+
+	policyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, errors.Wrap(err, "Unable to read signing policy")
+	}
+
+	if err := json.Unmarshal(policyBytes, &p); err != nil {
+		return p, errors.Wrap(err, "Unable to parse signing policy")
+	}
+
+	return p, nil
+}
+
+// Returned when a manager operation needs to be confirmed using ConfirmOperation
+type ConfirmationRequiredError struct {
+	ID         string
+	Operations []string
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+	return "Confirmation required for " + strings.Join(e.Operations, ", ") + " (" + e.ID + ")"
+}
+
+// Returned by SignRawBytes when the signing policy refuses the bytes, or they are waiting
+// for confirmation; nothing was signed, but the signer did not fail either
+type PolicyError struct {
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return e.Err.Error()
+}
+
+type PendingConfirmation struct {
+	ID         string    `json:"id"`
+	Operations []string  `json:"operations"`
+	Created    time.Time `json:"created"`
+}
+
+var (
+	policy     = DefaultPolicy()
+	policyLock sync.Mutex
+
+	// Operations waiting for confirmation, and confirmed operations with their expiration
+	pendingOps   = make(map[string]PendingConfirmation)
+	confirmedOps = make(map[string]time.Time)
+)
+
+// Replaces the active signing policy
+func SetPolicy(p SigningPolicy) {
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	policy = p
+
+	log.WithFields(log.Fields{
+		"Watermarks": p.AllowedWatermarks, "Kinds": p.AllowedKinds, "MaxTransfer": p.MaxTransferMutez,
+		"MaxFee": p.MaxFeeMutez, "Destinations": len(p.AllowedDestinations), "ConfirmManagerOps": p.ConfirmManagerOps,
+	}).Info("Loaded signing policy")
+}
+
+// Checks the bytes to be signed against the policy. Returns the decoded contents so they can be audited.
+func checkPolicy(opBytes []byte) ([]OperationContent, error) {
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	if len(opBytes) == 0 || !containsInt(policy.AllowedWatermarks, int(opBytes[0])) {
+		return nil, errors.New("Policy: Watermark not allowed")
+	}
+
+	contents, err := decodeOperation(opBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Policy: Unable to decode operation")
+	}
+
+	var managerOps []string
+
+	totalFee := new(big.Int)
+
+	for _, c := range contents {
+
+		if !containsString(policy.AllowedKinds, c.Kind) {
+			return contents, errors.Errorf("Policy: Operation kind %s not allowed", c.Kind)
+		}
+
+		if c.Kind == OP_TRANSACTION {
+
+			if policy.MaxTransferMutez > 0 && c.Amount.Cmp(big.NewInt(policy.MaxTransferMutez)) > 0 {
+				return contents, errors.Errorf("Policy: Transaction amount %s exceeds limit of %d", c.Amount, policy.MaxTransferMutez)
+			}
+
+			if len(policy.AllowedDestinations) > 0 && !containsString(policy.AllowedDestinations, c.Destination) {
+				return contents, errors.Errorf("Policy: Destination %s not allowed", c.Destination)
+			}
+		}
+
+		if c.IsManager() {
+			managerOps = append(managerOps, c.String())
+			totalFee.Add(totalFee, c.Fee)
+		}
+	}
+
+	if policy.MaxFeeMutez > 0 && totalFee.Cmp(big.NewInt(policy.MaxFeeMutez)) > 0 {
+		return contents, errors.Errorf("Policy: Total fee %s exceeds limit of %d", totalFee, policy.MaxFeeMutez)
+	}
+
+	if policy.ConfirmManagerOps && len(managerOps) > 0 {
+
+		id := confirmationID(managerOps)
+
+		// Confirmations are single-use
+		if expires, ok := confirmedOps[id]; ok {
+
+			delete(confirmedOps, id)
+
+			if time.Now().Before(expires) {
+				return contents, nil
+			}
+		}
+
+		if _, ok := pendingOps[id]; !ok {
+			pendingOps[id] = PendingConfirmation{
+				ID:         id,
+				Operations: managerOps,
+				Created:    time.Now().UTC(),
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"ID": id, "Operations": managerOps,
+		}).Warn("Manager operation waiting for confirmation")
+
+		return contents, &ConfirmationRequiredError{ID: id, Operations: managerOps}
+	}
+
+	return contents, nil
+}
+
+// The same operation, re-forged with a new branch or counter, has the same id. A
+// different fee, gas limit or storage limit is a different operation.
+func confirmationID(descriptions []string) string {
+	h := sha256.Sum256([]byte(strings.Join(descriptions, "\n")))
+	return hex.EncodeToString(h[:8])
+}
+
+// Returns the manager operations waiting for confirmation, oldest first
+func (s *BaconSigner) PendingConfirmations() []PendingConfirmation {
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	pending := make([]PendingConfirmation, 0, len(pendingOps))
+	for _, p := range pendingOps {
+		pending = append(pending, p)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created.Before(pending[j].Created)
+	})
+
+	return pending
+}
+
+// Confirms a pending manager operation; the next signing request for the same operation will be allowed
+func (s *BaconSigner) ConfirmOperation(id string) error {
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	p, ok := pendingOps[id]
+	if !ok {
+		return errors.Errorf("No pending operation with id %s", id)
+	}
+
+	delete(pendingOps, id)
+	confirmedOps[id] = time.Now().Add(CONFIRMATION_TTL)
+
+	log.WithFields(log.Fields{
+		"ID": id, "Operations": p.Operations,
+	}).Info("Manager operation confirmed")
+
+	return nil
+}
+
+// Removes a pending manager operation without confirming it
+func (s *BaconSigner) RejectOperation(id string) error {
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	if _, ok := pendingOps[id]; !ok {
+		return errors.Errorf("No pending operation with id %s", id)
+	}
+
+	delete(pendingOps, id)
+
+	log.WithField("ID", id).Info("Manager operation rejected")
+
+	return nil
+}
+
+func containsInt(l []int, v int) bool {
+	for _, i := range l {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(l []string, v string) bool {
+	for _, i := range l {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
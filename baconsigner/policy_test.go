@@ -0,0 +1,198 @@
+package baconsigner
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/forge"
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+)
+
+const (
+	testBaker = "tz1LggX2HUdvJ1tF4Fvv8fjsrzLeW4Jr9t2Q"
+	testDest  = "tz2VN9n2C56xGLykHCjhNvZQqUeTVisrHjxA"
+)
+
+// Forges contents into generic operation bytes, as signGeneric would sign them
+func forgeGeneric(t *testing.T, contents ...rpc.Content) []byte {
+
+	branch := B58cencode(make([]byte, 32), branchprefix)
+
+	opHex, err := forge.Encode(branch, contents...)
+	if err != nil {
+		t.Fatalf("Unable to forge: %s", err)
+	}
+
+	opBytes, _ := hex.DecodeString(opHex)
+
+	return append([]byte{genericopprefix[0]}, opBytes...)
+}
+
+func registration(counter string) []rpc.Content {
+	return []rpc.Content{
+		{
+			Kind: rpc.REVEAL, Source: testBaker, Fee: "359", Counter: counter, GasLimit: "1000", StorageLimit: "0",
+			PublicKey: "edpkv45regue1bWtuHnCgLU8xWKLwa9qRqv4gimgJKro4LSc3C5VjV",
+		},
+		{
+			Kind: rpc.DELEGATION, Source: testBaker, Fee: "359", Counter: counter, GasLimit: "1100", StorageLimit: "0",
+			Delegate: testBaker,
+		},
+	}
+}
+
+func transaction(amount string) rpc.Content {
+	return rpc.Content{
+		Kind: rpc.TRANSACTION, Source: testBaker, Fee: "1420", Counter: "10", GasLimit: "10600", StorageLimit: "300",
+		Amount: amount, Destination: testDest,
+	}
+}
+
+func TestDecodeOperation(t *testing.T) {
+
+	contents, err := decodeOperation(forgeGeneric(t, registration("10")...))
+	if err != nil {
+		t.Fatalf("Unable to decode registration: %s", err)
+	}
+
+	if len(contents) != 2 || contents[0].Kind != OP_REVEAL || contents[1].Kind != OP_DELEGATION {
+		t.Fatalf("Unexpected contents %+v", contents)
+	}
+
+	if contents[1].Source != testBaker || contents[1].Delegate != testBaker {
+		t.Errorf("Unexpected delegation %+v", contents[1])
+	}
+
+	contents, err = decodeOperation(forgeGeneric(t, transaction("1234567890")))
+	if err != nil {
+		t.Fatalf("Unable to decode transaction: %s", err)
+	}
+
+	if contents[0].Amount.String() != "1234567890" || contents[0].Destination != testDest {
+		t.Errorf("Unexpected transaction %+v", contents[0])
+	}
+
+	// Endorsements include the chain id after the watermark
+	endoHex, err := forge.Encode(B58cencode(make([]byte, 32), branchprefix), rpc.Content{Kind: rpc.ENDORSEMENT, Level: 1000})
+	if err != nil {
+		t.Fatalf("Unable to forge endorsement: %s", err)
+	}
+
+	endoBytes, _ := hex.DecodeString(endoHex)
+	endoBytes = append([]byte{endorsementprefix[0], 0x7a, 0x06, 0xa7, 0x70}, endoBytes...)
+
+	if contents, err := decodeOperation(endoBytes); err != nil || contents[0].Kind != OP_ENDORSEMENT {
+		t.Errorf("Unable to decode endorsement: %+v %v", contents, err)
+	}
+
+	// Truncated operations are rejected
+	opBytes := forgeGeneric(t, transaction("1"))
+	if _, err := decodeOperation(opBytes[:len(opBytes)-3]); err == nil {
+		t.Errorf("Decoded truncated operation")
+	}
+}
+
+func TestPolicyLimits(t *testing.T) {
+
+	defer SetPolicy(DefaultPolicy())
+
+	// Default policy does not allow transactions
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1"))); err == nil {
+		t.Errorf("Default policy allowed transaction")
+	}
+
+	p := DefaultPolicy()
+	p.AllowedKinds = append(p.AllowedKinds, OP_TRANSACTION)
+	p.MaxTransferMutez = 1000
+	p.AllowedDestinations = []string{testDest}
+	p.ConfirmManagerOps = false
+	SetPolicy(p)
+
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1000"))); err != nil {
+		t.Errorf("Transaction within limit denied: %s", err)
+	}
+
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1001"))); err == nil {
+		t.Errorf("Transaction above limit allowed")
+	}
+
+	// Fees of a batch are added up
+	p.MaxFeeMutez = 2000
+	SetPolicy(p)
+
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1"))); err != nil {
+		t.Errorf("Transaction fee within limit denied: %s", err)
+	}
+
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1"), transaction("1"))); err == nil {
+		t.Errorf("Transaction fees above limit allowed")
+	}
+
+	p.AllowedDestinations = []string{testBaker}
+	SetPolicy(p)
+
+	if _, err := checkPolicy(forgeGeneric(t, transaction("1"))); err == nil {
+		t.Errorf("Transaction to unlisted destination allowed")
+	}
+
+	// Watermarks
+	p.AllowedWatermarks = []int{1, 2}
+	SetPolicy(p)
+
+	if _, err := checkPolicy(forgeGeneric(t, registration("10")...)); err == nil {
+		t.Errorf("Generic watermark allowed")
+	}
+}
+
+func TestPolicyConfirmation(t *testing.T) {
+
+	defer SetPolicy(DefaultPolicy())
+	SetPolicy(DefaultPolicy())
+
+	s := &BaconSigner{}
+
+	_, err := checkPolicy(forgeGeneric(t, registration("10")...))
+
+	confirmErr, ok := err.(*ConfirmationRequiredError)
+	if !ok {
+		t.Fatalf("Expected confirmation required, got %v", err)
+	}
+
+	if pending := s.PendingConfirmations(); len(pending) != 1 || pending[0].ID != confirmErr.ID {
+		t.Fatalf("Unexpected pending confirmations %+v", pending)
+	}
+
+	if err := s.ConfirmOperation(confirmErr.ID); err != nil {
+		t.Fatalf("Unable to confirm: %s", err)
+	}
+
+	// A higher fee is a different operation, and needs its own confirmation
+	highFee := registration("11")
+	highFee[1].Fee = "900000"
+
+	_, err = checkPolicy(forgeGeneric(t, highFee...))
+	if feeErr, ok := err.(*ConfirmationRequiredError); !ok || feeErr.ID == confirmErr.ID {
+		t.Fatalf("Confirmation reused with a higher fee: %v", err)
+	}
+
+	if err := s.RejectOperation(err.(*ConfirmationRequiredError).ID); err != nil {
+		t.Errorf("Unable to reject: %s", err)
+	}
+
+	// Re-forged with a new counter; same operation, so allowed once
+	if _, err := checkPolicy(forgeGeneric(t, registration("11")...)); err != nil {
+		t.Errorf("Confirmed operation denied: %s", err)
+	}
+
+	if _, err := checkPolicy(forgeGeneric(t, registration("12")...)); err == nil {
+		t.Errorf("Confirmation was used twice")
+	}
+
+	if err := s.RejectOperation(confirmErr.ID); err != nil {
+		t.Errorf("Unable to reject: %s", err)
+	}
+
+	if len(s.PendingConfirmations()) != 0 {
+		t.Errorf("Rejected operation still pending")
+	}
+}
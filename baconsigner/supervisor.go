@@ -0,0 +1,364 @@
+package baconsigner
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SIGNER_CHECK_INTERVAL = 10 * time.Second
+	RECONNECT_MIN_BACKOFF = 1 * time.Second
+	RECONNECT_MAX_BACKOFF = 60 * time.Second
+
+	// Signatures kept for latency stats
+	LATENCY_SAMPLES = 100
+)
+
+// Availability and signing latency of the signer, for the UI. Latencies are
+// over the last LATENCY_SAMPLES signatures, in milliseconds.
+type SignerHealth struct {
+	Available    bool      `json:"available"`
+	Error        string    `json:"error,omitempty"`
+	Since        time.Time `json:"since"`
+	Availability float64   `json:"availability"`
+	Disconnects  int       `json:"disconnects"`
+	Reconnects   int       `json:"reconnects"`
+	Signatures   int       `json:"signatures"`
+	SignErrors   int       `json:"signErrors"`
+	LastLatency  int64     `json:"lastLatency"`
+	AvgLatency   int64     `json:"avgLatency"`
+	P95Latency   int64     `json:"p95Latency"`
+	MaxLatency   int64     `json:"maxLatency"`
+
+	// Ledger only; set while the device watermark differs from BakinBacon's
+	WatermarkDrift *WatermarkDrift `json:"watermarkDrift,omitempty"`
+}
+
+// Zero value is an available signer with no history
+type signerHealth struct {
+	lock sync.Mutex
+
+	// Reason the signer is unavailable; nil when available
+	err      error
+	started  time.Time
+	since    time.Time
+	downtime time.Duration
+
+	disconnects int
+	reconnects  int
+	signatures  int
+	signErrors  int
+
+	latencies []time.Duration
+	next      int
+
+	drift *WatermarkDrift
+
+	// Chain and level of the last drift check before signing
+	driftChainID string
+	driftLevel   int
+
+	// Wakes the supervisor early, ie: after a failed signature
+	check chan struct{}
+}
+
+func (h *signerHealth) init() {
+	if h.started.IsZero() {
+		h.started = time.Now()
+		h.since = h.started
+		h.check = make(chan struct{}, 1)
+	}
+}
+
+// Returns true if the signer was available
+func (h *signerHealth) setUnavailable(err error) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.init()
+
+	wasAvailable := h.err == nil
+	if wasAvailable {
+		h.disconnects++
+		h.since = time.Now()
+	}
+	h.err = err
+
+	return wasAvailable
+}
+
+// Returns true if the signer was unavailable
+func (h *signerHealth) setAvailable() bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.init()
+
+	if h.err == nil {
+		return false
+	}
+
+	now := time.Now()
+	h.downtime += now.Sub(h.since)
+	h.since = now
+	h.err = nil
+
+	return true
+}
+
+func (h *signerHealth) reconnected() {
+
+	h.lock.Lock()
+	h.reconnects++
+	h.lock.Unlock()
+
+	h.setAvailable()
+}
+
+func (h *signerHealth) status() error {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.err
+}
+
+func (h *signerHealth) recordSignature(d time.Duration, err error) {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.init()
+
+	h.signatures++
+	if err != nil {
+		h.signErrors++
+	}
+
+	if len(h.latencies) < LATENCY_SAMPLES {
+		h.latencies = append(h.latencies, d)
+	} else {
+		h.latencies[h.next] = d
+	}
+	h.next = (h.next + 1) % LATENCY_SAMPLES
+}
+
+// Returns true if the drift changed
+func (h *signerHealth) setDrift(d *WatermarkDrift) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	changed := (h.drift == nil) != (d == nil) || (d != nil && *d != *h.drift)
+	h.drift = d
+
+	return changed
+}
+
+// Returns true the first time chainID is signed at level, or above; later signatures of
+// the same level skip the drift check
+func (h *signerHealth) newDriftLevel(chainID string, level int) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if chainID == h.driftChainID && level <= h.driftLevel {
+		return false
+	}
+
+	h.driftChainID, h.driftLevel = chainID, level
+
+	return true
+}
+
+// Ask the supervisor to check the signer now
+func (h *signerHealth) wake() {
+
+	h.lock.Lock()
+	h.init()
+	c := h.check
+	h.lock.Unlock()
+
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (h *signerHealth) snapshot() SignerHealth {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.init()
+
+	now := time.Now()
+
+	s := SignerHealth{
+		Available:   h.err == nil,
+		Since:       h.since,
+		Disconnects: h.disconnects,
+		Reconnects:  h.reconnects,
+		Signatures:  h.signatures,
+		SignErrors:  h.signErrors,
+	}
+
+	if h.drift != nil {
+		drift := *h.drift
+		s.WatermarkDrift = &drift
+	}
+
+	downtime := h.downtime
+	if h.err != nil {
+		s.Error = h.err.Error()
+		downtime += now.Sub(h.since)
+	}
+
+	if total := now.Sub(h.started); total > 0 {
+		s.Availability = 1 - float64(downtime)/float64(total)
+	}
+
+	if n := len(h.latencies); n > 0 {
+
+		sorted := make([]time.Duration, n)
+		copy(sorted, h.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		var sum time.Duration
+		for _, d := range sorted {
+			sum += d
+		}
+
+		s.LastLatency = h.latencies[(h.next+LATENCY_SAMPLES-1)%LATENCY_SAMPLES].Milliseconds()
+		s.AvgLatency = (sum / time.Duration(n)).Milliseconds()
+		s.P95Latency = sorted[(n*95-1)/100].Milliseconds()
+		s.MaxLatency = sorted[n-1].Milliseconds()
+	}
+
+	return s
+}
+
+// Health returns the availability and signing latency of the signer
+func (s *BaconSigner) Health() SignerHealth {
+	return s.health.snapshot()
+}
+
+// Supervise checks the signer in the background until shutdown. A Ledger which
+// stops answering, ie: unplugged, or the baking app was closed, is reopened with
+// backoff and checked for the same authorized key before baking resumes.
+func (s *BaconSigner) Supervise(shutdown chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	s.health.wake()
+
+	backoff := RECONNECT_MIN_BACKOFF
+	wait := SIGNER_CHECK_INTERVAL
+
+	for {
+
+		s.health.lock.Lock()
+		check := s.health.check
+		s.health.lock.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-check:
+		case <-shutdown:
+			log.Info("Shutting down signer supervisor")
+			return
+		}
+
+		wait = s.checkSigner(&backoff)
+	}
+}
+
+// Returns how long to wait before the next check
+func (s *BaconSigner) checkSigner(backoff *time.Duration) time.Duration {
+
+	dev := L
+	if s.SignerType != SIGNER_LEDGER || dev == nil {
+		s.health.setAvailable()
+		return SIGNER_CHECK_INTERVAL
+	}
+
+	err := dev.Ping()
+	if err == nil {
+
+		if s.health.setAvailable() {
+			log.Info("Ledger is available")
+		}
+
+		*backoff = RECONNECT_MIN_BACKOFF
+
+		s.checkWatermarkDrift(dev)
+
+		return SIGNER_CHECK_INTERVAL
+	}
+
+	if s.health.setUnavailable(errors.Wrap(err, "Ledger unavailable")) {
+		log.WithError(err).Warn("Lost connection to ledger; reconnecting")
+	}
+
+	if err := dev.Reconnect(); err != nil {
+
+		log.WithError(err).WithField("Retry", backoff.String()).Debug("Unable to reconnect to ledger")
+
+		s.health.setUnavailable(errors.Wrap(err, "Ledger unavailable"))
+
+		wait := *backoff
+		if *backoff *= 2; *backoff > RECONNECT_MAX_BACKOFF {
+			*backoff = RECONNECT_MAX_BACKOFF
+		}
+
+		return wait
+	}
+
+	s.health.reconnected()
+	*backoff = RECONNECT_MIN_BACKOFF
+
+	log.WithField("PKH", dev.Info.Pkh).Info("Reconnected to ledger")
+
+	return SIGNER_CHECK_INTERVAL
+}
+
+// Compares watermarks before the first signature of each level. Only warns; the
+// device enforces its own watermark regardless.
+func (s *BaconSigner) checkDriftBeforeSigning(dev *LedgerSigner, opBytes []byte) {
+
+	chainID, level, ok := consensusLevel(opBytes)
+	if !ok || dev == nil || !s.health.newDriftLevel(chainID, level) {
+		return
+	}
+
+	s.checkWatermarkDrift(dev)
+}
+
+// Warns once for each difference, not on every check
+func (s *BaconSigner) checkWatermarkDrift(dev *LedgerSigner) {
+
+	s.health.lock.Lock()
+	chainID := s.health.driftChainID
+	s.health.lock.Unlock()
+
+	drift, err := dev.checkWatermarkDrift(chainID)
+	if err != nil {
+		log.WithError(err).Debug("Unable to check ledger high watermark")
+		return
+	}
+
+	if !s.health.setDrift(drift) || drift == nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"DeviceHWM": drift.Device, "StorageHWM": drift.Storage,
+	}).Warn("Ledger high watermark does not match BakinBacon's watermark")
+}
@@ -0,0 +1,111 @@
+package baconsigner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	ledger "github.com/bakingbacon/goledger/ledger-apps/tezos"
+)
+
+// Emulator behind a cable which can be pulled
+type unpluggableTransport struct {
+	LedgerTransport
+	unplugged *bool
+}
+
+func (t *unpluggableTransport) Exchange(apdu []byte) ([]byte, error) {
+	if *t.unplugged {
+		return nil, errors.New("hidapi: device disconnected")
+	}
+	return t.LedgerTransport.Exchange(apdu)
+}
+
+func TestSupervisorReconnect(t *testing.T) {
+
+	emulator, db := openEmulator(t)
+
+	unplugged := false
+	SetLedgerTransport(func() (LedgerTransport, error) {
+		if unplugged {
+			return nil, errors.New("Ledger plugged in? Unlocked?")
+		}
+		return &unpluggableTransport{emulator, &unplugged}, nil
+	})
+
+	setupLedger(t, db, gtks.Ed25519)
+
+	s := &BaconSigner{SignerType: SIGNER_LEDGER, db: db}
+	backoff := RECONNECT_MIN_BACKOFF
+
+	if wait := s.checkSigner(&backoff); wait != SIGNER_CHECK_INTERVAL || s.SignerStatus(true) != nil {
+		t.Fatalf("Signer not available: %v", s.SignerStatus(true))
+	}
+
+	// Pull the cable; reconnect backs off
+	unplugged = true
+
+	for _, expected := range []time.Duration{RECONNECT_MIN_BACKOFF, 2 * RECONNECT_MIN_BACKOFF, 4 * RECONNECT_MIN_BACKOFF} {
+		if wait := s.checkSigner(&backoff); wait != expected {
+			t.Errorf("Waiting %s, expected %s", wait, expected)
+		}
+	}
+
+	if err := s.SignerStatus(true); err == nil {
+		t.Errorf("Unplugged signer is available")
+	}
+
+	// Plug it back in
+	unplugged = false
+
+	if wait := s.checkSigner(&backoff); wait != SIGNER_CHECK_INTERVAL || backoff != RECONNECT_MIN_BACKOFF {
+		t.Errorf("Waiting %s, backoff %s after reconnect", wait, backoff)
+	}
+
+	if err := s.SignerStatus(true); err != nil {
+		t.Errorf("Signer not available after reconnect: %s", err)
+	}
+
+	if _, err := s.SignRawBytes(blockBytes(5)); err != nil {
+		t.Errorf("Unable to sign after reconnect: %s", err)
+	}
+
+	h := s.Health()
+	if !h.Available || h.Disconnects != 1 || h.Reconnects != 1 || h.Signatures != 1 || h.Availability >= 1 {
+		t.Errorf("Unexpected health %+v", h)
+	}
+
+	// Device was deauthorized while away; baking must not resume
+	L.lock.Lock()
+	_, err := L.exchange(ledger.DeauthBaking, 0x00, nil)
+	L.lock.Unlock()
+
+	if err != nil {
+		t.Fatalf("Unable to deauthorize: %s", err)
+	}
+
+	s.checkSigner(&backoff)
+
+	if err := s.SignerStatus(true); err == nil {
+		t.Errorf("Deauthorized signer is available")
+	}
+}
+
+func TestSignerLatency(t *testing.T) {
+
+	var h signerHealth
+
+	for i := 1; i <= LATENCY_SAMPLES+20; i++ {
+		h.recordSignature(time.Duration(i)*time.Millisecond, nil)
+	}
+	h.recordSignature(3*time.Millisecond, errors.New("Failed"))
+
+	s := h.snapshot()
+
+	// Oldest 21 samples dropped
+	if s.Signatures != LATENCY_SAMPLES+21 || s.SignErrors != 1 || s.LastLatency != 3 || s.MaxLatency != 120 || s.P95Latency != 115 {
+		t.Errorf("Unexpected latency stats %+v", s)
+	}
+}
@@ -0,0 +1,182 @@
+package baconsigner
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+
+	"bakinbacon/util"
+)
+
+// Verifies a b58 encoded signature (edsig, spsig1, p2sig or generic sig) against
+// a b58 encoded public key (edpk, sppk, p2pk). Like the signers, msg is hashed
+// with blake2b before verification.
+func VerifySignature(pk, sig string, msg []byte) error {
+
+	sigBytes, err := decodeSignatureBytes(sig)
+	if err != nil {
+		return err
+	}
+
+	msgHash, err := util.CryptoGenericHash(msg, []byte{})
+	if err != nil {
+		return errors.Wrap(err, "Unable to hash message")
+	}
+
+	var valid bool
+
+	switch {
+	case strings.HasPrefix(pk, "edpk"):
+
+		pkBytes, err := b58cdecodeChecked(pk, edpkprefix, ed25519.PublicKeySize)
+		if err != nil {
+			return errors.Wrap(err, "Unable to decode public key")
+		}
+
+		valid = ed25519.Verify(pkBytes, msgHash, sigBytes)
+
+	case strings.HasPrefix(pk, "sppk"):
+
+		pkBytes, err := b58cdecodeChecked(pk, sppkprefix, 33)
+		if err != nil {
+			return errors.Wrap(err, "Unable to decode public key")
+		}
+
+		pubKey, err := btcec.ParsePubKey(pkBytes, btcec.S256())
+		if err != nil {
+			return errors.Wrap(err, "Unable to parse secp256k1 public key")
+		}
+
+		valid = verifyECDSA(pubKey.ToECDSA(), msgHash, sigBytes)
+
+	case strings.HasPrefix(pk, "p2pk"):
+
+		pkBytes, err := b58cdecodeChecked(pk, p2pkprefix, 33)
+		if err != nil {
+			return errors.Wrap(err, "Unable to decode public key")
+		}
+
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pkBytes)
+		if x == nil {
+			return errors.New("Unable to parse P-256 public key")
+		}
+
+		valid = verifyECDSA(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, msgHash, sigBytes)
+
+	default:
+		return errors.Errorf("Unknown public key type: %s", pk)
+	}
+
+	if !valid {
+		return errors.New("Signature verification failed")
+	}
+
+	return nil
+}
+
+// Returns the public key hash (tz1, tz2, tz3) of a b58 encoded public key
+func PublicKeyHash(pk string) (string, error) {
+
+	var (
+		pkBytes   []byte
+		pkhPrefix prefix
+		err       error
+	)
+
+	switch {
+	case strings.HasPrefix(pk, "edpk"):
+		pkBytes, err = b58cdecodeChecked(pk, edpkprefix, ed25519.PublicKeySize)
+		pkhPrefix = tz1prefix
+	case strings.HasPrefix(pk, "sppk"):
+		pkBytes, err = b58cdecodeChecked(pk, sppkprefix, 33)
+		pkhPrefix = tz2prefix
+	case strings.HasPrefix(pk, "p2pk"):
+		pkBytes, err = b58cdecodeChecked(pk, p2pkprefix, 33)
+		pkhPrefix = tz3prefix
+	default:
+		return "", errors.Errorf("Unknown public key type: %s", pk)
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to decode public key")
+	}
+
+	pkhHash, err := blake2b.New(20, []byte{})
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to create blake2b hash object")
+	}
+
+	if _, err := pkhHash.Write(pkBytes); err != nil {
+		return "", errors.Wrap(err, "Unable to hash public key")
+	}
+
+	return B58cencode(pkhHash.Sum([]byte{}), pkhPrefix), nil
+}
+
+// Returns the binary encoding of a public key hash; 1-byte curve tag followed by the 20-byte hash
+func PkhBytes(pkh string) ([]byte, error) {
+
+	var (
+		tag       byte
+		pkhPrefix prefix
+	)
+
+	switch {
+	case strings.HasPrefix(pkh, "tz1"):
+		tag, pkhPrefix = 0, tz1prefix
+	case strings.HasPrefix(pkh, "tz2"):
+		tag, pkhPrefix = 1, tz2prefix
+	case strings.HasPrefix(pkh, "tz3"):
+		tag, pkhPrefix = 2, tz3prefix
+	default:
+		return nil, errors.Errorf("Unknown public key hash type: %s", pkh)
+	}
+
+	hash, err := b58cdecodeChecked(pkh, pkhPrefix, 20)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode public key hash")
+	}
+
+	return append([]byte{tag}, hash...), nil
+}
+
+// Decodes any of the known signature encodings to the raw 64-byte signature
+func decodeSignatureBytes(sig string) ([]byte, error) {
+
+	var sigPrefix prefix
+
+	switch {
+	case strings.HasPrefix(sig, "edsig"):
+		sigPrefix = edsigprefix
+	case strings.HasPrefix(sig, "spsig1"):
+		sigPrefix = spsigprefix
+	case strings.HasPrefix(sig, "p2sig"):
+		sigPrefix = p2sigprefix
+	case strings.HasPrefix(sig, "sig"):
+		sigPrefix = sigprefix
+	default:
+		return nil, errors.Errorf("Unknown signature type: %s", sig)
+	}
+
+	sigBytes, err := b58cdecodeChecked(sig, sigPrefix, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode signature")
+	}
+
+	return sigBytes, nil
+}
+
+// Signatures are r || s, each 32 bytes
+func verifyECDSA(pubKey *ecdsa.PublicKey, hash, sig []byte) bool {
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+
+	return ecdsa.Verify(pubKey, hash, r, s)
+}
@@ -1,6 +1,8 @@
 package baconsigner
 
 import (
+	"sync"
+
 	"github.com/pkg/errors"
 
 	gtks "github.com/bakingbacon/go-tezos/v4/keys"
@@ -12,16 +14,54 @@ import (
 type WalletSigner struct {
 	sk     string
 	Pkh    string
-	wallet *gtks.Key
+	wallet *walletKey
+	locked bool
+
+	// Unlocked from the API while baking goroutines sign
+	lock sync.RWMutex
+
+	db storage.Store
+}
+
+var (
+	W *WalletSigner
+
+	// W is replaced by the wizard's import and generate
+	walletLock sync.RWMutex
+)
+
+var WALLET_LOCKED = errors.New("Wallet is locked; Unlock using passphrase")
+
+// The current W
+func getWallet() *WalletSigner {
+	walletLock.RLock()
+	defer walletLock.RUnlock()
+
+	return W
+}
+
+func setWallet(w *WalletSigner) {
+	walletLock.Lock()
+	defer walletLock.Unlock()
+
+	W = w
 }
 
-var W *WalletSigner
+// Replaces W with an imported, or generated, key which is not saved yet; returns its secret key and address
+func setNewWallet(db storage.Store, key *walletKey, sk string) (string, string) {
+
+	w := &WalletSigner{db: db, wallet: key, sk: sk, Pkh: key.Address()}
+	setWallet(w)
 
-func InitWalletSigner() error {
+	return w.sk, w.Pkh
+}
+
+func InitWalletSigner(db storage.Store) error {
 
-	W = &WalletSigner{}
+	w := &WalletSigner{db: db}
+	setWallet(w)
 
-	walletSk, err := storage.DB.GetSignerSk()
+	walletSk, err := db.GetSignerSk()
 	if err != nil {
 		return errors.Wrap(err, "Unable to get signer sk from DB")
 	}
@@ -30,83 +70,264 @@ func InitWalletSigner() error {
 		return errors.New("No wallet secret key found. Cannot bake.")
 	}
 
-	// Import key
-	wallet, err := gtks.FromBase58(walletSk, gtks.Ed25519)
+	// Check environment and key file for passphrase
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get wallet passphrase")
+	}
+
+	// Plaintext copies of an encrypted key
+	if isEncryptedKey(walletSk) {
+		warnPlaintextBackups(db)
+	}
+
+	// Encrypted key, but no passphrase available; wait for unlock from API
+	if isEncryptedKey(walletSk) && passphrase == "" {
+
+		_, pkh, _ := db.GetDelegate()
+
+		w.lock.Lock()
+		w.Pkh = pkh
+		w.locked = true
+		w.lock.Unlock()
+
+		log.WithField("Baker", pkh).Warn("Software wallet is encrypted; Waiting for unlock")
+
+		return nil
+	}
+
+	if !isEncryptedKey(walletSk) && passphrase == "" {
+		log.Warn("Secret key is stored unencrypted. Unlock with a passphrase to encrypt it.")
+	}
+
+	return w.Unlock(passphrase)
+}
+
+// Bolt does not overwrite freed pages, so a replaced plaintext key stays in the DB
+// file until it is compacted
+func scrubPlaintextKey(db storage.Store) {
+
+	m, ok := db.(storage.Maintainer)
+	if !ok {
+		return
+	}
+
+	before, after, err := m.Compact()
+	if err != nil {
+		log.WithError(err).Error("Unable to compact DB; Plaintext secret key may remain in unused pages")
+	} else {
+		log.WithFields(log.Fields{
+			"Before": before, "After": after,
+		}).Info("Compacted DB to remove plaintext secret key")
+	}
+
+	warnPlaintextBackups(db)
+}
+
+// Copies of the DB made before migrations and restores are not encrypted with it
+func warnPlaintextBackups(db storage.Store) {
+
+	m, ok := db.(storage.Maintainer)
+	if !ok {
+		return
+	}
+
+	keys, err := m.GetBackupSignerKeys()
+	if err != nil {
+		log.WithError(err).Error("Unable to check DB backups for plaintext secret keys")
+		return
+	}
+
+	for file, sk := range keys {
+		if !isEncryptedKey(sk) {
+			log.WithField("File", file).Warn("DB backup contains an unencrypted secret key; Delete it once no longer needed")
+		}
+	}
+}
+
+// Unlock decrypts the secret key from DB. If the key in DB is not encrypted, and a passphrase
+// is provided, the key is encrypted and saved back to DB, replacing the plaintext key.
+func (s *WalletSigner) Unlock(passphrase string) error {
+
+	walletSk, err := s.db.GetSignerSk()
 	if err != nil {
-		return errors.Wrap(err, "Failed to load wallet from secret key")
+		return errors.Wrap(err, "Unable to get signer sk from DB")
+	}
+
+	var wallet *walletKey
+
+	if isEncryptedKey(walletSk) {
+
+		wallet, err = decryptSecretKey(walletSk, passphrase)
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		wallet, err = parseSecretKey(walletSk)
+		if err != nil {
+			return errors.Wrap(err, "Failed to load wallet from secret key")
+		}
+
+		// Migrate plaintext key to encrypted
+		if passphrase != "" {
+
+			esk, err := encryptSecretKey(wallet, passphrase)
+			if err != nil {
+				return errors.Wrap(err, "Unable to encrypt secret key")
+			}
+
+			if err := s.db.SetSignerSk(esk); err != nil {
+				return errors.Wrap(err, "Unable to save encrypted secret key")
+			}
+
+			log.Info("Encrypted plaintext secret key in DB")
+
+			scrubPlaintextKey(s.db)
+		}
 	}
 
-	W.wallet = wallet
-	W.Pkh = wallet.PubKey.GetAddress()
+	s.lock.Lock()
+	s.wallet = wallet
+	s.Pkh = wallet.Address()
+	s.locked = false
+	s.lock.Unlock()
 
 	log.WithFields(log.Fields{
-		"Baker": W.Pkh, "PublicKey": W.wallet.PubKey.GetPublicKey(),
+		"Baker": wallet.Address(), "PublicKey": wallet.PublicKey(),
 	}).Info("Loaded software wallet")
 
 	return nil
 }
 
-// Generates a new ED25519 keypair; Only used on first setup through
-// UI wizard so init the signer here
-func GenerateNewKey() (string, string, error) {
+func (s *WalletSigner) IsLocked() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.locked || s.wallet == nil
+}
+
+// Decrypted key, and address; nil key while locked
+func (s *WalletSigner) key() (*walletKey, string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.locked {
+		return nil, s.Pkh
+	}
+
+	return s.wallet, s.Pkh
+}
 
-	W = &WalletSigner{}
+// Generates a new keypair of the given kind (ed25519, secp256k1, P-256); Only used on
+// first setup through UI wizard so init the signer here
+func GenerateNewKey(db storage.Store, kind gtks.ECKind) (string, string, error) {
 
-	newKey, err := gtks.Generate(gtks.Ed25519)
+	newKey, err := generateWalletKey(kind)
 	if err != nil {
 		log.WithError(err).Error("Failed to generate new key")
 		return "", "", errors.Wrap(err, "failed to generate new key")
 	}
 
-	W.wallet = newKey
-	W.sk = newKey.GetSecretKey()
-	W.Pkh = newKey.PubKey.GetAddress()
+	sk, pkh := setNewWallet(db, newKey, newKey.SecretKey())
 
-	return W.sk, W.Pkh, nil
+	return sk, pkh, nil
 }
 
-// Imports a secret key, saves to DB, and sets signer type to wallet
-func ImportSecretKey(iEdsk string) (string, string, error) {
+// Imports a secret key, saves to DB, and sets signer type to wallet.
+// Accepts edsk, spsk and p2sk keys. Encrypted keys (edesk, spesk, p2esk)
+// require the passphrase used to encrypt them.
+func ImportSecretKey(db storage.Store, iEdsk, passphrase string) (string, string, error) {
+
+	var (
+		importKey *walletKey
+		err       error
+	)
 
-	W = &WalletSigner{}
+	if isEncryptedKey(iEdsk) {
+		importKey, err = decryptSecretKey(iEdsk, passphrase)
+	} else {
+		importKey, err = parseSecretKey(iEdsk)
+	}
 
-	importKey, err := gtks.FromBase58(iEdsk, gtks.Ed25519)
 	if err != nil {
 		log.WithError(err).Error("Failed to import key")
 		return "", "", err
 	}
 
-	W.wallet = importKey
-	W.sk = iEdsk
-	W.Pkh = importKey.PubKey.GetAddress()
+	sk, pkh := setNewWallet(db, importKey, iEdsk)
 
-	return W.sk, W.Pkh, nil
+	return sk, pkh, nil
 }
 
-// Saves Sk/Pkh to DB
-func (s *WalletSigner) SaveSigner() error {
+// Encrypts Sk and saves Sk/Pkh to DB. If passphrase is empty, the
+// environment and key file are checked for one.
+func (s *WalletSigner) SaveSigner(passphrase string) error {
+
+	if passphrase == "" {
+
+		var err error
+
+		passphrase, err = getPassphrase()
+		if err != nil {
+			return errors.Wrap(err, "Unable to get wallet passphrase")
+		}
+
+		if passphrase == "" {
+			return errors.New("A passphrase is required to encrypt the secret key")
+		}
+	}
+
+	wallet, pkh := s.key()
+	if wallet == nil {
+		return WALLET_LOCKED
+	}
+
+	esk, err := encryptSecretKey(wallet, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encrypt secret key")
+	}
+
+	// A plaintext key from an older version is replaced
+	previousSk, _ := s.db.GetSignerSk()
 
-	if err := storage.DB.SetDelegate(s.sk, s.Pkh); err != nil {
+	if err := s.db.SetDelegate(esk, pkh); err != nil {
 		return errors.Wrap(err, "Unable to save key/wallet")
 	}
 
-	if err := storage.DB.SetSignerType(SIGNER_WALLET); err != nil {
+	if err := s.db.SetSignerType(SIGNER_WALLET); err != nil {
 		return errors.Wrap(err, "Unable to save key/wallet")
 	}
 
+	if previousSk != "" && !isEncryptedKey(previousSk) {
+		scrubPlaintextKey(s.db)
+	}
+
 	return nil
 }
 
 func (s *WalletSigner) SignBytes(opBytes []byte) (string, error) {
 
-	sig, err := s.wallet.SignRawBytes(opBytes) // Returns 'Signature' object
+	wallet, _ := s.key()
+	if wallet == nil {
+		return "", WALLET_LOCKED
+	}
+
+	sig, err := wallet.Sign(opBytes)
 	if err != nil {
 		return "", errors.Wrap(err, "Failed wallet signer")
 	}
 
-	return sig.ToBase58(), nil
+	return sig, nil
 }
 
 func (s *WalletSigner) GetPublicKey() (string, string, error) {
-	return s.wallet.PubKey.GetPublicKey(), s.Pkh, nil
+
+	wallet, pkh := s.key()
+	if wallet == nil {
+		return "", pkh, WALLET_LOCKED
+	}
+
+	return wallet.PublicKey(), pkh, nil
 }
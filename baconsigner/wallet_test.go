@@ -0,0 +1,212 @@
+package baconsigner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"bakinbacon/storage"
+)
+
+// A plaintext key from an older version is encrypted on first unlock; after a
+// restart the wallet is locked until unlocked with that passphrase
+func TestWalletMigrateAndUnlock(t *testing.T) {
+
+	defer func(w *WalletSigner) { W = w }(W)
+
+	v := keyVectors[1]
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	if err := db.SetDelegate(v.sk, v.pkh); err != nil {
+		t.Fatalf("Unable to set delegate: %s", err)
+	}
+
+	// No passphrase; plaintext key is loaded as is
+	if err := InitWalletSigner(db); err != nil {
+		t.Fatalf("Unable to init wallet: %s", err)
+	}
+
+	if sk, _ := db.GetSignerSk(); W.IsLocked() || sk != v.sk {
+		t.Fatalf("Unexpected wallet, locked %v, sk %s", W.IsLocked(), sk)
+	}
+
+	if err := W.Unlock("foo"); err != nil {
+		t.Fatalf("Unable to unlock: %s", err)
+	}
+
+	if sk, _ := db.GetSignerSk(); !strings.HasPrefix(sk, "spesk") {
+		t.Fatalf("Secret key not encrypted: %s", sk)
+	}
+
+	// Restart
+	if err := InitWalletSigner(db); err != nil {
+		t.Fatalf("Unable to init wallet: %s", err)
+	}
+
+	testLockedWallet(t, "foo", v.pk, v.pkh)
+}
+
+// Once encrypted, the plaintext key is gone from the DB file; copies made
+// before are listed for the warning
+func TestWalletScrubPlaintext(t *testing.T) {
+
+	defer func(w *WalletSigner) { W = w }(W)
+
+	v := keyVectors[0]
+	datadir := t.TempDir() + "/"
+
+	db, err := storage.InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	defer db.Close()
+
+	if err := db.SetDelegate(v.sk, v.pkh); err != nil {
+		t.Fatalf("Unable to set delegate: %s", err)
+	}
+
+	dbFile := datadir + storage.DATABASE_FILE
+	backup := dbFile + ".v5-20211001-120000.bak"
+
+	plain, _ := ioutil.ReadFile(dbFile)
+	if err := ioutil.WriteFile(backup, plain, 0600); err != nil {
+		t.Fatalf("Unable to copy DB: %s", err)
+	}
+
+	if err := InitWalletSigner(db); err != nil {
+		t.Fatalf("Unable to init wallet: %s", err)
+	}
+
+	if err := W.Unlock("foo"); err != nil {
+		t.Fatalf("Unable to unlock: %s", err)
+	}
+
+	if content, _ := ioutil.ReadFile(dbFile); bytes.Contains(content, []byte(v.sk)) {
+		t.Errorf("Plaintext secret key left in DB file")
+	}
+
+	if keys, err := db.GetBackupSignerKeys(); err != nil || keys[backup] != v.sk {
+		t.Errorf("Plaintext backup not found: %v %v", keys, err)
+	}
+}
+
+// Run with -race; the API unlocks while baking signs
+func TestWalletConcurrentUnlock(t *testing.T) {
+
+	defer func(w *WalletSigner) { W = w }(W)
+
+	v := keyVectors[2]
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	if _, _, err := ImportSecretKey(db, v.sk, ""); err != nil {
+		t.Fatalf("Unable to import key: %s", err)
+	}
+
+	if err := getWallet().SaveSigner("bar"); err != nil {
+		t.Fatalf("Unable to save signer: %s", err)
+	}
+
+	if err := InitWalletSigner(db); err != nil {
+		t.Fatalf("Unable to init wallet: %s", err)
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_, _ = getWallet().SignBytes(blockBytes(100))
+			_ = getWallet().IsLocked()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_ = getWallet().Unlock("bar")
+		_, _, _ = ImportSecretKey(db, v.sk, "")
+	}()
+
+	wg.Wait()
+
+	if getWallet().IsLocked() {
+		t.Errorf("Imported wallet locked")
+	}
+}
+
+// A new key is saved encrypted, and is locked after a restart
+func TestWalletSaveAndUnlock(t *testing.T) {
+
+	defer func(w *WalletSigner) { W = w }(W)
+
+	v := keyVectors[2]
+
+	db := storage.NewMemoryStorage("granadanet")
+
+	if _, _, err := ImportSecretKey(db, v.sk, ""); err != nil {
+		t.Fatalf("Unable to import key: %s", err)
+	}
+
+	if err := W.SaveSigner(""); err == nil {
+		t.Errorf("Saved without a passphrase")
+	}
+
+	if err := W.SaveSigner("bar"); err != nil {
+		t.Fatalf("Unable to save signer: %s", err)
+	}
+
+	if sk, pkh, _ := db.GetDelegate(); !strings.HasPrefix(sk, "p2esk") || pkh != v.pkh {
+		t.Fatalf("Unexpected delegate %s %s", sk, pkh)
+	}
+
+	if signerType, _ := db.GetSignerType(); signerType != SIGNER_WALLET {
+		t.Errorf("Unexpected signer type %d", signerType)
+	}
+
+	// Restart
+	if err := InitWalletSigner(db); err != nil {
+		t.Fatalf("Unable to init wallet: %s", err)
+	}
+
+	testLockedWallet(t, "bar", v.pk, v.pkh)
+}
+
+// W stays locked after a wrong passphrase, and signs as pkh once unlocked
+func testLockedWallet(t *testing.T, passphrase, pk, pkh string) {
+
+	t.Helper()
+
+	msg := blockBytes(100)
+
+	if !W.IsLocked() || W.Pkh != pkh {
+		t.Fatalf("Wallet of %s not locked", W.Pkh)
+	}
+
+	if _, err := W.SignBytes(msg); err != WALLET_LOCKED {
+		t.Errorf("Expected locked wallet, got %v", err)
+	}
+
+	if err := W.Unlock("wrong"); err == nil {
+		t.Errorf("Unlocked with wrong passphrase")
+	}
+
+	if _, err := W.SignBytes(msg); err != WALLET_LOCKED {
+		t.Errorf("Wallet not locked after wrong passphrase: %v", err)
+	}
+
+	if err := W.Unlock(passphrase); err != nil {
+		t.Fatalf("Unable to unlock: %s", err)
+	}
+
+	sig, err := W.SignBytes(msg)
+	if err != nil {
+		t.Fatalf("Unable to sign: %s", err)
+	}
+
+	if err := VerifySignature(pk, sig, msg); err != nil {
+		t.Errorf("Signature did not verify: %s", err)
+	}
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"flag"
+	"io"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconclient"
+	"bakinbacon/baconsigner"
 	"bakinbacon/notifications"
+	"bakinbacon/signerserver"
 	"bakinbacon/storage"
 	"bakinbacon/webserver"
 )
@@ -28,6 +32,53 @@ var (
 	webUiAddr         *string
 	webUiPort         *int
 	dataDir           *string
+
+	walletPassphraseEnv  *string
+	walletPassphraseFile *string
+	signingPolicy        *string
+
+	// PKCS#11 HSM flags
+	pkcs11Module   *string
+	pkcs11Slot     *uint
+	pkcs11PinEnv   *string
+	pkcs11PinFile  *string
+	pkcs11KeyLabel *string
+	pkcs11Force    *bool
+
+	ledgerEmulator *bool
+
+	// Remote signer flags
+	signerServer         *bool
+	signerAddr           *string
+	signerPort           *int
+	signerMagicBytes     *string
+	signerCheckHwm       *bool
+	signerAuthorizedKeys *string
+
+	recordRpc     *string
+	faultScenario *string
+
+	migrateOnly   *bool
+	migrateDryRun *bool
+
+	backupFile       *string
+	restoreFile      *string
+	restoreForce     *bool
+	backupPassphrase *string
+
+	exportFile      *string
+	exportFormat    *string
+	exportFromCycle *int
+	exportToCycle   *int
+
+	recoveryDepth *int
+	skipRecovery  *bool
+
+	pruneInterval       *time.Duration
+	retainRightsCycles  *int
+	retainNonceCycles   *int
+	historyRetention    *string
+	retainHistoryCycles *int
 )
 
 // TODO: Translations (https://www.transifex.com/bakinbacon/bakinbacon-core/content/)
@@ -49,8 +100,57 @@ func main() {
 	// Clean exits
 	shutdownChannel := setupCloseChannel()
 
+	// Snapshot, or replace, the database and exit
+	if *backupFile != "" {
+		if err := runBackup(*backupFile, os.Getenv(*backupPassphrase)); err != nil {
+			log.WithError(err).Fatal("Could not back up database")
+		}
+		log.WithField("File", *backupFile).Info("Database backed up")
+		os.Exit(0)
+	}
+
+	if *restoreFile != "" {
+		if err := runRestore(*restoreFile, os.Getenv(*backupPassphrase), *restoreForce); err != nil {
+			log.WithError(err).Fatal("Could not restore database")
+		}
+		os.Exit(0)
+	}
+
+	// Export history for accounting and exit
+	if *exportFile != "" {
+		if err := runExport(*exportFile, *exportFormat, *exportFromCycle, *exportToCycle); err != nil {
+			log.WithError(err).Fatal("Could not export history")
+		}
+		log.WithField("File", *exportFile).Info("History exported")
+		os.Exit(0)
+	}
+
+	// Upgrade the database, or show what would be upgraded, and exit
+	if *migrateOnly || *migrateDryRun {
+
+		from, to, err := storage.Migrate(*dataDir, network, *migrateDryRun)
+		if err != nil {
+			log.WithError(err).Fatal("Could not migrate storage")
+		}
+
+		log.WithFields(log.Fields{
+			"From": from, "To": to, "DryRun": *migrateDryRun,
+		}).Info("Database schema version")
+
+		os.Exit(0)
+	}
+
+	// How long rights, nonces and history are kept in the database
+	storage.SetRetention(storage.Retention{
+		RightsCycles:   *retainRightsCycles,
+		NonceCycles:    *retainNonceCycles,
+		ArchiveHistory: *historyRetention == "archive",
+		HistoryCycles:  *retainHistoryCycles,
+	})
+
 	// Open/Init database
-	if err := storage.InitStorage(*dataDir, network); err != nil {
+	db, err := storage.InitStorage(*dataDir, network)
+	if err != nil {
 		log.WithError(err).Fatal("Could not open storage")
 	}
 
@@ -59,7 +159,7 @@ func main() {
 	log.Infof("=== Network: %s ===", network)
 
 	// Global Notifications handler singleton
-	if err := notifications.New(); err != nil {
+	if err := notifications.New(db); err != nil {
 		log.WithError(err).Error("Unable to load notifiers")
 	}
 
@@ -73,12 +173,81 @@ func main() {
 		"TimeBetweenBlocks":   networkConstants[network].TimeBetweenBlocks,
 	}).Debug("Loaded Network Constants")
 
+	// Where to find the passphrase for an encrypted software wallet
+	baconsigner.SetPassphraseSources(*walletPassphraseEnv, *walletPassphraseFile)
+
+	// HSM signer; replaces the wallet or ledger signer when a module is given
+	baconsigner.SetPkcs11Config(baconsigner.Pkcs11Config{
+		ModulePath: *pkcs11Module,
+		Slot:       *pkcs11Slot,
+		PinEnv:     *pkcs11PinEnv,
+		PinFile:    *pkcs11PinFile,
+		KeyLabel:   *pkcs11KeyLabel,
+		Force:      *pkcs11Force,
+	})
+
+	// Emulated ledger in place of a device, for demos and testing
+	if *ledgerEmulator {
+
+		if network == NETWORK_MAINNET {
+			log.Fatal("Ledger emulator cannot be used on mainnet")
+		}
+
+		emulator, err := baconsigner.LoadLedgerEmulator(*dataDir + "ledger-emulator.seed")
+		if err != nil {
+			log.WithError(err).Fatal("Cannot load ledger emulator")
+		}
+
+		baconsigner.SetLedgerTransport(func() (baconsigner.LedgerTransport, error) {
+			return emulator, nil
+		})
+
+		log.Warn("Using emulated ledger; keys are NOT on a hardware device")
+	}
+
+	// Restrict what the signer will sign; default policy if no file
+	if *signingPolicy != "" {
+		policy, err := baconsigner.LoadPolicy(*signingPolicy)
+		if err != nil {
+			log.WithError(err).Fatal("Cannot load signing policy")
+		}
+		baconsigner.SetPolicy(policy)
+	}
+
+	// Record RPC payloads for offline tests
+	if *recordRpc != "" {
+		log.WithField("Dir", *recordRpc).Warn("Recording RPC requests and responses")
+		baconclient.SetFixtureRecorder(*recordRpc)
+	}
+
+	// Misbehaving nodes, for resilience testing
+	if *faultScenario != "" {
+
+		if network == NETWORK_MAINNET {
+			log.Fatal("Fault scenarios cannot be used on mainnet")
+		}
+
+		scenario, err := baconclient.LoadFaultScenario(*faultScenario)
+		if err != nil {
+			log.WithError(err).Fatal("Cannot load fault scenario")
+		}
+
+		baconclient.SetFaultScenario(scenario)
+
+		log.WithField("Faults", len(scenario.Faults)).Warn("Injecting faults into RPC requests")
+	}
+
 	// Set up RPC polling-monitoring
-	bc, err = baconclient.New(networkConstants[network].TimeBetweenBlocks, shutdownChannel, &wg)
+	bc, err = baconclient.New(db, networkConstants[network].TimeBetweenBlocks,
+		networkConstants[network].ChainID, networkConstants[network].Protocols, shutdownChannel, &wg)
 	if err != nil {
 		log.WithError(err).Fatalf("Cannot create BaconClient")
 	}
 
+	// Watch the signer; reconnects the ledger if it goes away
+	wg.Add(1)
+	go bc.Signer.Supervise(shutdownChannel, &wg)
+
 	// Start web UI
 	// Template variables for the UI
 	wg.Add(1)
@@ -88,7 +257,23 @@ func main() {
 		MinBlockTime:   networkConstants[network].TimeBetweenBlocks,
 		UiBaseUrl:      os.Getenv("UI_DEBUG"),
 	}
-	webserver.Start(bc, *webUiAddr, *webUiPort, templateVars, shutdownChannel, &wg)
+	webserver.SetHistoryExport(func(w io.Writer, format string, fromCycle, toCycle int) error {
+		return exportHistory(db, w, format, fromCycle, toCycle)
+	})
+	webserver.Start(bc, db, *webUiAddr, *webUiPort, templateVars, shutdownChannel, &wg)
+
+	// Start octez-compatible remote signer
+	if *signerServer {
+		if err := startSignerServer(db, shutdownChannel, &wg); err != nil {
+			log.WithError(err).Fatal("Cannot start remote signer")
+		}
+	}
+
+	// Prune and compact the database in the background
+	if *pruneInterval > 0 {
+		wg.Add(1)
+		go runPruning(db, *pruneInterval, shutdownChannel, &wg)
+	}
 
 	// For canceling when new blocks appear
 	_, ctxCancel := context.WithCancel(context.Background())
@@ -97,7 +282,20 @@ func main() {
 	_ = bc.CanBake(false)
 
 	// Update bacon-status with most recent bake/endorse info
-	updateRecentBaconStatus()
+	updateRecentBaconStatus(db)
+
+	// Levels scanned for our bakes and endorsements, if the DB has no watermarks
+	depth := *recoveryDepth
+	if depth == 0 {
+		depth = networkConstants[network].BlocksPerCycle
+	}
+
+	if *skipRecovery && recoveryPending(db) {
+		log.Warn("Skipping recovery of history from chain; Watermarks may be behind operations already signed")
+		if err := db.SetRecoveryState(storage.RECOVERY_SKIPPED); err != nil {
+			log.WithError(err).Error("Unable to save recovery state")
+		}
+	}
 
 	// loop forever, waiting for new blocks coming from the RPC monitors
 	Main:
@@ -113,30 +311,42 @@ func main() {
 			// Create a new context for this run
 			ctx, ctxCancel = context.WithCancel(context.Background())
 
+			// Switch to a rotated consensus key before any signing for this block
+			bc.CheckConsensusKey(block.Metadata.Level.Cycle)
+
 			// If we can't bake, no need to do try and do anything else
 			// This check is silent = true on success
 			if !bc.CanBake(true) {
 				continue
 			}
 
+			// Nothing is signed on an empty DB until our history is recovered from chain
+			if recoveryPending(db) {
+				startRecovery(db, *block, depth, shutdownChannel, &wg)
+				continue
+			}
+
 			wg.Add(1)
-			go handleEndorsement(ctx, &wg, *block)
+			go handleEndorsement(ctx, &wg, db, *block)
 
 			wg.Add(1)
-			go revealNonces(ctx, &wg, *block)
+			go revealNonces(ctx, &wg, db, *block)
 
 			wg.Add(1)
-			go handleBake(ctx, &wg, *block)
+			go handleBake(ctx, &wg, db, *block)
 
 			//
 			// Utility
 			//
 
 			// Update UI with next rights
-			go updateCycleRightsStatus(block.Metadata.Level)
+			go updateCycleRightsStatus(db, block.Metadata.Level)
 
 			// Pre-fetch rights to DB as both backup and for UI display
-			go prefetchCycleRights(block.Metadata.Level)
+			go prefetchCycleRights(db, block.Metadata.Level)
+
+			// Realized rewards and deposits of recent bakes and endorsements
+			go settleHistory(db, block.Metadata.Level)
 
 		case <-shutdownChannel:
 			log.Warn("Shutting things down...")
@@ -150,12 +360,39 @@ func main() {
 	wg.Wait()
 
 	// Clean close DB, logs
-	storage.DB.Close()
+	db.Close()
 	closeLogging()
 
 	os.Exit(0)
 }
 
+func startSignerServer(db storage.Store, shutdownChannel chan interface{}, wg *sync.WaitGroup) error {
+
+	magicBytes, err := signerserver.ParseMagicBytes(*signerMagicBytes)
+	if err != nil {
+		return err
+	}
+
+	var authorizedKeys []string
+	if *signerAuthorizedKeys != "" {
+		authorizedKeys, err = signerserver.LoadAuthorizedKeys(*signerAuthorizedKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	return signerserver.Start(bc.Signer, db, signerserver.Config{
+		BindAddr:           *signerAddr,
+		BindPort:           *signerPort,
+		MagicBytes:         magicBytes,
+		CheckHighWatermark: *signerCheckHwm,
+		AuthorizedKeys:     authorizedKeys,
+		RecoveryPending: func() bool {
+			return recoveryPending(db)
+		},
+	}, shutdownChannel, wg)
+}
+
 func setupCloseChannel() chan interface{} {
 
 	// Create channels for signals
@@ -188,6 +425,52 @@ func parseArgs() {
 
 	dataDir = flag.String("datadir", "./", "Location of database")
 
+	walletPassphraseEnv = flag.String("wallet-passphrase-env", "BAKINBACON_PASSPHRASE", "Environment variable containing the software wallet passphrase")
+	walletPassphraseFile = flag.String("wallet-passphrase-file", "", "File containing the software wallet passphrase")
+	signingPolicy = flag.String("signing-policy", "", "JSON file of the signing policy; see README for format and defaults")
+
+	pkcs11Module = flag.String("pkcs11-module", "", "Path to PKCS#11 module of an HSM holding the baking key; ie: /usr/lib/softhsm/libsofthsm2.so")
+	pkcs11Slot = flag.Uint("pkcs11-slot", 0, "PKCS#11 slot id")
+	pkcs11PinEnv = flag.String("pkcs11-pin-env", "BAKINBACON_PKCS11_PIN", "Environment variable containing the PKCS#11 user PIN")
+	pkcs11PinFile = flag.String("pkcs11-pin-file", "", "File containing the PKCS#11 user PIN")
+	pkcs11KeyLabel = flag.String("pkcs11-key-label", "", "Label of the baking key pair in the HSM")
+	pkcs11Force = flag.Bool("pkcs11-force", false, "Bake with the PKCS#11 key even if it is not the delegate in the database, replacing it")
+
+	ledgerEmulator = flag.Bool("ledger-emulator", false, "Use an emulated Ledger Tezos Baking app instead of a device; testnets only")
+
+	signerServer = flag.Bool("signer-server", false, "Expose the loaded signer using the octez remote signer API")
+	signerAddr = flag.String("signer-addr", "127.0.0.1", "Address on which to bind remote signer")
+	signerPort = flag.Int("signer-port", 6732, "Port on which to bind remote signer")
+	signerMagicBytes = flag.String("signer-magic-bytes", "0x01,0x02", "Comma separated list of magic bytes the remote signer will sign")
+	signerCheckHwm = flag.Bool("signer-check-hwm", true, "Check and update the shared high watermark when remote signing blocks and endorsements")
+	signerAuthorizedKeys = flag.String("signer-authorized-keys", "", "File of public keys, one per line, required to authenticate remote signing requests")
+
+	recordRpc = flag.String("record-rpc", "", "Directory to which RPC requests and responses are written, as test fixtures")
+	faultScenario = flag.String("fault-scenario", "", "JSON file of faults to inject into RPC requests, for testing; testnets only")
+
+	migrateOnly = flag.Bool("migrate-only", false, "Upgrade the database schema, backing it up first, and exit")
+	migrateDryRun = flag.Bool("migrate-dry-run", false, "Show the database migrations which would be applied, without applying them, and exit")
+
+	backupFile = flag.String("backup", "", "Write a snapshot of the database to this file and exit; works while BakinBacon runs, through its API")
+	restoreFile = flag.String("restore", "", "Replace the database with this backup and exit; BakinBacon must be stopped")
+	restoreForce = flag.Bool("restore-force", false, "Restore a backup with older watermarks than the current database")
+	backupPassphrase = flag.String("backup-passphrase-env", "BAKINBACON_BACKUP_PASSPHRASE", "Environment variable containing the passphrase with which backups are encrypted; unencrypted if empty")
+
+	exportFile = flag.String("export-history", "", "Write all bakes, endorsements and nonce reveals to this file and exit; works while BakinBacon runs, through its API")
+	exportFormat = flag.String("export-format", "csv", "Format of -export-history: csv, json")
+	exportFromCycle = flag.Int("export-from-cycle", 0, "First cycle of -export-history")
+	exportToCycle = flag.Int("export-to-cycle", -1, "Last cycle of -export-history; -1 for up to the current cycle")
+
+	recoveryDepth = flag.Int("recovery-depth", 0, "Levels scanned back from head for our bakes and endorsements when the database has no watermarks; 0 is one cycle")
+	skipRecovery = flag.Bool("skip-recovery", false, "Bake on a database without watermarks without first recovering history from chain")
+
+	defaults := storage.DefaultRetention()
+	pruneInterval = flag.Duration("prune-interval", 6*time.Hour, "How often old rights, nonces and history are pruned from the database, which is then compacted if mostly free space; 0 disables")
+	retainRightsCycles = flag.Int("retain-rights-cycles", defaults.RightsCycles, "Cycles of past baking and endorsing rights kept in the database")
+	retainNonceCycles = flag.Int("retain-nonce-cycles", defaults.NonceCycles, "Cycles nonces are kept in the database after the cycle in which they are revealed")
+	historyRetention = flag.String("history-retention", "keep", "Bakes and endorsements older than -retain-history-cycles are kept in the database, or moved to an archive file: keep, archive")
+	retainHistoryCycles = flag.Int("retain-history-cycles", defaults.HistoryCycles, "Cycles of bakes and endorsements kept in the database with -history-retention archive")
+
 	printVersion := flag.Bool("version", false, "Show version and exit")
 
 	flag.Parse()
@@ -198,6 +481,16 @@ func parseArgs() {
 		os.Exit(1)
 	}
 
+	if *exportFormat != "csv" && *exportFormat != "json" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *historyRetention != "keep" && *historyRetention != "archive" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	// Handle print version and exit
 	if *printVersion {
 		log.Printf("Bakin'Bacon %s (%s)", version, commitHash)
@@ -14,6 +14,7 @@ import (
 	"github.com/bakingbacon/go-tezos/v4/rpc"
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/baconclient"
 	"bakinbacon/baconsigner"
 	"bakinbacon/nonce"
 	"bakinbacon/notifications"
@@ -30,7 +31,10 @@ const (
 	POW_LENGTH        int = 4
 )
 
-func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
+// Time for the mempool to accumulate endorsements and ops between fetches
+var mempoolInterval = 10 * time.Second
+
+func handleBake(ctx context.Context, wg *sync.WaitGroup, db storage.Store, block rpc.Block) {
 
 	// Decrement waitGroup on exit
 	defer wg.Done()
@@ -42,6 +46,16 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 		}
 	}()
 
+	// Same endpoints throughout, even if Current changes meanwhile; preapply
+	// and injection go through the inject endpoint
+	rpcClient := bc.Current()
+	injector := bc.Injector()
+
+	if rpcClient == nil || injector == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Warn("Not baking")
+		return
+	}
+
 	// Reference
 	// https://gitlab.com/tezos/tezos/-/blob/mainnet-staging/src/proto_006_PsCARTHA/lib_delegate/client_baking_forge.ml
 
@@ -54,11 +68,6 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	// Check that we have not already baked this block
 	// ie: implement internal watermark
 
-	// TODO
-	//  error="failed to preapply new block: response returned code 500 with body
-	//  "kind":"permanent", "id":"proto.006-PsCARTHA.baking.timestamp_too_early",
-	//  "minimum":"2020-09-20T22:17:28Z","provided":"2020-09-20T22:16:58Z"
-
 	//
 	// Steps to making a block
 	//
@@ -76,7 +85,7 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	nextLevelToBake := block.Header.Level + 1
 
 	// Check watermark to ensure we have not baked at this level before
-	watermark, err := storage.DB.GetBakingWatermark()
+	watermark, err := db.GetBakingWatermark()
 	if err != nil {
 		// watermark = 0 on DB error
 		log.WithError(err).Error("Unable to get baking watermark from DB")
@@ -99,7 +108,7 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 		MaxPriority: MAX_BAKE_PRIORITY,
 	}
 
-	resp, bakingRights, err := bc.Current.BakingRights(bakingRightsFilter)
+	resp, bakingRights, err := rpcClient.BakingRights(bakingRightsFilter)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": resp.Body(),
@@ -227,20 +236,20 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 
 	for time.Now().UTC().Before(endMempool) && endorsingPower < minEndorsingPower {
 
-		// Sleep 10s to let mempool accumulate
-		log.Infof("Sleeping 10s for more endorsements and ops")
+		// Sleep to let mempool accumulate
+		log.Infof("Sleeping %s for more endorsements and ops", mempoolInterval)
 
 		// Sleep, but also check if new block arrived
 		select {
 		case <-ctx.Done():
 			log.Info("New block arrived; Canceling current bake")
 			return
-		case <-time.After(10 * time.Second):
+		case <-time.After(mempoolInterval):
 			break
 		}
 
 		// Get mempool contents
-		_, mempoolOps, err := bc.Current.Mempool(mempoolInput)
+		_, mempoolOps, err := rpcClient.Mempool(mempoolInput)
 		if err != nil {
 			log.WithError(err).Error("Failed to fetch mempool ops")
 			return
@@ -258,7 +267,7 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 
 		// compute_endorsing_power with current endorsements
 		// Send all operations in the first slot, which are endorsements
-		endorsingPower, err = computeEndorsingPower(&hashBlockID, block.Header.Level, operations[0])
+		endorsingPower, err = computeEndorsingPower(rpcClient, &hashBlockID, block.Header.Level, operations[0])
 		if err != nil {
 			log.WithError(err).Error("Unable to compute endorsing power; Using 90% of minimum power")
 
@@ -283,7 +292,7 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	// Timestamp of previous block + 30s = minimal timestamp
 
 	// With endorsing power and priority, compute earliest timestamp to inject block
-	resp, minimalInjectionTime, err := bc.Current.MinimalValidTime(rpc.MinimalValidTimeInput{
+	resp, minimalInjectionTime, err := rpcClient.MinimalValidTime(rpc.MinimalValidTimeInput{
 		BlockID:        &hashBlockID,
 		Priority:       priority,
 		EndorsingPower: endorsingPower,
@@ -340,7 +349,31 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	//
 	// If the initial preapply fails, attempt again using an empty list of operations
 	//
-	resp, preapplyBlockResp, err := bc.Current.PreapplyBlock(preapplyBlockheader)
+	resp, preapplyBlockResp, err := injector.PreapplyBlock(preapplyBlockheader)
+	err = baconclient.DecodeRPCError(resp, err)
+
+	// Node disagrees on the minimal timestamp; wait for the one it reports, and try once more
+	if minimum, tooEarly := baconclient.IsTimestampTooEarly(err); tooEarly && minimum.After(minimalInjectionTime) {
+
+		log.WithFields(log.Fields{
+			"MinimalTS": minimalInjectionTime.Format(time.RFC3339Nano), "NodeMinimalTS": minimum.Format(time.RFC3339Nano),
+		}).Warn("Timestamp too early for node; Retrying preapply")
+
+		select {
+		case <-ctx.Done():
+			log.Info("New block arrived; Canceling current bake")
+			return
+		case <-time.After(time.Until(minimum)):
+			break
+		}
+
+		minimalInjectionTime = minimum
+		preapplyBlockheader.Timestamp = &minimalInjectionTime
+
+		resp, preapplyBlockResp, err = injector.PreapplyBlock(preapplyBlockheader)
+		err = baconclient.DecodeRPCError(resp, err)
+	}
+
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -363,7 +396,7 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	log.WithField("ProtocolData", protocolData).Debug("Generated Protocol Data")
 
 	// Forge the block header using RPC
-// 	resp, forgedBlockHeader, err := bc.Current.ForgeBlockHeader(rpc.ForgeBlockHeaderInput{
+// 	resp, forgedBlockHeader, err := rpcClient.ForgeBlockHeader(rpc.ForgeBlockHeaderInput{
 // 		BlockID: &hashBlockID,
 // 		BlockHeader: rpc.ForgeBlockHeaderBody{
 // 			Level:          shellHeader.Level,
@@ -467,10 +500,17 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 		return
 	}
 
+	// Raise the watermark before injecting. A failed injection may still have reached
+	// the node, ie: the response was lost; another block at this level would be a double bake.
+	if err := db.CheckAndSetBakingWatermark(nextLevelToBake); err != nil {
+		log.WithError(err).WithField("BakingLevel", nextLevelToBake).Error("Unable to raise baking watermark; Cancel bake to prevent double baking")
+		return
+	}
+
 	// Inject block
-	resp, blockHash, err := bc.Current.InjectionBlock(ibi)
+	resp, blockHash, err := injector.InjectionBlock(ibi)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
+		log.WithError(baconclient.DecodeRPCError(resp, err)).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Block Injection Failure")
 		return
@@ -481,14 +521,27 @@ func handleBake(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	}).Info("Block Injected")
 
 	// Save watermark to DB
-	if err := storage.DB.RecordBakedBlock(nextLevelToBake, blockHash); err != nil {
+	if err := db.RecordBakedBlock(nextLevelToBake, blockHash); err != nil {
 		log.WithError(err).Error("Unable to save block; Watermark compromised")
 	}
 
+	// Details for export; rewards are settled from chain once included
+	if err := db.SaveHistoryEntry(storage.HistoryEntry{
+		Kind:      storage.HISTORY_BAKE,
+		Level:     nextLevelToBake,
+		Cycle:     getCycleFromLevel(nextLevelToBake),
+		Timestamp: shellHeader.Timestamp,
+		Hash:      blockHash,
+		Priority:  priority,
+		Status:    storage.HISTORY_PENDING,
+	}); err != nil {
+		log.WithError(err).Error("Unable to save bake to history")
+	}
+
 	// Save nonce to DB for reveal in next cycle
 	withNonce := ""
 	if n.EncodedNonce != "" {
-		if err := storage.DB.SaveNonce(block.Metadata.Level.Cycle, n); err != nil {
+		if err := db.SaveNonce(block.Metadata.Level.Cycle, n); err != nil {
 			log.WithError(err).Error("Unable to save nonce for reveal")
 		}
 		withNonce = ", with nonce"
@@ -708,7 +761,7 @@ func parseMempoolOperations(ops *rpc.Mempool, curBranch string, curLevel int, he
 	return operations, nil
 }
 
-func computeEndorsingPower(blockId rpc.BlockID, bakingLevel int, operations []rpc.Operations) (int, error) {
+func computeEndorsingPower(rpcClient *baconclient.BaconSlice, blockId rpc.BlockID, bakingLevel int, operations []rpc.Operations) (int, error) {
 
 	// Endorsing power is just the total number of endorsing slots for a delegate.
 	// We fetch endorsing rights for this level, validate entry, increment power.
@@ -720,7 +773,7 @@ func computeEndorsingPower(blockId rpc.BlockID, bakingLevel int, operations []rp
 		BlockID: blockId,
 		Level:   bakingLevel,
 	}
-	resp, endorsingRights, err := bc.Current.EndorsingRights(endorsingRightsInput)
+	resp, endorsingRights, err := rpcClient.EndorsingRights(endorsingRightsInput)
 	if err != nil {
 		return endorsingPower, err
 	}
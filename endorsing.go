@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -14,6 +15,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/baconclient"
 	"bakinbacon/notifications"
 	"bakinbacon/storage"
 )
@@ -33,7 +35,7 @@ $ ~/.opam/for_tezos/bin/tezos-codec decode 009-PsFLoren.operation from 5b3c0553c
     "edsigtXomBKi5CTRf5cjATJWSyaRvhfYNHqSUGrn4SdbYRcGwQrUGjzEfQDTuqHhuA8b2d8NarZjz8TRf65WkpQmo423BtomS8Q" }
 */
 
-func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
+func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, db storage.Store, block rpc.Block) {
 
 	// Decrement waitGroup on exit
 	defer wg.Done()
@@ -45,10 +47,20 @@ func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, block rpc.Block)
 		}
 	}()
 
+	// Same endpoints throughout, even if Current changes meanwhile; preapply
+	// and injection go through the inject endpoint
+	rpcClient := bc.Current()
+	injector := bc.Injector()
+
+	if rpcClient == nil || injector == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Warn("Not endorsing")
+		return
+	}
+
 	endorsingLevel := block.Header.Level
 
 	// Check watermark to ensure we have not endorsed at this level before
-	watermark, err := storage.DB.GetEndorsingWatermark()
+	watermark, err := db.GetEndorsingWatermark()
 	if err != nil {
 		// watermark = 0 on DB error
 		log.WithError(err).Error("Unable to get endorsing watermark from DB")
@@ -70,7 +82,7 @@ func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, block rpc.Block)
 		Delegate: bc.Signer.BakerPkh,
 	}
 
-	resp, endorsingRights, err := bc.Current.EndorsingRights(endorsingRightsFilter)
+	resp, endorsingRights, err := rpcClient.EndorsingRights(endorsingRightsFilter)
 
 	log.WithFields(log.Fields{
 		"Level": endorsingLevel, "Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -206,10 +218,17 @@ func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, block rpc.Block)
 		return
 	}
 
+	// Raise the watermark before injecting, as for blocks; a failed injection may have
+	// reached the node, and another endorsement at this level would be a double endorsement
+	if err := db.CheckAndSetEndorsingWatermark(endorsingLevel); err != nil {
+		log.WithError(err).WithField("EndorsingLevel", endorsingLevel).Error("Unable to raise endorsing watermark; Canceling to prevent double endorsing")
+		return
+	}
+
 	// Inject endorsement
-	resp, opHash, err := bc.Current.InjectionOperation(injectionInput)
+	resp, opHash, err := injector.InjectionOperation(injectionInput)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
+		log.WithError(baconclient.DecodeRPCError(resp, err)).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Endorsement Injection Failure")
 
@@ -219,10 +238,23 @@ func handleEndorsement(ctx context.Context, wg *sync.WaitGroup, block rpc.Block)
 	log.WithField("Operation", opHash).Info("Endorsement Injected")
 
 	// Save endorsement to DB for watermarking
-	if err := storage.DB.RecordEndorsement(endorsingLevel, opHash); err != nil {
+	if err := db.RecordEndorsement(endorsingLevel, opHash); err != nil {
 		log.WithError(err).Error("Unable to save endorsement; Watermark compromised")
 	}
 
+	// Details for export; rewards are settled from chain once included
+	if err := db.SaveHistoryEntry(storage.HistoryEntry{
+		Kind:      storage.HISTORY_ENDORSEMENT,
+		Level:     endorsingLevel,
+		Cycle:     getCycleFromLevel(endorsingLevel),
+		Timestamp: time.Now().UTC(),
+		Hash:      opHash,
+		Slots:     len(allSlots),
+		Status:    storage.HISTORY_PENDING,
+	}); err != nil {
+		log.WithError(err).Error("Unable to save endorsement to history")
+	}
+
 	// Update status for UI
 	bc.Status.SetRecentEndorsement(endorsingLevel, block.Metadata.Level.Cycle, opHash)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"bakinbacon/baconclient"
+)
+
+// Requests of bc, set up after this, go through the faults of scenario
+func setFaultScenario(t *testing.T, scenario string) {
+
+	file := filepath.Join(t.TempDir(), "scenario.json")
+	if err := ioutil.WriteFile(file, []byte(scenario), 0600); err != nil {
+		t.Fatalf("Unable to write scenario: %s", err)
+	}
+
+	s, err := baconclient.LoadFaultScenario(file)
+	if err != nil {
+		t.Fatalf("Unable to load scenario: %s", err)
+	}
+
+	baconclient.SetFaultScenario(s)
+	t.Cleanup(func() { baconclient.SetFaultScenario(nil) })
+}
+
+// The node receives the block, but its response is lost; baking the same
+// head again must not sign, nor inject, another block at that level
+func TestFaultsNoDoubleBake(t *testing.T) {
+
+	setFaultScenario(t, `{"faults": [
+		{"name": "lost", "method": "POST", "path": "/injection/block", "count": 1, "truncate": true}
+	]}`)
+
+	replay, db := replayClient(t, "testdata/bake-granadanet.jsonl")
+
+	mempoolInterval = 0
+
+	dryRun := false
+	dryRunBake = &dryRun
+
+	for i := 0; i < 2; i++ {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		handleBake(context.Background(), &wg, db, replayBlock())
+		wg.Wait()
+	}
+
+	if n := replay.Served("POST", "/injection/block"); n != 1 {
+		t.Errorf("Block injected %d times at the same level", n)
+	}
+
+	if watermark, _ := db.GetBakingWatermark(); watermark != 331391 {
+		t.Errorf("Watermark %d; expected 331391 after failed injection", watermark)
+	}
+}
+
+func TestFaultsNoDoubleEndorse(t *testing.T) {
+
+	setFaultScenario(t, `{"faults": [
+		{"name": "lost", "method": "POST", "path": "/injection/operation", "count": 1, "truncate": true}
+	]}`)
+
+	replay, db := replayClient(t, "testdata/endorse-granadanet.jsonl")
+
+	dryRun := false
+	dryRunEndorsement = &dryRun
+
+	for i := 0; i < 2; i++ {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		handleEndorsement(context.Background(), &wg, db, replayBlock())
+		wg.Wait()
+	}
+
+	if n := replay.Served("POST", "/injection/operation"); n != 1 {
+		t.Errorf("Endorsement injected %d times at the same level", n)
+	}
+
+	if watermark, _ := db.GetEndorsingWatermark(); watermark != 331390 {
+		t.Errorf("Watermark %d; expected 331390 after failed injection", watermark)
+	}
+}
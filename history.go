@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/storage"
+)
+
+const (
+	// Bakes and endorsements are settled once their block is this deep
+	HISTORY_SETTLE_DEPTH = 2
+
+	// Most pending entries settled per block
+	HISTORY_SETTLE_MAX = 32
+)
+
+// Set while settleHistory runs; a slow node must not stack them up
+var settling int32
+
+// Fills in the realized rewards, fees and deposits of pending bakes and endorsements
+// from chain, once included. Entries still pending after a cycle are left as they are.
+func settleHistory(db storage.Store, metadataLevel rpc.Level) {
+
+	if !atomic.CompareAndSwapInt32(&settling, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&settling, 0)
+
+	pkh := bc.Signer.BakerPkh
+
+	pending, err := db.GetPendingHistory(metadataLevel.Level - networkConstants[network].BlocksPerCycle)
+	if err != nil {
+		log.WithError(err).Error("Unable to get pending history")
+		return
+	}
+
+	// Same endpoint throughout, even if Current changes meanwhile
+	rpcClient := bc.Current()
+	if rpcClient == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Warn("Unable to settle history")
+		return
+	}
+
+	// Block at level L settles our bake of L and endorsement of L-1
+	blocks := make(map[int]*rpc.Block)
+
+	settled := 0
+
+	for _, e := range pending {
+
+		blockLevel := e.Level
+		if e.Kind == storage.HISTORY_ENDORSEMENT {
+			blockLevel++
+		}
+
+		if blockLevel > metadataLevel.Level-HISTORY_SETTLE_DEPTH {
+			continue
+		}
+
+		if settled == HISTORY_SETTLE_MAX {
+			break
+		}
+
+		block, ok := blocks[blockLevel]
+		if !ok {
+			blockID := rpc.BlockIDLevel(blockLevel)
+
+			_, block, err = rpcClient.Block(&blockID)
+			if err != nil {
+				log.WithError(err).WithField("Level", blockLevel).Warn("Unable to fetch block to settle history")
+				return
+			}
+
+			blocks[blockLevel] = block
+		}
+
+		settleEntry(&e, block, pkh)
+
+		if err := db.SaveHistoryEntry(e); err != nil {
+			log.WithError(err).Error("Unable to save settled history")
+			return
+		}
+		settled++
+
+		log.WithFields(log.Fields{
+			"Kind": e.Kind, "Level": e.Level, "Status": e.Status, "Rewards": e.Rewards,
+		}).Debug("Settled history")
+	}
+}
+
+// Included, or missed, by block; a bake is in its own block, an endorsement in the next
+func settleEntry(e *storage.HistoryEntry, block *rpc.Block, pkh string) {
+
+	e.Status = storage.HISTORY_MISSED
+
+	switch e.Kind {
+	case storage.HISTORY_BAKE:
+
+		if block.Hash != e.Hash {
+			return
+		}
+
+		e.Timestamp = block.Header.Timestamp
+		e.Rewards, e.Fees, e.Deposits = sumBalanceUpdates(block.Metadata.BalanceUpdates, pkh)
+		e.Status = storage.HISTORY_INCLUDED
+
+	case storage.HISTORY_ENDORSEMENT:
+
+		if len(block.Operations) == 0 {
+			return
+		}
+
+		for _, op := range block.Operations[0] {
+			if op.Hash != e.Hash {
+				continue
+			}
+
+			for _, c := range op.Contents {
+				if c.Metadata == nil {
+					continue
+				}
+
+				rewards, fees, deposits := sumBalanceUpdates(c.Metadata.BalanceUpdates, pkh)
+				e.Rewards += rewards
+				e.Fees += fees
+				e.Deposits += deposits
+				e.Slots = len(c.Metadata.Slots)
+			}
+
+			e.Timestamp = block.Header.Timestamp
+			e.Status = storage.HISTORY_INCLUDED
+
+			return
+		}
+	}
+}
+
+// Frozen rewards, fees and deposits of pkh, in mutez
+func sumBalanceUpdates(updates []rpc.BalanceUpdates, pkh string) (int64, int64, int64) {
+
+	var rewards, fees, deposits int64
+
+	for _, u := range updates {
+
+		if u.Kind != "freezer" || u.Delegate != pkh {
+			continue
+		}
+
+		change, err := strconv.ParseInt(u.Change, 10, 64)
+		if err != nil {
+			log.WithError(err).WithField("Change", u.Change).Warn("Unable to parse balance update")
+			continue
+		}
+
+		switch u.Category {
+		case "rewards":
+			rewards += change
+		case "fees":
+			fees += change
+		case "deposits":
+			deposits += change
+		}
+	}
+
+	return rewards, fees, deposits
+}
+
+// Writes history, of cycles fromCycle to toCycle, as csv or json; all cycles
+// from fromCycle if toCycle is negative
+func exportHistory(db storage.Store, w io.Writer, format string, fromCycle, toCycle int) error {
+
+	if format != "csv" && format != "json" {
+		return errors.Errorf("Unknown export format %q; csv or json", format)
+	}
+
+	history, err := db.GetHistory()
+	if err != nil {
+		return errors.Wrap(err, "Unable to read history")
+	}
+
+	// Moved out of the DB by -history-retention archive
+	if m, ok := db.(storage.Maintainer); ok {
+
+		archived, err := m.GetArchivedHistory()
+		if err != nil {
+			return errors.Wrap(err, "Unable to read archived history")
+		}
+
+		history = storage.MergeArchivedHistory(history, archived)
+	}
+
+	entries := make([]storage.HistoryEntry, 0, len(history))
+
+	for _, e := range history {
+
+		// Only known by level
+		if e.Status == storage.HISTORY_UNKNOWN {
+			e.Cycle = getCycleFromLevel(e.Level)
+		}
+
+		if e.Cycle < fromCycle || (toCycle >= 0 && e.Cycle > toCycle) {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if format == "json" {
+		return json.NewEncoder(w).Encode(entries)
+	}
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{
+		"kind", "level", "cycle", "timestamp", "hash", "priority", "slots", "rewards", "fees", "deposits", "status",
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+
+		var timestamp, priority, slots string
+
+		if !e.Timestamp.IsZero() {
+			timestamp = e.Timestamp.UTC().Format(time.RFC3339)
+		}
+
+		// Priority and slots are not known of entries known only by level
+		switch {
+		case e.Status == storage.HISTORY_UNKNOWN:
+		case e.Kind == storage.HISTORY_BAKE:
+			priority = strconv.Itoa(e.Priority)
+		case e.Kind == storage.HISTORY_ENDORSEMENT:
+			slots = strconv.Itoa(e.Slots)
+		}
+
+		if err := cw.Write([]string{
+			e.Kind, strconv.Itoa(e.Level), strconv.Itoa(e.Cycle), timestamp, e.Hash, priority, slots,
+			strconv.FormatInt(e.Rewards, 10), strconv.FormatInt(e.Fees, 10), strconv.FormatInt(e.Deposits, 10), e.Status,
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// Writes history to file and exits. If BakinBacon is running, history is
+// exported through its API instead.
+func runExport(file, format string, fromCycle, toCycle int) error {
+
+	tmpFile := file + ".tmp"
+
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create export file")
+	}
+	defer os.Remove(tmpFile)
+
+	db, err := storage.InitStorage(*dataDir, network)
+	switch {
+	case errors.Cause(err) == storage.ErrDatabaseInUse:
+
+		log.Info("Database in use; Exporting through the running BakinBacon")
+
+		err = exportFromApi(f, format, fromCycle, toCycle)
+
+	case err == nil:
+		err = exportHistory(db, f, format, fromCycle, toCycle)
+		db.Close()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(os.Rename(tmpFile, file), "Unable to save export file")
+}
+
+func exportFromApi(w io.Writer, format string, fromCycle, toCycle int) error {
+
+	apiUrl := fmt.Sprintf("http://%s:%d/api/history/export?format=%s&from_cycle=%d&to_cycle=%d",
+		*webUiAddr, *webUiPort, format, fromCycle, toCycle)
+
+	resp, err := http.Get(apiUrl)
+	if err != nil {
+		return errors.Wrap(err, "Unable to reach BakinBacon API")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Export through API failed: %s %s", resp.Status, msg)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+
+	return errors.Wrap(err, "Unable to download export")
+}
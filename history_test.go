@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/storage"
+)
+
+func TestSettleEntry(t *testing.T) {
+
+	updates := []rpc.BalanceUpdates{
+		{Kind: "contract", Contract: replayBaker, Change: "-640000000"},
+		{Kind: "freezer", Category: "deposits", Delegate: replayBaker, Change: "640000000"},
+		{Kind: "freezer", Category: "rewards", Delegate: replayBaker, Change: "40000000"},
+		{Kind: "freezer", Category: "fees", Delegate: replayBaker, Change: "1234"},
+		{Kind: "freezer", Category: "rewards", Delegate: "tz1other", Change: "5"},
+	}
+
+	block := &rpc.Block{
+		Hash:   "BLbake",
+		Header: rpc.Header{Timestamp: time.Unix(1629052134, 0).UTC()},
+		Metadata: rpc.Metadata{
+			BalanceUpdates: updates,
+		},
+		Operations: [][]rpc.Operations{{{
+			Hash: "onendorse",
+			Contents: rpc.Contents{{
+				Kind: rpc.ENDORSEMENT_WITH_SLOT,
+				Metadata: &rpc.ContentsMetadata{
+					Delegate:       replayBaker,
+					Slots:          []int{3, 7},
+					BalanceUpdates: updates[:3],
+				},
+			}},
+		}}},
+	}
+
+	bake := storage.HistoryEntry{Kind: storage.HISTORY_BAKE, Hash: "BLbake", Status: storage.HISTORY_PENDING}
+	settleEntry(&bake, block, replayBaker)
+
+	if bake.Status != storage.HISTORY_INCLUDED || bake.Rewards != 40000000 || bake.Fees != 1234 || bake.Deposits != 640000000 || !bake.Timestamp.Equal(block.Header.Timestamp) {
+		t.Errorf("Unexpected settled bake %+v", bake)
+	}
+
+	// Another block at our level
+	orphan := storage.HistoryEntry{Kind: storage.HISTORY_BAKE, Hash: "BLorphan", Status: storage.HISTORY_PENDING}
+	settleEntry(&orphan, block, replayBaker)
+
+	if orphan.Status != storage.HISTORY_MISSED || orphan.Rewards != 0 {
+		t.Errorf("Unexpected settled orphan %+v", orphan)
+	}
+
+	endorsement := storage.HistoryEntry{Kind: storage.HISTORY_ENDORSEMENT, Hash: "onendorse", Status: storage.HISTORY_PENDING}
+	settleEntry(&endorsement, block, replayBaker)
+
+	if endorsement.Status != storage.HISTORY_INCLUDED || endorsement.Rewards != 40000000 || endorsement.Slots != 2 {
+		t.Errorf("Unexpected settled endorsement %+v", endorsement)
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+
+	db := storage.NewMemoryStorage(network)
+
+	// Recorded before history was kept; cycle 80 of granadanet
+	if err := db.RecordEndorsement(323584, "onlegacy"); err != nil {
+		t.Fatalf("Unable to record endorsement: %s", err)
+	}
+
+	for _, e := range []storage.HistoryEntry{
+		{Kind: storage.HISTORY_BAKE, Level: 331391, Cycle: 81, Timestamp: time.Unix(1629052134, 0), Hash: "BLbake", Priority: 1, Rewards: 40000000, Deposits: 640000000, Status: storage.HISTORY_INCLUDED},
+		{Kind: storage.HISTORY_NONCE_REVEAL, Level: 331391, Cycle: 81, Hash: "onreveal", Status: storage.HISTORY_INJECTED},
+		{Kind: storage.HISTORY_ENDORSEMENT, Level: 331776, Cycle: 82, Hash: "onendorse", Slots: 2, Status: storage.HISTORY_PENDING},
+	} {
+		if err := db.SaveHistoryEntry(e); err != nil {
+			t.Fatalf("Unable to save history: %s", err)
+		}
+	}
+
+	var csv bytes.Buffer
+	if err := exportHistory(db, &csv, "csv", 0, -1); err != nil {
+		t.Fatalf("Unable to export csv: %s", err)
+	}
+
+	expected := strings.Join([]string{
+		"kind,level,cycle,timestamp,hash,priority,slots,rewards,fees,deposits,status",
+		"endorsement,323584,80,,onlegacy,,,0,0,0,unknown",
+		"bake,331391,81,2021-08-15T18:28:54Z,BLbake,1,,40000000,0,640000000,included",
+		"nonce_reveal,331391,81,,onreveal,,,0,0,0,injected",
+		"endorsement,331776,82,,onendorse,,2,0,0,0,pending",
+	}, "\n") + "\n"
+
+	if csv.String() != expected {
+		t.Errorf("Unexpected csv:\n%s", csv.String())
+	}
+
+	var out bytes.Buffer
+	if err := exportHistory(db, &out, "json", 81, 81); err != nil {
+		t.Fatalf("Unable to export json: %s", err)
+	}
+
+	var entries []storage.HistoryEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("Unable to decode json: %s", err)
+	}
+
+	if len(entries) != 2 || entries[0].Hash != "BLbake" || entries[1].Kind != storage.HISTORY_NONCE_REVEAL {
+		t.Errorf("Unexpected entries of cycle 81 %+v", entries)
+	}
+
+	if err := exportHistory(db, &out, "xml", 0, -1); err == nil {
+		t.Errorf("Exported unknown format")
+	}
+}
+
+// Bakes and endorsements moved to the archive are still exported
+func TestExportArchivedHistory(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", network)
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	if err := db.RecordBakedBlock(323584, "BLold"); err != nil {
+		t.Fatalf("Unable to record bake: %s", err)
+	}
+
+	if err := db.SaveHistoryEntry(storage.HistoryEntry{
+		Kind: storage.HISTORY_BAKE, Level: 323584, Cycle: 80, Hash: "BLold", Rewards: 40000000, Status: storage.HISTORY_INCLUDED,
+	}); err != nil {
+		t.Fatalf("Unable to save history: %s", err)
+	}
+
+	if err := db.RecordEndorsement(331776, "onrecent"); err != nil {
+		t.Fatalf("Unable to record endorsement: %s", err)
+	}
+
+	if n, err := db.ArchiveHistory(331776); err != nil || n != 1 {
+		t.Fatalf("Expected 1 operation archived; got %d, %v", n, err)
+	}
+
+	var csv bytes.Buffer
+	if err := exportHistory(db, &csv, "csv", 0, -1); err != nil {
+		t.Fatalf("Unable to export csv: %s", err)
+	}
+
+	expected := strings.Join([]string{
+		"kind,level,cycle,timestamp,hash,priority,slots,rewards,fees,deposits,status",
+		"bake,323584,80,,BLold,0,,40000000,0,0,included",
+		"endorsement,331776,82,,onrecent,,,0,0,0,unknown",
+	}, "\n") + "\n"
+
+	if csv.String() != expected {
+		t.Errorf("Unexpected csv:\n%s", csv.String())
+	}
+}
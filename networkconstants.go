@@ -19,8 +19,17 @@ type Constants struct {
 	// Granada changed the simple calculations, so we need to
 	// know the last level before the change. For mainnet,
 	// this happened just before C388 (388 * 4096 - 1)
+
+	// RPC endpoints must be on this chain, and bake one of these protocols.
+	// Add new protocols here once BakinBacon can bake them.
+	ChainID   string
+	Protocols []string
 }
 
+const (
+	PROTOCOL_GRANADA = "PtGRANADsDU8R9daYKAgWnQYAJ64omN1o3KMGVCykShA97vQbvV"
+)
+
 var networkConstants map[string]Constants
 
 func init() {
@@ -31,9 +40,11 @@ func init() {
 	// curl -Ss https://mainnet-tezos.giganode.io/chains/main/blocks/head/context/constants | jq -r '[ (.minimal_block_delay|tonumber), .blocks_per_cycle, .blocks_per_roll_snapshot, .blocks_per_commitment, (.block_security_deposit|tonumber), (.endorsement_security_deposit|tonumber), (.proof_of_work_threshold|tonumber), .initial_endorsers] | @csv'
 	networkConstants[NETWORK_MAINNET] = Constants{
 		30, 8192, 512, 64, 64000000, 2500000, 70368744177663, 192, 1589247, 388,
+		"NetXdQprcVkpaWU", []string{PROTOCOL_GRANADA},
 	}
 
 	networkConstants[NETWORK_GRANADANET] = Constants{
 		15, 4096, 256, 32, 640000000, 2500000, 70368744177663, 192, 4095, 2,
+		"NetXz969SFaFn8k", []string{PROTOCOL_GRANADA},
 	}
 }
@@ -4,9 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bakingbacon/go-tezos/v4/crypto"
 	"github.com/bakingbacon/go-tezos/v4/forge"
@@ -14,13 +14,12 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/baconclient"
 	"bakinbacon/nonce"
 	"bakinbacon/storage"
 	"bakinbacon/util"
 )
 
-var previouslyInjectedErr = regexp.MustCompile(`while applying operation (o[a-zA-Z0-9]{50}).*previously revealed`)
-
 func generateNonce() (nonce.Nonce, error) {
 
 	// Generate a 64 char hexadecimal seed from random 32 bytes
@@ -49,7 +48,7 @@ func generateNonce() (nonce.Nonce, error) {
 	return n, nil
 }
 
-func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
+func revealNonces(ctx context.Context, wg *sync.WaitGroup, db storage.Store, block rpc.Block) {
 
 	// Decrement waitGroup on exit
 	defer wg.Done()
@@ -61,6 +60,14 @@ func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 		}
 	}()
 
+	// Same endpoint throughout, even if it changes meanwhile; reveals are
+	// only preapplied and injected
+	injector := bc.Injector()
+	if injector == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Warn("Not revealing nonces")
+		return
+	}
+
 	// Only reveal in levels 1-16 of cycle
 	cyclePosition := block.Metadata.Level.CyclePosition
 	if cyclePosition == 0 || cyclePosition > 256 {
@@ -70,7 +77,7 @@ func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 	// Get nonces for previous cycle from DB
 	previousCycle := block.Metadata.Level.Cycle - 1
 
-	nonces, err := storage.DB.GetNoncesForCycle(previousCycle)
+	nonces, err := db.GetNoncesForCycle(previousCycle)
 	if err != nil {
 		log.WithError(err).WithField("Cycle", previousCycle).Warn("Unable to get nonces from DB")
 		return
@@ -140,12 +147,12 @@ func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 		}
 
 		// Validate the operation against the node for any errors
-		resp, preApplyResp, err := bc.Current.PreapplyOperations(preapplyNonceRevealOp)
+		resp, preApplyResp, err := injector.PreapplyOperations(preapplyNonceRevealOp)
 		if err != nil {
 
 			// If somehow the nonce reveal was already injected, but we have no record of the opHash,
 			// we can inject it again without worry to discover the opHash and save it
-			if strings.Contains(resp.String(), "nonce.previously_revealed") {
+			if err = baconclient.DecodeRPCError(resp, err); baconclient.IsNoncePreviouslyRevealed(err) {
 
 				log.Warn("Nonce previously injected, unknown opHash.")
 
@@ -181,15 +188,16 @@ func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 			Operation: nonceRevelationBytes,
 		}
 
-		resp, revealOpHash, err := bc.Current.InjectionOperation(injectionInput)
+		resp, revealOpHash, err := injector.InjectionOperation(injectionInput)
 		if err != nil {
 
-			// Check error message for possible previous injection. If notice not present
-			// then we have a real error on our hands. If notice present, let func finish
-			// and save operational hash to DB
-			parts := previouslyInjectedErr.FindStringSubmatch(resp.String())
-			if len(parts) > 0 {
-				revealOpHash = parts[1]
+			// Check error for possible previous injection. If not, then we have a real
+			// error on our hands. If so, let func finish and save operational hash to DB
+			err = baconclient.DecodeRPCError(resp, err)
+
+			rpcErrors, _ := baconclient.RPCErrorsOf(err)
+			if baconclient.IsNoncePreviouslyRevealed(err) && rpcErrors.OperationHash() != "" {
+				revealOpHash = rpcErrors.OperationHash()
 			} else {
 
 				log.WithError(err).WithFields(log.Fields{
@@ -204,8 +212,20 @@ func revealNonces(ctx context.Context, wg *sync.WaitGroup, block rpc.Block) {
 
 		// Update DB with hash of reveal operation
 		nonce.RevealOp = revealOpHash
-		if err := storage.DB.SaveNonce(previousCycle, nonce); err != nil {
+		if err := db.SaveNonce(previousCycle, nonce); err != nil {
 			log.WithError(err).Error("Unable to save nonce reveal to DB")
 		}
+
+		// A reveal has no reward of its own; nothing to settle
+		if err := db.SaveHistoryEntry(storage.HistoryEntry{
+			Kind:      storage.HISTORY_NONCE_REVEAL,
+			Level:     nonce.Level,
+			Cycle:     previousCycle,
+			Timestamp: time.Now().UTC(),
+			Hash:      revealOpHash,
+			Status:    storage.HISTORY_INJECTED,
+		}); err != nil {
+			log.WithError(err).Error("Unable to save nonce reveal to history")
+		}
 	}
 }
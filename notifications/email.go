@@ -14,11 +14,13 @@ type NotifyEmail struct {
 	Smtp_host string `json:"smtphost"`
 	Smtp_port int    `json:"smtpport"`
 	Enabled   bool   `json:"enabled"`
+
+	db storage.Store
 }
 
-func NewEmail(config []byte, saveConfig bool) (*NotifyEmail, error) {
+func NewEmail(db storage.Store, config []byte, saveConfig bool) (*NotifyEmail, error) {
 
-	ne := &NotifyEmail{}
+	ne := &NotifyEmail{db: db}
 	ne.Enabled = true
 
 	return ne, nil
@@ -40,7 +42,7 @@ func (n *NotifyEmail) SaveConfig() error {
 		return errors.Wrap(err, "Unable to marshal email config")
 	}
 
-	if err := storage.DB.SaveNotifiersConfig("email", config); err != nil {
+	if err := n.db.SaveNotifiersConfig("email", config); err != nil {
 		return errors.Wrap(err, "Unable to save email config")
 	}
 
@@ -21,6 +21,9 @@ const (
 	ENDORSE_FAIL
 	VERSION
 	NONCE
+	CONSENSUS_KEY
+	RPC
+	RECOVERY
 )
 
 type Notifier interface {
@@ -31,13 +34,15 @@ type Notifier interface {
 type Notification struct {
 	Notifiers        map[string]Notifier
 	lastSentCategory map[Category]time.Time
+
+	db storage.Store
 }
 
 var N *Notification
 
-func New() error {
+func New(db storage.Store) error {
 
-	N = &Notification{}
+	N = &Notification{db: db}
 	N.Notifiers = make(map[string]Notifier)
 	N.lastSentCategory = make(map[Category]time.Time)
 
@@ -51,7 +56,7 @@ func New() error {
 func (n *Notification) LoadNotifiers() error {
 
 	// Get telegram notifications config from DB, as []byte string
-	tConfig, err := storage.DB.GetNotifiersConfig("telegram")
+	tConfig, err := n.db.GetNotifiersConfig("telegram")
 	if err != nil {
 		return errors.Wrap(err, "Unable to load telegram config")
 	}
@@ -62,7 +67,7 @@ func (n *Notification) LoadNotifiers() error {
 	}
 
 	// Get email notifications config from DB
-	eConfig, err := storage.DB.GetNotifiersConfig("email")
+	eConfig, err := n.db.GetNotifiersConfig("email")
 	if err != nil {
 		return errors.Wrap(err, "Unable to load email config")
 	}
@@ -79,14 +84,14 @@ func (n *Notification) Configure(notifier string, config []byte, saveconfig bool
 
 	switch notifier {
 	case "telegram":
-		nt, err := NewTelegram(config, saveconfig)
+		nt, err := NewTelegram(n.db, config, saveconfig)
 		if err != nil {
 			return err
 		}
 		n.Notifiers["telegram"] = nt
 
 	case "email":
-		ne, err := NewEmail(config, saveconfig)
+		ne, err := NewEmail(n.db, config, saveconfig)
 		if err != nil {
 			return err
 		}
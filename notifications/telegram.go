@@ -19,6 +19,8 @@ type NotifyTelegram struct {
 	ChatIds []int  `json:"chatids"`
 	ApiKey  string `json:"apikey"`
 	Enabled bool   `json:"enabled"`
+
+	db storage.Store
 }
 
 // NewTelegram creates a new NotifyTelegram object using a JSON byte-stream
@@ -28,9 +30,9 @@ type NotifyTelegram struct {
 // If saveConfig is true, save the new object's config to DB. Normally would not
 // do this if we just loaded from DB on app startup, but would want to do this
 // after getting new config from web UI.
-func NewTelegram(config []byte, saveConfig bool) (*NotifyTelegram, error) {
+func NewTelegram(db storage.Store, config []byte, saveConfig bool) (*NotifyTelegram, error) {
 
-	n := &NotifyTelegram{}
+	n := &NotifyTelegram{db: db}
 	n.Enabled = true
 
 	// empty config from db?
@@ -110,7 +112,7 @@ func (n *NotifyTelegram) SaveConfig() error {
 		return errors.Wrap(err, "Unable to marshal telegram config")
 	}
 
-	if err := storage.DB.SaveNotifiersConfig("telegram", config); err != nil {
+	if err := n.db.SaveNotifiersConfig("telegram", config); err != nil {
 		return errors.Wrap(err, "Unable to save telegram config")
 	}
 
@@ -7,16 +7,17 @@ import (
 
 	"github.com/bakingbacon/go-tezos/v4/rpc"
 
+	"bakinbacon/baconclient"
 	"bakinbacon/storage"
 )
 
 // Update BaconStatus with the most recent information from DB. This
 // is done to initialize BaconStatus with values, otherwise status does
 // not update until next bake/endorse.
-func updateRecentBaconStatus() {
+func updateRecentBaconStatus(db storage.Store) {
 
 	// Update baconClient.Status with most recent endorsement
-	recentEndorsementLevel, recentEndorsementHash, err := storage.DB.GetRecentEndorsement()
+	recentEndorsementLevel, recentEndorsementHash, err := db.GetRecentEndorsement()
 	if err != nil {
 		log.WithError(err).Error("Unable to get recent endorsement")
 	}
@@ -24,7 +25,7 @@ func updateRecentBaconStatus() {
 	bc.Status.SetRecentEndorsement(recentEndorsementLevel, getCycleFromLevel(recentEndorsementLevel), recentEndorsementHash)
 
 	// Update baconClient.Status with most recent bake
-	recentBakeLevel, recentBakeHash, err := storage.DB.GetRecentBake()
+	recentBakeLevel, recentBakeHash, err := db.GetRecentBake()
 	if err != nil {
 		log.WithError(err).Error("Unable to get recent bake")
 	}
@@ -33,14 +34,14 @@ func updateRecentBaconStatus() {
 }
 
 // Called on each new block; update BaconStatus with next opportunity for bakes/endorses
-func updateCycleRightsStatus(metadataLevel rpc.Level) {
+func updateCycleRightsStatus(db storage.Store, metadataLevel rpc.Level) {
 
 	nextCycle := metadataLevel.Cycle + 1
 
 	// Update our baconStatus with next endorsement level and next baking right.
 	// If this returns err, it means there was no bucket data which means
 	// we have never fetched current cycle rights and should do so asap
-	nextEndorsingLevel, highestFetchedCycle, err := storage.DB.GetNextEndorsingRight(metadataLevel.Level)
+	nextEndorsingLevel, highestFetchedCycle, err := db.GetNextEndorsingRight(metadataLevel.Level)
 	if err != nil {
 		log.WithError(err).Error("GetNextEndorsingRight")
 	}
@@ -59,19 +60,19 @@ func updateCycleRightsStatus(metadataLevel rpc.Level) {
 		case highestFetchedCycle < metadataLevel.Cycle:
 			log.WithField("Cycle", metadataLevel.Cycle).Info("Fetch Cycle Endorsing Rights")
 
-			go fetchEndorsingRights(metadataLevel, metadataLevel.Cycle)
+			go fetchEndorsingRights(db, metadataLevel, metadataLevel.Cycle)
 
 		case highestFetchedCycle < nextCycle:
 			log.WithField("Cycle", nextCycle).Info("Fetch Next Cycle Endorsing Rights")
 
-			go fetchEndorsingRights(metadataLevel, nextCycle)
+			go fetchEndorsingRights(db, metadataLevel, nextCycle)
 		}
 	}
 
 	//
 	// Next baking right; similar logic to above
 	//
-	nextBakeLevel, nextBakePriority, highestFetchedCycle, err := storage.DB.GetNextBakingRight(metadataLevel.Level)
+	nextBakeLevel, nextBakePriority, highestFetchedCycle, err := db.GetNextBakingRight(metadataLevel.Level)
 	if err != nil {
 		log.WithError(err).Error("GetNextEndorsingRight")
 	}
@@ -89,19 +90,19 @@ func updateCycleRightsStatus(metadataLevel rpc.Level) {
 		case highestFetchedCycle < metadataLevel.Cycle:
 			log.WithField("Cycle", metadataLevel.Cycle).Info("Fetch Cycle Baking Rights")
 
-			go fetchBakingRights(metadataLevel, metadataLevel.Cycle)
+			go fetchBakingRights(db, metadataLevel, metadataLevel.Cycle)
 
 		case highestFetchedCycle < nextCycle:
 			log.WithField("Cycle", nextCycle).Info("Fetch Next Cycle Baking Rights")
 
-			go fetchBakingRights(metadataLevel, nextCycle)
+			go fetchBakingRights(db, metadataLevel, nextCycle)
 		}
 	}
 }
 
 // Called on each new block; Only processes every 1024 blocks
 // Fetches the bake/endorse rights for the next cycle and stores to DB
-func prefetchCycleRights(metadataLevel rpc.Level) {
+func prefetchCycleRights(db storage.Store, metadataLevel rpc.Level) {
 
 	// We only prefetch every 1024 levels
 	if metadataLevel.Level % 1024 != 0 {
@@ -112,11 +113,11 @@ func prefetchCycleRights(metadataLevel rpc.Level) {
 
 	log.WithField("NextCycle", nextCycle).Info("Pre-fetching rights for next cycle")
 
-	go fetchEndorsingRights(metadataLevel, nextCycle)
-	go fetchBakingRights(metadataLevel, nextCycle)
+	go fetchEndorsingRights(db, metadataLevel, nextCycle)
+	go fetchBakingRights(db, metadataLevel, nextCycle)
 }
 
-func fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch int) {
+func fetchEndorsingRights(db storage.Store, metadataLevel rpc.Level, cycleToFetch int) {
 
 	if bc.Signer.BakerPkh == "" {
 		log.Error("Cannot fetch endorsing rights; No baker configured")
@@ -138,6 +139,13 @@ func fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch int) {
 		return
 	}
 
+	// Same endpoint throughout, even if Current changes meanwhile
+	rpcClient := bc.Current()
+	if rpcClient == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Error("Unable to fetch endorsing rights")
+		return
+	}
+
 	// Can't have more rights than blocks per cycle; set the
 	// capacity of the slice to avoid reallocation on append
 	allEndorsingRights := make([]rpc.EndorsingRights, 0, blocksPerCycle)
@@ -153,7 +161,7 @@ func fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch int) {
 			Delegate: bc.Signer.BakerPkh,
 		}
 
-		resp, endorsingRights, err := bc.Current.EndorsingRights(endorsingRightsFilter)
+		resp, endorsingRights, err := rpcClient.EndorsingRights(endorsingRightsFilter)
 		if err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -173,12 +181,12 @@ func fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch int) {
 	}).Debug("Prefetched Endorsing Rights")
 
 	// Save rights to DB, even if len == 0 so that it is noted we queried this cycle
-	if err := storage.DB.SaveEndorsingRightsForCycle(cycleToFetch, allEndorsingRights); err != nil {
+	if err := db.SaveEndorsingRightsForCycle(cycleToFetch, allEndorsingRights); err != nil {
 		log.WithError(err).Error("Unable to save endorsing rights for cycle")
 	}
 }
 
-func fetchBakingRights(metadataLevel rpc.Level, cycleToFetch int) {
+func fetchBakingRights(db storage.Store, metadataLevel rpc.Level, cycleToFetch int) {
 
 	if bc.Signer.BakerPkh == "" {
 		log.Error("Cannot fetch baking rights; No baker configured")
@@ -193,6 +201,13 @@ func fetchBakingRights(metadataLevel rpc.Level, cycleToFetch int) {
 		return
 	}
 
+	// Same endpoint throughout, even if Current changes meanwhile
+	rpcClient := bc.Current()
+	if rpcClient == nil {
+		log.WithError(baconclient.NO_ENDPOINT).Error("Unable to fetch baking rights")
+		return
+	}
+
 	allBakingRights := make([]rpc.BakingRights, 0, blocksPerCycle)
 
 	// Range from start to end, fetch rights per level
@@ -204,7 +219,7 @@ func fetchBakingRights(metadataLevel rpc.Level, cycleToFetch int) {
 			Delegate: bc.Signer.BakerPkh,
 		}
 
-		resp, bakingRights, err := bc.Current.BakingRights(bakingRightsFilter)
+		resp, bakingRights, err := rpcClient.BakingRights(bakingRightsFilter)
 		if err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"Request": resp.Request.URL, "Response": string(resp.Body()),
@@ -227,7 +242,7 @@ func fetchBakingRights(metadataLevel rpc.Level, cycleToFetch int) {
 	}).Info("Prefetched Baking Rights")
 
 	// Save filtered rights to DB, even if len == 0 so that it is noted we queried this cycle
-	if err := storage.DB.SaveBakingRightsForCycle(cycleToFetch, allBakingRights); err != nil {
+	if err := db.SaveBakingRightsForCycle(cycleToFetch, allBakingRights); err != nil {
 		log.WithError(err).Error("Unable to save baking rights for cycle")
 	}
 }
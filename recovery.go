@@ -0,0 +1,216 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+// Set while recoverHistory runs in the background
+var recovering int32
+
+// A DB without watermarks, ie: a new or lost datadir, may belong to a baker which
+// already signed at recent levels. Until the history is recovered from chain, or
+// recovery skipped, nothing is signed.
+func recoveryPending(db storage.Store) bool {
+
+	state, err := db.GetRecoveryState()
+	if err != nil {
+		log.WithError(err).Error("Unable to get recovery state")
+		return true
+	}
+
+	switch state {
+	case storage.RECOVERY_DONE, storage.RECOVERY_SKIPPED:
+		return false
+	case storage.RECOVERY_RUNNING:
+		// Interrupted; the watermarks are those of a partial recovery
+		return true
+	}
+
+	bakingWatermark, _ := db.GetBakingWatermark()
+	endorsingWatermark, _ := db.GetEndorsingWatermark()
+
+	return bakingWatermark == 0 && endorsingWatermark == 0
+}
+
+// Starts recovery from head, unless already running
+func startRecovery(db storage.Store, head rpc.Block, depth int, shutdown <-chan interface{}, wg *sync.WaitGroup) {
+
+	if !atomic.CompareAndSwapInt32(&recovering, 0, 1) {
+		return
+	}
+
+	if err := db.SetRecoveryState(storage.RECOVERY_RUNNING); err != nil {
+		log.WithError(err).Error("Unable to save recovery state")
+		atomic.StoreInt32(&recovering, 0)
+		return
+	}
+
+	log.WithField("Depth", depth).Warn("Database has no watermarks; Recovering history from chain before signing")
+	bc.Status.SetError(errors.New("Recovering history from chain; Signing paused"))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer atomic.StoreInt32(&recovering, 0)
+
+		bakes, endorsements, err := recoverHistory(db, head, depth, shutdown)
+		if err != nil {
+			log.WithError(err).Error("Unable to recover history; Retrying on next block")
+			return
+		}
+
+		if err := db.SetRecoveryState(storage.RECOVERY_DONE); err != nil {
+			log.WithError(err).Error("Unable to save recovery state")
+			return
+		}
+
+		updateRecentBaconStatus(db)
+		bc.Status.ClearError()
+
+		msg := "Recovered history from chain; Signing resumed"
+		log.WithFields(log.Fields{
+			"Bakes": bakes, "Endorsements": endorsements,
+		}).Info(msg)
+
+		notifications.N.Send(msg, notifications.RECOVERY)
+	}()
+}
+
+// Scans depth levels back from head for blocks baked by us, and our endorsements
+// included in blocks, and saves them as history. The watermarks are then raised to
+// at least head; an operation signed just before the DB was lost may not be
+// included yet, so the endorsement of head is given up rather than risked.
+func recoverHistory(db storage.Store, head rpc.Block, depth int, shutdown <-chan interface{}) (int, int, error) {
+
+	pkh := bc.Signer.BakerPkh
+	if pkh == "" {
+		return 0, 0, errors.New("No baker configured")
+	}
+
+	// Same endpoint throughout, even if Current changes meanwhile
+	rpcClient := bc.Current()
+	if rpcClient == nil {
+		return 0, 0, baconclient.NO_ENDPOINT
+	}
+
+	headLevel := head.Header.Level
+
+	lowest := headLevel - depth + 1
+	if lowest < 1 {
+		lowest = 1
+	}
+
+	var bakes, endorsements int
+
+	for level := headLevel; level >= lowest; level-- {
+
+		select {
+		case <-shutdown:
+			return bakes, endorsements, errors.New("Shutting down")
+		default:
+		}
+
+		if (headLevel-level)%256 == 0 {
+			log.WithFields(log.Fields{
+				"Level": level, "Lowest": lowest,
+			}).Info("Recovering history")
+		}
+
+		blockLevel := rpc.BlockIDLevel(level)
+
+		resp, block, err := rpcClient.Block(&blockLevel)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Request": resp.Request.URL, "Response": string(resp.Body()),
+			}).Error("Unable to fetch block")
+
+			return bakes, endorsements, errors.Wrapf(err, "Unable to fetch block %d", level)
+		}
+
+		if block.Metadata.Baker == pkh {
+			if err := db.RecoverBakedBlock(level, block.Hash); err != nil {
+				return bakes, endorsements, errors.Wrap(err, "Unable to save bake")
+			}
+			bakes++
+
+			e := storage.HistoryEntry{
+				Kind:      storage.HISTORY_BAKE,
+				Level:     level,
+				Cycle:     block.Metadata.Level.Cycle,
+				Timestamp: block.Header.Timestamp,
+				Hash:      block.Hash,
+				Priority:  block.Header.Priority,
+				Status:    storage.HISTORY_INCLUDED,
+			}
+			e.Rewards, e.Fees, e.Deposits = sumBalanceUpdates(block.Metadata.BalanceUpdates, pkh)
+
+			if err := db.SaveHistoryEntry(e); err != nil {
+				return bakes, endorsements, errors.Wrap(err, "Unable to save bake to history")
+			}
+
+			log.WithFields(log.Fields{
+				"Level": level, "Hash": block.Hash,
+			}).Info("Recovered bake")
+		}
+
+		// Endorsements of the previous level are in the first validation pass
+		if len(block.Operations) == 0 {
+			continue
+		}
+
+		for _, op := range block.Operations[0] {
+			for _, c := range op.Contents {
+
+				if c.Kind != rpc.ENDORSEMENT_WITH_SLOT || c.Metadata == nil || c.Metadata.Delegate != pkh {
+					continue
+				}
+
+				endorsedLevel := level - 1
+				if c.Endorsement != nil && c.Endorsement.Operations != nil {
+					endorsedLevel = c.Endorsement.Operations.Level
+				}
+
+				if err := db.RecoverEndorsement(endorsedLevel, op.Hash); err != nil {
+					return bakes, endorsements, errors.Wrap(err, "Unable to save endorsement")
+				}
+				endorsements++
+
+				e := storage.HistoryEntry{
+					Kind:      storage.HISTORY_ENDORSEMENT,
+					Level:     endorsedLevel,
+					Cycle:     getCycleFromLevel(endorsedLevel),
+					Timestamp: block.Header.Timestamp,
+					Hash:      op.Hash,
+					Slots:     len(c.Metadata.Slots),
+					Status:    storage.HISTORY_INCLUDED,
+				}
+				e.Rewards, e.Fees, e.Deposits = sumBalanceUpdates(c.Metadata.BalanceUpdates, pkh)
+
+				if err := db.SaveHistoryEntry(e); err != nil {
+					return bakes, endorsements, errors.Wrap(err, "Unable to save endorsement to history")
+				}
+
+				log.WithFields(log.Fields{
+					"Level": endorsedLevel, "Hash": op.Hash,
+				}).Info("Recovered endorsement")
+			}
+		}
+	}
+
+	// Errors only mean the watermark is already higher
+	_ = db.CheckAndSetBakingWatermark(headLevel)
+	_ = db.CheckAndSetEndorsingWatermark(headLevel)
+	_ = db.CheckAndSetPreendorsingWatermark(headLevel)
+
+	return bakes, endorsements, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/baconsigner"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+const (
+	replayBaker = "tz1MTZEJE7YH3wzo8YYiAGd8sgiCTxNRHczR"
+	replayHead  = "BLaFKaVAfXczucuUGTfh7VxrqW41uSmKBbJbv4PUgnETjH9iQmY"
+)
+
+// Fresh in-memory DB with the test wallet as baker, and bc replaying the fixtures of file
+func replayClient(t *testing.T, file string) (*baconclient.ReplayTransport, storage.Store) {
+
+	db := storage.NewMemoryStorage(network)
+
+	if err := db.SetDelegate("edsk3yXukqCQXjCnS4KRKEiotS7wRZPoKuimSJmWnfH2m3a2krJVdf", replayBaker); err != nil {
+		t.Fatalf("Unable to save delegate: %s", err)
+	}
+
+	if err := db.SetSignerType(baconsigner.SIGNER_WALLET); err != nil {
+		t.Fatalf("Unable to save signer type: %s", err)
+	}
+
+	if err := notifications.New(db); err != nil {
+		t.Fatalf("Unable to init notifications: %s", err)
+	}
+
+	signer, err := baconsigner.New(db)
+	if err != nil {
+		t.Fatalf("Unable to init signer: %s", err)
+	}
+
+	if err := signer.LoadDelegate(true); err != nil {
+		t.Fatalf("Unable to load delegate: %s", err)
+	}
+
+	replay, err := baconclient.LoadFixtures(file)
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	bc = baconclient.NewReplay(replay, signer)
+
+	t.Cleanup(func() {
+		if misses := replay.Misses(); len(misses) > 0 {
+			t.Errorf("Requests without fixture: %v", misses)
+		}
+	})
+
+	return replay, db
+}
+
+// Head on which the fixtures bake and endorse
+func replayBlock() rpc.Block {
+	return rpc.Block{
+		Protocol: PROTOCOL_GRANADA,
+		ChainID:  networkConstants[network].ChainID,
+		Hash:     replayHead,
+		Header:   rpc.Header{Level: 331390},
+		Metadata: rpc.Metadata{Level: rpc.Level{Level: 331390, Cycle: 81, CyclePosition: 3709}},
+	}
+}
+
+func TestReplayBake(t *testing.T) {
+
+	replay, db := replayClient(t, "testdata/bake-granadanet.jsonl")
+
+	mempoolInterval = 0
+
+	dryRun := true
+	dryRunBake = &dryRun
+
+	block := replayBlock()
+
+	bake := func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		handleBake(context.Background(), &wg, db, block)
+		wg.Wait()
+	}
+
+	preapply := "/chains/main/blocks/" + replayHead + "/helpers/preapply/block?sort=true&timestamp=1629052134"
+
+	// Dry-run; everything up to injection
+	bake()
+
+	if replay.Served("POST", preapply) != 1 || replay.Served("POST", "/injection/block") != 0 {
+		t.Fatalf("Dry-run bake did not stop before injection")
+	}
+
+	if watermark, _ := db.GetBakingWatermark(); watermark != 0 {
+		t.Errorf("Watermark %d raised by dry-run", watermark)
+	}
+
+	dryRun = false
+	bake()
+
+	if replay.Served("POST", "/injection/block") != 1 {
+		t.Fatalf("Block not injected")
+	}
+
+	if watermark, _ := db.GetBakingWatermark(); watermark != 331391 {
+		t.Errorf("Watermark %d; expected 331391", watermark)
+	}
+
+	if level, hash, _ := db.GetRecentBake(); level != 331391 || hash != "BLxExq4QmHSkc7gBM3CmzSNjZ3vF5zhWBMCKCgnpvdeRAtjS4Xp" {
+		t.Errorf("Unexpected recent bake %d %s", level, hash)
+	}
+
+	// Settled from chain later
+	if pending, _ := db.GetPendingHistory(0); len(pending) != 1 || pending[0].Hash != "BLxExq4QmHSkc7gBM3CmzSNjZ3vF5zhWBMCKCgnpvdeRAtjS4Xp" || pending[0].Cycle != 81 {
+		t.Errorf("Unexpected pending history %+v", pending)
+	}
+
+	// Same level again is refused by the watermark, before any request; rights were
+	// fetched twice, by the dry-run and the bake above, and not a third time
+	rights := "/chains/main/blocks/" + replayHead + "/helpers/baking_rights?delegate=" + replayBaker + "&level=331391&max_priority=4"
+
+	bake()
+
+	if n := replay.Served("GET", rights); n != 2 {
+		t.Errorf("Baking rights fetched %d times; expected 2", n)
+	}
+
+	if replay.Served("POST", "/injection/block") != 1 {
+		t.Errorf("Block injected twice at the same level")
+	}
+}
+
+func TestReplayPrefetch(t *testing.T) {
+
+	_, db := replayClient(t, "testdata/prefetch-granadanet.jsonl")
+
+	// Last levels of cycle 81, which ends at 331776
+	metadataLevel := rpc.Level{Level: 331772, Cycle: 81, CyclePosition: 4091}
+
+	fetchEndorsingRights(db, metadataLevel, 81)
+	fetchBakingRights(db, metadataLevel, 81)
+
+	if level, cycle, err := db.GetNextEndorsingRight(331772); err != nil || level != 331773 || cycle != 81 {
+		t.Errorf("Unexpected next endorsing right %d, cycle %d: %v", level, cycle, err)
+	}
+
+	if level, _, _ := db.GetNextEndorsingRight(331773); level != 331775 {
+		t.Errorf("Unexpected endorsing right after 331773: %d", level)
+	}
+
+	if level, priority, cycle, err := db.GetNextBakingRight(331772); err != nil || level != 331774 || priority != 2 || cycle != 81 {
+		t.Errorf("Unexpected next baking right %d, priority %d, cycle %d: %v", level, priority, cycle, err)
+	}
+
+	// Priority above MAX_BAKE_PRIORITY is not saved
+	if level, _, _, _ := db.GetNextBakingRight(331774); level != 0 {
+		t.Errorf("Saved baking right at %d above max priority", level)
+	}
+}
+
+func TestReplayRecovery(t *testing.T) {
+
+	replay, db := replayClient(t, "testdata/recover-granadanet.jsonl")
+
+	if !recoveryPending(db) {
+		t.Fatalf("Recovery not pending on empty database")
+	}
+
+	var wg sync.WaitGroup
+
+	// Last three levels; our bake at 331389 and endorsement of 331389
+	startRecovery(db, replayBlock(), 3, make(chan interface{}), &wg)
+	wg.Wait()
+
+	if replay.Served("GET", "/chains/main/blocks/331388") != 1 || replay.Served("GET", "/chains/main/blocks/331387") != 0 {
+		t.Errorf("Levels not scanned to depth 3")
+	}
+
+	if state, _ := db.GetRecoveryState(); state != storage.RECOVERY_DONE || recoveryPending(db) {
+		t.Errorf("Unexpected recovery state %q", state)
+	}
+
+	if level, hash, _ := db.GetRecentBake(); level != 331389 || hash != "BMbhb6MqGzqcoq9kr7KEDJXakAYZMTJzhrrxgDGDUNNvC7HdpkM" {
+		t.Errorf("Unexpected recovered bake %d %s", level, hash)
+	}
+
+	if level, hash, _ := db.GetRecentEndorsement(); level != 331389 || hash != "onvBHaZ5Xkq4qbJ2fR3Us5eLmsKSzRYB8BXvWhP4kpT9HwbUDyN" {
+		t.Errorf("Unexpected recovered endorsement %d %s", level, hash)
+	}
+
+	// With details from chain, for export
+	history, _ := db.GetHistory()
+	if len(history) != 2 || history[0].Kind != storage.HISTORY_BAKE || history[0].Status != storage.HISTORY_INCLUDED || history[0].Cycle != 81 || history[0].Timestamp.IsZero() {
+		t.Errorf("Unexpected recovered history %+v", history)
+	}
+
+	// Raised to head; head itself is not endorsed again
+	bakingWatermark, _ := db.GetBakingWatermark()
+	endorsingWatermark, _ := db.GetEndorsingWatermark()
+
+	if bakingWatermark != 331390 || endorsingWatermark != 331390 {
+		t.Errorf("Unexpected watermarks %d, %d; expected head 331390", bakingWatermark, endorsingWatermark)
+	}
+
+	// Interrupted recovery is pending again, despite its watermarks
+	db.SetRecoveryState(storage.RECOVERY_RUNNING)
+	if !recoveryPending(db) {
+		t.Errorf("Interrupted recovery not pending")
+	}
+}
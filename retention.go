@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+// Prunes the DB every interval, per storage retention, and compacts it when
+// more than half of it is free pages
+func runPruning(db storage.Store, interval time.Duration, shutdown <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pruneDatabase(db)
+		case <-shutdown:
+			return
+		}
+	}
+}
+
+func pruneDatabase(db storage.Store) {
+
+	status := bc.Status.Snapshot()
+	if status.Level == 0 {
+		// No head yet
+		return
+	}
+
+	retention := storage.GetRetention()
+	blocksPerCycle := networkConstants[network].BlocksPerCycle
+
+	// First level of the current cycle
+	cycleStart := status.Level - status.CyclePosition
+
+	rights, err := db.PruneRights(cycleStart - retention.RightsCycles*blocksPerCycle)
+	if err != nil {
+		log.WithError(err).Error("Unable to prune rights")
+	}
+
+	// Nonces of a cycle are revealed in the next one
+	nonces, err := db.PruneNonces(status.Cycle - 1 - retention.NonceCycles)
+	if err != nil {
+		log.WithError(err).Error("Unable to prune nonces")
+	}
+
+	// Archiving and compaction are only for stores kept in a file
+	m, isFile := db.(storage.Maintainer)
+
+	var archived int
+	if retention.ArchiveHistory && isFile {
+		archived, err = m.ArchiveHistory(cycleStart - retention.HistoryCycles*blocksPerCycle)
+		if err != nil {
+			log.WithError(err).Error("Unable to archive history")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Rights": rights, "Nonces": nonces, "Archived": archived,
+	}).Info("Pruned database")
+
+	if !isFile {
+		return
+	}
+
+	size, free, err := m.GetDatabaseSize()
+	if err != nil {
+		log.WithError(err).Error("Unable to get database size")
+		return
+	}
+
+	if free*2 < size {
+		return
+	}
+
+	before, after, err := m.Compact()
+	if err != nil {
+		log.WithError(err).Error("Unable to compact database")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Before": before, "After": after,
+	}).Info("Compacted database")
+}
@@ -0,0 +1,447 @@
+package signerserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/storage"
+)
+
+const (
+	MAGIC_BLOCK       byte = 0x01
+	MAGIC_ENDORSEMENT byte = 0x02
+	MAGIC_GENERIC     byte = 0x03
+
+	// Tenderbake
+	MAGIC_TB_BLOCK       byte = 0x11
+	MAGIC_PREENDORSEMENT byte = 0x12
+	MAGIC_TB_ENDORSEMENT byte = 0x13
+
+	// Prefix of the message signed by clients when authentication is required
+	MAGIC_AUTH byte = 0x04
+)
+
+type Config struct {
+	BindAddr           string
+	BindPort           int
+	MagicBytes         []byte
+	CheckHighWatermark bool
+	AuthorizedKeys     []string
+
+	// Nothing is signed while history and watermarks are being recovered from chain
+	RecoveryPending func() bool
+}
+
+var (
+	// Global vars for the signerserver package
+	httpSvr *http.Server
+	signer  *baconsigner.BaconSigner
+	db      storage.Store
+	config  Config
+
+	// Only one signature at a time so the watermark check and update are atomic
+	signLock sync.Mutex
+)
+
+// A request which is not signed by choice, such as one below the watermark, as opposed
+// to the signer failing
+type refusal struct {
+	error
+}
+
+type signerErrorMsg struct {
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+	Msg  string `json:"msg"`
+}
+
+// Start launches an octez-compatible remote signer backed by the loaded BaconSigner
+func Start(_signer *baconsigner.BaconSigner, _db storage.Store, _config Config, shutdownChannel <-chan interface{}, wg *sync.WaitGroup) error {
+
+	// Set the package globals
+	signer = _signer
+	db = _db
+	config = _config
+
+	httpAddr := fmt.Sprintf("%s:%d", config.BindAddr, config.BindPort)
+	httpSvr = &http.Server{
+		Handler:      newRouter(),
+		Addr:         httpAddr,
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+
+	log.WithFields(log.Fields{
+		"Addr": httpAddr, "MagicBytes": hex.EncodeToString(config.MagicBytes),
+		"CheckHWM": config.CheckHighWatermark, "AuthorizedKeys": len(config.AuthorizedKeys),
+	}).Info("Bakin'Bacon Remote Signer Listening")
+
+	// Launch signer in background
+	go func() {
+		if err := httpSvr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Errorf("Signer: ListenAndServe()")
+		}
+
+		log.Info("Signer: Shutdown")
+	}()
+
+	// Wait for shutdown signal on channel
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-shutdownChannel
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := httpSvr.Shutdown(ctx); err != nil {
+			log.WithError(err).Errorf("Signer: Shutdown()")
+		}
+	}()
+
+	return nil
+}
+
+// Routes of the octez remote signer protocol
+func newRouter() *mux.Router {
+
+	router := mux.NewRouter()
+	router.HandleFunc("/authorized_keys", getAuthorizedKeys).Methods("GET")
+	router.HandleFunc("/keys/{pkh}", getPublicKey).Methods("GET")
+	router.HandleFunc("/keys/{pkh}", signRequest).Methods("POST")
+
+	return router
+}
+
+// Returns the list of authorized key hashes; empty object if authentication is not required
+func getAuthorizedKeys(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("Signer - getAuthorizedKeys")
+
+	if len(config.AuthorizedKeys) == 0 {
+		signerReturn(w, map[string]interface{}{})
+		return
+	}
+
+	pkhs := make([]string, 0, len(config.AuthorizedKeys))
+
+	for _, pk := range config.AuthorizedKeys {
+		pkh, err := baconsigner.PublicKeyHash(pk)
+		if err != nil {
+			log.WithError(err).WithField("PK", pk).Error("Invalid authorized key")
+			continue
+		}
+		pkhs = append(pkhs, pkh)
+	}
+
+	signerReturn(w, map[string][]string{
+		"authorized_keys": pkhs,
+	})
+}
+
+// Returns the public key of the requested pkh, if it is the key we manage
+func getPublicKey(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("Signer - getPublicKey")
+
+	pk, pkh, err := signer.GetPublicKey()
+	if err != nil {
+		signerError(errors.Wrap(err, "Unable to get public key"), http.StatusInternalServerError, w)
+		return
+	}
+
+	if mux.Vars(r)["pkh"] != pkh {
+		signerError(errors.New("Unknown key"), http.StatusNotFound, w)
+		return
+	}
+
+	signerReturn(w, map[string]string{
+		"public_key": pk,
+	})
+}
+
+// Signs the hex-encoded bytes in the body after checking authentication, magic byte and watermark
+func signRequest(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("Signer - signRequest")
+
+	reqPkh := mux.Vars(r)["pkh"]
+
+	// Body is a JSON string of hex bytes
+	var dataHex string
+	if err := json.NewDecoder(r.Body).Decode(&dataHex); err != nil {
+		signerError(errors.Wrap(err, "Cannot decode body"), http.StatusBadRequest, w)
+		return
+	}
+
+	data, err := hex.DecodeString(dataHex)
+	if err != nil || len(data) == 0 {
+		signerError(errors.New("Invalid hex bytes"), http.StatusBadRequest, w)
+		return
+	}
+
+	level, signature, err := signData(reqPkh, data, r.URL.Query().Get("authentication"))
+
+	// Signed and policy-denied requests are also in the signing audit log
+	logger := log.WithFields(log.Fields{
+		"Remote": r.RemoteAddr, "Magic": fmt.Sprintf("0x%02x", data[0]), "Level": level,
+	})
+
+	if err != nil {
+
+		status := http.StatusInternalServerError
+
+		switch errors.Cause(err).(type) {
+		case refusal, *baconsigner.PolicyError:
+			status = http.StatusForbidden
+		}
+
+		logger.WithError(err).WithField("Status", status).Warn("Remote Signer Request Denied")
+
+		signerError(err, status, w)
+		return
+	}
+
+	logger.Info("Remote Signer Request Signed")
+
+	signerReturn(w, map[string]string{
+		"signature": signature,
+	})
+}
+
+// Applies all policy checks and signs; returns the level (if known) and b58 signature
+func signData(reqPkh string, data []byte, authentication string) (int, string, error) {
+
+	_, pkh, err := signer.GetPublicKey()
+	if err != nil {
+		return 0, "", errors.Wrap(err, "Unable to get public key")
+	}
+
+	if reqPkh != pkh {
+		return 0, "", refusal{errors.New("Unknown key")}
+	}
+
+	if err := checkAuthentication(reqPkh, data, authentication); err != nil {
+		return 0, "", refusal{err}
+	}
+
+	if !allowedMagicByte(data[0]) {
+		return 0, "", refusal{errors.Errorf("Magic byte 0x%02x not allowed", data[0])}
+	}
+
+	// Watermarks of an empty DB are behind what was signed before it was lost
+	if config.RecoveryPending != nil && config.RecoveryPending() {
+		return 0, "", refusal{errors.New("Recovering history from chain; Not signing until watermarks are restored")}
+	}
+
+	signLock.Lock()
+	defer signLock.Unlock()
+
+	level, err := levelFromData(data)
+	if err != nil {
+		return 0, "", refusal{err}
+	}
+
+	// Check the shared high watermark before signing
+	if config.CheckHighWatermark && level > 0 {
+
+		watermark, err := getWatermark(data[0])
+		if err != nil {
+			return level, "", errors.Wrap(err, "Unable to get watermark")
+		}
+
+		if watermark >= level {
+			return level, "", refusal{errors.Errorf("Level %d is not above high watermark %d", level, watermark)}
+		}
+	}
+
+	signature, err := signer.SignRawBytes(data)
+	if err != nil {
+		return level, "", errors.Wrap(err, "Failed to sign bytes")
+	}
+
+	// Raise the shared watermark so neither baker signs this level again
+	if config.CheckHighWatermark && level > 0 {
+		if err := setWatermark(data[0], level); err != nil {
+			return level, "", errors.Wrap(err, "Unable to record watermark")
+		}
+	}
+
+	return level, signature, nil
+}
+
+// When authorized keys are configured, the client must sign 0x04 || pkh || data with one of them
+func checkAuthentication(reqPkh string, data []byte, authentication string) error {
+
+	if len(config.AuthorizedKeys) == 0 {
+		return nil
+	}
+
+	if authentication == "" {
+		return errors.New("Missing authentication signature")
+	}
+
+	pkhBytes, err := baconsigner.PkhBytes(reqPkh)
+	if err != nil {
+		return err
+	}
+
+	authMsg := append([]byte{MAGIC_AUTH}, pkhBytes...)
+	authMsg = append(authMsg, data...)
+
+	for _, pk := range config.AuthorizedKeys {
+		if err := baconsigner.VerifySignature(pk, authentication, authMsg); err == nil {
+			return nil
+		}
+	}
+
+	return errors.New("Invalid authentication signature")
+}
+
+func allowedMagicByte(m byte) bool {
+	for _, b := range config.MagicBytes {
+		if b == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Extracts the level from block headers, preendorsements and endorsements; 0 for all other operations.
+// Tenderbake rounds are not tracked, so a second block or endorsement at the same level is refused.
+func levelFromData(data []byte) (int, error) {
+
+	switch data[0] {
+	case MAGIC_BLOCK, MAGIC_TB_BLOCK:
+
+		// magic(1) + chain_id(4) + level(4)
+		if len(data) < 9 {
+			return 0, errors.New("Block header too short")
+		}
+
+		return int(binary.BigEndian.Uint32(data[5:9])), nil
+
+	case MAGIC_ENDORSEMENT:
+
+		// magic(1) + chain_id(4) + branch(32) + tag(1) + level(4)
+		if len(data) < 42 || data[37] != 0 {
+			return 0, errors.New("Unknown endorsement encoding")
+		}
+
+		return int(binary.BigEndian.Uint32(data[38:42])), nil
+
+	case MAGIC_PREENDORSEMENT, MAGIC_TB_ENDORSEMENT:
+
+		// magic(1) + chain_id(4) + branch(32) + tag(1) + slot(2) + level(4)
+		if len(data) < 44 || (data[37] != 20 && data[37] != 21) {
+			return 0, errors.New("Unknown endorsement encoding")
+		}
+
+		return int(binary.BigEndian.Uint32(data[40:44])), nil
+	}
+
+	return 0, nil
+}
+
+func getWatermark(magic byte) (int, error) {
+	switch magic {
+	case MAGIC_BLOCK, MAGIC_TB_BLOCK:
+		return db.GetBakingWatermark()
+	case MAGIC_PREENDORSEMENT:
+		return db.GetPreendorsingWatermark()
+	}
+	return db.GetEndorsingWatermark()
+}
+
+func setWatermark(magic byte, level int) error {
+	switch magic {
+	case MAGIC_BLOCK, MAGIC_TB_BLOCK:
+		return db.CheckAndSetBakingWatermark(level)
+	case MAGIC_PREENDORSEMENT:
+		return db.CheckAndSetPreendorsingWatermark(level)
+	}
+	return db.CheckAndSetEndorsingWatermark(level)
+}
+
+// ParseMagicBytes parses a comma separated list such as "0x01,0x02"
+func ParseMagicBytes(s string) ([]byte, error) {
+
+	var magicBytes []byte
+
+	for _, m := range strings.Split(s, ",") {
+
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+
+		b, err := strconv.ParseUint(strings.TrimPrefix(m, "0x"), 16, 8)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid magic byte %s", m)
+		}
+
+		magicBytes = append(magicBytes, byte(b))
+	}
+
+	return magicBytes, nil
+}
+
+// LoadAuthorizedKeys reads public keys, one per line, from a file. Lines starting with # are ignored.
+func LoadAuthorizedKeys(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open authorized keys")
+	}
+	defer f.Close()
+
+	var keys []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Sanity check the key
+		if _, err := baconsigner.PublicKeyHash(line); err != nil {
+			return nil, errors.Wrapf(err, "Invalid authorized key %s", line)
+		}
+
+		keys = append(keys, line)
+	}
+
+	return keys, scanner.Err()
+}
+
+func signerError(err error, status int, w http.ResponseWriter) {
+	e, _ := json.Marshal([]signerErrorMsg{{"temporary", "failure", err.Error()}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(e)
+}
+
+func signerReturn(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Error("Signer Return Encode Failure")
+	}
+}
@@ -0,0 +1,335 @@
+package signerserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/forge"
+	gtks "github.com/bakingbacon/go-tezos/v4/keys"
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/pkg/errors"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/storage"
+)
+
+const (
+	testSk  = "edsk4FTF78Qf1m2rykGpHqostAiq5gYW4YZEoGUSWBTJr2njsDHSnd"
+	testPk  = "edpkv45regue1bWtuHnCgLU8xWKLwa9qRqv4gimgJKro4LSc3C5VjV"
+	testPkh = "tz1LggX2HUdvJ1tF4Fvv8fjsrzLeW4Jr9t2Q"
+)
+
+// Serves the signer, backed by a software wallet of testSk, with the given config
+func testServer(t *testing.T, c Config) *httptest.Server {
+
+	mem := storage.NewMemoryStorage("granadanet")
+
+	if err := mem.SetDelegate(testSk, testPkh); err != nil {
+		t.Fatalf("Unable to set delegate: %s", err)
+	}
+
+	if err := mem.SetSignerType(baconsigner.SIGNER_WALLET); err != nil {
+		t.Fatalf("Unable to set signer type: %s", err)
+	}
+
+	s, err := baconsigner.New(mem)
+	if err != nil {
+		t.Fatalf("Unable to init signer: %s", err)
+	}
+
+	signer, db, config = s, mem, c
+
+	srv := httptest.NewServer(newRouter())
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// Posts the bytes for signing, with the authentication signature if not empty; returns the status and body
+func postSign(t *testing.T, srv *httptest.Server, data []byte, authentication string) (int, string) {
+
+	body, _ := json.Marshal(hex.EncodeToString(data))
+
+	signUrl := srv.URL + "/keys/" + testPkh
+	if authentication != "" {
+		signUrl += "?authentication=" + url.QueryEscape(authentication)
+	}
+
+	resp, err := http.Post(signUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unable to post: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var out bytes.Buffer
+	_, _ = out.ReadFrom(resp.Body)
+
+	return resp.StatusCode, out.String()
+}
+
+func TestSignPolicy(t *testing.T) {
+
+	srv := testServer(t, Config{MagicBytes: []byte{MAGIC_BLOCK, MAGIC_ENDORSEMENT, MAGIC_GENERIC}})
+
+	branch := baconsigner.B58cencode(make([]byte, 32), []byte{1, 52})
+
+	opHex, err := forge.Encode(branch, rpc.Content{
+		Kind: rpc.TRANSACTION, Source: testPkh, Fee: "1420", Counter: "10", GasLimit: "10600", StorageLimit: "300",
+		Amount: "1000000", Destination: "tz2VN9n2C56xGLykHCjhNvZQqUeTVisrHjxA",
+	})
+	if err != nil {
+		t.Fatalf("Unable to forge transaction: %s", err)
+	}
+
+	opBytes, _ := hex.DecodeString(opHex)
+
+	// Generic operations pass the magic byte check; the signing policy still refuses transactions
+	status, body := postSign(t, srv, append([]byte{MAGIC_GENERIC}, opBytes...), "")
+	if status != http.StatusForbidden || !bytes.Contains([]byte(body), []byte("Policy: Operation kind transaction not allowed")) {
+		t.Errorf("Transaction not refused: %d %s", status, body)
+	}
+}
+
+func blockBytes(level uint32) []byte {
+	b := []byte{MAGIC_BLOCK, 0x7a, 0x06, 0xa7, 0x70, 0, 0, 0, 0, 0x01, 0x02}
+	binary.BigEndian.PutUint32(b[5:9], level)
+	return b
+}
+
+func endorsementBytes(level uint32) []byte {
+	b := append([]byte{MAGIC_ENDORSEMENT, 0x7a, 0x06, 0xa7, 0x70}, make([]byte, 32)...)
+	b = append(b, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[38:42], level)
+	return b
+}
+
+// Tenderbake preendorsement (tag 20) or endorsement (tag 21); slot, level, round, block payload hash
+func tenderbakeBytes(magic, tag byte, level uint32) []byte {
+	b := append([]byte{magic, 0x7a, 0x06, 0xa7, 0x70}, make([]byte, 32)...)
+	b = append(b, tag, 0, 0)
+	b = append(b, make([]byte, 4+4+32)...)
+	binary.BigEndian.PutUint32(b[40:44], level)
+	return b
+}
+
+func TestAllowedMagicByte(t *testing.T) {
+
+	config = Config{MagicBytes: []byte{MAGIC_BLOCK, MAGIC_ENDORSEMENT}}
+
+	for m, allowed := range map[byte]bool{
+		MAGIC_BLOCK:       true,
+		MAGIC_ENDORSEMENT: true,
+		MAGIC_GENERIC:     false,
+		MAGIC_AUTH:        false,
+		0x00:              false,
+	} {
+		if allowedMagicByte(m) != allowed {
+			t.Errorf("Magic byte 0x%02x allowed is %v, expected %v", m, !allowed, allowed)
+		}
+	}
+
+	srv := testServer(t, Config{MagicBytes: []byte{MAGIC_ENDORSEMENT}})
+
+	if status, body := postSign(t, srv, blockBytes(100), ""); status != http.StatusForbidden {
+		t.Errorf("Block signed without its magic byte allowed: %d %s", status, body)
+	}
+}
+
+func TestLevelFromData(t *testing.T) {
+
+	badTag := endorsementBytes(50)
+	badTag[37] = 0x01
+
+	tests := []struct {
+		name  string
+		data  []byte
+		level int
+		err   bool
+	}{
+		{"block", blockBytes(4242), 4242, false},
+		{"short block", blockBytes(4242)[:8], 0, true},
+		{"magic only", []byte{MAGIC_BLOCK}, 0, true},
+		{"endorsement", endorsementBytes(4242), 4242, false},
+		{"short endorsement", endorsementBytes(4242)[:41], 0, true},
+		{"unknown endorsement tag", badTag, 0, true},
+		{"tenderbake block", append([]byte{MAGIC_TB_BLOCK}, blockBytes(4242)[1:]...), 4242, false},
+		{"preendorsement", tenderbakeBytes(MAGIC_PREENDORSEMENT, 20, 4242), 4242, false},
+		{"tenderbake endorsement", tenderbakeBytes(MAGIC_TB_ENDORSEMENT, 21, 4242), 4242, false},
+		{"short tenderbake endorsement", tenderbakeBytes(MAGIC_TB_ENDORSEMENT, 21, 4242)[:43], 0, true},
+		{"unknown tenderbake tag", tenderbakeBytes(MAGIC_TB_ENDORSEMENT, 0, 4242), 0, true},
+		{"generic", []byte{MAGIC_GENERIC}, 0, false},
+	}
+
+	for _, tt := range tests {
+		level, err := levelFromData(tt.data)
+		if level != tt.level || (err != nil) != tt.err {
+			t.Errorf("%s: level %d, error %v", tt.name, level, err)
+		}
+	}
+}
+
+func TestSignHighWatermark(t *testing.T) {
+
+	srv := testServer(t, Config{MagicBytes: []byte{MAGIC_BLOCK, MAGIC_ENDORSEMENT}, CheckHighWatermark: true})
+
+	if err := db.CheckAndSetBakingWatermark(100); err != nil {
+		t.Fatalf("Unable to set watermark: %s", err)
+	}
+
+	// At and below the watermark
+	for _, level := range []uint32{100, 99} {
+		if status, body := postSign(t, srv, blockBytes(level), ""); status != http.StatusForbidden {
+			t.Errorf("Block %d signed at watermark 100: %d %s", level, status, body)
+		}
+	}
+
+	status, body := postSign(t, srv, blockBytes(101), "")
+	if status != http.StatusOK {
+		t.Fatalf("Block 101 not signed: %d %s", status, body)
+	}
+
+	var out struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("Unable to decode signature: %s", err)
+	}
+
+	if err := baconsigner.VerifySignature(testPk, out.Signature, blockBytes(101)); err != nil {
+		t.Errorf("Invalid signature of block 101: %s", err)
+	}
+
+	if watermark, _ := db.GetBakingWatermark(); watermark != 101 {
+		t.Errorf("Baking watermark %d, expected 101", watermark)
+	}
+
+	if status, _ := postSign(t, srv, blockBytes(101), ""); status != http.StatusForbidden {
+		t.Errorf("Block 101 signed twice")
+	}
+
+	// Endorsements have their own watermark
+	if status, body := postSign(t, srv, endorsementBytes(101), ""); status != http.StatusOK {
+		t.Fatalf("Endorsement 101 not signed: %d %s", status, body)
+	}
+
+	if watermark, _ := db.GetEndorsingWatermark(); watermark != 101 {
+		t.Errorf("Endorsing watermark %d, expected 101", watermark)
+	}
+}
+
+// Watermarks cannot be read
+type brokenStore struct {
+	*storage.MemoryStorage
+}
+
+func (brokenStore) GetBakingWatermark() (int, error) {
+	return 0, errors.New("DB unavailable")
+}
+
+// Refused while recovery is pending; a signer failure is not a refusal
+func TestSignStatus(t *testing.T) {
+
+	pending := true
+
+	srv := testServer(t, Config{
+		MagicBytes: []byte{MAGIC_BLOCK}, CheckHighWatermark: true,
+		RecoveryPending: func() bool { return pending },
+	})
+
+	status, body := postSign(t, srv, blockBytes(100), "")
+	if status != http.StatusForbidden || !strings.Contains(body, "Recovering history") {
+		t.Errorf("Block signed while recovery pending: %d %s", status, body)
+	}
+
+	pending = false
+
+	if status, body := postSign(t, srv, blockBytes(100), ""); status != http.StatusOK {
+		t.Errorf("Block not signed after recovery: %d %s", status, body)
+	}
+
+	db = brokenStore{db.(*storage.MemoryStorage)}
+
+	if status, body := postSign(t, srv, blockBytes(101), ""); status != http.StatusInternalServerError {
+		t.Errorf("Unexpected status of watermark read error: %d %s", status, body)
+	}
+}
+
+// Tenderbake preendorsement and endorsement of the same level are both signed, once
+func TestSignTenderbakeWatermark(t *testing.T) {
+
+	srv := testServer(t, Config{MagicBytes: []byte{MAGIC_PREENDORSEMENT, MAGIC_TB_ENDORSEMENT}, CheckHighWatermark: true})
+
+	preendorsement := tenderbakeBytes(MAGIC_PREENDORSEMENT, 20, 200)
+	endorsement := tenderbakeBytes(MAGIC_TB_ENDORSEMENT, 21, 200)
+
+	for _, data := range [][]byte{preendorsement, endorsement} {
+		if status, body := postSign(t, srv, data, ""); status != http.StatusOK {
+			t.Errorf("0x%02x at level 200 not signed: %d %s", data[0], status, body)
+		}
+	}
+
+	for _, data := range [][]byte{preendorsement, endorsement} {
+		if status, _ := postSign(t, srv, data, ""); status != http.StatusForbidden {
+			t.Errorf("0x%02x at level 200 signed twice", data[0])
+		}
+	}
+
+	if watermark, _ := db.GetPreendorsingWatermark(); watermark != 200 {
+		t.Errorf("Preendorsing watermark %d, expected 200", watermark)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+
+	client, err := gtks.Generate(gtks.Ed25519)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	other, err := gtks.Generate(gtks.Ed25519)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	srv := testServer(t, Config{MagicBytes: []byte{MAGIC_BLOCK}, AuthorizedKeys: []string{client.PubKey.GetPublicKey()}})
+
+	pkhBytes, err := baconsigner.PkhBytes(testPkh)
+	if err != nil {
+		t.Fatalf("Unable to decode pkh: %s", err)
+	}
+
+	data := blockBytes(100)
+
+	authMsg := append([]byte{MAGIC_AUTH}, pkhBytes...)
+	authMsg = append(authMsg, data...)
+
+	sign := func(k *gtks.Key, msg []byte) string {
+		sig, err := k.SignRawBytes(msg)
+		if err != nil {
+			t.Fatalf("Unable to sign: %s", err)
+		}
+		return sig.ToBase58()
+	}
+
+	for name, authentication := range map[string]string{
+		"missing":       "",
+		"data only":     sign(client, data),
+		"unknown key":   sign(other, authMsg),
+		"not signature": "edsig",
+	} {
+		if status, body := postSign(t, srv, data, authentication); status != http.StatusForbidden {
+			t.Errorf("Signed with %s authentication: %d %s", name, status, body)
+		}
+	}
+
+	if status, body := postSign(t, srv, data, sign(client, authMsg)); status != http.StatusOK {
+		t.Errorf("Not signed with authentication: %d %s", status, body)
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Each entry includes the hash of the previous entry, so any modification
+// or removal of an entry breaks the chain and is detected by VerifyAuditLog.
+type AuditEntry struct {
+	Seq        int       `json:"seq"`
+	Timestamp  time.Time `json:"ts"`
+	Watermark  int       `json:"watermark"`
+	Operations []string  `json:"operations"`
+	BytesHash  string    `json:"bytesHash"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+}
+
+// Hash of the entry, excluding the Hash field itself
+func (e AuditEntry) computeHash() (string, error) {
+
+	e.Hash = ""
+
+	entryBytes, err := json.Marshal(e)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to marshal audit entry")
+	}
+
+	h := sha256.Sum256(entryBytes)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Appends an entry to the audit log; sequence number and hashes are set here
+func (s *Storage) AddAuditEntry(e AuditEntry) error {
+
+	return s.update(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(AUDIT_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate audit bucket")
+		}
+
+		// Link to previous entry
+		if _, v := b.Cursor().Last(); v != nil {
+
+			var prev AuditEntry
+			if err := json.Unmarshal(v, &prev); err != nil {
+				return errors.Wrap(err, "Unable to unmarshal previous audit entry")
+			}
+
+			e.PrevHash = prev.Hash
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "Unable to get audit sequence")
+		}
+
+		e.Seq = int(seq)
+
+		e.Hash, err = e.computeHash()
+		if err != nil {
+			return err
+		}
+
+		entryBytes, err := json.Marshal(e)
+		if err != nil {
+			return errors.Wrap(err, "Unable to marshal audit entry")
+		}
+
+		return b.Put(itob(e.Seq), entryBytes)
+	})
+}
+
+// Returns all entries in the audit log, oldest first
+func (s *Storage) GetAuditEntries() ([]AuditEntry, error) {
+
+	entries := make([]AuditEntry, 0)
+
+	err := s.view(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(AUDIT_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate audit bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return errors.Wrapf(err, "Unable to unmarshal audit entry %d", btoi(k))
+			}
+
+			entries = append(entries, e)
+
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// Checks the hash chain of the audit log. Returns an error describing the first broken entry.
+func VerifyAuditLog(entries []AuditEntry) error {
+
+	prevHash := ""
+
+	for i, e := range entries {
+
+		if i > 0 && e.Seq != entries[i-1].Seq+1 {
+			return errors.Errorf("Audit entry missing after sequence %d", entries[i-1].Seq)
+		}
+
+		if e.PrevHash != prevHash {
+			return errors.Errorf("Audit entry %d does not link to previous entry", e.Seq)
+		}
+
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+
+		if hash != e.Hash {
+			return errors.Errorf("Audit entry %d has been modified", e.Seq)
+		}
+
+		prevHash = e.Hash
+	}
+
+	return nil
+}
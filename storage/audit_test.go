@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+
+	var entries []AuditEntry
+
+	prevHash := ""
+	for i := 1; i <= 3; i++ {
+
+		e := AuditEntry{
+			Seq:        i,
+			Timestamp:  time.Unix(int64(i), 0).UTC(),
+			Watermark:  2,
+			Operations: []string{"endorsement"},
+			Result:     "signed",
+			PrevHash:   prevHash,
+		}
+		e.Hash, _ = e.computeHash()
+		prevHash = e.Hash
+
+		entries = append(entries, e)
+	}
+
+	if err := VerifyAuditLog(entries); err != nil {
+		t.Fatalf("Valid audit log failed verification: %s", err)
+	}
+
+	// Modified entry
+	modified := append([]AuditEntry{}, entries...)
+	modified[1].Result = "denied"
+	if err := VerifyAuditLog(modified); err == nil {
+		t.Errorf("Modified entry not detected")
+	}
+
+	// Removed entry
+	removed := []AuditEntry{entries[0], entries[2]}
+	if err := VerifyAuditLog(removed); err == nil {
+		t.Errorf("Removed entry not detected")
+	}
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/pbkdf2"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Encrypted backups are the DB file in chunks, each sealed with secretbox; the
+// nonce of a chunk is a random prefix and the chunk's sequence, with the high
+// bit set on the last chunk, so chunks cannot be reordered, or dropped from the end.
+// Unencrypted backups are a plain copy of the DB file.
+const (
+	BACKUP_MAGIC      = "BBAKENC1"
+	BACKUP_CHUNK_SIZE = 64 * 1024
+
+	backupSaltLength   = 16
+	backupPrefixLength = 16
+	backupIterations   = 32768
+	backupLastChunk    = uint64(1) << 63
+)
+
+// Returned when another process, ie: a running BakinBacon, has the DB open
+var ErrDatabaseInUse = errors.New("Database is in use")
+
+// Backup writes a consistent snapshot of the DB to w, from a read transaction,
+// so baking carries on meanwhile. The snapshot is encrypted if passphrase is set.
+// Returns the size of the DB.
+//
+// The snapshot is copied to a file in datadir first, and streamed from there. A slow
+// download would otherwise hold the lock, and a Compact waiting for it would hold up
+// every other transaction, watermarks included, until the download finished.
+func (s *Storage) Backup(w io.Writer, passphrase string) (int64, error) {
+
+	snapshot, err := ioutil.TempFile(s.datadir, DATABASE_FILE+".snapshot-")
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to create snapshot")
+	}
+
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
+
+	var size int64
+
+	err = s.view(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		_, err := tx.WriteTo(snapshot)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to write snapshot")
+	}
+
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "Unable to read snapshot")
+	}
+
+	copySnapshot := func(w io.Writer) (int64, error) {
+		return io.Copy(w, snapshot)
+	}
+
+	if err := writeBackup(w, passphrase, copySnapshot); err != nil {
+		return 0, errors.Wrap(err, "Unable to write backup")
+	}
+
+	return size, nil
+}
+
+// BackupFile writes a snapshot of the DB in datadir while BakinBacon is stopped;
+// returns ErrDatabaseInUse if it is running.
+func BackupFile(datadir string, w io.Writer, passphrase string) (int64, error) {
+
+	db, err := bolt.Open(datadir+DATABASE_FILE, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err == bolt.ErrTimeout {
+		return 0, ErrDatabaseInUse
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to open db")
+	}
+	defer db.Close()
+
+	return backupDB(db, w, passphrase)
+}
+
+func backupDB(db *bolt.DB, w io.Writer, passphrase string) (int64, error) {
+
+	var size int64
+
+	err := db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return writeBackup(w, passphrase, tx.WriteTo)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to write backup")
+	}
+
+	return size, nil
+}
+
+// Writes the DB file, from dbWriteTo, to w; encrypted if passphrase is set
+func writeBackup(w io.Writer, passphrase string, dbWriteTo func(io.Writer) (int64, error)) error {
+
+	if passphrase == "" {
+		_, err := dbWriteTo(w)
+		return err
+	}
+
+	ew, err := newEncryptingWriter(w, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dbWriteTo(ew); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+func backupKey(passphrase string, salt []byte) *[32]byte {
+
+	var key [32]byte
+	copy(key[:], pbkdf2.Key([]byte(passphrase), salt, backupIterations, 32, sha512.New))
+
+	return &key
+}
+
+func backupNonce(prefix []byte, seq uint64) *[24]byte {
+
+	var nonce [24]byte
+	copy(nonce[:], prefix)
+	binary.BigEndian.PutUint64(nonce[backupPrefixLength:], seq)
+
+	return &nonce
+}
+
+type encryptingWriter struct {
+	w      io.Writer
+	key    *[32]byte
+	prefix []byte
+	seq    uint64
+	buf    []byte
+}
+
+func newEncryptingWriter(w io.Writer, passphrase string) (*encryptingWriter, error) {
+
+	header := make([]byte, backupSaltLength+backupPrefixLength)
+	if _, err := rand.Read(header); err != nil {
+		return nil, errors.Wrap(err, "Unable to generate salt")
+	}
+
+	if _, err := w.Write(append([]byte(BACKUP_MAGIC), header...)); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		w:      w,
+		key:    backupKey(passphrase, header[:backupSaltLength]),
+		prefix: header[backupSaltLength:],
+		buf:    make([]byte, 0, BACKUP_CHUNK_SIZE),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+
+	n := len(p)
+
+	for len(p) > 0 {
+
+		// A full chunk is only sealed once more data comes; the last one is sealed by Close
+		if len(e.buf) == BACKUP_CHUNK_SIZE {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+
+		c := copy(e.buf[len(e.buf):BACKUP_CHUNK_SIZE], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+	}
+
+	return n, nil
+}
+
+func (e *encryptingWriter) seal(last bool) error {
+
+	seq := e.seq
+	if last {
+		seq |= backupLastChunk
+	}
+
+	sealed := secretbox.Seal(nil, e.buf, backupNonce(e.prefix, seq), e.key)
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+
+	if _, err := e.w.Write(append(length, sealed...)); err != nil {
+		return err
+	}
+
+	e.seq++
+	e.buf = e.buf[:0]
+
+	return nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+// Writes the DB file of a backup, decrypting it if needed, to w
+func readBackup(r io.Reader, w io.Writer, passphrase string) error {
+
+	magic := make([]byte, len(BACKUP_MAGIC))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return errors.Wrap(err, "Unable to read backup")
+	}
+
+	if string(magic) != BACKUP_MAGIC {
+		if passphrase != "" {
+			log.Warn("Backup is not encrypted; Ignoring passphrase")
+		}
+		_, err := io.Copy(w, io.MultiReader(bytes.NewReader(magic), r))
+		return err
+	}
+
+	if passphrase == "" {
+		return errors.New("Backup is encrypted; Passphrase required")
+	}
+
+	header := make([]byte, backupSaltLength+backupPrefixLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.Wrap(err, "Unable to read backup header")
+	}
+
+	key := backupKey(passphrase, header[:backupSaltLength])
+	prefix := header[backupSaltLength:]
+
+	length := make([]byte, 4)
+
+	for seq := uint64(0); ; seq++ {
+
+		if _, err := io.ReadFull(r, length); err != nil {
+			return errors.New("Backup is truncated")
+		}
+
+		n := binary.BigEndian.Uint32(length)
+		if n < secretbox.Overhead || n > BACKUP_CHUNK_SIZE+secretbox.Overhead {
+			return errors.New("Backup is corrupt")
+		}
+
+		sealed := make([]byte, n)
+		if _, err := io.ReadFull(r, sealed); err != nil {
+			return errors.New("Backup is truncated")
+		}
+
+		chunk, ok := secretbox.Open(nil, sealed, backupNonce(prefix, seq), key)
+		last := false
+
+		if !ok {
+			if chunk, ok = secretbox.Open(nil, sealed, backupNonce(prefix, seq|backupLastChunk), key); !ok {
+				return errors.New("Unable to decrypt backup; Wrong passphrase?")
+			}
+			last = true
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// Network, schema version and watermarks of a DB
+type dbSummary struct {
+	network   string
+	version   int
+	baking    int
+	endorsing int
+}
+
+func summarizeDB(db *bolt.DB) (dbSummary, error) {
+
+	var sum dbSummary
+
+	err := db.View(func(tx *bolt.Tx) error {
+
+		cfg := tx.Bucket([]byte(CONFIG_BUCKET))
+		bakes := tx.Bucket([]byte(BAKING_BUCKET))
+		endorses := tx.Bucket([]byte(ENDORSING_BUCKET))
+
+		if cfg == nil || bakes == nil || endorses == nil {
+			return errors.New("Not a BakinBacon database")
+		}
+
+		sum.network = string(cfg.Get([]byte(NETWORK)))
+		sum.version = schemaVersion(tx)
+		sum.baking = int(bakes.Sequence())
+		sum.endorsing = int(endorses.Sequence())
+
+		return nil
+	})
+
+	return sum, err
+}
+
+// Restore replaces the DB in datadir with a backup, while BakinBacon is stopped.
+// The backup must be of the same network and must not have lower watermarks than
+// the current DB, unless forced. The current DB is kept as a .bak file.
+func Restore(datadir, network string, r io.Reader, passphrase string, force bool) error {
+
+	dbFile := datadir + DATABASE_FILE
+	restoreFile := dbFile + ".restore"
+
+	f, err := os.OpenFile(restoreFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create restore file")
+	}
+
+	err = readBackup(r, f, passphrase)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	// Removed on any error; renamed on success
+	defer os.Remove(restoreFile)
+
+	if err != nil {
+		return err
+	}
+
+	restored, err := bolt.Open(restoreFile, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return errors.Wrap(err, "Backup is not a valid database")
+	}
+
+	snapshot, err := summarizeDB(restored)
+	restored.Close()
+
+	if err != nil {
+		return errors.Wrap(err, "Backup is not a valid database")
+	}
+
+	latest := migrations[len(migrations)-1].version
+
+	switch {
+	case snapshot.version > latest:
+		return errors.Errorf("Backup schema version %d is newer than supported version %d", snapshot.version, latest)
+	case snapshot.network == "":
+		// Before the network was recorded; it will be on the next start
+		log.WithField("Network", network).Warn("Backup does not record its network; Assuming it matches")
+	case snapshot.network != network:
+		return errors.Errorf("Backup is for network %s, not %s", snapshot.network, network)
+	}
+
+	// Compare with the current DB, if any
+	if _, err := os.Stat(dbFile); err == nil {
+
+		db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+		if err != nil {
+			if err == bolt.ErrTimeout {
+				return errors.Wrap(ErrDatabaseInUse, "Stop BakinBacon before restoring")
+			}
+			return errors.Wrap(err, "Unable to open current database")
+		}
+
+		current, err := summarizeDB(db)
+		db.Close()
+
+		if err == nil && (snapshot.baking < current.baking || snapshot.endorsing < current.endorsing) {
+
+			fields := log.Fields{
+				"BackupBaking": snapshot.baking, "BackupEndorsing": snapshot.endorsing,
+				"CurrentBaking": current.baking, "CurrentEndorsing": current.endorsing,
+			}
+
+			if !force {
+				log.WithFields(fields).Error("Backup watermarks are older than current")
+				return errors.New("Backup has older watermarks than the current database; Restoring could lead to double signing")
+			}
+
+			log.WithFields(fields).Warn("Restoring older watermarks; Forced")
+		}
+
+		previous := fmt.Sprintf("%s.pre-restore-%s.bak", dbFile, time.Now().UTC().Format("20060102-150405"))
+		if err := os.Rename(dbFile, previous); err != nil {
+			return errors.Wrap(err, "Unable to keep current database")
+		}
+
+		log.WithField("File", previous).Info("Kept current database")
+	}
+
+	if err := os.Rename(restoreFile, dbFile); err != nil {
+		return errors.Wrap(err, "Unable to restore database")
+	}
+
+	log.WithFields(log.Fields{
+		"Baking": snapshot.baking, "Endorsing": snapshot.endorsing, "Version": snapshot.version,
+	}).Info("Database restored")
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestBackupRestore(t *testing.T) {
+
+	datadir := t.TempDir() + "/"
+
+	db, err := InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+
+	// More than a chunk of data
+	if err := db.SaveNotifiersConfig("telegram", bytes.Repeat([]byte("a"), 3*BACKUP_CHUNK_SIZE)); err != nil {
+		t.Fatalf("Unable to save config: %s", err)
+	}
+
+	if err := db.RecordBakedBlock(331391, "BLxExq4QmHSkc7gBM3CmzSNjZ3vF5zhWBMCKCgnpvdeRAtjS4Xp"); err != nil {
+		t.Fatalf("Unable to record bake: %s", err)
+	}
+
+	// While the DB is open
+	var plain, encrypted bytes.Buffer
+
+	if _, err := db.Backup(&plain, ""); err != nil {
+		t.Fatalf("Unable to back up: %s", err)
+	}
+
+	if _, err := db.Backup(&encrypted, "hunter2"); err != nil {
+		t.Fatalf("Unable to back up encrypted: %s", err)
+	}
+
+	if bytes.Contains(encrypted.Bytes(), []byte("aaaaaaaa")) {
+		t.Errorf("Encrypted backup contains plain data")
+	}
+
+	if err := Restore(datadir, "granadanet", bytes.NewReader(plain.Bytes()), "", false); errors.Cause(err) != ErrDatabaseInUse {
+		t.Errorf("Restored over database in use: %v", err)
+	}
+
+	db.Close()
+
+	// Into an empty datadir
+	other := t.TempDir() + "/"
+
+	truncated := encrypted.Bytes()[:encrypted.Len()-BACKUP_CHUNK_SIZE/2]
+
+	for _, bad := range []struct {
+		name       string
+		backup     []byte
+		passphrase string
+		network    string
+	}{
+		{"no passphrase", encrypted.Bytes(), "", "granadanet"},
+		{"wrong passphrase", encrypted.Bytes(), "hunter3", "granadanet"},
+		{"truncated", truncated, "hunter2", "granadanet"},
+		{"other network", plain.Bytes(), "", "mainnet"},
+		{"not a database", []byte("not a bolt database at all"), "", "granadanet"},
+	} {
+		if err := Restore(other, bad.network, bytes.NewReader(bad.backup), bad.passphrase, false); err == nil {
+			t.Errorf("Restored backup with %s", bad.name)
+		}
+	}
+
+	if files, _ := filepath.Glob(other + "*"); len(files) != 0 {
+		t.Errorf("Failed restores left files %v", files)
+	}
+
+	if err := Restore(other, "granadanet", bytes.NewReader(encrypted.Bytes()), "hunter2", false); err != nil {
+		t.Fatalf("Unable to restore: %s", err)
+	}
+
+	db, err = InitStorage(other, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to open restored db: %s", err)
+	}
+
+	config, _ := db.GetNotifiersConfig("telegram")
+	watermark, _ := db.GetBakingWatermark()
+
+	if len(config) != 3*BACKUP_CHUNK_SIZE || watermark != 331391 {
+		t.Errorf("Unexpected restored config of %d bytes, watermark %d", len(config), watermark)
+	}
+
+	// Signed past the backup
+	if err := db.RecordBakedBlock(331400, "BLbTFJH8yvhEuCWS4T3jM2AFDpvuKrtTVwBiA8DwKgfG6Xvrhqd"); err != nil {
+		t.Fatalf("Unable to record bake: %s", err)
+	}
+	db.Close()
+
+	if err := Restore(other, "granadanet", bytes.NewReader(plain.Bytes()), "", false); err == nil {
+		t.Errorf("Restored older watermarks")
+	}
+
+	if err := Restore(other, "granadanet", bytes.NewReader(plain.Bytes()), "", true); err != nil {
+		t.Fatalf("Unable to force restore: %s", err)
+	}
+
+	if backups, _ := filepath.Glob(other + DATABASE_FILE + ".pre-restore-*.bak"); len(backups) != 1 {
+		t.Errorf("Expected the replaced database kept; found %v", backups)
+	}
+}
+
+// A slow download does not hold up compaction, nor the watermarks waiting behind it
+func TestBackupSlowClient(t *testing.T) {
+
+	datadir := t.TempDir() + "/"
+
+	db, err := InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	defer db.Close()
+
+	pr, pw := io.Pipe()
+
+	backedUp := make(chan error, 1)
+	go func() {
+		_, err := db.Backup(pw, "")
+		pw.Close()
+		backedUp <- err
+	}()
+
+	// Download started, and stalled
+	if _, err := io.ReadFull(pr, make([]byte, 1)); err != nil {
+		t.Fatalf("Unable to read backup: %s", err)
+	}
+
+	compacted := make(chan error, 1)
+	go func() {
+		if _, _, err := db.Compact(); err != nil {
+			compacted <- err
+			return
+		}
+		compacted <- db.CheckAndSetBakingWatermark(100)
+	}()
+
+	select {
+	case err := <-compacted:
+		if err != nil {
+			t.Errorf("Unable to compact, and set watermark: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Compaction blocked by backup download")
+	}
+
+	if _, err := io.Copy(ioutil.Discard, pr); err != nil {
+		t.Errorf("Unable to read backup: %s", err)
+	}
+
+	if err := <-backedUp; err != nil {
+		t.Errorf("Unable to back up: %s", err)
+	}
+
+	if snapshots, _ := filepath.Glob(datadir + "*.snapshot-*"); len(snapshots) != 0 {
+		t.Errorf("Snapshot not removed: %v", snapshots)
+	}
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
-	"bytes"
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -18,7 +23,7 @@ const (
 func (s *Storage) GetDelegate() (string, string, error) {
 	var sk, pkh string
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		sk = string(b.Get([]byte(SIGNER_SK)))
 		pkh = string(b.Get([]byte(PUBLIC_KEY_HASH)))
@@ -29,7 +34,7 @@ func (s *Storage) GetDelegate() (string, string, error) {
 }
 
 func (s *Storage) SetDelegate(sk, pkh string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		if err := b.Put([]byte(SIGNER_SK), []byte(sk)); err != nil {
 			return err
@@ -41,10 +46,18 @@ func (s *Storage) SetDelegate(sk, pkh string) error {
 	})
 }
 
+// Only sets the pkh; used by signers which keep the secret key elsewhere
+func (s *Storage) SetDelegatePkh(pkh string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CONFIG_BUCKET))
+		return b.Put([]byte(PUBLIC_KEY_HASH), []byte(pkh))
+	})
+}
+
 func (s *Storage) GetSignerType() (int, error) {
 	var st int = 0
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		_st := b.Get([]byte(SIGNER_TYPE))
 		if _st != nil {
@@ -57,7 +70,7 @@ func (s *Storage) GetSignerType() (int, error) {
 }
 
 func (s *Storage) SetSignerType(d int) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		return b.Put([]byte(SIGNER_TYPE), itob(d))
 	})
@@ -66,7 +79,7 @@ func (s *Storage) SetSignerType(d int) error {
 func (s *Storage) GetSignerSk() (string, error) {
 	var sk string
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		sk = string(b.Get([]byte(SIGNER_SK)))
 		return nil
@@ -75,16 +88,52 @@ func (s *Storage) GetSignerSk() (string, error) {
 	return sk, err
 }
 
+// The secret key is stored encrypted (edesk); plaintext keys from older
+// versions are encrypted in place when the wallet is first unlocked
 func (s *Storage) SetSignerSk(sk string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		return b.Put([]byte(SIGNER_SK), []byte(sk))
 	})
 }
 
+// Signer secret keys found in copies of the DB kept by migrations and restores,
+// by file. Copies which cannot be opened are skipped.
+func (s *Storage) GetBackupSignerKeys() (map[string]string, error) {
+
+	files, err := filepath.Glob(s.datadir + DATABASE_FILE + ".*.bak")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to list DB backups")
+	}
+
+	keys := make(map[string]string)
+
+	for _, file := range files {
+
+		db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+		if err != nil {
+			log.WithError(err).WithField("File", file).Warn("Unable to open DB backup")
+			continue
+		}
+
+		_ = db.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte(CONFIG_BUCKET)); b != nil {
+				if sk := b.Get([]byte(SIGNER_SK)); len(sk) > 0 {
+					keys[file] = string(sk)
+				}
+			}
+			return nil
+		})
+
+		db.Close()
+	}
+
+	return keys, nil
+}
+
 // Ledger
 func (s *Storage) SaveLedgerToDB(pkh, bipPath string, ledgerType int) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 
@@ -111,7 +160,7 @@ func (s *Storage) GetLedgerConfig() (string, string, error) {
 
 	var pkh, bipPath string
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET))
 		pkh = string(b.Get([]byte(PUBLIC_KEY_HASH)))
 		bipPath = string(b.Get([]byte(BIP_PATH)))
@@ -122,20 +171,210 @@ func (s *Storage) GetLedgerConfig() (string, string, error) {
 }
 
 // RPC
-func (s *Storage) AddRPCEndpoint(endpoint string) (int, error) {
+
+// Returned by the API in place of secrets; sent back, it keeps the saved value
+const REDACTED = "********"
+
+// What an endpoint is used for. Monitor endpoints only report new heads.
+const (
+	ROLE_BOTH    = "both"
+	ROLE_READ    = "read"
+	ROLE_INJECT  = "inject"
+	ROLE_MONITOR = "monitor"
+)
+
+// ValidRole returns true for a known role; empty is both
+func ValidRole(role string) bool {
+	switch role {
+	case "", ROLE_BOTH, ROLE_READ, ROLE_INJECT, ROLE_MONITOR:
+		return true
+	}
+	return false
+}
+
+// An RPC endpoint as stored in ENDPOINTS_BUCKET, JSON encoded. Endpoints
+// added by older versions are stored as a bare URL.
+type RPCEndpoint struct {
+	Url      string `json:"url"`
+	Disabled bool   `json:"disabled,omitempty"`
+	Role     string `json:"role,omitempty"`
+
+	// Connection options, for nodes behind an authenticating reverse proxy or
+	// reached through a proxy. Certificates and key are PEM encoded. Proxy is
+	// http://, https:// or socks5://
+	Headers     map[string]string `json:"headers,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+	ClientCert  string            `json:"clientCert,omitempty"`
+	ClientKey   string            `json:"clientKey,omitempty"`
+	CACert      string            `json:"caCert,omitempty"`
+	ProxyURL    string            `json:"proxyUrl,omitempty"`
+}
+
+// Redacted returns a copy of the endpoint safe for the API; password, token,
+// client key and header values are replaced by REDACTED, and the URL and
+// proxy as by RedactURL
+func (e RPCEndpoint) Redacted() RPCEndpoint {
+
+	redact := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		return REDACTED
+	}
+
+	r := e
+	r.Url = RedactURL(e.Url)
+	r.Password = redact(e.Password)
+	r.BearerToken = redact(e.BearerToken)
+	r.ClientKey = redact(e.ClientKey)
+
+	if len(e.Headers) > 0 {
+		r.Headers = make(map[string]string, len(e.Headers))
+		for k, v := range e.Headers {
+			r.Headers[k] = redact(v)
+		}
+	}
+
+	r.ProxyURL = RedactURL(e.ProxyURL)
+
+	return r
+}
+
+// RedactURL returns rawURL safe for the API and logs. A password in the URL is
+// replaced by xxxxx, as url.Redacted does, and query values, often API keys,
+// by REDACTED. A URL which does not parse is replaced entirely.
+func RedactURL(rawURL string) string {
+
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return REDACTED
+	}
+
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			if n := strings.IndexByte(p, '='); n >= 0 && n < len(p)-1 {
+				params[i] = p[:n+1] + REDACTED
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	return u.Redacted()
+}
+
+// Puts back the password and query values of saved that rawURL, as edited
+// from a RedactURL, still has redacted
+func keepURLSecrets(rawURL, saved string) string {
+
+	if rawURL == RedactURL(saved) {
+		return saved
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	s, err := url.Parse(saved)
+	if err != nil {
+		return rawURL
+	}
+
+	if password, ok := u.User.Password(); ok && password == "xxxxx" {
+		if savedPassword, ok := s.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), savedPassword)
+		}
+	}
+
+	if u.RawQuery != "" {
+
+		savedParams := make(map[string]string)
+		for _, p := range strings.Split(s.RawQuery, "&") {
+			if n := strings.IndexByte(p, '='); n >= 0 {
+				savedParams[p[:n]] = p[n+1:]
+			}
+		}
+
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			if n := strings.IndexByte(p, '='); n >= 0 && p[n+1:] == REDACTED {
+				if v, ok := savedParams[p[:n]]; ok {
+					params[i] = p[:n+1] + v
+				}
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	return u.String()
+}
+
+// KeepSecrets returns a copy of e where fields left redacted, as returned by
+// the API, take their value from saved
+func (e RPCEndpoint) KeepSecrets(saved RPCEndpoint) RPCEndpoint {
+
+	keep := func(v, old string) string {
+		if v == REDACTED {
+			return old
+		}
+		return v
+	}
+
+	r := e
+	r.Password = keep(e.Password, saved.Password)
+	r.BearerToken = keep(e.BearerToken, saved.BearerToken)
+	r.ClientKey = keep(e.ClientKey, saved.ClientKey)
+
+	if len(e.Headers) > 0 {
+		r.Headers = make(map[string]string, len(e.Headers))
+		for k, v := range e.Headers {
+			r.Headers[k] = keep(v, saved.Headers[k])
+		}
+	}
+
+	r.Url = keepURLSecrets(e.Url, saved.Url)
+	r.ProxyURL = keepURLSecrets(e.ProxyURL, saved.ProxyURL)
+
+	return r
+}
+
+func decodeRPCEndpoint(v []byte) (RPCEndpoint, error) {
+
+	var e RPCEndpoint
+
+	if len(v) == 0 || v[0] != '{' {
+		e.Url = string(v)
+		return e, nil
+	}
+
+	if err := json.Unmarshal(v, &e); err != nil {
+		return e, errors.Wrap(err, "Unable to decode endpoint")
+	}
+
+	return e, nil
+}
+
+func (s *Storage) AddRPCEndpoint(endpoint RPCEndpoint) (int, error) {
+
 	var rpcId int = 0
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
 		if b == nil {
 			return errors.New("AddRPC - Unable to locate endpoints bucket")
 		}
 
 		var foundDup bool
-		endpointBytes := []byte(endpoint)
 
 		if err := b.ForEach(func(k, v []byte) error {
-			if bytes.Equal(v, endpointBytes) {
+			if e, err := decodeRPCEndpoint(v); err == nil && e.Url == endpoint.Url {
 				foundDup = true
 			}
 			return nil
@@ -148,6 +387,11 @@ func (s *Storage) AddRPCEndpoint(endpoint string) (int, error) {
 			return nil
 		}
 
+		endpointBytes, err := json.Marshal(endpoint)
+		if err != nil {
+			return errors.Wrap(err, "Unable to encode endpoint")
+		}
+
 		// else, add
 		id, _ := b.NextSequence()
 		rpcId = int(id)
@@ -158,31 +402,67 @@ func (s *Storage) AddRPCEndpoint(endpoint string) (int, error) {
 	return rpcId, err
 }
 
+// GetRPCEndpoints returns the URL of each endpoint, by id
 func (s *Storage) GetRPCEndpoints() (map[int]string, error) {
+
 	endpoints := make(map[int]string)
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	configs, err := s.GetRPCEndpointConfigs()
+	for id, e := range configs {
+		endpoints[id] = e.Url
+	}
+
+	return endpoints, err
+}
+
+// GetRPCEndpointConfigs returns each endpoint, by id
+func (s *Storage) GetRPCEndpointConfigs() (map[int]RPCEndpoint, error) {
+
+	endpoints := make(map[int]RPCEndpoint)
+
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
 		if b == nil {
 			return errors.New("GetRPC - Unable to locate endpoints bucket")
 		}
 
-		if err := b.ForEach(func(k, v []byte) error {
-			id := btoi(k)
-			endpoints[id] = string(v)
+		return b.ForEach(func(k, v []byte) error {
+			e, err := decodeRPCEndpoint(v)
+			if err != nil {
+				return errors.Wrapf(err, "Endpoint %d", btoi(k))
+			}
+			endpoints[btoi(k)] = e
 			return nil
-		}); err != nil {
-			return err
-		}
-
-		return nil
+		})
 	})
 
 	return endpoints, err
 }
 
+// UpdateRPCEndpoint replaces the stored endpoint endpointId
+func (s *Storage) UpdateRPCEndpoint(endpointId int, endpoint RPCEndpoint) error {
+
+	endpointBytes, err := json.Marshal(endpoint)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode endpoint")
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
+		if b == nil {
+			return errors.New("UpdateRPC - Unable to locate endpoints bucket")
+		}
+
+		if b.Get(itob(endpointId)) == nil {
+			return errors.Errorf("No endpoint with id %d", endpointId)
+		}
+
+		return b.Put(itob(endpointId), endpointBytes)
+	})
+}
+
 func (s *Storage) DeleteRPCEndpoint(endpointId int) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
 		if b == nil {
 			return errors.New("Unable to locate endpoints bucket")
@@ -199,7 +479,7 @@ func (s *Storage) AddDefaultEndpoints(network string) error {
 
 	var currentSeq uint64
 
-	if err := s.db.View(func(tx *bolt.Tx) error {
+	if err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
 		if b == nil {
 			return errors.New("AddDefaultRPCs - Unable to locate endpoints bucket")
@@ -210,22 +490,38 @@ func (s *Storage) AddDefaultEndpoints(network string) error {
 		return err
 	}
 
-	if currentSeq == 0 {
-
-		// Statically add BakinBacon's RPC endpoints
-		switch network {
-		case "mainnet":
-			_, _ = DB.AddRPCEndpoint("http://mainnet-us.rpc.bakinbacon.io")
-			_, _ = DB.AddRPCEndpoint("http://mainnet-eu.rpc.bakinbacon.io")
+	if currentSeq != 0 {
+		return nil
+	}
 
-		case "granadanet":
-			_, _ = DB.AddRPCEndpoint("http://granadanet-us.rpc.bakinbacon.io")
-			_, _ = DB.AddRPCEndpoint("http://granadanet-eu.rpc.bakinbacon.io")
+	endpoints, err := defaultEndpoints(network)
+	if err != nil {
+		return err
+	}
 
-		default:
-			return errors.New("Unknown network for storage")
-		}
+	for _, e := range endpoints {
+		_, _ = s.AddRPCEndpoint(e)
 	}
 
 	return nil
 }
+
+// Statically add BakinBacon's RPC endpoints
+func defaultEndpoints(network string) ([]RPCEndpoint, error) {
+
+	switch network {
+	case "mainnet":
+		return []RPCEndpoint{
+			{Url: "http://mainnet-us.rpc.bakinbacon.io"},
+			{Url: "http://mainnet-eu.rpc.bakinbacon.io"},
+		}, nil
+
+	case "granadanet":
+		return []RPCEndpoint{
+			{Url: "http://granadanet-us.rpc.bakinbacon.io"},
+			{Url: "http://granadanet-eu.rpc.bakinbacon.io"},
+		}, nil
+	}
+
+	return nil, errors.New("Unknown network for storage")
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	CONSENSUS_SK          = "consensussk"
+	CONSENSUS_PKH         = "consensuspkh"
+	CONSENSUS_CYCLE       = "consensuscycle"
+	PENDING_CONSENSUS_SK  = "pendingconsensussk"
+	PENDING_CONSENSUS_PKH = "pendingconsensuspkh"
+	PENDING_CONSENSUS_CYC = "pendingconsensuscycle"
+)
+
+// Consensus key registered for the baker. Secret keys are stored encrypted (edesk, spesk, p2esk).
+type ConsensusKey struct {
+	Sk              string
+	Pkh             string
+	ActivationCycle int
+}
+
+func (c ConsensusKey) IsSet() bool {
+	return c.Pkh != ""
+}
+
+// Returns the active consensus key, and the key waiting for its activation cycle.
+// Either may be empty; with no active key, the baker's own key signs blocks and endorsements.
+func (s *Storage) GetConsensusKeys() (ConsensusKey, ConsensusKey, error) {
+
+	var active, pending ConsensusKey
+
+	err := s.view(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(CONFIG_BUCKET))
+
+		active.Sk = string(b.Get([]byte(CONSENSUS_SK)))
+		active.Pkh = string(b.Get([]byte(CONSENSUS_PKH)))
+		if c := b.Get([]byte(CONSENSUS_CYCLE)); c != nil {
+			active.ActivationCycle = btoi(c)
+		}
+
+		pending.Sk = string(b.Get([]byte(PENDING_CONSENSUS_SK)))
+		pending.Pkh = string(b.Get([]byte(PENDING_CONSENSUS_PKH)))
+		if c := b.Get([]byte(PENDING_CONSENSUS_CYC)); c != nil {
+			pending.ActivationCycle = btoi(c)
+		}
+
+		return nil
+	})
+
+	return active, pending, err
+}
+
+// Saves a newly registered consensus key; replaces any other pending key
+func (s *Storage) SetPendingConsensusKey(ck ConsensusKey) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CONFIG_BUCKET))
+		if err := b.Put([]byte(PENDING_CONSENSUS_SK), []byte(ck.Sk)); err != nil {
+			return err
+		}
+		if err := b.Put([]byte(PENDING_CONSENSUS_PKH), []byte(ck.Pkh)); err != nil {
+			return err
+		}
+		return b.Put([]byte(PENDING_CONSENSUS_CYC), itob(ck.ActivationCycle))
+	})
+}
+
+// Replaces the active consensus key with the pending key
+func (s *Storage) ActivatePendingConsensusKey() error {
+	return s.update(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(CONFIG_BUCKET))
+
+		for _, k := range [][2]string{
+			{CONSENSUS_SK, PENDING_CONSENSUS_SK},
+			{CONSENSUS_PKH, PENDING_CONSENSUS_PKH},
+			{CONSENSUS_CYCLE, PENDING_CONSENSUS_CYC},
+		} {
+			// Copy; values from Get are only valid until the bucket is modified
+			v := append([]byte{}, b.Get([]byte(k[1]))...)
+
+			if err := b.Put([]byte(k[0]), v); err != nil {
+				return err
+			}
+			if err := b.Delete([]byte(k[1])); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/nonce"
+)
+
+// Kinds of history, each a bucket within HISTORY_BUCKET, of level to entry
+const (
+	HISTORY_BAKE         = "bake"
+	HISTORY_ENDORSEMENT  = "endorsement"
+	HISTORY_NONCE_REVEAL = "nonce_reveal"
+)
+
+// Status of an entry. Bakes and endorsements are pending until settled from
+// chain, once included, or not. Nonce reveals are not settled. Bakes and
+// endorsements recorded before entries were kept have only a level and hash.
+const (
+	HISTORY_PENDING  = "pending"
+	HISTORY_INCLUDED = "included"
+	HISTORY_MISSED   = "missed"
+	HISTORY_INJECTED = "injected"
+	HISTORY_UNKNOWN  = "unknown"
+)
+
+var historyKinds = []string{HISTORY_BAKE, HISTORY_ENDORSEMENT, HISTORY_NONCE_REVEAL}
+
+// A bake, endorsement or nonce reveal, for export. Level is that of the block
+// baked, endorsed, or of which the nonce is revealed. Rewards, fees and deposits
+// are in mutez, as realized on chain.
+type HistoryEntry struct {
+	Kind      string    `json:"kind"`
+	Level     int       `json:"level"`
+	Cycle     int       `json:"cycle"`
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+	Priority  int       `json:"priority"`
+	Slots     int       `json:"slots"`
+	Rewards   int64     `json:"rewards"`
+	Fees      int64     `json:"fees"`
+	Deposits  int64     `json:"deposits"`
+	Status    string    `json:"status"`
+}
+
+func (s *Storage) SaveHistoryEntry(e HistoryEntry) error {
+
+	entryBytes, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal history entry")
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte(HISTORY_BUCKET)).CreateBucketIfNotExists([]byte(e.Kind))
+		if err != nil {
+			return errors.Wrap(err, "Unable to create history bucket")
+		}
+		return b.Put(itob(e.Level), entryBytes)
+	})
+}
+
+// All of history, ordered by level. Bakes, endorsements and nonce reveals
+// without an entry are included with what is known of them.
+func (s *Storage) GetHistory() ([]HistoryEntry, error) {
+
+	var entries []HistoryEntry
+
+	err := s.view(func(tx *bolt.Tx) error {
+
+		saved := make(map[string]map[int]bool)
+
+		for _, kind := range historyKinds {
+
+			saved[kind] = make(map[int]bool)
+
+			b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(kind))
+			if b == nil {
+				continue
+			}
+
+			if err := b.ForEach(func(k, v []byte) error {
+				var e HistoryEntry
+				if err := json.Unmarshal(v, &e); err != nil {
+					log.WithError(err).Error("Unable to unmarshal history entry")
+					return nil
+				}
+				saved[kind][e.Level] = true
+				entries = append(entries, e)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		for kind, opBucket := range map[string]string{HISTORY_BAKE: BAKING_BUCKET, HISTORY_ENDORSEMENT: ENDORSING_BUCKET} {
+			if err := tx.Bucket([]byte(opBucket)).ForEach(func(k, v []byte) error {
+				if level := btoi(k); !saved[kind][level] {
+					entries = append(entries, unknownEntry(kind, level, string(v)))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Revealed nonces, in a bucket per cycle
+		return tx.Bucket([]byte(NONCE_BUCKET)).ForEach(func(cycle, _ []byte) error {
+
+			cb := tx.Bucket([]byte(NONCE_BUCKET)).Bucket(cycle)
+			if cb == nil {
+				return nil
+			}
+
+			return cb.ForEach(func(k, v []byte) error {
+				var n nonce.Nonce
+				if err := json.Unmarshal(v, &n); err == nil && n.RevealOp != "" && !saved[HISTORY_NONCE_REVEAL][n.Level] {
+					entries = append(entries, unknownEntry(HISTORY_NONCE_REVEAL, n.Level, n.RevealOp))
+				}
+				return nil
+			})
+		})
+	})
+
+	sortHistory(entries)
+
+	return entries, err
+}
+
+// Pending entries from sinceLevel; older ones are left pending
+func (s *Storage) GetPendingHistory(sinceLevel int) ([]HistoryEntry, error) {
+
+	var entries []HistoryEntry
+
+	err := s.view(func(tx *bolt.Tx) error {
+
+		for _, kind := range historyKinds {
+
+			b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(kind))
+			if b == nil {
+				continue
+			}
+
+			c := b.Cursor()
+
+			for k, v := c.Seek(itob(sinceLevel)); k != nil; k, v = c.Next() {
+				var e HistoryEntry
+				if err := json.Unmarshal(v, &e); err == nil && e.Status == HISTORY_PENDING {
+					entries = append(entries, e)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	sortHistory(entries)
+
+	return entries, err
+}
+
+// History, with the archived operations it no longer has, ordered by level. An
+// operation in both, if archiving was interrupted, is taken from history.
+func MergeArchivedHistory(history []HistoryEntry, archived []ArchivedOperation) []HistoryEntry {
+
+	kept := make(map[string]map[int]bool)
+	for _, kind := range historyKinds {
+		kept[kind] = make(map[int]bool)
+	}
+
+	for _, e := range history {
+		kept[e.Kind][e.Level] = true
+	}
+
+	for _, op := range archived {
+		if !kept[op.Kind][op.Level] {
+			history = append(history, op.HistoryEntry())
+		}
+	}
+
+	sortHistory(history)
+
+	return history
+}
+
+func unknownEntry(kind string, level int, hash string) HistoryEntry {
+	return HistoryEntry{
+		Kind:   kind,
+		Level:  level,
+		Hash:   hash,
+		Status: HISTORY_UNKNOWN,
+	}
+}
+
+// By level, then bake, endorsement and nonce reveal
+func sortHistory(entries []HistoryEntry) {
+
+	order := make(map[string]int)
+	for i, kind := range historyKinds {
+		order[kind] = i
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Level != entries[j].Level {
+			return entries[i].Level < entries[j].Level
+		}
+		return order[entries[i].Kind] < order[entries[j].Kind]
+	})
+}
@@ -0,0 +1,653 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/pkg/errors"
+
+	"bakinbacon/nonce"
+)
+
+// MemoryStorage keeps everything Storage does, in memory; for tests. Safe for
+// concurrent use. Unlike a new Storage, it starts without RPC endpoints.
+type MemoryStorage struct {
+	network string
+
+	sk, pkh, bipPath string
+	signerType       int
+	recoveryState    string
+
+	activeConsensusKey, pendingConsensusKey ConsensusKey
+
+	endpoints   map[int]RPCEndpoint
+	endpointSeq int
+
+	// Level to hash, and the watermark
+	bakes, endorsements                 map[int]string
+	bakingWatermark, endorsingWatermark int
+	preendorsingWatermark               int
+
+	// Level to cycle, and level to priority; nil until first saved
+	endorsingRights, bakingRights           map[int]int
+	endorsingRightsCycle, bakingRightsCycle int
+
+	// Cycle to level to nonce
+	nonces map[int]map[int]nonce.Nonce
+
+	notifiers map[string][]byte
+	audit     []AuditEntry
+
+	// Kind to level to entry
+	history map[string]map[int]HistoryEntry
+
+	lock sync.Mutex
+}
+
+func NewMemoryStorage(network string) *MemoryStorage {
+	return &MemoryStorage{
+		network:      network,
+		endpoints:    make(map[int]RPCEndpoint),
+		bakes:        make(map[int]string),
+		endorsements: make(map[int]string),
+		nonces:       make(map[int]map[int]nonce.Nonce),
+		notifiers:    make(map[string][]byte),
+		history:      make(map[string]map[int]HistoryEntry),
+	}
+}
+
+func (m *MemoryStorage) Close() {}
+
+func (m *MemoryStorage) GetNetwork() (string, error) {
+	return m.network, nil
+}
+
+func (m *MemoryStorage) GetDelegate() (string, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.sk, m.pkh, nil
+}
+
+func (m *MemoryStorage) SetDelegate(sk, pkh string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.sk, m.pkh = sk, pkh
+
+	return nil
+}
+
+func (m *MemoryStorage) SetDelegatePkh(pkh string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.pkh = pkh
+
+	return nil
+}
+
+func (m *MemoryStorage) GetSignerType() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.signerType, nil
+}
+
+func (m *MemoryStorage) SetSignerType(d int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.signerType = d
+
+	return nil
+}
+
+func (m *MemoryStorage) GetSignerSk() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.sk, nil
+}
+
+func (m *MemoryStorage) SetSignerSk(sk string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.sk = sk
+
+	return nil
+}
+
+func (m *MemoryStorage) SaveLedgerToDB(pkh, bipPath string, ledgerType int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.signerType, m.pkh, m.bipPath = ledgerType, pkh, bipPath
+
+	return nil
+}
+
+func (m *MemoryStorage) GetLedgerConfig() (string, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.pkh, m.bipPath, nil
+}
+
+func (m *MemoryStorage) AddRPCEndpoint(endpoint RPCEndpoint) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, e := range m.endpoints {
+		if e.Url == endpoint.Url {
+			return 0, nil
+		}
+	}
+
+	m.endpointSeq++
+	m.endpoints[m.endpointSeq] = endpoint
+
+	return m.endpointSeq, nil
+}
+
+func (m *MemoryStorage) GetRPCEndpoints() (map[int]string, error) {
+
+	endpoints := make(map[int]string)
+
+	configs, err := m.GetRPCEndpointConfigs()
+	for id, e := range configs {
+		endpoints[id] = e.Url
+	}
+
+	return endpoints, err
+}
+
+func (m *MemoryStorage) GetRPCEndpointConfigs() (map[int]RPCEndpoint, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	endpoints := make(map[int]RPCEndpoint, len(m.endpoints))
+	for id, e := range m.endpoints {
+		endpoints[id] = e
+	}
+
+	return endpoints, nil
+}
+
+func (m *MemoryStorage) UpdateRPCEndpoint(endpointId int, endpoint RPCEndpoint) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.endpoints[endpointId]; !ok {
+		return errors.Errorf("No endpoint with id %d", endpointId)
+	}
+
+	m.endpoints[endpointId] = endpoint
+
+	return nil
+}
+
+func (m *MemoryStorage) DeleteRPCEndpoint(endpointId int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.endpoints, endpointId)
+
+	return nil
+}
+
+func (m *MemoryStorage) AddDefaultEndpoints(network string) error {
+
+	m.lock.Lock()
+	seq := m.endpointSeq
+	m.lock.Unlock()
+
+	if seq != 0 {
+		return nil
+	}
+
+	endpoints, err := defaultEndpoints(network)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range endpoints {
+		_, _ = m.AddRPCEndpoint(e)
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) GetConsensusKeys() (ConsensusKey, ConsensusKey, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.activeConsensusKey, m.pendingConsensusKey, nil
+}
+
+func (m *MemoryStorage) SetPendingConsensusKey(ck ConsensusKey) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.pendingConsensusKey = ck
+
+	return nil
+}
+
+func (m *MemoryStorage) ActivatePendingConsensusKey() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.activeConsensusKey, m.pendingConsensusKey = m.pendingConsensusKey, ConsensusKey{}
+
+	return nil
+}
+
+func (m *MemoryStorage) GetBakingWatermark() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.bakingWatermark, nil
+}
+
+func (m *MemoryStorage) GetEndorsingWatermark() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.endorsingWatermark, nil
+}
+
+func (m *MemoryStorage) RecordBakedBlock(level int, blockHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if level > m.bakingWatermark {
+		m.bakingWatermark = level
+	}
+	m.bakes[level] = blockHash
+
+	return nil
+}
+
+func (m *MemoryStorage) RecordEndorsement(level int, endorsementHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if level > m.endorsingWatermark {
+		m.endorsingWatermark = level
+	}
+	m.endorsements[level] = endorsementHash
+
+	return nil
+}
+
+func (m *MemoryStorage) CheckAndSetBakingWatermark(level int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return checkAndSet(&m.bakingWatermark, level)
+}
+
+func (m *MemoryStorage) CheckAndSetEndorsingWatermark(level int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return checkAndSet(&m.endorsingWatermark, level)
+}
+
+func (m *MemoryStorage) GetPreendorsingWatermark() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.preendorsingWatermark, nil
+}
+
+func (m *MemoryStorage) CheckAndSetPreendorsingWatermark(level int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return checkAndSet(&m.preendorsingWatermark, level)
+}
+
+func checkAndSet(watermark *int, level int) error {
+
+	if *watermark >= level {
+		return errors.Errorf("Level %d is not above watermark %d", level, *watermark)
+	}
+
+	*watermark = level
+
+	return nil
+}
+
+func (m *MemoryStorage) GetRecentBake() (int, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	level := highestLevel(m.bakes)
+
+	return level, m.bakes[level], nil
+}
+
+func (m *MemoryStorage) GetRecentEndorsement() (int, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	level := highestLevel(m.endorsements)
+
+	return level, m.endorsements[level], nil
+}
+
+func highestLevel(history map[int]string) int {
+
+	var highest int
+
+	for level := range history {
+		if level > highest {
+			highest = level
+		}
+	}
+
+	return highest
+}
+
+func (m *MemoryStorage) GetRecoveryState() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.recoveryState, nil
+}
+
+func (m *MemoryStorage) SetRecoveryState(state string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.recoveryState = state
+
+	return nil
+}
+
+func (m *MemoryStorage) RecoverBakedBlock(level int, blockHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if level > m.bakingWatermark {
+		m.bakingWatermark = level
+	}
+	m.bakes[level] = blockHash
+
+	return nil
+}
+
+func (m *MemoryStorage) RecoverEndorsement(level int, endorsementHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if level > m.endorsingWatermark {
+		m.endorsingWatermark = level
+	}
+	m.endorsements[level] = endorsementHash
+
+	return nil
+}
+
+func (m *MemoryStorage) SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.EndorsingRights) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.endorsingRights == nil {
+		m.endorsingRights = make(map[int]int)
+	}
+
+	m.endorsingRightsCycle = cycle
+
+	for _, r := range endorsingRights {
+		m.endorsingRights[r.Level] = cycle
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) SaveBakingRightsForCycle(cycle int, bakingRights []rpc.BakingRights) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.bakingRights == nil {
+		m.bakingRights = make(map[int]int)
+	}
+
+	m.bakingRightsCycle = cycle
+
+	for _, r := range bakingRights {
+		m.bakingRights[r.Level] = r.Priority
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) GetNextEndorsingRight(curLevel int) (int, int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.endorsingRights == nil {
+		return 0, 0, errors.New("Endorsing Rights Bucket Not Found")
+	}
+
+	return nextLevel(m.endorsingRights, curLevel), m.endorsingRightsCycle, nil
+}
+
+func (m *MemoryStorage) GetNextBakingRight(curLevel int) (int, int, int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.bakingRights == nil {
+		return 0, 0, 0, errors.New("Endorsing Rights Bucket Not Found")
+	}
+
+	level := nextLevel(m.bakingRights, curLevel)
+
+	return level, m.bakingRights[level], m.bakingRightsCycle, nil
+}
+
+// Lowest level above curLevel; 0 if none
+func nextLevel(rights map[int]int, curLevel int) int {
+
+	var next int
+
+	for level := range rights {
+		if level > curLevel && (next == 0 || level < next) {
+			next = level
+		}
+	}
+
+	return next
+}
+
+func (m *MemoryStorage) PruneRights(beforeLevel int) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var pruned int
+
+	for _, rights := range []map[int]int{m.endorsingRights, m.bakingRights} {
+		for level := range rights {
+			if level < beforeLevel {
+				delete(rights, level)
+				pruned++
+			}
+		}
+	}
+
+	return pruned, nil
+}
+
+func (m *MemoryStorage) SaveNonce(cycle int, n nonce.Nonce) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.nonces[cycle] == nil {
+		m.nonces[cycle] = make(map[int]nonce.Nonce)
+	}
+
+	n.Nonce = append([]byte(nil), n.Nonce...)
+	m.nonces[cycle][n.Level] = n
+
+	return nil
+}
+
+// Ordered by level, as from Storage
+func (m *MemoryStorage) GetNoncesForCycle(cycle int) ([]nonce.Nonce, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var nonces []nonce.Nonce
+
+	for _, n := range m.nonces[cycle] {
+		n.Nonce = append([]byte(nil), n.Nonce...)
+		nonces = append(nonces, n)
+	}
+
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i].Level < nonces[j].Level
+	})
+
+	return nonces, nil
+}
+
+func (m *MemoryStorage) PruneNonces(beforeCycle int) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var pruned int
+
+	for cycle, nonces := range m.nonces {
+		if cycle < beforeCycle {
+			pruned += len(nonces)
+			delete(m.nonces, cycle)
+		}
+	}
+
+	return pruned, nil
+}
+
+func (m *MemoryStorage) GetNotifiersConfig(notifier string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if config, ok := m.notifiers[notifier]; ok {
+		return append([]byte(nil), config...), nil
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryStorage) SaveNotifiersConfig(notifier string, config []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.notifiers[notifier] = append([]byte(nil), config...)
+
+	return nil
+}
+
+func (m *MemoryStorage) AddAuditEntry(e AuditEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.audit) > 0 {
+		e.PrevHash = m.audit[len(m.audit)-1].Hash
+	}
+
+	e.Seq = len(m.audit) + 1
+
+	var err error
+	if e.Hash, err = e.computeHash(); err != nil {
+		return err
+	}
+
+	e.Operations = append([]string(nil), e.Operations...)
+	m.audit = append(m.audit, e)
+
+	return nil
+}
+
+func (m *MemoryStorage) GetAuditEntries() ([]AuditEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := make([]AuditEntry, 0, len(m.audit))
+	for _, e := range m.audit {
+		e.Operations = append([]string(nil), e.Operations...)
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func (m *MemoryStorage) SaveHistoryEntry(e HistoryEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.history[e.Kind] == nil {
+		m.history[e.Kind] = make(map[int]HistoryEntry)
+	}
+
+	m.history[e.Kind][e.Level] = e
+
+	return nil
+}
+
+// As from Storage, with bakes, endorsements and reveals without an entry
+func (m *MemoryStorage) GetHistory() ([]HistoryEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var entries []HistoryEntry
+
+	for _, kindEntries := range m.history {
+		for _, e := range kindEntries {
+			entries = append(entries, e)
+		}
+	}
+
+	for kind, ops := range map[string]map[int]string{HISTORY_BAKE: m.bakes, HISTORY_ENDORSEMENT: m.endorsements} {
+		for level, hash := range ops {
+			if _, ok := m.history[kind][level]; !ok {
+				entries = append(entries, unknownEntry(kind, level, hash))
+			}
+		}
+	}
+
+	for _, nonces := range m.nonces {
+		for level, n := range nonces {
+			if _, ok := m.history[HISTORY_NONCE_REVEAL][level]; !ok && n.RevealOp != "" {
+				entries = append(entries, unknownEntry(HISTORY_NONCE_REVEAL, level, n.RevealOp))
+			}
+		}
+	}
+
+	sortHistory(entries)
+
+	return entries, nil
+}
+
+func (m *MemoryStorage) GetPendingHistory(sinceLevel int) ([]HistoryEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var entries []HistoryEntry
+
+	for _, kindEntries := range m.history {
+		for level, e := range kindEntries {
+			if level >= sinceLevel && e.Status == HISTORY_PENDING {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	sortHistory(entries)
+
+	return entries, nil
+}
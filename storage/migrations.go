@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	SCHEMA_VERSION = "schemaversion"
+	NETWORK        = "network"
+)
+
+// A change to the layout or encoding of the DB. Migrations are applied in
+// order of version, each in its own transaction, which also records the
+// version; a failed migration leaves the DB at the previous version.
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *bolt.Tx, network string) error
+}
+
+// Append only; never change a released migration, add another
+var migrations = []migration{
+	{1, "Create buckets", createBuckets},
+	{2, "Store endpoints saved as a bare URL as JSON", encodeLegacyEndpoints},
+	{3, "Record network of the DB", recordNetwork},
+	{4, "Create history buckets", createHistoryBuckets},
+	{5, "Create preendorsing watermark bucket", createPreendorsingBucket},
+}
+
+var errDryRun = errors.New("Dry-run; rolled back")
+
+// Schema version of a DB; 0 if the DB is new or predates versioning
+func schemaVersion(tx *bolt.Tx) int {
+
+	b := tx.Bucket([]byte(CONFIG_BUCKET))
+	if b == nil {
+		return 0
+	}
+
+	v := b.Get([]byte(SCHEMA_VERSION))
+	if v == nil {
+		return 0
+	}
+
+	return btoi(v)
+}
+
+// Migrate opens the DB in datadir and applies pending migrations, without
+// starting anything else; with dryRun, the migrations run and are rolled back.
+// Returns the schema version before and after.
+func Migrate(datadir, network string, dryRun bool) (int, int, error) {
+
+	db, err := bolt.Open(datadir+DATABASE_FILE, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "Failed to open db")
+	}
+	defer db.Close()
+
+	return migrate(db, datadir, network, dryRun)
+}
+
+func migrate(db *bolt.DB, datadir, network string, dryRun bool) (int, int, error) {
+
+	var (
+		current int
+		isNew   bool
+	)
+
+	_ = db.View(func(tx *bolt.Tx) error {
+		current = schemaVersion(tx)
+		isNew = tx.Bucket([]byte(CONFIG_BUCKET)) == nil
+		return nil
+	})
+
+	latest := migrations[len(migrations)-1].version
+
+	if current > latest {
+		return current, current, errors.Errorf("DB schema version %d is newer than supported version %d; Upgrade BakinBacon", current, latest)
+	}
+
+	if current == latest {
+		return current, current, nil
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	if dryRun {
+
+		// All in one transaction, which is never committed
+		err := db.Update(func(tx *bolt.Tx) error {
+			for _, m := range pending {
+				if err := applyMigration(tx, m, network); err != nil {
+					return err
+				}
+				log.WithFields(log.Fields{
+					"Version": m.version, "Migration": m.description,
+				}).Info("Migration would be applied")
+			}
+			return errDryRun
+		})
+		if err != errDryRun {
+			return current, current, err
+		}
+
+		return current, latest, nil
+	}
+
+	// Nothing to lose in a new DB
+	if !isNew {
+		backup := fmt.Sprintf("%s%s.v%d-%s.bak", datadir, DATABASE_FILE, current, time.Now().UTC().Format("20060102-150405"))
+
+		if err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backup, 0600)
+		}); err != nil {
+			return current, current, errors.Wrap(err, "Unable to back up DB before migration")
+		}
+
+		log.WithField("Backup", backup).Info("Backed up DB before migration")
+	}
+
+	for _, m := range pending {
+
+		if err := db.Update(func(tx *bolt.Tx) error {
+			return applyMigration(tx, m, network)
+		}); err != nil {
+			return current, current, err
+		}
+
+		current = m.version
+
+		log.WithFields(log.Fields{
+			"Version": m.version, "Migration": m.description,
+		}).Info("Applied DB migration")
+	}
+
+	return current, current, nil
+}
+
+func applyMigration(tx *bolt.Tx, m migration, network string) error {
+
+	if err := m.migrate(tx, network); err != nil {
+		return errors.Wrapf(err, "Migration %d (%s) failed", m.version, m.description)
+	}
+
+	b := tx.Bucket([]byte(CONFIG_BUCKET))
+	if b == nil {
+		return errors.Errorf("Migration %d (%s) failed: no config bucket", m.version, m.description)
+	}
+
+	return b.Put([]byte(SCHEMA_VERSION), itob(m.version))
+}
+
+// 1; The layout before versioning
+func createBuckets(tx *bolt.Tx, network string) error {
+
+	// Config bucket
+	cfgBkt, err := tx.CreateBucketIfNotExists([]byte(CONFIG_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Cannot create config bucket")
+	}
+
+	// Nested bucket inside config
+	if _, err := cfgBkt.CreateBucketIfNotExists([]byte(ENDPOINTS_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create endpoints bucket")
+	}
+
+	// Nested bucket inside config
+	if _, err := cfgBkt.CreateBucketIfNotExists([]byte(NOTIFICATIONS_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create notifications bucket")
+	}
+
+	//
+	// Root buckets
+	if _, err := tx.CreateBucketIfNotExists([]byte(ENDORSING_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create endorsing bucket")
+	}
+
+	if _, err := tx.CreateBucketIfNotExists([]byte(BAKING_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create baking bucket")
+	}
+
+	if _, err := tx.CreateBucketIfNotExists([]byte(NONCE_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create nonce bucket")
+	}
+
+	if _, err := tx.CreateBucketIfNotExists([]byte(RIGHTS_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create rights bucket")
+	}
+
+	if _, err := tx.CreateBucketIfNotExists([]byte(AUDIT_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create audit bucket")
+	}
+
+	return nil
+}
+
+// 2; Endpoints were stored as a bare URL before they had options
+func encodeLegacyEndpoints(tx *bolt.Tx, network string) error {
+
+	b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET))
+
+	legacy := make(map[string][]byte)
+
+	if err := b.ForEach(func(k, v []byte) error {
+		if len(v) > 0 && v[0] != '{' {
+			e, _ := json.Marshal(RPCEndpoint{Url: string(v)})
+			legacy[string(k)] = e
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Not while iterating
+	for k, v := range legacy {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 3; So a DB is not used, or restored, on another network
+func recordNetwork(tx *bolt.Tx, network string) error {
+
+	b := tx.Bucket([]byte(CONFIG_BUCKET))
+
+	if b.Get([]byte(NETWORK)) != nil {
+		return nil
+	}
+
+	return b.Put([]byte(NETWORK), []byte(network))
+}
+
+// 4; Details of bakes, endorsements and nonce reveals, for export
+func createHistoryBuckets(tx *bolt.Tx, network string) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(HISTORY_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Cannot create history bucket")
+	}
+
+	for _, kind := range historyKinds {
+		if _, err := b.CreateBucketIfNotExists([]byte(kind)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s history bucket", kind)
+		}
+	}
+
+	return nil
+}
+
+// 5; Tenderbake preendorsements have their own watermark, for the remote signer
+func createPreendorsingBucket(tx *bolt.Tx, network string) error {
+
+	if _, err := tx.CreateBucketIfNotExists([]byte(PREENDORSING_BUCKET)); err != nil {
+		return errors.Wrap(err, "Cannot create preendorsing bucket")
+	}
+
+	return nil
+}
+
+// Network the DB was created for
+func (s *Storage) GetNetwork() (string, error) {
+
+	var network string
+
+	err := s.view(func(tx *bolt.Tx) error {
+		network = string(tx.Bucket([]byte(CONFIG_BUCKET)).Get([]byte(NETWORK)))
+		return nil
+	})
+
+	return network, err
+}
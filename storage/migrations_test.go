@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Schema version, and first endpoint, of a DB file
+func dbSchemaVersion(t *testing.T, file string) (int, []byte) {
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		t.Fatalf("Unable to open db: %s", err)
+	}
+	defer db.Close()
+
+	var (
+		version  int
+		endpoint []byte
+	)
+
+	_ = db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		endpoint = append(endpoint, tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(ENDPOINTS_BUCKET)).Get(itob(1))...)
+		return nil
+	})
+
+	return version, endpoint
+}
+
+func TestMigrations(t *testing.T) {
+
+	datadir := t.TempDir() + "/"
+
+	// DB of a version before schema versioning
+	legacy, err := bolt.Open(datadir+DATABASE_FILE, 0600, nil)
+	if err != nil {
+		t.Fatalf("Unable to create db: %s", err)
+	}
+
+	if err := legacy.Update(func(tx *bolt.Tx) error {
+		cfg, _ := tx.CreateBucketIfNotExists([]byte(CONFIG_BUCKET))
+		endpoints, _ := cfg.CreateBucketIfNotExists([]byte(ENDPOINTS_BUCKET))
+		id, _ := endpoints.NextSequence()
+		if err := endpoints.Put(itob(int(id)), []byte("http://legacy:8732")); err != nil {
+			return err
+		}
+		bakes, _ := tx.CreateBucketIfNotExists([]byte(BAKING_BUCKET))
+		return bakes.SetSequence(331391)
+	}); err != nil {
+		t.Fatalf("Unable to fill db: %s", err)
+	}
+	legacy.Close()
+
+	// Dry-run changes nothing
+	if from, to, err := Migrate(datadir, "granadanet", true); err != nil || from != 0 || to != 5 {
+		t.Fatalf("Unexpected dry-run from %d to %d: %v", from, to, err)
+	}
+
+	if version, endpoint := dbSchemaVersion(t, datadir+DATABASE_FILE); version != 0 || string(endpoint) != "http://legacy:8732" {
+		t.Errorf("Dry-run applied migrations; version %d, endpoint %s", version, endpoint)
+	}
+
+	if backups, _ := filepath.Glob(datadir + "*.bak"); len(backups) != 0 {
+		t.Errorf("Dry-run backed up db: %v", backups)
+	}
+
+	db, err := InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+
+	network, _ := db.GetNetwork()
+	watermark, _ := db.GetBakingWatermark()
+	configs, _ := db.GetRPCEndpointConfigs()
+
+	if network != "granadanet" || watermark != 331391 || len(configs) != 1 || configs[1].Url != "http://legacy:8732" {
+		t.Errorf("Unexpected network %s, watermark %d, endpoints %+v", network, watermark, configs)
+	}
+
+	db.Close()
+
+	if version, endpoint := dbSchemaVersion(t, datadir+DATABASE_FILE); version != 5 || string(endpoint) != `{"url":"http://legacy:8732"}` {
+		t.Errorf("Unexpected version %d, endpoint %s", version, endpoint)
+	}
+
+	backups, _ := filepath.Glob(datadir + DATABASE_FILE + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup; found %v", backups)
+	}
+
+	if version, endpoint := dbSchemaVersion(t, backups[0]); version != 0 || string(endpoint) != "http://legacy:8732" {
+		t.Errorf("Backup is not of the db before migration; version %d, endpoint %s", version, endpoint)
+	}
+
+	if db, err := InitStorage(datadir, "mainnet"); err == nil {
+		db.Close()
+		t.Errorf("Opened granadanet db for mainnet")
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+
+	datadir := t.TempDir() + "/"
+
+	db, err := InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	db.Close()
+
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+
+	migrations = append(append([]migration(nil), saved...), migration{6, "Broken", func(tx *bolt.Tx, network string) error {
+		if err := tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(NETWORK), []byte("broken")); err != nil {
+			return err
+		}
+		return errors.New("Broken")
+	}})
+
+	if db, err := InitStorage(datadir, "granadanet"); err == nil {
+		db.Close()
+		t.Fatalf("Broken migration applied")
+	}
+
+	if version, _ := dbSchemaVersion(t, datadir+DATABASE_FILE); version != 5 {
+		t.Errorf("Unexpected version %d after failed migration", version)
+	}
+
+	migrations = saved
+
+	db, err = InitStorage(datadir, "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage after failed migration: %s", err)
+	}
+
+	// Rolled back
+	if network, _ := db.GetNetwork(); network != "granadanet" {
+		t.Errorf("Failed migration changed network to %s", network)
+	}
+
+	// As if migrated by a newer version
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(SCHEMA_VERSION), itob(99))
+	}); err != nil {
+		t.Fatalf("Unable to set version: %s", err)
+	}
+	db.Close()
+
+	if db, err := InitStorage(datadir, "granadanet"); err == nil {
+		db.Close()
+		t.Errorf("Opened db of a newer schema version")
+	}
+}
@@ -19,7 +19,7 @@ func (s *Storage) SaveNonce(cycle int, n nonce.Nonce) error {
 		return errors.Wrap(err, "Unable to marshal nonce")
 	}
 
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		cb, err := tx.Bucket([]byte(NONCE_BUCKET)).CreateBucketIfNotExists(itob(cycle))
 		if err != nil {
 			return errors.Wrap(err, "Unable to create nonce-cycle bucket")
@@ -33,7 +33,7 @@ func (s *Storage) GetNoncesForCycle(cycle int) ([]nonce.Nonce, error) {
 	// Get back all nonces for cycle
 	var nonces []nonce.Nonce
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		cb, err := tx.Bucket([]byte(NONCE_BUCKET)).CreateBucketIfNotExists(itob(cycle))
 		if err != nil {
 			return errors.Wrap(err, "Unable to create nonce-cycle bucket")
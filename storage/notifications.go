@@ -9,7 +9,7 @@ func (s *Storage) GetNotifiersConfig(notifier string) ([]byte, error) {
 
 	var config []byte
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(NOTIFICATIONS_BUCKET))
 		if b == nil {
 			return errors.New("Unable to locate notifications bucket")
@@ -25,7 +25,7 @@ func (s *Storage) GetNotifiersConfig(notifier string) ([]byte, error) {
 
 func (s *Storage) SaveNotifiersConfig(notifier string, config []byte) error {
 
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CONFIG_BUCKET)).Bucket([]byte(NOTIFICATIONS_BUCKET))
 		if b == nil {
 			return errors.New("Unable to locate notifications bucket")
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HISTORY_ARCHIVE_FILE = "history-archive.jsonl"
+	COMPACT_TX_SIZE      = 4 * 1024 * 1024
+)
+
+// How long each kind of data is kept, in cycles before the current one. Rights
+// are only needed ahead of head; nonces until they are revealed, in the cycle after
+// theirs. Bakes, endorsements and their history are kept forever, unless archived,
+// in which case those older than HistoryCycles are moved to HISTORY_ARCHIVE_FILE.
+type Retention struct {
+	RightsCycles   int  `json:"rightsCycles"`
+	NonceCycles    int  `json:"nonceCycles"`
+	ArchiveHistory bool `json:"archiveHistory"`
+	HistoryCycles  int  `json:"historyCycles"`
+}
+
+func DefaultRetention() Retention {
+	return Retention{
+		RightsCycles:  2,
+		NonceCycles:   5,
+		HistoryCycles: 10,
+	}
+}
+
+// Set from main at startup
+var retention = DefaultRetention()
+
+func SetRetention(r Retention) {
+	retention = r
+}
+
+func GetRetention() Retention {
+	return retention
+}
+
+// One bake, endorsement or nonce reveal, as archived; with its history entry, if one was kept
+type ArchivedOperation struct {
+	Kind  string        `json:"kind"`
+	Level int           `json:"level"`
+	Hash  string        `json:"hash"`
+	Entry *HistoryEntry `json:"entry,omitempty"`
+}
+
+// As exported; known only by level and hash if archived without an entry
+func (op ArchivedOperation) HistoryEntry() HistoryEntry {
+
+	if op.Entry != nil {
+		return *op.Entry
+	}
+
+	return unknownEntry(op.Kind, op.Level, op.Hash)
+}
+
+// PruneRights deletes baking and endorsing rights below level. The sequences,
+// the highest cycles fetched, are kept.
+func (s *Storage) PruneRights(beforeLevel int) (int, error) {
+
+	var pruned int
+
+	err := s.update(func(tx *bolt.Tx) error {
+
+		for _, name := range []string{ENDORSING_RIGHTS_BUCKET, BAKING_RIGHTS_BUCKET} {
+
+			b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(name))
+			if b == nil {
+				continue
+			}
+
+			n, err := deleteBelow(b, beforeLevel)
+			if err != nil {
+				return err
+			}
+			pruned += n
+		}
+
+		return nil
+	})
+
+	return pruned, err
+}
+
+// PruneNonces deletes the nonces of cycles below cycle, revealed or not
+func (s *Storage) PruneNonces(beforeCycle int) (int, error) {
+
+	var pruned int
+
+	err := s.update(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(NONCE_BUCKET))
+
+		var cycles [][]byte
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && btoi(k) < beforeCycle; k, v = c.Next() {
+			if v == nil {
+				cycles = append(cycles, append([]byte(nil), k...))
+			}
+		}
+
+		for _, k := range cycles {
+			pruned += b.Bucket(k).Stats().KeyN
+			if err := b.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return pruned, err
+}
+
+// ArchiveHistory moves bakes, endorsements and history entries below level from the DB
+// to the end of the archive file. The watermarks, bucket sequences, are kept.
+func (s *Storage) ArchiveHistory(beforeLevel int) (int, error) {
+
+	var archived int
+
+	err := s.update(func(tx *bolt.Tx) error {
+
+		var ops []ArchivedOperation
+
+		// Index in ops, by kind and level
+		index := make(map[string]map[int]int)
+
+		for _, h := range []struct{ kind, bucket string }{
+			{HISTORY_BAKE, BAKING_BUCKET}, {HISTORY_ENDORSEMENT, ENDORSING_BUCKET},
+		} {
+			index[h.kind] = make(map[int]int)
+
+			c := tx.Bucket([]byte(h.bucket)).Cursor()
+			for k, v := c.First(); k != nil && btoi(k) < beforeLevel; k, v = c.Next() {
+				index[h.kind][btoi(k)] = len(ops)
+				ops = append(ops, ArchivedOperation{Kind: h.kind, Level: btoi(k), Hash: string(v)})
+			}
+		}
+
+		// History entries go with their operation; nonce reveals on their own
+		var historyBuckets []*bolt.Bucket
+
+		for _, kind := range historyKinds {
+
+			b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(kind))
+			if b == nil {
+				continue
+			}
+			historyBuckets = append(historyBuckets, b)
+
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && btoi(k) < beforeLevel; k, v = c.Next() {
+
+				e := new(HistoryEntry)
+				if err := json.Unmarshal(v, e); err != nil {
+					return errors.Wrap(err, "Unable to unmarshal history entry")
+				}
+
+				if i, ok := index[kind][e.Level]; ok {
+					ops[i].Entry = e
+					continue
+				}
+
+				ops = append(ops, ArchivedOperation{Kind: kind, Level: e.Level, Hash: e.Hash, Entry: e})
+			}
+		}
+
+		if len(ops) == 0 {
+			return nil
+		}
+
+		// Written before deleting; if this fails, nothing is deleted
+		if err := appendArchive(filepath.Join(s.datadir, HISTORY_ARCHIVE_FILE), ops); err != nil {
+			return errors.Wrap(err, "Unable to write history archive")
+		}
+
+		for _, name := range []string{BAKING_BUCKET, ENDORSING_BUCKET} {
+			historyBuckets = append(historyBuckets, tx.Bucket([]byte(name)))
+		}
+
+		for _, b := range historyBuckets {
+			if _, err := deleteBelow(b, beforeLevel); err != nil {
+				return err
+			}
+		}
+
+		archived = len(ops)
+
+		return nil
+	})
+
+	return archived, err
+}
+
+func appendArchive(file string, ops []ArchivedOperation) error {
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// GetArchivedHistory reads back the archive file; empty if nothing was archived
+func (s *Storage) GetArchivedHistory() ([]ArchivedOperation, error) {
+
+	b, err := os.ReadFile(filepath.Join(s.datadir, HISTORY_ARCHIVE_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read history archive")
+	}
+
+	var ops []ArchivedOperation
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var op ArchivedOperation
+		if err := dec.Decode(&op); err != nil {
+			return ops, errors.Wrap(err, "Unable to decode history archive")
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// Deletes keys, levels, below level
+func deleteBelow(b *bolt.Bucket, level int) (int, error) {
+
+	var keys [][]byte
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && btoi(k) < level; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+// Size of the DB file, and of the free pages within it, which compaction returns
+func (s *Storage) GetDatabaseSize() (int64, int64, error) {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var size int64
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	stats := s.db.Stats()
+	free := int64(stats.FreePageN+stats.PendingPageN) * int64(s.db.Info().PageSize)
+
+	return size, free, nil
+}
+
+// Compact rewrites the DB without its free pages, and swaps it in while
+// BakinBacon runs; transactions wait meanwhile. Returns the sizes before and after.
+func (s *Storage) Compact() (int64, int64, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dbFile := s.db.Path()
+	compactFile := dbFile + ".compact"
+
+	before, err := fileSize(dbFile)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dst, err := bolt.Open(compactFile, 0600, nil)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "Unable to create compacted db")
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return compactCopy(dst, tx)
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(compactFile)
+		return 0, 0, errors.Wrap(err, "Unable to compact db")
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(compactFile)
+		return 0, 0, errors.Wrap(err, "Unable to close db")
+	}
+
+	// If the rename fails, the original DB is reopened
+	renameErr := os.Rename(compactFile, dbFile)
+	if renameErr != nil {
+		os.Remove(compactFile)
+	}
+
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		log.WithError(err).Error("Unable to reopen db after compaction")
+		return 0, 0, errors.Wrap(err, "Unable to reopen db")
+	}
+	s.db = db
+
+	if renameErr != nil {
+		return 0, 0, errors.Wrap(renameErr, "Unable to replace db")
+	}
+
+	after, _ := fileSize(dbFile)
+
+	return before, after, nil
+}
+
+// Copies every bucket, nested buckets and sequences of src into dst, in
+// transactions of up to COMPACT_TX_SIZE bytes
+func compactCopy(dst *bolt.DB, src *bolt.Tx) error {
+
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+
+	// Keys of the buckets leading to the current one
+	var walk func(path [][]byte, b *bolt.Bucket) error
+
+	// Bucket of dst at path, in the current transaction
+	dstBucket := func(path [][]byte) *bolt.Bucket {
+		b := tx.Bucket(path[0])
+		for _, k := range path[1:] {
+			b = b.Bucket(k)
+		}
+		return b
+	}
+
+	walk = func(path [][]byte, b *bolt.Bucket) error {
+
+		if err := dstBucket(path).SetSequence(b.Sequence()); err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+
+			// Start a new transaction when this one is large enough
+			if size += int64(len(k) + len(v)); size > COMPACT_TX_SIZE {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				if tx, err = dst.Begin(true); err != nil {
+					return err
+				}
+				size = 0
+			}
+
+			sub := append(append([][]byte(nil), path...), k)
+
+			if v == nil {
+				if _, err := dstBucket(path).CreateBucket(k); err != nil {
+					return err
+				}
+				return walk(sub, b.Bucket(k))
+			}
+
+			return dstBucket(path).Put(k, v)
+		})
+	}
+
+	if err := src.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+		return walk([][]byte{name}, b)
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func fileSize(file string) (int64, error) {
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to stat db")
+	}
+
+	return fi.Size(), nil
+}
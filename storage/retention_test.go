@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/nonce"
+)
+
+func TestRetention(t *testing.T) {
+
+	db, err := InitStorage(t.TempDir()+"/", "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	// Cycles 80 and 81 of granadanet, in order
+	for _, c := range []struct{ cycle, level int }{{80, 327680}, {81, 331776}} {
+
+		cycle, level := c.cycle, c.level
+
+		if err := db.SaveBakingRightsForCycle(cycle, []rpc.BakingRights{{Level: level, Priority: 1}}); err != nil {
+			t.Fatalf("Unable to save baking rights: %s", err)
+		}
+
+		if err := db.SaveEndorsingRightsForCycle(cycle, []rpc.EndorsingRights{{Level: level}}); err != nil {
+			t.Fatalf("Unable to save endorsing rights: %s", err)
+		}
+
+		if err := db.SaveNonce(cycle, nonce.Nonce{Level: level}); err != nil {
+			t.Fatalf("Unable to save nonce: %s", err)
+		}
+
+		if err := db.RecordBakedBlock(level, "BLbake"); err != nil {
+			t.Fatalf("Unable to record bake: %s", err)
+		}
+
+		if err := db.RecordEndorsement(level, "onendorse"); err != nil {
+			t.Fatalf("Unable to record endorsement: %s", err)
+		}
+	}
+
+	// Large enough to leave free pages once deleted
+	if err := db.SaveNotifiersConfig("telegram", bytes.Repeat([]byte("a"), 1024*1024)); err != nil {
+		t.Fatalf("Unable to save config: %s", err)
+	}
+
+	if n, err := db.PruneRights(331776); err != nil || n != 2 {
+		t.Errorf("Expected 2 rights pruned; got %d, %v", n, err)
+	}
+
+	// Only the rights of cycle 81 remain; the highest fetched cycle is kept
+	if level, highest, err := db.GetNextEndorsingRight(0); err != nil || level != 331776 || highest != 81 {
+		t.Errorf("Unexpected next endorsing right %d, highest cycle %d, %v", level, highest, err)
+	}
+
+	if n, err := db.PruneNonces(81); err != nil || n != 1 {
+		t.Errorf("Expected 1 nonce pruned; got %d, %v", n, err)
+	}
+
+	if nonces, _ := db.GetNoncesForCycle(81); len(nonces) != 1 {
+		t.Errorf("Expected nonces of cycle 81 kept; got %d", len(nonces))
+	}
+
+	// Details of the bake of cycle 80, and its nonce reveal
+	bake := HistoryEntry{Kind: HISTORY_BAKE, Level: 327680, Cycle: 80, Hash: "BLbake", Rewards: 40000000, Status: HISTORY_INCLUDED}
+	reveal := HistoryEntry{Kind: HISTORY_NONCE_REVEAL, Level: 327680, Cycle: 80, Hash: "onreveal", Status: HISTORY_INJECTED}
+
+	for _, e := range []HistoryEntry{bake, reveal} {
+		if err := db.SaveHistoryEntry(e); err != nil {
+			t.Fatalf("Unable to save history: %s", err)
+		}
+	}
+
+	if n, err := db.ArchiveHistory(331776); err != nil || n != 3 {
+		t.Errorf("Expected 3 operations archived; got %d, %v", n, err)
+	}
+
+	archived, err := db.GetArchivedHistory()
+	if err != nil || len(archived) != 3 || archived[0].HistoryEntry() != bake ||
+		archived[1] != (ArchivedOperation{HISTORY_ENDORSEMENT, 327680, "onendorse", nil}) || archived[2].HistoryEntry() != reveal {
+		t.Errorf("Unexpected archive %v, %v", archived, err)
+	}
+
+	// Only cycle 81 is left in the DB; the archive fills in cycle 80
+	history, _ := db.GetHistory()
+	if len(history) != 2 {
+		t.Errorf("Unexpected history after archiving %v", history)
+	}
+
+	if merged := MergeArchivedHistory(history, archived); len(merged) != 5 || merged[0] != bake || merged[2] != reveal {
+		t.Errorf("Unexpected merged history %v", merged)
+	}
+
+	if level, _, _ := db.GetRecentBake(); level != 331776 {
+		t.Errorf("Expected recent bake kept; got %d", level)
+	}
+
+	if err := db.SaveNotifiersConfig("telegram", []byte("{}")); err != nil {
+		t.Fatalf("Unable to save config: %s", err)
+	}
+
+	size, free, err := db.GetDatabaseSize()
+	if err != nil || free == 0 || free > size {
+		t.Fatalf("Unexpected size %d, free %d, %v", size, free, err)
+	}
+
+	before, after, err := db.Compact()
+	if err != nil {
+		t.Fatalf("Unable to compact: %s", err)
+	}
+
+	if after >= before {
+		t.Errorf("Compaction did not shrink database: %d to %d", before, after)
+	}
+
+	// Still open, with data, watermarks and nested buckets intact
+	bakingWatermark, _ := db.GetBakingWatermark()
+	endorsingWatermark, _ := db.GetEndorsingWatermark()
+
+	if bakingWatermark != 331776 || endorsingWatermark != 331776 {
+		t.Errorf("Unexpected watermarks after compaction %d, %d", bakingWatermark, endorsingWatermark)
+	}
+
+	if level, _, highest, _ := db.GetNextBakingRight(0); level != 331776 || highest != 81 {
+		t.Errorf("Unexpected next baking right %d, highest cycle %d after compaction", level, highest)
+	}
+
+	if nonces, _ := db.GetNoncesForCycle(81); len(nonces) != 1 {
+		t.Errorf("Expected nonces after compaction; got %d", len(nonces))
+	}
+
+	if network, _ := db.GetNetwork(); network != "granadanet" {
+		t.Errorf("Unexpected network after compaction %q", network)
+	}
+
+	if err := db.RecordBakedBlock(331777, "BLnext"); err != nil {
+		t.Errorf("Unable to write after compaction: %s", err)
+	}
+}
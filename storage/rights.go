@@ -18,7 +18,7 @@ const (
 
 func (s *Storage) SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.EndorsingRights) error {
 
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 
 		b, err := tx.Bucket([]byte(RIGHTS_BUCKET)).CreateBucketIfNotExists([]byte(ENDORSING_RIGHTS_BUCKET))
 		if err != nil {
@@ -43,7 +43,7 @@ func (s *Storage) SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.E
 
 func (s *Storage) SaveBakingRightsForCycle(cycle int, bakingRights []rpc.BakingRights) error {
 
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 
 		b, err := tx.Bucket([]byte(RIGHTS_BUCKET)).CreateBucketIfNotExists([]byte(BAKING_RIGHTS_BUCKET))
 		if err != nil {
@@ -77,7 +77,7 @@ func (s *Storage) GetNextEndorsingRight(curLevel int) (int, int, error) {
 
 	curLevelBytes := itob(curLevel)
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(ENDORSING_RIGHTS_BUCKET))
 		if b == nil {
@@ -119,7 +119,7 @@ func (s *Storage) GetNextBakingRight(curLevel int) (int, int, int, error) {
 
 	curLevelBytes := itob(curLevel)
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(BAKING_RIGHTS_BUCKET))
 		if b == nil {
@@ -158,7 +158,7 @@ func (s *Storage) GetRecentEndorsement() (int, string, error) {
 		recentEndorsementHash  string = ""
 	)
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(ENDORSING_BUCKET))
 		if b == nil {
@@ -186,7 +186,7 @@ func (s *Storage) GetRecentBake() (int, string, error) {
 		recentBakeHash  string = ""
 	)
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(BAKING_BUCKET))
 		if b == nil {
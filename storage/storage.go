@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/binary"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,78 +21,82 @@ const (
 	RIGHTS_BUCKET        = "rights"
 	ENDPOINTS_BUCKET     = "endpoints"
 	NOTIFICATIONS_BUCKET = "notifs"
+	AUDIT_BUCKET         = "audit"
+	HISTORY_BUCKET       = "history"
+
+	// Only the watermark, in the bucket sequence; preendorsements are not recorded
+	PREENDORSING_BUCKET = "preendorses"
 )
 
 type Storage struct {
-	db *bolt.DB
-}
+	db      *bolt.DB
+	datadir string
 
-var DB Storage
+	// Held for writing only while the DB file is swapped, ie: by Compact
+	lock sync.RWMutex
+}
 
-func InitStorage(datadir, network string) error {
+// Opens, or creates, the DB in datadir. The Storage returned is passed to
+// whatever needs it; there is no package-level DB.
+func InitStorage(datadir, network string) (*Storage, error) {
 
 	db, err := bolt.Open(datadir+DATABASE_FILE, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDatabaseInUse
+	}
 	if err != nil {
-		return errors.Wrap(err, "Failed to init db")
+		return nil, errors.Wrap(err, "Failed to init db")
 	}
 
-	// Ensure some buckets exist, and migrations
-	err = db.Update(func(tx *bolt.Tx) error {
-
-		// Config bucket
-		cfgBkt, err := tx.CreateBucketIfNotExists([]byte(CONFIG_BUCKET))
-		if err != nil {
-			return errors.Wrap(err, "Cannot create config bucket")
-		}
-
-		// Nested bucket inside config
-		if _, err := cfgBkt.CreateBucketIfNotExists([]byte(ENDPOINTS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create endpoints bucket")
-		}
-
-		// Nested bucket inside config
-		if _, err := cfgBkt.CreateBucketIfNotExists([]byte(NOTIFICATIONS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create notifications bucket")
-		}
-
-		//
-		// Root buckets
-		if _, err := tx.CreateBucketIfNotExists([]byte(ENDORSING_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create endorsing bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(BAKING_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create baking bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(NONCE_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create nonce bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(RIGHTS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create rights bucket")
-		}
-
-		return nil
-	})
-	if err != nil {
-		return err
+	// Bring the layout up to date; creates the buckets of a new DB
+	if _, _, err := migrate(db, datadir, network, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Storage{
+		db:      db,
+		datadir: datadir,
 	}
 
-	// set variable so main program can access
-	DB = Storage{
-		db: db,
+	// A DB keeps the watermarks and endpoints of one network
+	if dbNetwork, err := s.GetNetwork(); err != nil || dbNetwork != network {
+		db.Close()
+		return nil, errors.Errorf("Database is for network %q, not %s", dbNetwork, network)
 	}
 
 	// Add the default endpoints only on brand new setup
-	return DB.AddDefaultEndpoints(network)
+	if err := s.AddDefaultEndpoints(network); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
 }
 
 func (s *Storage) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.db.Close()
 	log.Info("Database closed")
 }
 
+// All transactions go through view and update, so the DB can be swapped
+func (s *Storage) view(fn func(*bolt.Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.db.View(fn)
+}
+
+func (s *Storage) update(fn func(*bolt.Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.db.Update(fn)
+}
+
 // itob returns an 8-byte big endian representation of v.
 func itob(v int) []byte {
 	b := make([]byte, 8)
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/nonce"
+)
+
+// Store is what BakinBacon keeps between runs. Storage, a bolt DB file, is used
+// when baking; MemoryStorage, for tests, keeps nothing between runs.
+type Store interface {
+	ConfigStore
+	WatermarkStore
+	RightsStore
+	NonceStore
+	NotificationsStore
+	AuditStore
+	HistoryStore
+
+	Close()
+}
+
+// Delegate, signer, RPC endpoints and consensus keys
+type ConfigStore interface {
+	GetNetwork() (string, error)
+
+	GetDelegate() (string, string, error)
+	SetDelegate(sk, pkh string) error
+	SetDelegatePkh(pkh string) error
+	GetSignerType() (int, error)
+	SetSignerType(d int) error
+	GetSignerSk() (string, error)
+	SetSignerSk(sk string) error
+	SaveLedgerToDB(pkh, bipPath string, ledgerType int) error
+	GetLedgerConfig() (string, string, error)
+
+	AddRPCEndpoint(endpoint RPCEndpoint) (int, error)
+	GetRPCEndpoints() (map[int]string, error)
+	GetRPCEndpointConfigs() (map[int]RPCEndpoint, error)
+	UpdateRPCEndpoint(endpointId int, endpoint RPCEndpoint) error
+	DeleteRPCEndpoint(endpointId int) error
+	AddDefaultEndpoints(network string) error
+
+	GetConsensusKeys() (ConsensusKey, ConsensusKey, error)
+	SetPendingConsensusKey(ck ConsensusKey) error
+	ActivatePendingConsensusKey() error
+}
+
+// Watermarks, and the history of bakes and endorsements they come with
+type WatermarkStore interface {
+	GetBakingWatermark() (int, error)
+	GetEndorsingWatermark() (int, error)
+	RecordBakedBlock(level int, blockHash string) error
+	RecordEndorsement(level int, endorsementHash string) error
+	CheckAndSetBakingWatermark(level int) error
+	CheckAndSetEndorsingWatermark(level int) error
+	GetPreendorsingWatermark() (int, error)
+	CheckAndSetPreendorsingWatermark(level int) error
+	GetRecentBake() (int, string, error)
+	GetRecentEndorsement() (int, string, error)
+
+	GetRecoveryState() (string, error)
+	SetRecoveryState(state string) error
+	RecoverBakedBlock(level int, blockHash string) error
+	RecoverEndorsement(level int, endorsementHash string) error
+}
+
+type RightsStore interface {
+	SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.EndorsingRights) error
+	SaveBakingRightsForCycle(cycle int, bakingRights []rpc.BakingRights) error
+	GetNextEndorsingRight(curLevel int) (int, int, error)
+	GetNextBakingRight(curLevel int) (int, int, int, error)
+	PruneRights(beforeLevel int) (int, error)
+}
+
+type NonceStore interface {
+	SaveNonce(cycle int, n nonce.Nonce) error
+	GetNoncesForCycle(cycle int) ([]nonce.Nonce, error)
+	PruneNonces(beforeCycle int) (int, error)
+}
+
+type NotificationsStore interface {
+	GetNotifiersConfig(notifier string) ([]byte, error)
+	SaveNotifiersConfig(notifier string, config []byte) error
+}
+
+type AuditStore interface {
+	AddAuditEntry(e AuditEntry) error
+	GetAuditEntries() ([]AuditEntry, error)
+}
+
+type HistoryStore interface {
+	SaveHistoryEntry(e HistoryEntry) error
+	GetHistory() ([]HistoryEntry, error)
+	GetPendingHistory(sinceLevel int) ([]HistoryEntry, error)
+}
+
+// Maintainer is implemented by stores kept in a file, ie: Storage
+type Maintainer interface {
+	Backup(w io.Writer, passphrase string) (int64, error)
+	GetDatabaseSize() (int64, int64, error)
+	Compact() (int64, int64, error)
+	ArchiveHistory(beforeLevel int) (int, error)
+	GetArchivedHistory() ([]ArchivedOperation, error)
+	GetBackupSignerKeys() (map[string]string, error)
+}
+
+var (
+	_ Store      = (*Storage)(nil)
+	_ Maintainer = (*Storage)(nil)
+	_ Store      = (*MemoryStorage)(nil)
+)
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/nonce"
+)
+
+// Both stores must behave the same; tests elsewhere use MemoryStorage in
+// place of the bolt DB
+func TestStores(t *testing.T) {
+
+	bolt, err := InitStorage(t.TempDir()+"/", "granadanet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	t.Cleanup(bolt.Close)
+
+	for name, db := range map[string]Store{
+		"bolt":   bolt,
+		"memory": NewMemoryStorage("granadanet"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, db)
+		})
+	}
+}
+
+func testStore(t *testing.T, db Store) {
+
+	if network, _ := db.GetNetwork(); network != "granadanet" {
+		t.Errorf("Unexpected network %q", network)
+	}
+
+	// Watermarks only go up
+	if err := db.CheckAndSetBakingWatermark(100); err != nil {
+		t.Fatalf("Unable to set watermark: %s", err)
+	}
+
+	if err := db.CheckAndSetBakingWatermark(100); err == nil {
+		t.Errorf("Watermark set twice at the same level")
+	}
+
+	if err := db.RecordEndorsement(90, "onfirst"); err != nil {
+		t.Fatalf("Unable to record endorsement: %s", err)
+	}
+
+	if err := db.RecordEndorsement(91, "onsecond"); err != nil {
+		t.Fatalf("Unable to record endorsement: %s", err)
+	}
+
+	if level, hash, _ := db.GetRecentEndorsement(); level != 91 || hash != "onsecond" {
+		t.Errorf("Unexpected recent endorsement %d %s", level, hash)
+	}
+
+	if watermark, _ := db.GetEndorsingWatermark(); watermark != 91 {
+		t.Errorf("Unexpected endorsing watermark %d", watermark)
+	}
+
+	// Preendorsements are watermarked apart from endorsements of the same level
+	if err := db.CheckAndSetPreendorsingWatermark(91); err != nil {
+		t.Errorf("Unable to set preendorsing watermark: %s", err)
+	}
+
+	if err := db.CheckAndSetPreendorsingWatermark(91); err == nil {
+		t.Errorf("Preendorsing watermark set twice at the same level")
+	}
+
+	// Rights
+	if _, _, err := db.GetNextEndorsingRight(0); err == nil {
+		t.Errorf("Expected error before rights are fetched")
+	}
+
+	if err := db.SaveBakingRightsForCycle(2, []rpc.BakingRights{{Level: 8200, Priority: 1}, {Level: 8300, Priority: 0}}); err != nil {
+		t.Fatalf("Unable to save baking rights: %s", err)
+	}
+
+	if level, priority, highest, err := db.GetNextBakingRight(8200); err != nil || level != 8300 || priority != 0 || highest != 2 {
+		t.Errorf("Unexpected next baking right %d, priority %d, highest cycle %d, %v", level, priority, highest, err)
+	}
+
+	if n, _ := db.PruneRights(8300); n != 1 {
+		t.Errorf("Expected 1 right pruned; got %d", n)
+	}
+
+	// Nonces, by level
+	for _, level := range []int{8300, 8200} {
+		if err := db.SaveNonce(2, nonce.Nonce{Level: level, EncodedNonce: "nce"}); err != nil {
+			t.Fatalf("Unable to save nonce: %s", err)
+		}
+	}
+
+	if nonces, _ := db.GetNoncesForCycle(2); len(nonces) != 2 || nonces[0].Level != 8200 {
+		t.Errorf("Unexpected nonces %v", nonces)
+	}
+
+	// Config
+	if err := db.SetDelegate("edsk", "tz1"); err != nil {
+		t.Fatalf("Unable to set delegate: %s", err)
+	}
+
+	if sk, pkh, _ := db.GetDelegate(); sk != "edsk" || pkh != "tz1" {
+		t.Errorf("Unexpected delegate %s %s", sk, pkh)
+	}
+
+	if err := db.SaveNotifiersConfig("email", []byte(`{"enabled":true}`)); err != nil {
+		t.Fatalf("Unable to save notifier: %s", err)
+	}
+
+	if config, _ := db.GetNotifiersConfig("email"); string(config) != `{"enabled":true}` {
+		t.Errorf("Unexpected notifier config %s", config)
+	}
+
+	id, err := db.AddRPCEndpoint(RPCEndpoint{Url: "http://127.0.0.1:8732"})
+	if err != nil {
+		t.Fatalf("Unable to add endpoint: %s", err)
+	}
+
+	if endpoints, _ := db.GetRPCEndpoints(); endpoints[id] != "http://127.0.0.1:8732" {
+		t.Errorf("Endpoint %d not found in %v", id, endpoints)
+	}
+
+	if err := db.DeleteRPCEndpoint(id); err != nil {
+		t.Errorf("Unable to delete endpoint: %s", err)
+	}
+
+	// History, with the endorsement of 90 and the nonce reveal of 8300 known only by their hash
+	if err := db.SaveNonce(2, nonce.Nonce{Level: 8300, RevealOp: "onreveal"}); err != nil {
+		t.Fatalf("Unable to save nonce: %s", err)
+	}
+
+	for _, e := range []HistoryEntry{
+		{Kind: HISTORY_ENDORSEMENT, Level: 91, Hash: "onsecond", Slots: 2, Status: HISTORY_INCLUDED},
+		{Kind: HISTORY_BAKE, Level: 100, Hash: "BLbake", Priority: 1, Status: HISTORY_PENDING},
+	} {
+		if err := db.SaveHistoryEntry(e); err != nil {
+			t.Fatalf("Unable to save history: %s", err)
+		}
+	}
+
+	history, err := db.GetHistory()
+	if err != nil || len(history) != 4 {
+		t.Fatalf("Unexpected history %v, %v", history, err)
+	}
+
+	if history[0] != (HistoryEntry{Kind: HISTORY_ENDORSEMENT, Level: 90, Hash: "onfirst", Status: HISTORY_UNKNOWN}) ||
+		history[1].Slots != 2 || history[2].Priority != 1 ||
+		history[3] != (HistoryEntry{Kind: HISTORY_NONCE_REVEAL, Level: 8300, Hash: "onreveal", Status: HISTORY_UNKNOWN}) {
+		t.Errorf("Unexpected history %v", history)
+	}
+
+	if pending, _ := db.GetPendingHistory(100); len(pending) != 1 || pending[0].Hash != "BLbake" {
+		t.Errorf("Unexpected pending history %v", pending)
+	}
+
+	if pending, _ := db.GetPendingHistory(101); len(pending) != 0 {
+		t.Errorf("Unexpected pending history before 101 %v", pending)
+	}
+
+	// Recording an earlier level keeps the watermark
+	if err := db.RecordBakedBlock(99, "BLlate"); err != nil {
+		t.Fatalf("Unable to record bake: %s", err)
+	}
+
+	if err := db.RecordEndorsement(89, "onlate"); err != nil {
+		t.Fatalf("Unable to record endorsement: %s", err)
+	}
+
+	if watermark, _ := db.GetBakingWatermark(); watermark != 100 {
+		t.Errorf("Baking watermark lowered to %d", watermark)
+	}
+
+	if watermark, _ := db.GetEndorsingWatermark(); watermark != 91 {
+		t.Errorf("Endorsing watermark lowered to %d", watermark)
+	}
+
+	// Audit log is hash-chained
+	for i := 0; i < 2; i++ {
+		if err := db.AddAuditEntry(AuditEntry{Timestamp: time.Unix(int64(i), 0).UTC(), Result: "signed"}); err != nil {
+			t.Fatalf("Unable to add audit entry: %s", err)
+		}
+	}
+
+	entries, _ := db.GetAuditEntries()
+	if err := VerifyAuditLog(entries); err != nil || len(entries) != 2 {
+		t.Errorf("Unexpected audit log of %d entries: %v", len(entries), err)
+	}
+}
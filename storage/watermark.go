@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"github.com/pkg/errors"
+
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -12,11 +14,15 @@ func (s *Storage) GetEndorsingWatermark() (int, error) {
 	return s.getWatermark(ENDORSING_BUCKET)
 }
 
+func (s *Storage) GetPreendorsingWatermark() (int, error) {
+	return s.getWatermark(PREENDORSING_BUCKET)
+}
+
 func (s *Storage) getWatermark(wBucket string) (int, error) {
 
 	var watermark uint64
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		watermark = tx.Bucket([]byte(wBucket)).Sequence()
 		return nil
 	})
@@ -32,12 +38,74 @@ func (s *Storage) RecordEndorsement(level int, endorsementHash string) error {
 	return s.recordOperation(ENDORSING_BUCKET, level, endorsementHash)
 }
 
+// Saves the level:opHash, and raises the watermark; it is never lowered
 func (s *Storage) recordOperation(opBucket string, level int, opHash string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(opBucket))
-		if err := b.SetSequence(uint64(level)); err != nil { // Record our watermark
-			return err
+		if uint64(level) > b.Sequence() {
+			if err := b.SetSequence(uint64(level)); err != nil {
+				return err
+			}
+		}
+		return b.Put(itob(level), []byte(opHash))
+	})
+}
+
+// Used by the remote signer to atomically check, and raise, the shared high watermark.
+// Returns an error if level is not above the current watermark.
+func (s *Storage) CheckAndSetBakingWatermark(level int) error {
+	return s.checkAndSetWatermark(BAKING_BUCKET, level)
+}
+
+func (s *Storage) CheckAndSetEndorsingWatermark(level int) error {
+	return s.checkAndSetWatermark(ENDORSING_BUCKET, level)
+}
+
+func (s *Storage) CheckAndSetPreendorsingWatermark(level int) error {
+	return s.checkAndSetWatermark(PREENDORSING_BUCKET, level)
+}
+
+func (s *Storage) checkAndSetWatermark(wBucket string, level int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(wBucket))
+		if watermark := int(b.Sequence()); watermark >= level {
+			return errors.Errorf("Level %d is not above watermark %d", level, watermark)
 		}
-		return b.Put(itob(level), []byte(opHash)) // Save the level:opHash
+		return b.SetSequence(uint64(level))
 	})
 }
+
+// Recovery of watermarks and history from chain, after the DB was lost
+const (
+	RECOVERY_STATE   = "recovery"
+	RECOVERY_RUNNING = "running"
+	RECOVERY_DONE    = "done"
+	RECOVERY_SKIPPED = "skipped"
+)
+
+func (s *Storage) GetRecoveryState() (string, error) {
+
+	var state string
+
+	err := s.view(func(tx *bolt.Tx) error {
+		state = string(tx.Bucket([]byte(CONFIG_BUCKET)).Get([]byte(RECOVERY_STATE)))
+		return nil
+	})
+
+	return state, err
+}
+
+func (s *Storage) SetRecoveryState(state string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(RECOVERY_STATE), []byte(state))
+	})
+}
+
+// Saves a bake found on chain; levels can come in any order
+func (s *Storage) RecoverBakedBlock(level int, blockHash string) error {
+	return s.recordOperation(BAKING_BUCKET, level, blockHash)
+}
+
+func (s *Storage) RecoverEndorsement(level int, endorsementHash string) error {
+	return s.recordOperation(ENDORSING_BUCKET, level, endorsementHash)
+}
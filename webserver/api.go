@@ -11,7 +11,6 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconclient"
-	"bakinbacon/storage"
 )
 
 //
@@ -33,18 +32,18 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug("API - GetStatus")
 
-	_, pkh, err := storage.DB.GetDelegate()
+	_, pkh, err := db.GetDelegate()
 	if err != nil {
 		apiError(errors.Wrap(err, "Cannot get delegate"), w)
 		return
 	}
 
 	s := struct {
-		*baconclient.BaconStatus
+		baconclient.StatusSnapshot
 		Delegate string `json:"pkh"`
 		Ts       int64  `json:"ts"`
 	}{
-		baconClient.Status,
+		baconClient.Status.Snapshot(),
 		pkh,
 		time.Now().Unix(),
 	}
@@ -67,7 +66,7 @@ func setDelegate(w http.ResponseWriter, r *http.Request) {
 	pkh := string(body)
 
 	// No esdk if using ledger
-	if err := storage.DB.SetDelegate("", pkh); err != nil {
+	if err := db.SetDelegate("", pkh); err != nil {
 		apiError(errors.Wrap(err, "Cannot set delegate"), w)
 		return
 	}
@@ -76,3 +75,33 @@ func setDelegate(w http.ResponseWriter, r *http.Request) {
 
 	apiReturnOk(w)
 }
+
+//
+// Unlock encrypted software wallet
+func unlockWallet(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - unlockWallet")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for wallet unlock"), w)
+		return
+	}
+
+	if err := baconClient.Signer.UnlockWallet(k["passphrase"]); err != nil {
+		apiError(errors.Wrap(err, "Cannot unlock wallet"), w)
+		return
+	}
+
+	// Update bacon status; non-silent checks
+	_ = baconClient.CanBake(false)
+
+	apiReturnOk(w)
+}
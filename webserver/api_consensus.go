@@ -0,0 +1,118 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Active and pending consensus keys, and whether the protocol supports them
+func getConsensusKeyStatus(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getConsensusKeyStatus")
+
+	supported, err := baconClient.ConsensusKeySupported()
+	if err != nil {
+		apiError(err, w)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"supported": supported,
+		"status":    baconClient.Signer.ConsensusKeyStatus(),
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Generate new consensus key; ?curve=ed25519 (default), secp256k1 or p256
+// Key is held in memory until registered
+func generateConsensusKey(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - generateConsensusKey")
+
+	sk, pkh, err := baconClient.Signer.GenerateConsensusKey(r.URL.Query().Get("curve"))
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot generate consensus key"), w)
+		return
+	}
+
+	// Return back to UI, so the user can back up the secret key
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"edsk": sk,
+		"pkh":  pkh,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Import a secret key to use as consensus key; passphrase only needed for encrypted keys
+func importConsensusKey(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - importConsensusKey")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for consensus key import"), w)
+		return
+	}
+
+	pkh, err := baconClient.Signer.ImportConsensusKey(k["edsk"], k["passphrase"])
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot import consensus key"), w)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"pkh": pkh,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Inject update_consensus_key for the generated, or imported, key. Passphrase encrypts
+// the key in DB; if empty, the wallet passphrase sources are checked.
+func registerConsensusKey(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - registerConsensusKey")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for consensus key registration"), w)
+		return
+	}
+
+	opHash, activationCycle, err := baconClient.RegisterConsensusKey(k["passphrase"])
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot register consensus key"), w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"OpHash": opHash, "ActivationCycle": activationCycle,
+	}).Info("Injected consensus key update")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"ophash":          opHash,
+		"activationCycle": activationCycle,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
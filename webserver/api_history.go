@@ -0,0 +1,74 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Writes bakes, endorsements and nonce reveals of cycles fromCycle to toCycle,
+// all from fromCycle if toCycle is negative, as csv or json
+type HistoryExportFunc func(w io.Writer, format string, fromCycle, toCycle int) error
+
+// Cycles of levels are network constants, known to main, which sets this
+var historyExport HistoryExportFunc
+
+func SetHistoryExport(f HistoryExportFunc) {
+	historyExport = f
+}
+
+// Baking, endorsing and nonce reveal history, for accounting
+func exportHistory(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - exportHistory")
+
+	if historyExport == nil {
+		apiError(errors.New("History export not available"), w)
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	contentTypes := map[string]string{"csv": "text/csv", "json": "application/json"}
+	if contentTypes[format] == "" {
+		apiError(errors.Errorf("Unknown export format %q; csv or json", format), w)
+		return
+	}
+
+	fromCycle, toCycle := 0, -1
+
+	if v := query.Get("from_cycle"); v != "" {
+		c, err := strconv.Atoi(v)
+		if err != nil {
+			apiError(errors.Wrap(err, "Cannot parse from_cycle"), w)
+			return
+		}
+		fromCycle = c
+	}
+
+	if v := query.Get("to_cycle"); v != "" {
+		c, err := strconv.Atoi(v)
+		if err != nil {
+			apiError(errors.Wrap(err, "Cannot parse to_cycle"), w)
+			return
+		}
+		toCycle = c
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", "attachment; filename=bakinbacon-history."+format)
+
+	// Headers are sent with the first bytes; an error after that can only be logged
+	if err := historyExport(w, format, fromCycle, toCycle); err != nil {
+		log.WithError(err).Error("API exportHistory")
+	}
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconsigner"
+)
+
+// Ledger high watermarks, along with BakinBacon's own, so the UI can show any drift
+func getLedgerWatermarks(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getLedgerWatermarks")
+
+	if baconClient.Signer.SignerType != baconsigner.SIGNER_LEDGER {
+		if err := json.NewEncoder(w).Encode(map[string]bool{
+			"ledger": false,
+		}); err != nil {
+			log.WithError(err).Error("UI Return Encode Failure")
+		}
+		return
+	}
+
+	device, err := baconClient.Signer.LedgerWatermarks()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot read ledger high watermark"), w)
+		return
+	}
+
+	bakingWatermark, err := db.GetBakingWatermark()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get baking watermark"), w)
+		return
+	}
+
+	endorsingWatermark, err := db.GetEndorsingWatermark()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endorsing watermark"), w)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"ledger":    true,
+		"device":    device,
+		"baking":    bakingWatermark,
+		"endorsing": endorsingWatermark,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Set the ledger high watermark; blocks until the user confirms, or rejects, on the device
+func setLedgerWatermark(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - setLedgerWatermark")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k struct {
+		Level int `json:"level"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for high watermark"), w)
+		return
+	}
+
+	if err := baconClient.Signer.SetLedgerWatermark(k.Level); err != nil {
+		apiError(errors.Wrap(err, "Cannot set ledger high watermark"), w)
+		return
+	}
+
+	apiReturnOk(w)
+}
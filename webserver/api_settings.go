@@ -2,13 +2,17 @@ package webserver
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/baconclient"
 	"bakinbacon/notifications"
 	"bakinbacon/storage"
 )
@@ -51,11 +55,14 @@ func getSettings(w http.ResponseWriter, r *http.Request) {
 	log.Trace("API - getSettings")
 
 	// Get RPC endpoints
-	endpoints, err := storage.DB.GetRPCEndpoints()
+	endpoints, err := db.GetRPCEndpoints()
 	if err != nil {
 		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
 		return
 	}
+	for id, e := range endpoints {
+		endpoints[id] = storage.RedactURL(e)
+	}
 	log.WithField("Endpoints", endpoints).Debug("API Settings Endpoints")
 
 	// Get Notification settings
@@ -80,7 +87,10 @@ func addEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	log.Trace("API - addEndpoint")
 
-	var k map[string]string
+	var k struct {
+		Rpc     string              `json:"rpc"`
+		Options storage.RPCEndpoint `json:"options"`
+	}
 
 	err := json.NewDecoder(r.Body).Decode(&k)
 	if err != nil {
@@ -88,19 +98,44 @@ func addEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	endpoint := k.Options
+	endpoint.Url = k.Rpc
+	endpoint.Disabled = false
+
+	if !storage.ValidRole(endpoint.Role) {
+		apiError(errors.Errorf("Unknown endpoint role %q", endpoint.Role), w)
+		return
+	}
+
+	// Refuse endpoints of another network, or running an unknown protocol
+	if err := baconclient.ValidateEndpoint(endpoint); err != nil {
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(k.Rpc)).Error("API AddEndpoint")
+		apiError(errors.Wrap(err, "Cannot add endpoint"), w)
+
+		return
+	}
+
 	// Save new RPC to db to get id
-	id, err := storage.DB.AddRPCEndpoint(k["rpc"])
+	id, err := db.AddRPCEndpoint(endpoint)
 	if err != nil {
-		log.WithError(err).WithField("Endpoint", k).Error("API AddEndpoint")
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(k.Rpc)).Error("API AddEndpoint")
 		apiError(errors.Wrap(err, "Cannot add endpoint to DB"), w)
 
 		return
 	}
 
+	if id == 0 {
+		apiError(errors.New("Endpoint already added"), w)
+		return
+	}
+
 	// Init new bacon watcher for this RPC
-	baconClient.AddRpc(id, k["rpc"])
+	if err := baconClient.AddRpc(id, endpoint); err != nil {
+		apiError(errors.Wrap(err, "Cannot connect endpoint"), w)
+		return
+	}
 
-	log.WithField("Endpoint", k["rpc"]).Debug("API Added Endpoint")
+	log.WithField("Endpoint", storage.RedactURL(k.Rpc)).Debug("API Added Endpoint")
 
 	apiReturnOk(w)
 }
@@ -109,16 +144,32 @@ func listEndpoints(w http.ResponseWriter, r *http.Request) {
 
 	log.Trace("API - listEndpoints")
 
-	endpoints, err := storage.DB.GetRPCEndpoints()
+	configs, err := db.GetRPCEndpointConfigs()
 	if err != nil {
 		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
 		return
 	}
 
+	endpoints := make(map[int]string, len(configs))
+	options := make(map[int]storage.RPCEndpoint, len(configs))
+	disabled := make([]int, 0)
+
+	for id, e := range configs {
+		options[id] = e.Redacted()
+		endpoints[id] = options[id].Url
+		if e.Disabled {
+			disabled = append(disabled, id)
+		}
+	}
+
 	log.WithField("Endpoints", endpoints).Debug("API List Endpoints")
 
-	if err := json.NewEncoder(w).Encode(map[string]map[int]string{
+	// Score, circuit breaker and head latency of each endpoint, by id
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
 		"endpoints": endpoints,
+		"options":   options,
+		"disabled":  disabled,
+		"health":    baconClient.EndpointHealth(),
 	}); err != nil {
 		log.WithError(err).Error("UI Return Encode Failure")
 	}
@@ -136,18 +187,26 @@ func deleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Need to shutdown the RPC client first
-	if e := baconClient.ShutdownRpc(k["rpc"]); e != nil {
-		log.WithError(e).WithField("Endpoint", k).Error("API DeleteEndpoint")
-		apiError(errors.Wrap(err, "Cannot shutdown RPC client for deletion"), w)
-
+	endpoints, err := db.GetRPCEndpointConfigs()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
 		return
 	}
 
+	// Need to shutdown the RPC client first; disabled endpoints are not running
+	if !endpoints[k["rpc"]].Disabled {
+		if e := baconClient.ShutdownRpc(k["rpc"]); e != nil {
+			log.WithError(e).WithField("Endpoint", k).Error("API DeleteEndpoint")
+			apiError(errors.Wrap(e, "Cannot shutdown RPC client for deletion"), w)
+
+			return
+		}
+	}
+
 	// Then delete from storage
-	if e := storage.DB.DeleteRPCEndpoint(k["rpc"]); e != nil {
+	if e := db.DeleteRPCEndpoint(k["rpc"]); e != nil {
 		log.WithError(e).WithField("Endpoint", k).Error("API DeleteEndpoint")
-		apiError(errors.Wrap(err, "Cannot delete endpoint from DB"), w)
+		apiError(errors.Wrap(e, "Cannot delete endpoint from DB"), w)
 
 		return
 	}
@@ -156,3 +215,306 @@ func deleteEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	apiReturnOk(w)
 }
+
+//
+// Change the URL of an endpoint; it reconnects if enabled
+func editEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - editEndpoint")
+
+	var k struct {
+		Id  int    `json:"rpc"`
+		Url string `json:"url"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for rpc edit"), w)
+		return
+	}
+
+	endpoints, err := db.GetRPCEndpointConfigs()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
+		return
+	}
+
+	endpoint, ok := endpoints[k.Id]
+	if !ok {
+		apiError(errors.Errorf("No endpoint with id %d", k.Id), w)
+		return
+	}
+
+	// Edited from the redacted URL of listendpoints; redacted parts keep their saved value
+	k.Url = storage.RPCEndpoint{Url: k.Url}.KeepSecrets(endpoint).Url
+
+	for id, e := range endpoints {
+		if id != k.Id && e.Url == k.Url {
+			apiError(errors.New("Endpoint already added"), w)
+			return
+		}
+	}
+
+	endpoint.Url = k.Url
+
+	// Same checks as adding
+	if err := baconclient.ValidateEndpoint(endpoint); err != nil {
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(k.Url)).Error("API EditEndpoint")
+		apiError(errors.Wrap(err, "Cannot edit endpoint"), w)
+
+		return
+	}
+
+	if err := db.UpdateRPCEndpoint(k.Id, endpoint); err != nil {
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(k.Url)).Error("API EditEndpoint")
+		apiError(errors.Wrap(err, "Cannot save endpoint to DB"), w)
+
+		return
+	}
+
+	if !endpoint.Disabled {
+		if err := baconClient.EditRpc(k.Id, endpoint); err != nil {
+			apiError(errors.Wrap(err, "Cannot reconnect endpoint"), w)
+			return
+		}
+	}
+
+	log.WithField("Endpoint", storage.RedactURL(k.Url)).Debug("API Edited Endpoint")
+
+	apiReturnOk(w)
+}
+
+//
+// Enable or disable an endpoint; disabled endpoints are kept, but not connected
+func enableEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - enableEndpoint")
+
+	var k struct {
+		Id      int  `json:"rpc"`
+		Enabled bool `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for rpc enable"), w)
+		return
+	}
+
+	endpoints, err := db.GetRPCEndpointConfigs()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
+		return
+	}
+
+	endpoint, ok := endpoints[k.Id]
+	if !ok {
+		apiError(errors.Errorf("No endpoint with id %d", k.Id), w)
+		return
+	}
+
+	// Nothing to do
+	if endpoint.Disabled != k.Enabled {
+		apiReturnOk(w)
+		return
+	}
+
+	endpoint.Disabled = !k.Enabled
+
+	if err := db.UpdateRPCEndpoint(k.Id, endpoint); err != nil {
+		apiError(errors.Wrap(err, "Cannot save endpoint to DB"), w)
+		return
+	}
+
+	if k.Enabled {
+		if err := baconClient.AddRpc(k.Id, endpoint); err != nil {
+			apiError(errors.Wrap(err, "Cannot connect endpoint"), w)
+			return
+		}
+	} else if err := baconClient.ShutdownRpc(k.Id); err != nil {
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(endpoint.Url)).Error("API EnableEndpoint")
+	}
+
+	log.WithFields(log.Fields{"Endpoint": storage.RedactURL(endpoint.Url), "Enabled": k.Enabled}).Debug("API Enabled Endpoint")
+
+	apiReturnOk(w)
+}
+
+//
+// Set the connection options of an endpoint: headers, credentials, certificates
+// and proxy. Secrets left redacted, as returned by listendpoints, are kept.
+func setEndpointOptions(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - setEndpointOptions")
+
+	var k struct {
+		Id      int                 `json:"rpc"`
+		Options storage.RPCEndpoint `json:"options"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for rpc options"), w)
+		return
+	}
+
+	endpoints, err := db.GetRPCEndpointConfigs()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
+		return
+	}
+
+	saved, ok := endpoints[k.Id]
+	if !ok {
+		apiError(errors.Errorf("No endpoint with id %d", k.Id), w)
+		return
+	}
+
+	endpoint := k.Options.KeepSecrets(saved)
+	endpoint.Url = saved.Url
+	endpoint.Disabled = saved.Disabled
+	endpoint.Role = saved.Role
+
+	// Connect with the new options before saving them
+	if err := baconclient.ValidateEndpoint(endpoint); err != nil {
+		log.WithError(err).WithField("Endpoint", storage.RedactURL(endpoint.Url)).Error("API SetEndpointOptions")
+		apiError(errors.Wrap(err, "Cannot set endpoint options"), w)
+
+		return
+	}
+
+	if err := db.UpdateRPCEndpoint(k.Id, endpoint); err != nil {
+		apiError(errors.Wrap(err, "Cannot save endpoint to DB"), w)
+		return
+	}
+
+	if !endpoint.Disabled {
+		if err := baconClient.EditRpc(k.Id, endpoint); err != nil {
+			apiError(errors.Wrap(err, "Cannot reconnect endpoint"), w)
+			return
+		}
+	}
+
+	log.WithField("Endpoint", storage.RedactURL(endpoint.Url)).Debug("API Set Endpoint Options")
+
+	apiReturnOk(w)
+}
+
+//
+// Set what an endpoint is used for: read, inject, both, or monitor (heads only)
+func setEndpointRole(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - setEndpointRole")
+
+	var k struct {
+		Id   int    `json:"rpc"`
+		Role string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for rpc role"), w)
+		return
+	}
+
+	if !storage.ValidRole(k.Role) {
+		apiError(errors.Errorf("Unknown endpoint role %q", k.Role), w)
+		return
+	}
+
+	endpoints, err := db.GetRPCEndpointConfigs()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get endpoints"), w)
+		return
+	}
+
+	endpoint, ok := endpoints[k.Id]
+	if !ok {
+		apiError(errors.Errorf("No endpoint with id %d", k.Id), w)
+		return
+	}
+
+	endpoint.Role = k.Role
+
+	if err := db.UpdateRPCEndpoint(k.Id, endpoint); err != nil {
+		apiError(errors.Wrap(err, "Cannot save endpoint to DB"), w)
+		return
+	}
+
+	if !endpoint.Disabled {
+		if err := baconClient.EditRpc(k.Id, endpoint); err != nil {
+			apiError(errors.Wrap(err, "Cannot reconnect endpoint"), w)
+			return
+		}
+	}
+
+	log.WithFields(log.Fields{"Endpoint": storage.RedactURL(endpoint.Url), "Role": k.Role}).Debug("API Set Endpoint Role")
+
+	apiReturnOk(w)
+}
+
+//
+// Consistent snapshot of the database, while baking continues; encrypted if a passphrase is given
+func backupDatabase(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - backupDatabase")
+
+	var k struct {
+		Passphrase string `json:"passphrase"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil && err != io.EOF {
+		apiError(errors.Wrap(err, "Cannot decode body for backup"), w)
+		return
+	}
+
+	m, ok := db.(storage.Maintainer)
+	if !ok {
+		apiError(errors.New("Database cannot be backed up"), w)
+		return
+	}
+
+	network, _ := db.GetNetwork()
+
+	filename := fmt.Sprintf("bakinbacon-%s-%s.db", network, time.Now().UTC().Format("20060102-150405"))
+	if k.Passphrase != "" {
+		filename += ".enc"
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	// Headers are sent with the first bytes; an error after that can only be logged
+	size, err := m.Backup(w, k.Passphrase)
+	if err != nil {
+		log.WithError(err).Error("API backupDatabase")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Size": size, "Encrypted": k.Passphrase != "",
+	}).Info("Database backed up")
+}
+
+//
+// Size of the database, space compaction would return, and what is kept
+func getDatabaseInfo(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getDatabaseInfo")
+
+	m, ok := db.(storage.Maintainer)
+	if !ok {
+		apiError(errors.New("Database size unknown"), w)
+		return
+	}
+
+	size, free, err := m.GetDatabaseSize()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get database size"), w)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"size":      size,
+		"free":      free,
+		"retention": storage.GetRetention(),
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+// List manager operations waiting for confirmation
+func getPendingConfirmations(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getPendingConfirmations")
+
+	if err := json.NewEncoder(w).Encode(baconClient.Signer.PendingConfirmations()); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Confirm, or reject, a pending manager operation. Confirmed operations
+// are signed when next requested; ie: when the user retries the action.
+func confirmOperation(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - confirmOperation")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k struct {
+		Id     string `json:"id"`
+		Reject bool   `json:"reject"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for confirmation"), w)
+		return
+	}
+
+	var err error
+	if k.Reject {
+		err = baconClient.Signer.RejectOperation(k.Id)
+	} else {
+		err = baconClient.Signer.ConfirmOperation(k.Id)
+	}
+
+	if err != nil {
+		apiError(err, w)
+		return
+	}
+
+	apiReturnOk(w)
+}
+
+// Export the signing audit log, along with the result of verifying its hash chain
+func exportAuditLog(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - exportAuditLog")
+
+	entries, err := db.GetAuditEntries()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot read audit log"), w)
+		return
+	}
+
+	verifyErr := ""
+	if err := storage.VerifyAuditLog(entries); err != nil {
+		verifyErr = err.Error()
+		log.WithError(err).Error("Audit log verification failed")
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=bakinbacon-audit.json")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":     entries,
+		"verified":    verifyErr == "",
+		"verifyError": verifyErr,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+// Signer availability, reconnects, and signing latency
+func getSignerHealth(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getSignerHealth")
+
+	if err := json.NewEncoder(w).Encode(baconClient.Signer.Health()); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
@@ -15,7 +15,8 @@ func testLedger(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug("API - testLedger")
 
-	ledgerInfo, err := baconClient.Signer.TestLedger()
+	// Curve only applies when the device has no authorized baking key; defaults to ed25519
+	ledgerInfo, err := baconClient.Signer.TestLedger(r.URL.Query().Get("curve"))
 	if err != nil {
 		apiError(errors.Wrap(err, "Unable to access ledger"), w)
 		return
@@ -58,14 +59,14 @@ func confirmBakingPkh(w http.ResponseWriter, r *http.Request) {
 }
 
 //
-// Generate new key
+// Generate new key; ?curve=ed25519 (default), secp256k1 or p256
 // Save generated key to database, and set signer type to wallet
 func generateNewKey(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug("API - generateNewKey")
 
 	// Generate new key temporarily
-	newEdsk, newPkh, err := baconClient.Signer.GenerateNewKey()
+	newEdsk, newPkh, err := baconClient.Signer.GenerateNewKey(r.URL.Query().Get("curve"))
 	if err != nil {
 		apiError(errors.Wrap(err, "Cannot generate new key"), w)
 		return
@@ -102,8 +103,8 @@ func importSecretKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Imports key temporarily
-	edsk, pkh, err := baconClient.Signer.ImportSecretKey(k["edsk"])
+	// Imports key temporarily; passphrase only needed for encrypted keys
+	edsk, pkh, err := baconClient.Signer.ImportSecretKey(k["edsk"], k["passphrase"])
 	if err != nil {
 		apiError(errors.Wrap(err, "Cannot import secret key"), w)
 		return
@@ -120,6 +121,82 @@ func importSecretKey(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//
+// Derive a key from a BIP39 mnemonic, with optional passphrase, derivation path and curve
+// Key is only saved by finishWallet, after the user confirms the address
+func importMnemonic(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - importMnemonic")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for mnemonic import"), w)
+		return
+	}
+
+	// Imports key temporarily
+	edsk, pkh, err := baconClient.Signer.ImportMnemonic(k["mnemonic"], k["passphrase"], k["path"], k["curve"])
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot import mnemonic"), w)
+		return
+	}
+
+	log.WithField("PKH", pkh).Info("Imported key-pair from mnemonic")
+
+	// Return back to UI
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"edsk": edsk,
+		"pkh":  pkh,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
+//
+// Derive the key of a fundraiser account from mnemonic, email and password
+// Key is only saved by finishWallet, after the user confirms the address
+func importFundraiser(w http.ResponseWriter, r *http.Request) {
+
+	log.Debug("API - importFundraiser")
+
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for fundraiser import"), w)
+		return
+	}
+
+	// Imports key temporarily
+	edsk, pkh, err := baconClient.Signer.ImportFundraiser(k["mnemonic"], k["email"], k["password"])
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot import fundraiser account"), w)
+		return
+	}
+
+	log.WithField("PKH", pkh).Info("Imported fundraiser key-pair")
+
+	// Return back to UI
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"edsk": edsk,
+		"pkh":  pkh,
+	}); err != nil {
+		log.WithError(err).Error("UI Return Encode Failure")
+	}
+}
+
 //
 // Call baconClient.RegisterBaker() to construct and inject registration operation.
 // This will also check if reveal is needed.
@@ -152,12 +229,25 @@ func registerBaker(w http.ResponseWriter, r *http.Request) {
 
 //
 // Finish wallet wizard
-// This API saves the generated, or imported, secret key to the DB and saves the signer method
+// This API encrypts and saves the generated, or imported, secret key to the DB and saves the signer method
 func finishWalletWizard(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug("API - FinishWalletWizard")
 
-	if err := baconClient.Signer.SaveSigner(); err != nil {
+	// CORS crap; Handle OPTION preflight check
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	var k map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&k)
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for wallet passphrase"), w)
+		return
+	}
+
+	if err := baconClient.Signer.SaveSigner(k["passphrase"]); err != nil {
 		apiError(errors.Wrap(err, "Cannot save key/wallet to db"), w)
 		return
 	}
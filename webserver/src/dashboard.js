@@ -7,7 +7,8 @@ import Row from 'react-bootstrap/Row';
 
 import DelegateInfo from './delegateinfo.js'
 import NextOpportunities from './nextopportunities.js'
-import { BaconAlert, CAN_BAKE, NO_SIGNER, substr } from './util.js'
+import WalletUnlock from './walletunlock.js'
+import { BaconAlert, CAN_BAKE, NO_SIGNER, WALLET_LOCKED, substr } from './util.js'
 
 const BaconDashboard = (props) => {
 
@@ -50,6 +51,10 @@ const BaconDashboard = (props) => {
 		<BaconAlert alert={alert} />
 		}
 
+		{ status.state === WALLET_LOCKED &&
+		<WalletUnlock />
+		}
+
 		{ status.state === CAN_BAKE &&
 		<Row>
 			<Col md={5}>
@@ -13,6 +13,7 @@ import BakinDashboard from './dashboard.js'
 import DelegateRegister from './delegateregister.js'
 import Settings from './settings'
 import SetupWizard from './wizards'
+import SignConfirm from './signconfirm.js'
 import Voting from './voting.js'
 
 import ToasterContext, { ToasterContextProvider } from './toaster.js';
@@ -126,6 +127,7 @@ const Bakinbacon = () => {
 			{ isLoading ? <Row><Col>Loading dashboard...</Col></Row> : 
 			<Row>
 			  <Col>
+				<SignConfirm />
 				<Tabs defaultActiveKey="dashboard" id="bakinbacon-tabs" mountOnEnter={true} unmountOnExit={true}>
 					<Tab eventKey="dashboard" title="Dashboard">
 						{ status.state === NOT_REGISTERED ?
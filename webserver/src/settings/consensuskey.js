@@ -0,0 +1,165 @@
+import React, { useState, useContext, useEffect } from 'react';
+
+import Alert from 'react-bootstrap/Alert'
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Form from 'react-bootstrap/Form'
+
+import ToasterContext from '../toaster.js';
+import { apiRequest } from '../util.js';
+
+
+// Rotate the key which signs blocks and endorsements, without changing the baker address.
+// Step 1: generate or import a key. Step 2: register it on chain. The current key keeps
+// signing until the activation cycle, then BakinBacon switches automatically.
+const ConsensusKey = () => {
+
+	const [ supported, setSupported ] = useState(false);
+	const [ status, setStatus ] = useState({});
+	const [ curve, setCurve ] = useState("ed25519");
+	const [ importKey, setImportKey ] = useState("");
+	const [ importPassphrase, setImportPassphrase ] = useState("");
+	const [ passphrase, setPassphrase ] = useState("");
+	const [ newSk, setNewSk ] = useState("");
+	const [ err, setError ] = useState("");
+	const addToast = useContext(ToasterContext);
+
+	useEffect(() => {
+		loadStatus();
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const loadStatus = () => {
+		const apiUrl = window.BASE_URL + "/api/consensus/status";
+		apiRequest(apiUrl)
+		.then((data) => {
+			setSupported(data.supported);
+			setStatus(data.status);
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	const generateKey = () => {
+		setError("");
+		const apiUrl = window.BASE_URL + "/api/consensus/generate?curve=" + curve;
+		apiRequest(apiUrl)
+		.then((data) => {
+			setNewSk(data.edsk);
+			loadStatus();
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	const doImportKey = () => {
+		setError("");
+		const apiUrl = window.BASE_URL + "/api/consensus/import";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ edsk: importKey, passphrase: importPassphrase })
+		};
+		apiRequest(apiUrl, requestOptions)
+		.then(() => {
+			setNewSk("");
+			setImportKey("");
+			setImportPassphrase("");
+			loadStatus();
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	const registerKey = () => {
+		setError("");
+		const apiUrl = window.BASE_URL + "/api/consensus/register";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ passphrase: passphrase })
+		};
+		apiRequest(apiUrl, requestOptions)
+		.then((data) => {
+			addToast({
+				title: "Consensus Key Registered",
+				msg: "Operation " + data.ophash + " injected. Key activates in cycle " + data.activationCycle + ".",
+				type: "success",
+			});
+			setNewSk("");
+			setPassphrase("");
+			loadStatus();
+		})
+		.catch((errMsg) => {
+			// Manager operations may need confirmation first; confirm then register again
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	return (
+		<Card>
+			<Card.Header as="h5">Consensus Key</Card.Header>
+			<Card.Body>
+				<Card.Text>The consensus key signs blocks and endorsements. Your baker address does not change.</Card.Text>
+				{ status.activePkh &&
+				<Card.Text>Active consensus key: <strong>{status.activePkh}</strong></Card.Text>
+				}
+				{ status.pendingPkh &&
+				<Card.Text>Pending consensus key: <strong>{status.pendingPkh}</strong>, activates in cycle {status.activationCycle}. The current key signs until then.</Card.Text>
+				}
+				{ status.locked &&
+				<Alert variant="warning">Consensus key is locked. Unlock the wallet to use it.</Alert>
+				}
+				{ !supported &&
+				<Alert variant="info">The current protocol does not support consensus keys.</Alert>
+				}
+				{ supported && !status.stagedPkh &&
+				<>
+				<Form.Row>
+					<Form.Group>
+						<Form.Label>Generate a new key</Form.Label>
+						<Form.Control as="select" value={curve} onChange={(e) => setCurve(e.target.value)}>
+							<option value="ed25519">ed25519 (tz1)</option>
+							<option value="secp256k1">secp256k1 (tz2)</option>
+							<option value="p256">P-256 (tz3)</option>
+						</Form.Control>
+					</Form.Group>
+				</Form.Row>
+				<Button variant="primary" size="sm" onClick={generateKey}>Generate</Button>
+				<Form.Group className="mt-3">
+					<Form.Label>Or import a secret key</Form.Label>
+					<Form.Control type="text" placeholder="edsk..." value={importKey} onChange={(e) => setImportKey(e.target.value)} />
+					<Form.Control type="password" placeholder="Passphrase, if encrypted" className="mt-1" value={importPassphrase} onChange={(e) => setImportPassphrase(e.target.value)} />
+				</Form.Group>
+				<Button variant="primary" size="sm" onClick={doImportKey}>Import</Button>
+				</>
+				}
+				{ supported && status.stagedPkh &&
+				<>
+				<Card.Text>New consensus key: <strong>{status.stagedPkh}</strong></Card.Text>
+				{ newSk &&
+				<Alert variant="warning">Back up this secret key: <strong>{newSk}</strong></Alert>
+				}
+				<Form.Group>
+					<Form.Label>Passphrase to encrypt the key; leave empty to use the wallet passphrase</Form.Label>
+					<Form.Control type="password" value={passphrase} onChange={(e) => setPassphrase(e.target.value)} />
+				</Form.Group>
+				<Button variant="primary" size="sm" onClick={registerKey}>Register Consensus Key</Button>
+				</>
+				}
+				{ err &&
+				<Alert variant="danger" className="mt-3">{err}</Alert>
+				}
+			</Card.Body>
+		</Card>
+	);
+}
+
+export default ConsensusKey
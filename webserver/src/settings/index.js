@@ -3,8 +3,11 @@ import React, { useState, useContext, useEffect } from 'react';
 import Col from 'react-bootstrap/Col';
 import Row from 'react-bootstrap/Row';
 
+import ConsensusKey from './consensuskey.js'
+import Ledger from './ledger.js'
 import Notifications from './notifications.js'
 import Rpcservers from './rpcservers.js'
+import SignerHealth from './signerhealth.js'
 
 import ToasterContext from '../toaster.js';
 import { apiRequest } from '../util.js';
@@ -51,6 +54,11 @@ const Settings = (props) => {
 		<Row>
 		  <Col md={5}>
 			<Rpcservers settings={settings} loadSettings={loadSettings} />
+			<SignerHealth />
+		  </Col>
+		  <Col md={7}>
+			<ConsensusKey />
+			<Ledger />
 		  </Col>
 		</Row>
 		<Row>
@@ -58,6 +66,11 @@ const Settings = (props) => {
 		    <Notifications settings={settings} loadSettings={loadSettings} />
 		  </Col>
 		</Row>
+		<Row>
+		  <Col>
+		    <a href={window.BASE_URL + "/api/signer/audit"}>Export signing audit log</a>
+		  </Col>
+		</Row>
 		</>
 	)
 }
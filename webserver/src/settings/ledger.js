@@ -0,0 +1,96 @@
+import React, { useState, useContext, useEffect } from 'react';
+
+import Alert from 'react-bootstrap/Alert'
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Form from 'react-bootstrap/Form'
+
+import ToasterContext from '../toaster.js';
+import { apiRequest } from '../util.js';
+
+
+// High watermarks of the Tezos Baking app. The device refuses to sign at or below
+// these levels; after moving the ledger between bakers, or a chain reset, set it here.
+const Ledger = () => {
+
+	const [ status, setStatus ] = useState({});
+	const [ level, setLevel ] = useState("");
+	const [ waiting, setWaiting ] = useState(false);
+	const [ err, setError ] = useState("");
+	const addToast = useContext(ToasterContext);
+
+	useEffect(() => {
+		loadStatus();
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const loadStatus = () => {
+		const apiUrl = window.BASE_URL + "/api/ledger/hwm";
+		apiRequest(apiUrl)
+		.then((data) => {
+			setStatus(data);
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	const setWatermark = () => {
+		setError("");
+		setWaiting(true);
+		const apiUrl = window.BASE_URL + "/api/ledger/sethwm";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ level: parseInt(level) })
+		};
+		apiRequest(apiUrl, requestOptions)
+		.then(() => {
+			addToast({
+				title: "Ledger Watermark Set",
+				msg: "Ledger high watermark set to " + level,
+				type: "success",
+			});
+			setLevel("");
+			loadStatus();
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		})
+		.finally(() => {
+			setWaiting(false);
+		});
+	}
+
+	// Only shown when baking with a ledger
+	if (!status.ledger) {
+		return null;
+	}
+
+	const stored = Math.max(status.baking, status.endorsing);
+
+	return (
+		<Card className="mt-3">
+			<Card.Header as="h5">Ledger High Watermark</Card.Header>
+			<Card.Body>
+				<Card.Text>Device: <strong>{status.device.main}</strong>{ status.device.chainId && <> on {status.device.chainId}, test chain <strong>{status.device.test}</strong></> }</Card.Text>
+				<Card.Text>BakinBacon: <strong>{stored}</strong> (baked {status.baking}, endorsed {status.endorsing})</Card.Text>
+				{ status.device.main !== stored &&
+				<Alert variant="warning">The device watermark does not match BakinBacon's. If the device was used by another baker, or the chain was reset, set the watermark below.</Alert>
+				}
+				<Form.Group>
+					<Form.Label>New high watermark</Form.Label>
+					<Form.Control type="number" min="0" value={level} onChange={(e) => setLevel(e.target.value)} />
+				</Form.Group>
+				<Button variant="primary" size="sm" onClick={setWatermark} disabled={waiting || level === ""}>{ waiting ? "Confirm on device..." : "Set Watermark" }</Button>
+				{ err &&
+				<Alert variant="danger" className="mt-3">{err}</Alert>
+				}
+			</Card.Body>
+		</Card>
+	);
+}
+
+export default Ledger
@@ -1,5 +1,6 @@
 import React, { useState, useContext, useEffect } from 'react';
 
+import Badge from 'react-bootstrap/Badge';
 import Button from 'react-bootstrap/Button';
 import Col from 'react-bootstrap/Col';
 import Card from 'react-bootstrap/Card';
@@ -16,12 +17,59 @@ const Rpcservers = (props) => {
 
 	const [newRpc, setNewRpc] = useState("");
 	const [rpcEndpoints, setRpcEndpoints] = useState({});
+	const [rpcHealth, setRpcHealth] = useState({});
+	const [rpcDisabled, setRpcDisabled] = useState([]);
+	const [editRpcId, setEditRpcId] = useState(null);
+	const [editRpcUrl, setEditRpcUrl] = useState("");
+	const [rpcOptions, setRpcOptions] = useState({});
+	const [optionsRpcId, setOptionsRpcId] = useState(null);
+	const [editOptions, setEditOptions] = useState({});
 	const addToast = useContext(ToasterContext);
 
 	useEffect(() => {
 		setRpcEndpoints(settings.endpoints);
 	}, [settings]);
 
+	// Scores change with every block
+	useEffect(() => {
+		loadHealth();
+		const i = setInterval(loadHealth, 10000);
+		return () => clearInterval(i);
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const loadHealth = () => {
+		const apiUrl = window.BASE_URL + "/api/settings/listendpoints";
+		apiRequest(apiUrl)
+		.then((data) => {
+			setRpcHealth(data.health || {});
+			setRpcDisabled(data.disabled || []);
+			setRpcOptions(data.options || {});
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+		});
+	}
+
+	const healthBadge = (rpcId) => {
+		if (rpcDisabled.includes(Number(rpcId))) {
+			return <Badge variant="secondary">Disabled</Badge>;
+		}
+		const h = rpcHealth[rpcId];
+		if (!h) {
+			return null;
+		}
+		if (h.quarantined) {
+			return <><Badge variant="danger">Quarantined</Badge><div className="text-danger small">{h.quarantined}</div></>;
+		}
+		if (h.circuit === "open") {
+			return <Badge variant="danger" title={"Too many failed requests; retrying after " + new Date(h.openUntil).toLocaleTimeString()}>Failing</Badge>;
+		}
+		const variant = h.score >= 80 ? "success" : (h.score >= 50 ? "warning" : "danger");
+		const title = "Freshness " + Math.round(h.freshness * 100) + "%, errors " + Math.round((1 - h.errors) * 100) + "%, latency " + h.avgLatency + "ms, head delay " + h.heads.avgBehind + "ms";
+		return <><Badge variant={variant} title={title}>{h.score}</Badge>{h.current && <Badge variant="primary" className="ml-1">Active</Badge>}{h.injector && <Badge variant="info" className="ml-1">Injector</Badge>}</>;
+	}
+
 	const handleNewRpcChange = (event) => {
 		setNewRpc(event.target.value);
 	}
@@ -93,6 +141,139 @@ const Rpcservers = (props) => {
 		});
 	}
 
+	const startEditRpc = (rpc) => {
+		setEditRpcId(rpc);
+		setEditRpcUrl(rpcEndpoints[rpc]);
+	}
+
+	const editRpc = () => {
+		const apiUrl = window.BASE_URL + "/api/settings/editendpoint"
+		const postData = {rpc: Number(editRpcId), url: stripSlash(editRpcUrl)}
+		handlePostAPI(apiUrl, postData).then(() => {
+			loadHealth();
+		})
+		.finally(() => {
+			setEditRpcId(null);
+		});
+	}
+
+	const setRole = (rpc, role) => {
+		const apiUrl = window.BASE_URL + "/api/settings/endpointrole"
+		const postData = {rpc: Number(rpc), role: role}
+		handlePostAPI(apiUrl, postData).then(() => {
+			loadHealth();
+		});
+	}
+
+	const enableRpc = (rpc, enabled) => {
+		const apiUrl = window.BASE_URL + "/api/settings/enableendpoint"
+		const postData = {rpc: Number(rpc), enabled: enabled}
+		handlePostAPI(apiUrl, postData).then(() => {
+			loadHealth();
+		});
+	}
+
+	// Secrets come back redacted; sent back unchanged, the saved values are kept
+	const startEditOptions = (rpc) => {
+		const o = rpcOptions[rpc] || {};
+		setOptionsRpcId(rpc);
+		setEditOptions({
+			headers: Object.entries(o.headers || {}).map(([k, v]) => k + ": " + v).join("\n"),
+			username: o.username || "",
+			password: o.password || "",
+			bearerToken: o.bearerToken || "",
+			clientCert: o.clientCert || "",
+			clientKey: o.clientKey || "",
+			caCert: o.caCert || "",
+			proxyUrl: o.proxyUrl || "",
+		});
+	}
+
+	const handleOptionChange = (event) => {
+		setEditOptions({...editOptions, [event.target.name]: event.target.value});
+	}
+
+	const saveOptions = () => {
+		const headers = {};
+		editOptions.headers.split("\n").forEach((line) => {
+			const i = line.indexOf(":");
+			if (i > 0) {
+				headers[line.substr(0, i).trim()] = line.substr(i + 1).trim();
+			}
+		});
+		const apiUrl = window.BASE_URL + "/api/settings/endpointoptions"
+		const postData = {rpc: Number(optionsRpcId), options: {...editOptions, headers: headers}}
+		handlePostAPI(apiUrl, postData).then(() => {
+			loadHealth();
+		})
+		.finally(() => {
+			setOptionsRpcId(null);
+		});
+	}
+
+	const optionsForm = (rpcId) => {
+		const field = (name, label, type, rows) => (
+			<Form.Group as={Col} md="6">
+				<Form.Label>{label}</Form.Label>
+				<Form.Control type={type || "text"} as={rows ? "textarea" : "input"} rows={rows} size="sm" name={name} value={editOptions[name]} onChange={handleOptionChange} />
+			</Form.Group>
+		);
+		return <ListGroup.Item key={rpcId}>
+			<div className="mb-2">{rpcEndpoints[rpcId]}</div>
+			<Form.Row>
+				{field("username", "Username")}
+				{field("password", "Password", "password")}
+			</Form.Row>
+			<Form.Row>
+				{field("bearerToken", "Bearer Token", "password")}
+				{field("proxyUrl", "Proxy (http://, https:// or socks5://)")}
+			</Form.Row>
+			<Form.Row>
+				{field("headers", "Headers (Name: value, one per line)", "text", 3)}
+				{field("caCert", "CA Bundle (PEM)", "text", 3)}
+			</Form.Row>
+			<Form.Row>
+				{field("clientCert", "Client Certificate (PEM)", "text", 3)}
+				{field("clientKey", "Client Key (PEM)", "text", 3)}
+			</Form.Row>
+			<Button variant="primary" onClick={saveOptions} type="button" size="sm">Save</Button>{' '}
+			<Button variant="secondary" onClick={() => setOptionsRpcId(null)} type="button" size="sm">Cancel</Button>
+		</ListGroup.Item>
+	}
+
+	const rpcItem = (rpcId) => {
+		if (optionsRpcId === rpcId) {
+			return optionsForm(rpcId);
+		}
+		if (editRpcId === rpcId) {
+			return <ListGroup.Item key={rpcId}>
+				<Form.Row>
+				  <Form.Group as={Col} md="9">
+					<Form.Control type="text" size="sm" value={editRpcUrl} onChange={(e) => setEditRpcUrl(e.target.value)} />
+				  </Form.Group>
+				  <Form.Group as={Col} md="3">
+					<Button variant="primary" onClick={editRpc} type="button" size="sm">Save</Button>{' '}
+					<Button variant="secondary" onClick={() => setEditRpcId(null)} type="button" size="sm">Cancel</Button>
+				  </Form.Group>
+				</Form.Row>
+			</ListGroup.Item>
+		}
+		const disabled = rpcDisabled.includes(Number(rpcId));
+		return <ListGroup.Item key={rpcId}>
+			<Button onClick={() => delRpc(rpcId)} variant="danger" size="sm" type="button">{'X'}</Button>{' '}
+			<Button onClick={() => startEditRpc(rpcId)} variant="secondary" size="sm" type="button">Edit</Button>{' '}
+			<Button onClick={() => startEditOptions(rpcId)} variant="secondary" size="sm" type="button">Options</Button>{' '}
+			<Button onClick={() => enableRpc(rpcId, disabled)} variant="outline-secondary" size="sm" type="button">{disabled ? "Enable" : "Disable"}</Button>{' '}
+			{rpcEndpoints[rpcId]} {healthBadge(rpcId)}
+			<Form.Control as="select" size="sm" className="w-auto d-inline-block ml-2" value={(rpcOptions[rpcId] || {}).role || "both"} onChange={(e) => setRole(rpcId, e.target.value)}>
+				<option value="both">Read &amp; Inject</option>
+				<option value="read">Read only</option>
+				<option value="inject">Inject only</option>
+				<option value="monitor">Monitor only</option>
+			</Form.Control>
+		</ListGroup.Item>
+	}
+
 	// Add/Delete RPC, and Save Telegram/Email RPCs use POST and only care if failure.
 	// On 200 OK, refresh settings
 	const handlePostAPI = (url, data) => {
@@ -122,12 +303,10 @@ const Rpcservers = (props) => {
 		<Card>
 		  <Card.Header as="h5">RPC Servers</Card.Header>
 		  <Card.Body>
-		  <Card.Text>BakinBacon supports multiple RPC servers for increased redundancy against network issues and will always use the most up-to-date server.</Card.Text>
+		  <Card.Text>BakinBacon supports multiple RPC servers for increased redundancy against network issues and will use the healthiest server, scored on how quickly it sees new blocks, its request errors and latency, and injection success.</Card.Text>
 		  </Card.Body>
 		  <ListGroup variant="flush">
-			{ Object.keys(rpcEndpoints).map((rpcId) => {
-				return <ListGroup.Item key={rpcId}><Button onClick={() => delRpc(rpcId)} variant="danger" size="sm" type="button">{'X'}</Button> {rpcEndpoints[rpcId]}</ListGroup.Item>
-			})}
+			{ Object.keys(rpcEndpoints).map(rpcItem) }
 		  </ListGroup>
 		  <Card.Body>
 			<Form.Row>
@@ -0,0 +1,62 @@
+import React, { useState, useEffect } from 'react';
+
+import Alert from 'react-bootstrap/Alert'
+import Card from 'react-bootstrap/Card';
+
+import { apiRequest } from '../util.js';
+
+
+// Availability and latency of the signer. Reconnects, or slow signatures, point
+// at a flaky USB cable or hub before it costs blocks.
+const SignerHealth = () => {
+
+	const [ health, setHealth ] = useState(null);
+	const [ err, setError ] = useState("");
+
+	useEffect(() => {
+		loadHealth();
+		const i = setInterval(loadHealth, 10000);
+		return () => clearInterval(i);
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const loadHealth = () => {
+		const apiUrl = window.BASE_URL + "/api/signer/health";
+		apiRequest(apiUrl)
+		.then((data) => {
+			setHealth(data);
+			setError("");
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg);
+		});
+	}
+
+	if (!health) {
+		return null;
+	}
+
+	return (
+		<Card className="mt-3">
+			<Card.Header as="h5">Signer Health</Card.Header>
+			<Card.Body>
+				{ health.available ?
+				<Card.Text>Signer is <strong>available</strong> since {new Date(health.since).toLocaleString()}</Card.Text>
+				:
+				<Alert variant="danger">Signer unavailable since {new Date(health.since).toLocaleString()}: {health.error}</Alert>
+				}
+				<Card.Text>Availability: <strong>{(health.availability * 100).toFixed(2)}%</strong>, {health.disconnects} disconnects, {health.reconnects} reconnects</Card.Text>
+				<Card.Text>Signatures: {health.signatures} ({health.signErrors} failed); latency last {health.lastLatency}ms, avg {health.avgLatency}ms, p95 {health.p95Latency}ms, max {health.maxLatency}ms</Card.Text>
+				{ health.watermarkDrift &&
+				<Alert variant="warning">Ledger high watermark, {health.watermarkDrift.device}, does not match BakinBacon's, {health.watermarkDrift.storage}. Was the device used by another baker, or the database replaced?</Alert>
+				}
+				{ err &&
+				<Alert variant="danger">{err}</Alert>
+				}
+			</Card.Body>
+		</Card>
+	);
+}
+
+export default SignerHealth
@@ -0,0 +1,93 @@
+import React, { useEffect, useState } from 'react';
+
+import Alert from 'react-bootstrap/Alert'
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Col from 'react-bootstrap/Col';
+import Row from 'react-bootstrap/Row';
+
+import { apiRequest } from './util.js';
+
+
+// Manager operations (reveal, delegation, ...) must be confirmed here before
+// they are signed. After confirming, retry the action which requested it.
+const SignConfirm = () => {
+
+	const [ pending, setPending ] = useState([]);
+	const [ err, setError ] = useState("");
+
+	useEffect(() => {
+
+		fetchPending();
+
+		let fetchPendingTimer = setInterval(() => fetchPending(), 1000 * 5);
+		return () => {
+			// componentWillUnmount()
+			clearInterval(fetchPendingTimer);
+			fetchPendingTimer = null;
+		};
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const fetchPending = () => {
+		const pendingApiUrl = window.BASE_URL + "/api/signer/pending";
+		apiRequest(pendingApiUrl)
+		.then((data) => {
+			setPending(data);
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+		});
+	}
+
+	const doConfirm = (id, reject) => {
+
+		// Clear previous error messages
+		setError("");
+
+		const confirmApiUrl = window.BASE_URL + "/api/signer/confirm";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ id: id, reject: reject })
+		};
+
+		apiRequest(confirmApiUrl, requestOptions)
+		.then(() => {
+			fetchPending();
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg)
+		});
+	}
+
+	if (pending.length === 0) {
+		return null;
+	}
+
+	return (
+		<Row>
+		<Col>
+			<Card border="warning">
+				<Card.Header as="h5">Operations Waiting for Confirmation</Card.Header>
+				<Card.Body>
+					<Card.Text>The following operations were not signed. Confirm them, then retry the action which created them.</Card.Text>
+					{ pending.map((p) =>
+					<Row key={p.id} className="mb-2">
+						<Col md={8}>{p.operations.join(", ")}</Col>
+						<Col md={2}><Button variant="success" size="sm" block onClick={() => doConfirm(p.id, false)}>Confirm</Button></Col>
+						<Col md={2}><Button variant="danger" size="sm" block onClick={() => doConfirm(p.id, true)}>Reject</Button></Col>
+					</Row>
+					)}
+					{ err &&
+					<Alert variant="danger" className="mt-3">{err}</Alert>
+					}
+				</Card.Body>
+			</Card>
+		</Col>
+		</Row>
+	);
+}
+
+export default SignConfirm
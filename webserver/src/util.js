@@ -10,6 +10,7 @@ export const LOW_BALANCE = "lowbal"
 export const NO_SIGNER = "nosign"
 export const CAN_BAKE = "canbake"
 export const NOT_REGISTERED = "noreg"
+export const WALLET_LOCKED = "locked"
 
 export const CHAINIDS = {
 	"mainnet":     "NetXdQprcVkpaWU",
@@ -0,0 +1,74 @@
+import React, { useState } from 'react';
+
+import Alert from 'react-bootstrap/Alert'
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Col from 'react-bootstrap/Col';
+import Form from 'react-bootstrap/Form'
+import Row from 'react-bootstrap/Row';
+
+import { apiRequest } from './util.js';
+
+
+const WalletUnlock = () => {
+
+	const [ passphrase, setPassphrase ] = useState("");
+	const [ unlocked, setUnlocked ] = useState(false);
+	const [ err, setError ] = useState("");
+
+	const onPassphraseChange = (e) => {
+		setPassphrase(e.target.value);
+	}
+
+	const doUnlock = () => {
+
+		// Clear previous error messages
+		setError("");
+
+		const unlockApiUrl = window.BASE_URL + "/api/unlock";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ passphrase: passphrase })
+		};
+
+		apiRequest(unlockApiUrl, requestOptions)
+		.then(() => {
+			// Dashboard will update on next status fetch
+			setUnlocked(true);
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg)
+		});
+	}
+
+	if (unlocked) {
+		return (
+			<Row><Col><Alert variant="success">Wallet unlocked!</Alert></Col></Row>
+		);
+	}
+
+	return (
+		<Row>
+		<Col md={7}>
+			<Card>
+				<Card.Header as="h5">Unlock Wallet</Card.Header>
+				<Card.Body>
+					<Card.Text>Your secret key is encrypted. Enter your passphrase to unlock the wallet and resume baking.</Card.Text>
+					<Form.Group controlId="unlockPassphrase">
+						<Form.Label>Passphrase</Form.Label>
+						<Form.Control type="password" onChange={onPassphraseChange} />
+					</Form.Group>
+					<Button variant="primary" onClick={doUnlock}>Unlock</Button>
+					{ err &&
+					<Alert variant="danger" className="mt-3">{err}</Alert>
+					}
+				</Card.Body>
+			</Card>
+		</Col>
+		</Row>
+	);
+}
+
+export default WalletUnlock
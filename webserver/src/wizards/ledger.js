@@ -4,6 +4,7 @@ import Alert from 'react-bootstrap/Alert';
 import Button from 'react-bootstrap/Button';
 import Card from 'react-bootstrap/Card';
 import Col from 'react-bootstrap/Col';
+import Form from 'react-bootstrap/Form';
 import Loader from "react-loader-spinner";
 import Row from 'react-bootstrap/Row';
 
@@ -20,6 +21,7 @@ const WizardLedger = (props) => {
 	const [ alert, setAlert ] = useState({})
 	const [ info, setInfo ] = useState({})
 	const [ isLoading, setIsLoading ] = useState(false)
+	const [ curve, setCurve ] = useState("ed25519")
 
 	const testLedger = () => {
 		// Make API call to UI so BB can check for ledger
@@ -29,7 +31,8 @@ const WizardLedger = (props) => {
 		setInfo({});
 		setIsLoading(true);
 
-		const testLedgerApiUrl = window.BASE_URL + "/api/wizard/testLedger";
+		// Curve is only used if the device has no authorized baking key
+		const testLedgerApiUrl = window.BASE_URL + "/api/wizard/testLedger?curve=" + curve;
 		apiRequest(testLedgerApiUrl)
 		.then((data) => {
 			// Ledger and baking app detected by BB; enable continue button
@@ -112,9 +115,17 @@ const WizardLedger = (props) => {
 					</ol>
 					<Card.Text>If you do not have the 'Tezos Baking' application installed, you will need to download <a href="https://www.ledger.com/ledger-live/download" target="_blank" rel="noreferrer">Ledger Live</a> and use it to install the applications onto your device.</Card.Text>
 					<Card.Text>You must successfully test your ledger before continuing. Please click the 'Test Ledger' button below.</Card.Text>
+					<Card.Text>If the device has not been set up for baking, select the curve of the baking key. A device previously set up for baking will use its authorized key.</Card.Text>
 				</Col>
 			</Row>
 			<Row className="justify-content-md-center">
+				<Col md={3}>
+					<Form.Control as="select" value={curve} onChange={(e) => setCurve(e.target.value)}>
+						<option value="ed25519">Ed25519 (tz1)</option>
+						<option value="secp256k1">Secp256k1 (tz2)</option>
+						<option value="p256">P-256 (tz3)</option>
+					</Form.Control>
+				</Col>
 				<Col md={4}><Button variant="info" size="lg" block onClick={testLedger}>Test Ledger</Button></Col>
 				<Col md={4}><Button disabled={step !== 11} variant={step === 11 ? "success" : "dark"} size="lg" block onClick={stepTwo}>Continue...</Button></Col>
 			</Row>
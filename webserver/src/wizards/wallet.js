@@ -10,6 +10,11 @@ import Row from 'react-bootstrap/Row';
 import { apiRequest } from '../util.js';
 
 
+// Encrypted secret keys; ed25519, secp256k1, P-256
+const isEncryptedKey = (sk) => {
+	return ["edesk", "spesk", "p2esk"].includes(sk.substring(0, 5));
+}
+
 const WizardWallet = (props) => {
 
 	const { onFinishWizard } = props;
@@ -18,10 +23,16 @@ const WizardWallet = (props) => {
 	const [ edsk, setEdsk ] = useState("");
 	const [ importEdsk, setImportEdsk ] = useState("");
 	const [ pkh, setPkh ] = useState("");
+	const [ importPassphrase, setImportPassphrase ] = useState("");
+	const [ passphrase, setPassphrase ] = useState("");
+	const [ confirmPassphrase, setConfirmPassphrase ] = useState("");
+	const [ curve, setCurve ] = useState("ed25519");
+	const [ mnemonicType, setMnemonicType ] = useState("bip39");
+	const [ mnemonic, setMnemonic ] = useState({ mnemonic: "", passphrase: "", path: "", curve: "ed25519", email: "", password: "" });
 	const [ err, setError ] = useState("");
 	
 	const generateNewKey = () => {
-		const generateKeyApiUrl = window.BASE_URL + "/api/wizard/generateNewKey";
+		const generateKeyApiUrl = window.BASE_URL + "/api/wizard/generateNewKey?curve=" + curve;
 		apiRequest(generateKeyApiUrl)
 		.then((data) => {
 			setEdsk(data.edsk);
@@ -35,8 +46,28 @@ const WizardWallet = (props) => {
 	};
 	
 	const exitWizardWallet = () => {
+
+		// Clear previous error messages
+		setError("");
+
+		// Secret key is encrypted with this passphrase before saving
+		if (passphrase.length < 8) {
+			setError("Passphrase must be at least 8 characters long.");
+			return
+		}
+		if (passphrase !== confirmPassphrase) {
+			setError("Passphrases do not match.");
+			return
+		}
+
 		const finishWizardApiUrl = window.BASE_URL + "/api/wizard/finishWallet";
-		apiRequest(finishWizardApiUrl)
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ passphrase: passphrase })
+		};
+
+		apiRequest(finishWizardApiUrl, requestOptions)
 		.then(() => {
 			// Ignore response body; just need 200 OK
 			// Call parent finish wizard to exit this sub-wizard
@@ -51,6 +82,72 @@ const WizardWallet = (props) => {
 	const onSecretKeyChange = (e) => {
 		setImportEdsk(e.target.value);
 	}
+
+	const onCurveChange = (e) => {
+		setCurve(e.target.value);
+	}
+
+	const onImportPassphraseChange = (e) => {
+		setImportPassphrase(e.target.value);
+	}
+
+	const onPassphraseChange = (e) => {
+		setPassphrase(e.target.value);
+	}
+
+	const onConfirmPassphraseChange = (e) => {
+		setConfirmPassphrase(e.target.value);
+	}
+
+	const onMnemonicTypeChange = (e) => {
+		setMnemonicType(e.target.value);
+	}
+
+	const onMnemonicChange = (e) => {
+		setMnemonic({ ...mnemonic, [e.target.name]: e.target.value });
+	}
+
+	const doImportMnemonic = () => {
+
+		// Clear previous error messages
+		setError("");
+
+		// Sanity checks; words and checksum are validated by the API
+		const words = mnemonic.mnemonic.trim().split(/\s+/).length;
+		if (![12, 15, 18, 21, 24].includes(words)) {
+			setError("Mnemonic must be 12, 15, 18, 21 or 24 words; found " + words + ".");
+			return
+		}
+		if (mnemonicType === "fundraiser" && (mnemonic.email === "" || mnemonic.password === "")) {
+			setError("Email and password of the fundraiser account are required.");
+			return
+		}
+
+		// Fundraiser accounts have no derivation path or curve; always tz1
+		let importMnemonicApiUrl = window.BASE_URL + "/api/wizard/importMnemonic";
+		let body = { mnemonic: mnemonic.mnemonic, passphrase: mnemonic.passphrase, path: mnemonic.path, curve: mnemonic.curve };
+		if (mnemonicType === "fundraiser") {
+			importMnemonicApiUrl = window.BASE_URL + "/api/wizard/importFundraiser";
+			body = { mnemonic: mnemonic.mnemonic, email: mnemonic.email, password: mnemonic.password };
+		}
+
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify(body)
+		};
+
+		apiRequest(importMnemonicApiUrl, requestOptions)
+		.then((data) => {
+			setEdsk(data.edsk);
+			setPkh(data.pkh);
+			setStep(3);
+		})
+		.catch((errMsg) => {
+			console.log(errMsg);
+			setError(errMsg)
+		});
+	}
 	
 	const doImportKey = () => {
 	
@@ -58,12 +155,21 @@ const WizardWallet = (props) => {
 		setError("");
 	
 		// Sanity checks
-		if (importEdsk.substring(0, 4) !== "edsk") {
-			setError("Secret key must begin with 'edsk'");
+		const skPrefix = importEdsk.substring(0, 4);
+		if (isEncryptedKey(importEdsk)) {
+			if (importEdsk.length !== 88) {
+				setError("Encrypted secret key must be 88 characters long.");
+				return
+			}
+			if (importPassphrase === "") {
+				setError("Passphrase is required to import an encrypted secret key.");
+				return
+			}
+		} else if (skPrefix !== "edsk" && skPrefix !== "spsk" && skPrefix !== "p2sk") {
+			setError("Secret key must begin with 'edsk', 'spsk', 'p2sk', or their encrypted forms");
 			return
-		}
-		if (importEdsk.length !== 54 && importEdsk.length !== 98) {
-			setError("Secret key must be 54 or 98 characters long.");
+		} else if (importEdsk.length !== 54 && !(skPrefix === "edsk" && importEdsk.length === 98)) {
+			setError("Secret key must be 54 characters long, or 98 for 'edsk'.");
 			return
 		}
 
@@ -72,7 +178,7 @@ const WizardWallet = (props) => {
 		const requestOptions = {
 			method: 'POST',
 			headers: { 'Content-Type': 'application/json' },
-			body: JSON.stringify({ edsk: importEdsk })
+			body: JSON.stringify({ edsk: importEdsk, passphrase: importPassphrase })
 		};
 
 		apiRequest(importKeyApiUrl, requestOptions)
@@ -87,6 +193,34 @@ const WizardWallet = (props) => {
 		});
 	}
 	
+	// Secret key is encrypted with this passphrase when saved
+	const passphraseForm = (
+		<>
+		<Row className="mt-3">
+			<Col>
+				<Card.Text>Enter a passphrase to encrypt your secret key. You will need this passphrase each time Bakin'Bacon starts, unless it is provided through the BAKINBACON_PASSPHRASE environment variable or a passphrase file.</Card.Text>
+			</Col>
+		</Row>
+		<Row>
+			<Col md="5">
+				<Form.Group controlId="walletPassphrase">
+					<Form.Label>Passphrase</Form.Label>
+					<Form.Control type="password" onChange={onPassphraseChange} />
+				</Form.Group>
+			</Col>
+			<Col md="5">
+				<Form.Group controlId="walletConfirmPassphrase">
+					<Form.Label>Confirm Passphrase</Form.Label>
+					<Form.Control type="password" onChange={onConfirmPassphraseChange} />
+				</Form.Group>
+			</Col>
+		</Row>
+		{ err &&
+		<Alert variant="danger">{err}</Alert>
+		}
+		</>
+	);
+
 	// Returns
 
 	// Step 99 is a dummy step that should not ever get rendered
@@ -99,11 +233,18 @@ const WizardWallet = (props) => {
 			<Card.Title>Setup Software Wallet</Card.Title>
 			<Row>
 				<Col>
-					<Card.Text>There are two options when setting up a software wallet: 1) Generate a new secret key, or 2) Import an existing secret key.</Card.Text>
-					<Card.Text>Below, make your selection by clicking on 'Generate New Key', or by pasting your existing secret key and clicking 'Import Secret Key'. Your secret key must be unencrypted when importing.</Card.Text>
+					<Card.Text>There are three options when setting up a software wallet: 1) Generate a new secret key, 2) Import an existing secret key, or 3) Restore a key from its mnemonic (seed words), or from fundraiser credentials.</Card.Text>
+					<Card.Text>Below, make your selection by clicking on 'Generate New Key', or by pasting your existing secret key and clicking 'Import Secret Key'. If your secret key is encrypted (edesk, spesk, p2esk), also enter its passphrase.</Card.Text>
 				</Col>
 			</Row>
 			<Row className="justify-content-md-center">
+				<Col md="3">
+					<Form.Control as="select" value={curve} onChange={onCurveChange}>
+						<option value="ed25519">Ed25519 (tz1)</option>
+						<option value="secp256k1">Secp256k1 (tz2)</option>
+						<option value="p256">P-256 (tz3)</option>
+					</Form.Control>
+				</Col>
 				<Col md="4"><Button variant="primary" size="lg" block onClick={generateNewKey}>Generate New Key</Button></Col>
 			</Row>
 			<Row className="justify-content-md-center">
@@ -118,9 +259,64 @@ const WizardWallet = (props) => {
 						<Form.Label>Secret Key</Form.Label>
 						<Form.Control type="text" placeholder="edsk..." onChange={onSecretKeyChange} />
 					</Form.Group>
+					{ isEncryptedKey(importEdsk) &&
+					<Form.Group controlId="importPassphrase">
+						<Form.Label>Passphrase</Form.Label>
+						<Form.Control type="password" onChange={onImportPassphraseChange} />
+					</Form.Group>
+					}
 				</Col>
 				<Col md="3" className="mt-3"><Button variant="primary" size="lg" block onClick={doImportKey}>Import Secret Key</Button></Col>
 			</Row>
+			<Row className="justify-content-md-center">
+				<Col md={{span: 3}}><hr/></Col><Col md="1" className="text-center">OR</Col><Col md={{span: 3}}><hr/></Col>
+			</Row>
+			<Row className="justify-content-md-center">
+				<Col md="7">
+					<Form.Group controlId="mnemonicType">
+						<Form.Label>Mnemonic</Form.Label>
+						<Form.Control as="select" value={mnemonicType} onChange={onMnemonicTypeChange}>
+							<option value="bip39">BIP39 mnemonic (Ledger, Temple, Kukai, ...)</option>
+							<option value="fundraiser">Fundraiser account (mnemonic, email and password)</option>
+						</Form.Control>
+					</Form.Group>
+					<Form.Group controlId="mnemonicWords">
+						<Form.Control as="textarea" rows={2} name="mnemonic" placeholder="word1 word2 ..." onChange={onMnemonicChange} />
+					</Form.Group>
+					{ mnemonicType === "bip39" ?
+					<Form.Row>
+						<Form.Group as={Col} controlId="mnemonicPassphrase">
+							<Form.Label>Passphrase (optional)</Form.Label>
+							<Form.Control type="password" name="passphrase" onChange={onMnemonicChange} />
+						</Form.Group>
+						<Form.Group as={Col} controlId="mnemonicPath">
+							<Form.Label>Derivation Path</Form.Label>
+							<Form.Control type="text" name="path" placeholder="m/44'/1729'/0'/0'" onChange={onMnemonicChange} />
+						</Form.Group>
+						<Form.Group as={Col} md="3" controlId="mnemonicCurve">
+							<Form.Label>Curve</Form.Label>
+							<Form.Control as="select" name="curve" value={mnemonic.curve} onChange={onMnemonicChange}>
+								<option value="ed25519">tz1</option>
+								<option value="secp256k1">tz2</option>
+								<option value="p256">tz3</option>
+							</Form.Control>
+						</Form.Group>
+					</Form.Row>
+					:
+					<Form.Row>
+						<Form.Group as={Col} controlId="fundraiserEmail">
+							<Form.Label>Email</Form.Label>
+							<Form.Control type="text" name="email" onChange={onMnemonicChange} />
+						</Form.Group>
+						<Form.Group as={Col} controlId="fundraiserPassword">
+							<Form.Label>Password</Form.Label>
+							<Form.Control type="password" name="password" onChange={onMnemonicChange} />
+						</Form.Group>
+					</Form.Row>
+					}
+				</Col>
+				<Col md="3" className="mt-3"><Button variant="primary" size="lg" block onClick={doImportMnemonic}>Restore from Mnemonic</Button></Col>
+			</Row>
 
 			{ err &&
 			<Alert variant="danger">{err}</Alert>
@@ -149,6 +345,7 @@ const WizardWallet = (props) => {
 				<Col md={2}><b>Public Key Hash:</b></Col>
 				<Col>{pkh}</Col>
 			</Row>
+			{ passphraseForm }
 			<Row>
 				<Col md={3}><Button variant="primary" block onClick={exitWizardWallet}>I saved my key; Continue</Button></Col>
 			</Row>
@@ -163,7 +360,7 @@ const WizardWallet = (props) => {
 			<Card.Title>Setup Software Wallet</Card.Title>
 			<Row className="justify-content-md-center">
 				<Col>
-					<Alert variant="success">Successfully imported secret key!</Alert>
+					<Alert variant="success">Successfully imported key!</Alert>
 					<Card.Text>Below you will see your public key hash. Confirm this is the correct address. If not, reload this page to try again.</Card.Text>
 				</Col>
 			</Row>
@@ -171,6 +368,7 @@ const WizardWallet = (props) => {
 				<Col md={2}><b>Public Key Hash:</b></Col>
 				<Col>{pkh}</Col>
 			</Row>
+			{ passphraseForm }
 			<Row>
 				<Col md={3}><Button variant="primary" block onClick={exitWizardWallet}>Key is Correct; Continue</Button></Col>
 			</Row>
@@ -17,12 +17,14 @@ import (
 	"github.com/gorilla/mux"
 
 	"bakinbacon/baconclient"
+	"bakinbacon/storage"
 )
 
 var (
 	// Global vars for the webserver package
 	httpSvr     *http.Server
 	baconClient *baconclient.BaconClient
+	db          storage.Store
 )
 
 // Embed all UI objects
@@ -40,10 +42,11 @@ type TemplateVars struct {
 	UiBaseUrl      string
 }
 
-func Start(_baconClient *baconclient.BaconClient, bindAddr string, bindPort int, templateVars TemplateVars, shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+func Start(_baconClient *baconclient.BaconClient, _db storage.Store, bindAddr string, bindPort int, templateVars TemplateVars, shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
 
-	// Set the package global
+	// Set the package globals
 	baconClient = _baconClient
+	db = _db
 
 	// Repoint web ui down one directory
 	contentStatic, _ := fs.Sub(staticUi, "build")
@@ -67,6 +70,7 @@ func Start(_baconClient *baconclient.BaconClient, bindAddr string, bindPort int,
 	apiRouter.HandleFunc("/status", getStatus).Methods("GET")
 	apiRouter.HandleFunc("/delegate", setDelegate).Methods("POST")
 	apiRouter.HandleFunc("/health", getHealth).Methods("GET")
+	apiRouter.HandleFunc("/unlock", unlockWallet).Methods("POST", "OPTIONS")
 
 	// Settings tab
 	settingsRouter := apiRouter.PathPrefix("/settings").Subrouter()
@@ -76,6 +80,35 @@ func Start(_baconClient *baconclient.BaconClient, bindAddr string, bindPort int,
 	settingsRouter.HandleFunc("/addendpoint", addEndpoint).Methods("POST")
 	settingsRouter.HandleFunc("/listendpoints", listEndpoints).Methods("GET")
 	settingsRouter.HandleFunc("/deleteendpoint", deleteEndpoint).Methods("POST")
+	settingsRouter.HandleFunc("/editendpoint", editEndpoint).Methods("POST")
+	settingsRouter.HandleFunc("/enableendpoint", enableEndpoint).Methods("POST")
+	settingsRouter.HandleFunc("/endpointoptions", setEndpointOptions).Methods("POST")
+	settingsRouter.HandleFunc("/endpointrole", setEndpointRole).Methods("POST")
+	settingsRouter.HandleFunc("/backup", backupDatabase).Methods("POST")
+	settingsRouter.HandleFunc("/database", getDatabaseInfo).Methods("GET")
+
+	// Signing policy confirmations and audit log
+	signerRouter := apiRouter.PathPrefix("/signer").Subrouter()
+	signerRouter.HandleFunc("/pending", getPendingConfirmations).Methods("GET")
+	signerRouter.HandleFunc("/confirm", confirmOperation).Methods("POST", "OPTIONS")
+	signerRouter.HandleFunc("/audit", exportAuditLog).Methods("GET")
+	signerRouter.HandleFunc("/health", getSignerHealth).Methods("GET")
+
+	// Consensus key rotation
+	consensusRouter := apiRouter.PathPrefix("/consensus").Subrouter()
+	consensusRouter.HandleFunc("/status", getConsensusKeyStatus).Methods("GET")
+	consensusRouter.HandleFunc("/generate", generateConsensusKey).Methods("GET")
+	consensusRouter.HandleFunc("/import", importConsensusKey).Methods("POST", "OPTIONS")
+	consensusRouter.HandleFunc("/register", registerConsensusKey).Methods("POST", "OPTIONS")
+
+	// Ledger high watermarks
+	ledgerRouter := apiRouter.PathPrefix("/ledger").Subrouter()
+	ledgerRouter.HandleFunc("/hwm", getLedgerWatermarks).Methods("GET")
+	ledgerRouter.HandleFunc("/sethwm", setLedgerWatermark).Methods("POST", "OPTIONS")
+
+	// History export
+	historyRouter := apiRouter.PathPrefix("/history").Subrouter()
+	historyRouter.HandleFunc("/export", exportHistory).Methods("GET")
 
 	// Voting tab
 	votingRouter := apiRouter.PathPrefix("/voting").Subrouter()
@@ -87,8 +120,10 @@ func Start(_baconClient *baconclient.BaconClient, bindAddr string, bindPort int,
 	wizardRouter.HandleFunc("/confirmBakingPkh", confirmBakingPkh)
 	wizardRouter.HandleFunc("/generateNewKey", generateNewKey)
 	wizardRouter.HandleFunc("/importKey", importSecretKey).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/importMnemonic", importMnemonic).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/importFundraiser", importFundraiser).Methods("POST", "OPTIONS")
 	wizardRouter.HandleFunc("/registerBaker", registerBaker).Methods("POST", "OPTIONS")
-	wizardRouter.HandleFunc("/finishWallet", finishWalletWizard)
+	wizardRouter.HandleFunc("/finishWallet", finishWalletWizard).Methods("POST", "OPTIONS")
 
 	// For static content (js, images)
 	router.PathPrefix("/static/").Handler(http.FileServer(http.FS(contentStatic)))